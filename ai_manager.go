@@ -39,6 +39,7 @@ type ModelDecision struct {
 	Decision   *FullDecision `json:"decision"`
 	Error      error         `json:"error,omitempty"`
 	Duration   time.Duration `json:"duration"`
+	Usage      *ModelUsage   `json:"usage,omitempty"` // token 与成本，调用失败时为空
 }
 
 // AIManager 多AI模型管理器
@@ -133,6 +134,7 @@ func (am *AIManager) getPrimaryDecision(ctx *Context) (*FullDecision, []ModelDec
 			Decision:  decision,
 			Error:     err,
 			Duration:  duration,
+			Usage:     decisionUsage(decision),
 		},
 	}
 
@@ -169,6 +171,7 @@ func (am *AIManager) getVoteDecision(ctx *Context) (*FullDecision, []ModelDecisi
 				Decision:  decision,
 				Error:     err,
 				Duration:  time.Since(start),
+				Usage:     decisionUsage(decision),
 			}
 		}(name, brain)
 	}
@@ -387,3 +390,11 @@ func InitGlobalAIManager(config AIModelsConfig, proxyURL string) {
 func GetAIManager() *AIManager {
 	return globalAIManager
 }
+
+// decisionUsage 取出决策附带的调用计量（决策为空时返回 nil）
+func decisionUsage(d *FullDecision) *ModelUsage {
+	if d == nil {
+		return nil
+	}
+	return d.Usage
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// 资产层级
+const (
+	AssetTierMajor = "major" // 主流币（BTC/ETH 等），使用主流币杠杆与风控
+	AssetTierAlt   = "alt"   // 山寨币，使用更保守的山寨专属风控
+)
+
+// AssetInfo 单个交易对的分类与风控覆盖（0 / 空值表示使用层级默认值）
+type AssetInfo struct {
+	Symbol         string  `json:"symbol"`
+	Tier           string  `json:"tier"`                  // "major" / "alt"
+	Sector         string  `json:"sector"`                // 板块，用于板块热度与板块敞口；为空归入 Other
+	MaxLeverage    int     `json:"max_leverage"`          // 最大杠杆
+	MaxRiskUSD     float64 `json:"max_risk_usd"`          // 单笔止损亏损上限 (USDT)
+	MaxMarginUsage float64 `json:"max_margin_usage"`      // 单笔保证金占可用余额比例上限
+	Blacklisted    bool    `json:"blacklisted,omitempty"` // 黑名单：不作为交易对 / 扫描候选，已有持仓只做管理
+	Notes          string  `json:"notes,omitempty"`
+}
+
+// defaultAssets 内置资产分类；config.local.json 的 assets 与 data/assets.json（Web 编辑）会覆盖
+var defaultAssets = []AssetInfo{
+	{Symbol: "BTCUSDT", Tier: AssetTierMajor, Sector: "Major"},
+	{Symbol: "ETHUSDT", Tier: AssetTierMajor, Sector: "Major"},
+	{Symbol: "BNBUSDT", Tier: AssetTierAlt, Sector: "Major"},
+	{Symbol: "SOLUSDT", Tier: AssetTierAlt, Sector: "Major"},
+	{Symbol: "DOGEUSDT", Tier: AssetTierAlt, Sector: "Meme"},
+	{Symbol: "1000SHIBUSDT", Tier: AssetTierAlt, Sector: "Meme"},
+	{Symbol: "1000PEPEUSDT", Tier: AssetTierAlt, Sector: "Meme"},
+	{Symbol: "1000BONKUSDT", Tier: AssetTierAlt, Sector: "Meme"},
+	{Symbol: "WIFUSDT", Tier: AssetTierAlt, Sector: "Meme"},
+	{Symbol: "FETUSDT", Tier: AssetTierAlt, Sector: "AI"},
+	{Symbol: "RENDERUSDT", Tier: AssetTierAlt, Sector: "AI", Notes: "原 RNDRUSDT"},
+	{Symbol: "WLDUSDT", Tier: AssetTierAlt, Sector: "AI"},
+	{Symbol: "ARKMUSDT", Tier: AssetTierAlt, Sector: "AI"},
+	{Symbol: "ARBUSDT", Tier: AssetTierAlt, Sector: "L2"},
+	{Symbol: "OPUSDT", Tier: AssetTierAlt, Sector: "L2"},
+	{Symbol: "POLUSDT", Tier: AssetTierAlt, Sector: "L2", Notes: "原 MATICUSDT"},
+}
+
+// normalizeAsset 校验并归一化资产配置
+func normalizeAsset(a AssetInfo) (AssetInfo, error) {
+	a.Symbol = strings.ToUpper(strings.TrimSpace(a.Symbol))
+	if a.Symbol == "" {
+		return a, fmt.Errorf("symbol 不能为空")
+	}
+	a.Tier = strings.ToLower(strings.TrimSpace(a.Tier))
+	switch a.Tier {
+	case "":
+		a.Tier = AssetTierAlt
+	case AssetTierMajor, AssetTierAlt:
+	default:
+		return a, fmt.Errorf("未知 tier: %s（可选 major / alt）", a.Tier)
+	}
+	a.Sector = strings.TrimSpace(a.Sector)
+	if a.MaxLeverage < 0 || a.MaxRiskUSD < 0 || a.MaxMarginUsage < 0 || a.MaxMarginUsage > 1 {
+		return a, fmt.Errorf("max_leverage / max_risk_usd 不能为负，max_margin_usage 须在 0~1 之间")
+	}
+	return a, nil
+}
+
+// AssetRegistry 资产分类注册表：内置默认值 < 配置文件 < Web 编辑（持久化到 filePath）
+type AssetRegistry struct {
+	mu       sync.RWMutex
+	filePath string
+	assets   map[string]AssetInfo
+	edited   map[string]AssetInfo // 运行时编辑过的条目，与 deleted 一起持久化
+	deleted  map[string]bool      // 运行时删除的交易对
+}
+
+// assetOverrides data/assets.json 的格式
+type assetOverrides struct {
+	Assets  []AssetInfo `json:"assets"`
+	Deleted []string    `json:"deleted,omitempty"`
+}
+
+// NewAssetRegistry 创建资产注册表，依次叠加内置默认值、配置文件中的 assets 和 filePath 中的运行时编辑
+func NewAssetRegistry(filePath string, configured []AssetInfo) *AssetRegistry {
+	r := &AssetRegistry{
+		filePath: filePath,
+		assets:   make(map[string]AssetInfo),
+		edited:   make(map[string]AssetInfo),
+		deleted:  make(map[string]bool),
+	}
+	for _, a := range defaultAssets {
+		r.assets[a.Symbol] = a
+	}
+	for _, a := range configured {
+		if n, err := normalizeAsset(a); err != nil {
+			log.Printf("⚠️ [Assets] 忽略配置中的资产 %s: %v", a.Symbol, err)
+		} else {
+			r.assets[n.Symbol] = n
+		}
+	}
+	if filePath == "" {
+		return r
+	}
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return r
+	}
+	var ov assetOverrides
+	if err := json.Unmarshal(data, &ov); err != nil {
+		log.Printf("⚠️ [Assets] 解析 %s 失败: %v", filePath, err)
+		return r
+	}
+	for _, a := range ov.Assets {
+		if n, err := normalizeAsset(a); err == nil {
+			r.assets[n.Symbol] = n
+			r.edited[n.Symbol] = n
+		}
+	}
+	for _, s := range ov.Deleted {
+		delete(r.assets, s)
+		r.deleted[s] = true
+	}
+	log.Printf("✅ [Assets] 从 %s 加载 %d 条资产覆盖", filePath, len(ov.Assets)+len(ov.Deleted))
+	return r
+}
+
+// Get 返回交易对的资产信息；未登记的交易对视为 Other 板块的山寨币
+func (r *AssetRegistry) Get(symbol string) AssetInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if a, ok := r.assets[symbol]; ok {
+		return a
+	}
+	return AssetInfo{Symbol: symbol, Tier: AssetTierAlt}
+}
+
+// List 按层级、板块、交易对排序返回全部资产
+func (r *AssetRegistry) List() []AssetInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]AssetInfo, 0, len(r.assets))
+	for _, a := range r.assets {
+		out = append(out, a)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Tier != out[j].Tier {
+			return out[i].Tier == AssetTierMajor
+		}
+		if out[i].Sector != out[j].Sector {
+			return out[i].Sector < out[j].Sector
+		}
+		return out[i].Symbol < out[j].Symbol
+	})
+	return out
+}
+
+// Set 新增或覆盖一条资产配置并持久化
+func (r *AssetRegistry) Set(a AssetInfo) (AssetInfo, error) {
+	n, err := normalizeAsset(a)
+	if err != nil {
+		return n, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	undo := r.snapshotLocked(n.Symbol)
+	r.assets[n.Symbol] = n
+	r.edited[n.Symbol] = n
+	delete(r.deleted, n.Symbol)
+	if err := r.saveLocked(); err != nil {
+		undo()
+		return n, err
+	}
+	return n, nil
+}
+
+// Delete 删除一条资产配置（删除后按未登记的山寨币处理）并持久化
+func (r *AssetRegistry) Delete(symbol string) error {
+	symbol = strings.ToUpper(strings.TrimSpace(symbol))
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.assets[symbol]; !ok {
+		return fmt.Errorf("资产 %s 不存在", symbol)
+	}
+	undo := r.snapshotLocked(symbol)
+	delete(r.assets, symbol)
+	delete(r.edited, symbol)
+	r.deleted[symbol] = true
+	if err := r.saveLocked(); err != nil {
+		undo()
+		return err
+	}
+	return nil
+}
+
+// snapshotLocked 记录 symbol 当前的登记 / 编辑 / 删除状态，返回的函数用于持久化失败时恢复（调用方需持有写锁）
+func (r *AssetRegistry) snapshotLocked(symbol string) func() {
+	asset, hasAsset := r.assets[symbol]
+	edited, hasEdited := r.edited[symbol]
+	deleted := r.deleted[symbol]
+	return func() {
+		if hasAsset {
+			r.assets[symbol] = asset
+		} else {
+			delete(r.assets, symbol)
+		}
+		if hasEdited {
+			r.edited[symbol] = edited
+		} else {
+			delete(r.edited, symbol)
+		}
+		if deleted {
+			r.deleted[symbol] = true
+		} else {
+			delete(r.deleted, symbol)
+		}
+	}
+}
+
+// saveLocked 持久化运行时编辑（调用方需持有写锁）
+func (r *AssetRegistry) saveLocked() error {
+	if r.filePath == "" {
+		return nil
+	}
+	ov := assetOverrides{}
+	for _, a := range r.edited {
+		ov.Assets = append(ov.Assets, a)
+	}
+	for s := range r.deleted {
+		ov.Deleted = append(ov.Deleted, s)
+	}
+	sort.Slice(ov.Assets, func(i, j int) bool { return ov.Assets[i].Symbol < ov.Assets[j].Symbol })
+	sort.Strings(ov.Deleted)
+
+	return writeJSONFile(r.filePath, ov)
+}
+
+// Sectors 按板块分组返回已登记的交易对（不含 Other），用于板块热度
+func (r *AssetRegistry) Sectors() []SectorInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	bySector := make(map[string][]string)
+	for _, a := range r.assets {
+		if a.Sector == "" || a.Sector == otherSector {
+			continue
+		}
+		bySector[a.Sector] = append(bySector[a.Sector], a.Symbol)
+	}
+	names := make([]string, 0, len(bySector))
+	for name := range bySector {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	out := make([]SectorInfo, 0, len(names))
+	for _, name := range names {
+		symbols := bySector[name]
+		sort.Strings(symbols)
+		out = append(out, SectorInfo{Name: name, Symbols: symbols})
+	}
+	return out
+}
+
+// 全局资产注册表
+var globalAssetRegistry *AssetRegistry
+
+// InitGlobalAssetRegistry 初始化全局资产注册表
+func InitGlobalAssetRegistry(filePath string, configured []AssetInfo) {
+	globalAssetRegistry = NewAssetRegistry(filePath, configured)
+}
+
+// GetAssetRegistry 获取全局资产注册表；未初始化时（如回测）返回仅含内置分类的注册表
+func GetAssetRegistry() *AssetRegistry {
+	if globalAssetRegistry == nil {
+		globalAssetRegistry = NewAssetRegistry("", nil)
+	}
+	return globalAssetRegistry
+}
+
+// isAltSymbol 判断是否为山寨币（按资产注册表的 tier），用于山寨专属风险控制
+func isAltSymbol(symbol string) bool {
+	return GetAssetRegistry().Get(symbol).Tier != AssetTierMajor
+}
+
+// filterBlacklisted 去重并移除资产注册表中被列入黑名单的交易对（保持原顺序）
+func filterBlacklisted(symbols []string) []string {
+	r := GetAssetRegistry()
+	seen := make(map[string]bool, len(symbols))
+	out := make([]string, 0, len(symbols))
+	for _, s := range symbols {
+		if s == "" || seen[s] || r.Get(s).Blacklisted {
+			continue
+		}
+		seen[s] = true
+		out = append(out, s)
+	}
+	return out
+}
+
+// sectorOf 返回交易对所属板块名称
+func sectorOf(symbol string) string {
+	if s := GetAssetRegistry().Get(symbol).Sector; s != "" {
+		return s
+	}
+	return otherSector
+}
@@ -29,10 +29,13 @@ type BacktestSymbolData struct {
 
 type BacktestExchange struct {
 	account       AccountInfo
-	positions     map[string]PositionInfo
+	positions     map[string]PositionInfo // key: positionKey(symbol, side)
 	marketData    map[string]*MarketData
 	initialEquity float64
 
+	// HedgeMode 为 true 时按对冲模式撮合：同一交易对可同时持有多空两条腿
+	HedgeMode bool
+
 	data     map[string]*BacktestSymbolData
 	step     int // 当前回测步数（对应 3m K 线索引）
 	maxStep  int // 所有 symbol 共享的最大步数
@@ -282,6 +285,9 @@ func (b *BacktestExchange) FetchMarketData(symbols []string) error {
 			Sentiment:         sentiment,
 			IntradaySeries:    intraday,
 			LongerTermContext: longerTerm,
+			LastClose: lastClosedCloses(map[string][]Kline{
+				"3m": series3m, "30m": klines30m, "1h": klines1h, "4h": klines4h,
+			}),
 		}
 	}
 
@@ -304,6 +310,7 @@ func (b *BacktestExchange) revalueAccount() {
 		}
 
 		pos.MarkPrice = md.CurrentPrice
+		pos.LiquidationPrice = positionLiquidationPrice(pos)
 		if pos.Side == "long" {
 			pos.UnrealizedPnL = (pos.MarkPrice - pos.EntryPrice) * pos.Quantity
 		} else {
@@ -313,6 +320,9 @@ func (b *BacktestExchange) revalueAccount() {
 		if pos.MarginUsed > 0 {
 			pos.UnrealizedPnLPct = (pos.UnrealizedPnL / pos.MarginUsed) * 100
 		}
+		if pos.UnrealizedPnLPct > pos.PeakPnLPct {
+			pos.PeakPnLPct = pos.UnrealizedPnLPct
+		}
 		b.positions[k] = pos
 
 		totalUnrealizedPnL += pos.UnrealizedPnL
@@ -359,6 +369,11 @@ func (b *BacktestExchange) GetTradeHistory() []TradeRecord {
 	return nil
 }
 
+// IsHedgeMode 是否按对冲模式撮合
+func (b *BacktestExchange) IsHedgeMode() bool {
+	return b.HedgeMode
+}
+
 // ExecuteDecision 在回测环境下执行交易决策
 // 目前实现与 SimulatedExchange 基本一致，支持开仓、全平和部分平仓。
 func (b *BacktestExchange) ExecuteDecision(d Decision) error {
@@ -384,10 +399,14 @@ func (b *BacktestExchange) ExecuteDecision(d Decision) error {
 			side = "short"
 		}
 
-		if pos, exists := b.positions[d.Symbol]; exists {
-			if pos.Side != side {
-				return fmt.Errorf("conflict: existing %s position for %s", pos.Side, d.Symbol)
+		if !b.HedgeMode {
+			if opp, exists := b.positions[positionKey(d.Symbol, oppositeSide(side))]; exists {
+				return fmt.Errorf("conflict: existing %s position for %s", opp.Side, d.Symbol)
 			}
+		}
+
+		key := positionKey(d.Symbol, side)
+		if pos, exists := b.positions[key]; exists {
 			totalCost := pos.EntryPrice * pos.Quantity
 			newCost := price * quantity
 			totalQty := pos.Quantity + quantity
@@ -397,9 +416,9 @@ func (b *BacktestExchange) ExecuteDecision(d Decision) error {
 			pos.Quantity = totalQty
 			pos.MarginUsed += marginRequired
 			pos.Leverage = d.Leverage
-			b.positions[d.Symbol] = pos
+			b.positions[key] = pos
 		} else {
-			b.positions[d.Symbol] = PositionInfo{
+			b.positions[key] = PositionInfo{
 				Symbol:     d.Symbol,
 				Side:       side,
 				EntryPrice: price,
@@ -410,22 +429,18 @@ func (b *BacktestExchange) ExecuteDecision(d Decision) error {
 			}
 			b.account.PositionCount++
 		}
+		opened := b.positions[key]
+		opened.LiquidationPrice = positionLiquidationPrice(opened)
+		b.positions[key] = opened
 
 		b.account.AvailableBalance -= marginRequired
 		b.account.MarginUsed += marginRequired
 
 	case "close_long", "close_short":
-		pos, exists := b.positions[d.Symbol]
+		key := positionKey(d.Symbol, decisionPositionSide(d))
+		pos, exists := b.positions[key]
 		if !exists {
-			return fmt.Errorf("no position to close for %s", d.Symbol)
-		}
-
-		expectedSide := "long"
-		if d.Action == "close_short" {
-			expectedSide = "short"
-		}
-		if pos.Side != expectedSide {
-			return fmt.Errorf("position side mismatch: have %s, want close %s", pos.Side, expectedSide)
+			return fmt.Errorf("no %s position to close for %s", decisionPositionSide(d), d.Symbol)
 		}
 
 		var pnl float64
@@ -441,7 +456,7 @@ func (b *BacktestExchange) ExecuteDecision(d Decision) error {
 		b.account.TotalPnL += pnl
 		b.account.MarginUsed -= pos.MarginUsed
 
-		delete(b.positions, d.Symbol)
+		delete(b.positions, key)
 		b.account.PositionCount--
 
 		if b.History != nil {
@@ -461,10 +476,12 @@ func (b *BacktestExchange) ExecuteDecision(d Decision) error {
 		}
 
 	case "partial_close":
-		pos, exists := b.positions[d.Symbol]
-		if !exists {
-			return fmt.Errorf("no position to partial close for %s", d.Symbol)
+		found, err := findPosition(b.GetPositions(), d.Symbol, decisionPositionSide(d))
+		if err != nil {
+			return fmt.Errorf("partial close: %w", err)
 		}
+		pos := *found
+		key := positionKey(pos.Symbol, pos.Side)
 
 		// Remove the profit threshold check for backtest
 		// In backtest mode, allow partial close at any profit level for testing flexibility
@@ -522,10 +539,10 @@ func (b *BacktestExchange) ExecuteDecision(d Decision) error {
 		}
 
 		if pos.Quantity <= 0 || pos.MarginUsed == 0 {
-			delete(b.positions, d.Symbol)
+			delete(b.positions, key)
 			b.account.PositionCount--
 		} else {
-			b.positions[d.Symbol] = pos
+			b.positions[key] = pos
 		}
 
 		if b.History != nil {
@@ -3,10 +3,13 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"html"
 	"log"
 	"math"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 )
 
@@ -18,6 +21,7 @@ type BacktestConfig struct {
 	EndDate    string   `json:"end_date"`    // YYYY-MM-DD
 	InitialCap float64  `json:"initial_capital"`
 	OutputDir  string   `json:"output_dir"`
+	HedgeMode  bool     `json:"hedge_mode"` // 按对冲模式撮合：同一交易对可同时持有多空两条腿
 }
 
 // BacktestResult 回测结果
@@ -29,6 +33,7 @@ type BacktestResult struct {
 	DailyReturns   []DailyReturn          `json:"daily_returns"`
 	DrawdownCurve  []DrawdownPoint        `json:"drawdown_curve"`
 	SymbolStats    map[string]SymbolStats `json:"symbol_stats"`
+	RiskStats      RiskAdjustmentStats    `json:"risk_stats"` // 风控调整统计：AI 仓位被覆盖的频率
 	GeneratedAt    time.Time              `json:"generated_at"`
 }
 
@@ -107,6 +112,7 @@ func NewBacktestRunner(config BacktestConfig, aiConfig *Config) (*BacktestRunner
 	if err != nil {
 		return nil, fmt.Errorf("create backtest exchange: %w", err)
 	}
+	exchange.HedgeMode = config.HedgeMode
 
 	// 创建AI大脑
 	brain := NewAIBrain(aiConfig.AIAPIKey, aiConfig.AIAPIURL, aiConfig.AIModel, aiConfig.BinanceProxyURL)
@@ -120,6 +126,7 @@ func NewBacktestRunner(config BacktestConfig, aiConfig *Config) (*BacktestRunner
 			EquityCurve: make([]EquityPoint, 0),
 			Trades:      make([]TradeRecord, 0),
 			SymbolStats: make(map[string]SymbolStats),
+			RiskStats:   RiskAdjustmentStats{ByRule: make(map[string]int)},
 		},
 	}, nil
 }
@@ -180,9 +187,12 @@ func (br *BacktestRunner) Run() (*BacktestResult, error) {
 		ctx := &Context{
 			CurrentTime:   time.Now().Format("2006-01-02 15:04:05"),
 			CallCount:     callCount,
+			Symbols:       br.config.Symbols,
 			Account:       accountInfo,
 			Positions:     positions,
 			MarketDataMap: marketData,
+			HedgeMode:     br.exchange.IsHedgeMode(),
+			Portfolio:     buildPortfolioExposure(positionLegs(positions), marketData, accountInfo.TotalEquity, defaultPortfolioCorrelation),
 		}
 
 		// 获取AI决策
@@ -196,6 +206,17 @@ func (br *BacktestRunner) Run() (*BacktestResult, error) {
 			continue
 		}
 
+		// 与实盘一致：先归一化 action，再经过风控流水线
+		normalizeDecisionActions(decision.Decisions, positions)
+		riskErr := ValidateDecisions(ctx.riskConfig(), ctx.riskState(), decision.Decisions, accountInfo, positions, marketData)
+		for _, d := range decision.Decisions {
+			br.result.RiskStats.Add(d)
+		}
+		if riskErr != nil {
+			log.Printf("⚠️ 风控拒绝 (周期 #%d): %v", callCount, riskErr)
+			continue
+		}
+
 		// 执行决策
 		for _, d := range decision.Decisions {
 			if d.Action == "wait" || d.Action == "hold" {
@@ -224,6 +245,9 @@ func (br *BacktestRunner) Run() (*BacktestResult, error) {
 
 	elapsed := time.Since(startTime)
 	log.Printf("✅ 回测完成! 耗时: %v | 周期数: %d", elapsed, callCount)
+	rs := br.result.RiskStats
+	log.Printf("🛡️ 风控调整: %d/%d 决策被调整 (缩仓 %d | 改写 %d | 拒绝 %d) | 平均缩仓 %.1f%%",
+		rs.Adjusted, rs.Evaluated, rs.Resized, rs.Rewritten, rs.Rejected, rs.AvgSizeReductionPct())
 
 	return br.result, nil
 }
@@ -472,6 +496,11 @@ func (br *BacktestRunner) SaveReport() error {
 // generateHTMLReport 生成HTML报告
 func (br *BacktestRunner) generateHTMLReport() string {
 	s := br.result.Summary
+	rs := &br.result.RiskStats
+	adjustedPct := 0.0
+	if rs.Evaluated > 0 {
+		adjustedPct = float64(rs.Adjusted) / float64(rs.Evaluated) * 100
+	}
 
 	return fmt.Sprintf(`<!DOCTYPE html>
 <html>
@@ -565,6 +594,27 @@ func (br *BacktestRunner) generateHTMLReport() string {
             </div>
         </div>
 
+        <h2>🛡️ 风控调整</h2>
+        <div class="grid">
+            <div class="card">
+                <div class="card-title">风控评估决策</div>
+                <div class="card-value">%d</div>
+            </div>
+            <div class="card">
+                <div class="card-title">被调整决策</div>
+                <div class="card-value">%d (%.1f%%)</div>
+            </div>
+            <div class="card">
+                <div class="card-title">缩仓 / 改写 / 拒绝</div>
+                <div class="card-value">%d / %d / %d</div>
+            </div>
+            <div class="card">
+                <div class="card-title">平均缩仓幅度</div>
+                <div class="card-value">%.1f%%</div>
+            </div>
+        </div>
+        %s
+
         <div class="footer">
             <p>Deep Trader - AI 加密货币交易系统</p>
         </div>
@@ -587,9 +637,35 @@ func (br *BacktestRunner) generateHTMLReport() string {
 		s.AvgLoss,
 		s.LargestWin,
 		s.LargestLoss,
+		rs.Evaluated,
+		rs.Adjusted, adjustedPct,
+		rs.Resized, rs.Rewritten, rs.Rejected,
+		rs.AvgSizeReductionPct(),
+		br.riskRuleTableHTML(),
 	)
 }
 
+// riskRuleTableHTML 生成各风控规则触发次数表格
+func (br *BacktestRunner) riskRuleTableHTML() string {
+	byRule := br.result.RiskStats.ByRule
+	if len(byRule) == 0 {
+		return ""
+	}
+	rules := make([]string, 0, len(byRule))
+	for rule := range byRule {
+		rules = append(rules, rule)
+	}
+	sort.Slice(rules, func(i, j int) bool { return byRule[rules[i]] > byRule[rules[j]] })
+
+	var sb strings.Builder
+	sb.WriteString("<table>\n            <tr><th>规则</th><th>触发次数</th></tr>\n")
+	for _, rule := range rules {
+		sb.WriteString(fmt.Sprintf("            <tr><td>%s</td><td>%d</td></tr>\n", html.EscapeString(rule), byRule[rule]))
+	}
+	sb.WriteString("        </table>")
+	return sb.String()
+}
+
 func getColorClass(value float64) string {
 	if value >= 0 {
 		return "positive"
@@ -613,6 +689,7 @@ func RunBacktestCLI(dataDir string, symbols []string, initialCap float64, output
 		Symbols:    symbols,
 		InitialCap: initialCap,
 		OutputDir:  outputDir,
+		HedgeMode:  cfg.HedgeMode,
 	}
 
 	runner, err := NewBacktestRunner(btConfig, cfg)
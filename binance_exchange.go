@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"math"
@@ -11,6 +12,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/adshao/go-binance/v2"
@@ -37,11 +39,16 @@ type BinanceExchange struct {
 	MarketData       map[string]*MarketData
 	DualSidePosition bool               // true: Hedge mode, false: One-way mode
 	InitialEquity    float64            // 本次程序运行期间的基准净值
-	positionPeakPnL  map[string]float64 // 内存追踪持仓最高收益率
+	positionPeakPnL  map[string]float64 // 内存追踪持仓最高收益率，key 为 positionKey(symbol, side)
 	// positionOpenTime 记录本次程序运行期间每个符号+方向的首次建仓时间（毫秒）
 	// 用于在 brain.go 中计算真实的持仓时长，而不是每次轮询都重置为当前时间。
 	positionOpenTime map[string]int64
 	History          *TradeHistoryManager // 历史记录管理器
+
+	// 标记价格推送订阅的交易对；变化时关闭当前连接，由推送协程按新集合重连
+	markMu      sync.Mutex
+	markSymbols []string
+	markStopC   chan struct{}
 }
 
 // formatQuantity 统一处理不同币种的下单数量精度
@@ -121,6 +128,118 @@ func (e *BinanceExchange) SetLeverage(symbol string, leverage int) error {
 	return nil
 }
 
+// StreamMarkPrices 订阅标记价格实时推送（约 3 秒一次），断线后自动重连；onTick 在推送协程中调用。
+// 交易对集合变化时调用 UpdateMarkPriceSymbols 重新订阅。
+func (e *BinanceExchange) StreamMarkPrices(symbols []string, onTick func(symbol string, price float64)) {
+	e.markMu.Lock()
+	e.markSymbols = append([]string(nil), symbols...)
+	e.markMu.Unlock()
+
+	handler := func(event *futures.WsMarkPriceEvent) {
+		price, err := strconv.ParseFloat(event.MarkPrice, 64)
+		if err != nil {
+			return
+		}
+		onTick(event.Symbol, price)
+	}
+	errHandler := func(err error) {
+		log.Printf("⚠️ Mark price stream error: %v", err)
+	}
+	go func() {
+		for {
+			e.markMu.Lock()
+			current := e.markSymbols
+			e.markMu.Unlock()
+			if len(current) == 0 {
+				time.Sleep(10 * time.Second)
+				continue
+			}
+
+			doneC, stopC, err := futures.WsCombinedMarkPriceServe(current, handler, errHandler)
+			if err != nil {
+				log.Printf("⚠️ Mark price stream connect failed: %v", err)
+				time.Sleep(10 * time.Second)
+				continue
+			}
+			e.markMu.Lock()
+			e.markStopC = stopC
+			e.markMu.Unlock()
+			log.Printf("✅ Mark price stream connected for %d symbols", len(current))
+			<-doneC
+
+			e.markMu.Lock()
+			resubscribe := !sameSymbols(current, e.markSymbols)
+			e.markStopC = nil
+			e.markMu.Unlock()
+			if !resubscribe {
+				time.Sleep(10 * time.Second)
+			}
+		}
+	}()
+}
+
+// UpdateMarkPriceSymbols 更新标记价格推送的交易对集合，有变化时断开当前连接并按新集合重连
+func (e *BinanceExchange) UpdateMarkPriceSymbols(symbols []string) {
+	e.markMu.Lock()
+	defer e.markMu.Unlock()
+	if sameSymbols(symbols, e.markSymbols) {
+		return
+	}
+	e.markSymbols = append([]string(nil), symbols...)
+	if e.markStopC != nil {
+		close(e.markStopC)
+		e.markStopC = nil
+	}
+}
+
+// sameSymbols 判断两个交易对集合是否相同（忽略顺序）
+func sameSymbols(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	set := make(map[string]bool, len(a))
+	for _, s := range a {
+		set[s] = true
+	}
+	for _, s := range b {
+		if !set[s] {
+			return false
+		}
+	}
+	return true
+}
+
+// FetchMarginBrackets 从交易所拉取指定合约的维持保证金档位（需要签名接口权限）。
+// 单个币种失败不影响其它币种，返回已拉取到的档位与合并后的错误
+func (e *BinanceExchange) FetchMarginBrackets(symbols []string) (map[string][]MaintenanceBracket, error) {
+	result := make(map[string][]MaintenanceBracket, len(symbols))
+	var errs []error
+	for _, symbol := range symbols {
+		ctx, cancel := newAPICtx()
+		res, err := e.Client.NewGetLeverageBracketService().Symbol(symbol).Do(ctx)
+		cancel()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("get leverage bracket failed for %s: %w", symbol, err))
+			continue
+		}
+		for _, lb := range res {
+			brackets := make([]MaintenanceBracket, 0, len(lb.Brackets))
+			for _, b := range lb.Brackets {
+				brackets = append(brackets, MaintenanceBracket{
+					NotionalFloor:    b.NotionalFloor,
+					NotionalCap:      b.NotionalCap,
+					MaintMarginRatio: b.MaintMarginRatio,
+					Cum:              b.Cum,
+					InitialLeverage:  b.InitialLeverage,
+				})
+			}
+			result[lb.Symbol] = brackets
+		}
+	}
+	log.Printf("✅ Loaded maintenance margin brackets for %d symbols", len(result))
+	return result, errors.Join(errs...)
+}
+
 // NewBinanceExchange 创建带可选代理和状态跟踪的 BinanceExchange 实例
 func NewBinanceExchange(apiKey, secretKey, proxyURL string) *BinanceExchange {
 	client := binance.NewFuturesClient(apiKey, secretKey)
@@ -136,6 +255,8 @@ func NewBinanceExchange(apiKey, secretKey, proxyURL string) *BinanceExchange {
 			client.HTTPClient = &http.Client{
 				Transport: transport,
 			}
+			// 实时价格推送（失效条件监控）同样走代理
+			futures.SetWsProxyUrl(proxyURL)
 			log.Printf("✅ Binance Client using Proxy: %s", proxyURL)
 		}
 	}
@@ -289,7 +410,7 @@ func (e *BinanceExchange) FetchMarketData(symbols []string) error {
 		}
 
 		// 8. 获取资金费率和持仓量
-		fundingRate, _ := e.fetchFundingRate(symbol)
+		fundingRate, nextFundingTime, _ := e.fetchFundingRate(symbol)
 		oiData, _ := e.fetchOpenInterest(symbol)
 		lsRatio, _ := e.fetchLongShortRatio(symbol)
 
@@ -415,8 +536,9 @@ func (e *BinanceExchange) FetchMarketData(symbols []string) error {
 			BollingerMiddle: bbMid,
 			BollingerLower:  bbLower,
 
-			FundingRate:  fundingRate,
-			OpenInterest: oiData,
+			FundingRate:     fundingRate,
+			NextFundingTime: nextFundingTime,
+			OpenInterest:    oiData,
 
 			LongShortRatio: lsRatio,
 			Liquidation:    liqData,
@@ -425,6 +547,9 @@ func (e *BinanceExchange) FetchMarketData(symbols []string) error {
 			Sentiment:         sentiment,
 			IntradaySeries:    intraday,
 			LongerTermContext: longerTerm,
+			LastClose: lastClosedCloses(map[string][]Kline{
+				"3m": klines3m, "5m": klines5m, "15m": klines15m, "30m": klines30m, "1h": klines1h, "4h": klines4h,
+			}),
 		}
 	}
 	return nil
@@ -490,24 +615,28 @@ func (e *BinanceExchange) fetchKlines(symbol, interval string, limit int) ([]Kli
 	return res, nil
 }
 
-// fetchFundingRate 获取资金费率
-func (e *BinanceExchange) fetchFundingRate(symbol string) (float64, error) {
+// fetchFundingRate 获取资金费率及下次结算时间
+func (e *BinanceExchange) fetchFundingRate(symbol string) (float64, time.Time, error) {
 	ctx, cancel := newAPICtx()
 	defer cancel()
 
 	res, err := e.Client.NewPremiumIndexService().Symbol(symbol).Do(ctx)
 	if err != nil {
-		return 0, err
+		return 0, time.Time{}, err
 	}
 	if len(res) > 0 {
 		rate, err := strconv.ParseFloat(res[0].LastFundingRate, 64)
 		if err != nil {
 			log.Printf("⚠️ failed to parse funding rate for %s: %v", symbol, err)
-			return 0, err
+			return 0, time.Time{}, err
 		}
-		return rate, nil
+		var next time.Time
+		if res[0].NextFundingTime > 0 {
+			next = time.UnixMilli(res[0].NextFundingTime)
+		}
+		return rate, next, nil
 	}
-	return 0, fmt.Errorf("no data")
+	return 0, time.Time{}, fmt.Errorf("no data")
 }
 
 // fetchOpenInterest 获取持仓量
@@ -654,6 +783,11 @@ func (e *BinanceExchange) GetPositions() []PositionInfo {
 	return e.getPositionsFromRisk()
 }
 
+// IsHedgeMode 账户是否为对冲模式（dualSidePosition=true）
+func (e *BinanceExchange) IsHedgeMode() bool {
+	return e.DualSidePosition
+}
+
 // positionOpenTimeFile 为本地持久化文件名（相对于程序工作目录）
 const positionOpenTimeFile = "position_open_time.json"
 
@@ -730,6 +864,13 @@ func (e *BinanceExchange) getPositionsFromRisk() []PositionInfo {
 			side = "short"
 			amt = -amt
 		}
+		// 对冲模式下以交易所返回的 positionSide 为准，避免两条腿方向判断出错
+		switch p.PositionSide {
+		case string(futures.PositionSideTypeLong):
+			side = "long"
+		case string(futures.PositionSideTypeShort):
+			side = "short"
+		}
 
 		entryPrice, err := strconv.ParseFloat(p.EntryPrice, 64)
 		if err != nil {
@@ -764,15 +905,16 @@ func (e *BinanceExchange) getPositionsFromRisk() []PositionInfo {
 			unrealizedPnLPct = (unRealizedProfit / marginUsed) * 100
 		}
 
+		// 使用 "side:symbol" 作为 key 跟踪最高收益率与首次建仓时间，区分多空方向（对冲模式下两条腿互不干扰）
+		openKey := positionKey(p.Symbol, side)
+
 		// 更新并获取最高收益率
-		currentPeak := e.positionPeakPnL[p.Symbol]
+		currentPeak := e.positionPeakPnL[openKey]
 		if unrealizedPnLPct > currentPeak {
-			e.positionPeakPnL[p.Symbol] = unrealizedPnLPct
+			e.positionPeakPnL[openKey] = unrealizedPnLPct
 			currentPeak = unrealizedPnLPct
 		}
 
-		// 使用 "side:symbol" 作为 key 跟踪首次建仓时间，区分多空方向
-		openKey := fmt.Sprintf("%s:%s", side, p.Symbol)
 		activeKeys[openKey] = true
 		openTime, ok := e.positionOpenTime[openKey]
 		if !ok || openTime == 0 {
@@ -945,9 +1087,18 @@ func (e *BinanceExchange) ExecuteDecision(d Decision) error {
 		}
 	}
 
-	// 2. 开仓前，清理该币种所有现有挂单（防止止损/止盈堆积）
+	// 2. 开仓前，清理该币种现有挂单（防止止损/止盈堆积）
+	// 对冲模式下只清理同方向的止损/止盈，避免误删另一条腿的保护单
 	if d.Action == "open_long" || d.Action == "open_short" {
-		if err := e.CancelAllOrders(symbol); err != nil {
+		if e.DualSidePosition {
+			side := decisionPositionSide(d)
+			if err := e.CancelStopLossOrdersForSide(symbol, side); err != nil {
+				log.Printf("⚠️ 开仓前取消止损挂单失败 %s %s: %v", symbol, side, err)
+			}
+			if err := e.CancelTakeProfitOrdersForSide(symbol, side); err != nil {
+				log.Printf("⚠️ 开仓前取消止盈挂单失败 %s %s: %v", symbol, side, err)
+			}
+		} else if err := e.CancelAllOrders(symbol); err != nil {
 			log.Printf("⚠️ 开仓前取消挂单失败 %s: %v", symbol, err)
 		}
 	}
@@ -1066,13 +1217,13 @@ func (e *BinanceExchange) ExecuteDecision(d Decision) error {
 		}
 
 		// 如果是平仓操作，重置最高收益率记录，并尝试清理相关止盈/止损挂单
-		delete(e.positionPeakPnL, symbol)
+		delete(e.positionPeakPnL, positionKey(symbol, currentPos.Side))
 
-		// 平仓后尽量清理所有止损/止盈挂单，避免遗留订单
-		if err := e.CancelStopLossOrders(symbol); err != nil {
+		// 平仓后尽量清理该方向的止损/止盈挂单，避免遗留订单
+		if err := e.CancelStopLossOrdersForSide(symbol, currentPos.Side); err != nil {
 			log.Printf("⚠️ 平仓后取消止损挂单失败 %s: %v", symbol, err)
 		}
-		if err := e.CancelTakeProfitOrders(symbol); err != nil {
+		if err := e.CancelTakeProfitOrdersForSide(symbol, currentPos.Side); err != nil {
 			log.Printf("⚠️ 平仓后取消止盈挂单失败 %s: %v", symbol, err)
 		}
 	}
@@ -1137,21 +1288,14 @@ func (e *BinanceExchange) handleUpdateStopLoss(d Decision) error {
 		return fmt.Errorf("invalid new stop loss: %f", newSL)
 	}
 
-	// 1. 获取当前持仓
-	positions := e.GetPositions()
-	var currentPos *PositionInfo
-	for _, p := range positions {
-		if p.Symbol == symbol {
-			currentPos = &p
-			break
-		}
-	}
-	if currentPos == nil {
-		return fmt.Errorf("no position found for %s to update stop loss", symbol)
+	// 1. 获取当前持仓（对冲模式下按 side 定位具体的腿）
+	currentPos, err := findPosition(e.GetPositions(), symbol, decisionPositionSide(d))
+	if err != nil {
+		return fmt.Errorf("update stop loss: %w", err)
 	}
 
 	// 2. 取消旧的止损单
-	if err := e.CancelStopLossOrders(symbol); err != nil {
+	if err := e.CancelStopLossOrdersForSide(symbol, currentPos.Side); err != nil {
 		log.Printf("⚠️ 取消旧止损失败: %v", err)
 		// 继续尝试设置新止损
 	}
@@ -1174,21 +1318,14 @@ func (e *BinanceExchange) handleUpdateTakeProfit(d Decision) error {
 		return fmt.Errorf("invalid new take profit: %f", newTP)
 	}
 
-	// 1. 获取当前持仓
-	positions := e.GetPositions()
-	var currentPos *PositionInfo
-	for _, p := range positions {
-		if p.Symbol == symbol {
-			currentPos = &p
-			break
-		}
-	}
-	if currentPos == nil {
-		return fmt.Errorf("no position found for %s to update take profit", symbol)
+	// 1. 获取当前持仓（对冲模式下按 side 定位具体的腿）
+	currentPos, err := findPosition(e.GetPositions(), symbol, decisionPositionSide(d))
+	if err != nil {
+		return fmt.Errorf("update take profit: %w", err)
 	}
 
 	// 2. 取消旧的止盈单
-	if err := e.CancelTakeProfitOrders(symbol); err != nil {
+	if err := e.CancelTakeProfitOrdersForSide(symbol, currentPos.Side); err != nil {
 		log.Printf("⚠️ 取消旧止盈失败: %v", err)
 	}
 
@@ -1210,17 +1347,10 @@ func (e *BinanceExchange) handlePartialClose(d Decision) error {
 		return fmt.Errorf("invalid close percentage: %f", pct)
 	}
 
-	// 1. 获取当前持仓
-	positions := e.GetPositions()
-	var currentPos *PositionInfo
-	for _, p := range positions {
-		if p.Symbol == symbol {
-			currentPos = &p
-			break
-		}
-	}
-	if currentPos == nil {
-		return fmt.Errorf("no position found for %s to partial close", symbol)
+	// 1. 获取当前持仓（对冲模式下按 side 定位具体的腿）
+	currentPos, err := findPosition(e.GetPositions(), symbol, decisionPositionSide(d))
+	if err != nil {
+		return fmt.Errorf("partial close: %w", err)
 	}
 
 	// 2. 计算平仓数量
@@ -1251,8 +1381,7 @@ func (e *BinanceExchange) handlePartialClose(d Decision) error {
 
 	ctx, cancel := newAPICtx()
 	defer cancel()
-	_, err := service.Do(ctx)
-	if err != nil {
+	if _, err := service.Do(ctx); err != nil {
 		return fmt.Errorf("partial close failed: %v", err)
 	}
 
@@ -1279,12 +1408,12 @@ func (e *BinanceExchange) handlePartialClose(d Decision) error {
 	
 	// 如果是 100% 平仓，也清理记录并尝试清理止盈/止损挂单
 	if pct >= 99.9 {
-		delete(e.positionPeakPnL, symbol)
+		delete(e.positionPeakPnL, positionKey(symbol, currentPos.Side))
 
-		if err := e.CancelStopLossOrders(symbol); err != nil {
+		if err := e.CancelStopLossOrdersForSide(symbol, currentPos.Side); err != nil {
 			log.Printf("⚠️ 部分平仓(≈100%%)后取消止损挂单失败 %s: %v", symbol, err)
 		}
-		if err := e.CancelTakeProfitOrders(symbol); err != nil {
+		if err := e.CancelTakeProfitOrdersForSide(symbol, currentPos.Side); err != nil {
 			log.Printf("⚠️ 部分平仓(≈100%%)后取消止盈挂单失败 %s: %v", symbol, err)
 		}
 	}
@@ -1293,25 +1422,27 @@ func (e *BinanceExchange) handlePartialClose(d Decision) error {
 
 // CancelStopLossOrders 取消所有止损单
 func (e *BinanceExchange) CancelStopLossOrders(symbol string) error {
-	ctx, cancel := newAPICtx()
-	defer cancel()
-
-	openOrders, err := e.Client.NewListOpenOrdersService().Symbol(symbol).Do(ctx)
-	if err != nil {
-		return err
-	}
-	for _, o := range openOrders {
-		if o.Type == futures.OrderTypeStopMarket || o.Type == futures.OrderTypeStop {
-			cCtx, cCancel := newAPICtx()
-			_, _ = e.Client.NewCancelOrderService().Symbol(symbol).OrderID(o.OrderID).Do(cCtx)
-			cCancel()
-		}
-	}
-	return nil
+	return e.cancelOrdersByType(symbol, "", futures.OrderTypeStopMarket, futures.OrderTypeStop)
 }
 
 // CancelTakeProfitOrders 取消所有止盈单
 func (e *BinanceExchange) CancelTakeProfitOrders(symbol string) error {
+	return e.cancelOrdersByType(symbol, "", futures.OrderTypeTakeProfitMarket, futures.OrderTypeTakeProfit)
+}
+
+// CancelStopLossOrdersForSide 取消指定方向（long/short）的止损单；单向模式下等同于 CancelStopLossOrders
+func (e *BinanceExchange) CancelStopLossOrdersForSide(symbol, side string) error {
+	return e.cancelOrdersByType(symbol, side, futures.OrderTypeStopMarket, futures.OrderTypeStop)
+}
+
+// CancelTakeProfitOrdersForSide 取消指定方向（long/short）的止盈单；单向模式下等同于 CancelTakeProfitOrders
+func (e *BinanceExchange) CancelTakeProfitOrdersForSide(symbol, side string) error {
+	return e.cancelOrdersByType(symbol, side, futures.OrderTypeTakeProfitMarket, futures.OrderTypeTakeProfit)
+}
+
+// cancelOrdersByType 取消指定类型的挂单。
+// side 非空且处于对冲模式时，仅取消该方向（positionSide）的挂单，另一条腿的保护单保持不动。
+func (e *BinanceExchange) cancelOrdersByType(symbol, side string, types ...futures.OrderType) error {
 	ctx, cancel := newAPICtx()
 	defer cancel()
 
@@ -1319,11 +1450,28 @@ func (e *BinanceExchange) CancelTakeProfitOrders(symbol string) error {
 	if err != nil {
 		return err
 	}
+
+	var wantPosSide futures.PositionSideType
+	if e.DualSidePosition {
+		switch normalizePositionSide(side) {
+		case "long":
+			wantPosSide = futures.PositionSideTypeLong
+		case "short":
+			wantPosSide = futures.PositionSideTypeShort
+		}
+	}
+
 	for _, o := range openOrders {
-		if o.Type == futures.OrderTypeTakeProfitMarket || o.Type == futures.OrderTypeTakeProfit {
-			cCtx, cCancel := newAPICtx()
-			_, _ = e.Client.NewCancelOrderService().Symbol(symbol).OrderID(o.OrderID).Do(cCtx)
-			cCancel()
+		if wantPosSide != "" && o.PositionSide != wantPosSide {
+			continue
+		}
+		for _, t := range types {
+			if o.Type == t {
+				cCtx, cCancel := newAPICtx()
+				_, _ = e.Client.NewCancelOrderService().Symbol(symbol).OrderID(o.OrderID).Do(cCtx)
+				cCancel()
+				break
+			}
 		}
 	}
 	return nil
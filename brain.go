@@ -34,6 +34,8 @@ type AIBrain struct {
 	APIURL  string
 	Model   string
 	Client  *http.Client
+	// Source 调用来源，用于调用计量（见 usage.go）：live / shadow:<name> / reflection，为空时记为 live
+	Source  string
 }
 
 func NewAIBrain(apiKey, apiURL, model, proxyURL string) *AIBrain {
@@ -65,11 +67,17 @@ func NewAIBrain(apiKey, apiURL, model, proxyURL string) *AIBrain {
 // GetDecision 获取决策
 func (b *AIBrain) GetDecision(ctx *Context) (*FullDecision, error) {
 	// 1. 构建 Prompts
-	systemPrompt := buildSystemPrompt(ctx.Account.TotalEquity)
+	systemPrompt := buildSystemPrompt(ctx)
 	userPrompt := buildUserPrompt(ctx)
 
 	// 2. 调用 AI
-	response, err := b.callAI(systemPrompt, userPrompt)
+	strategyName := ""
+	if ctx.Strategy != nil {
+		strategyName = ctx.Strategy.Name
+	} else if sm := GetStrategyManager(); sm != nil {
+		strategyName = sm.GetActiveStrategyName()
+	}
+	response, usage, err := b.callAI(strategyName, systemPrompt, userPrompt)
 	if err != nil {
 		return nil, err
 	}
@@ -83,23 +91,30 @@ func (b *AIBrain) GetDecision(ctx *Context) (*FullDecision, error) {
 	fullDecision.SystemPrompt = systemPrompt
 	fullDecision.UserPrompt = userPrompt
 	fullDecision.Timestamp = time.Now()
+	fullDecision.Usage = usage
 
 	return fullDecision, nil
 }
 
-func buildSystemPrompt(accountEquity float64) string {
+func buildSystemPrompt(ctx *Context) string {
+	return buildSystemPromptFor(ctx.Strategy, ctx)
+}
+
+// buildSystemPromptFor 按指定策略构建 system prompt（strategy 为 nil 时使用当前策略），Web / CLI 预览复用同一逻辑
+func buildSystemPromptFor(strategy *Strategy, ctx *Context) string {
 	var sb strings.Builder
 
-	// 获取当前策略配置
+	// 获取策略配置
 	var riskCfg RiskConfig
 	var strategyName, strategyDesc string
 	sm := GetStrategyManager()
-	if sm != nil {
-		riskCfg = sm.GetRiskConfig()
-		if strategy := sm.GetActiveStrategy(); strategy != nil {
-			strategyName = strategy.Name
-			strategyDesc = strategy.Description
-		}
+	if strategy == nil && sm != nil {
+		strategy = sm.GetActiveStrategy()
+	}
+	if strategy != nil {
+		riskCfg = strategy.RiskParams
+		strategyName = strategy.Name
+		strategyDesc = strategy.Description
 	} else {
 		// 回退默认配置
 		riskCfg = RiskConfig{
@@ -113,10 +128,15 @@ func buildSystemPrompt(accountEquity float64) string {
 		strategyDesc = "中等风险，稳健交易"
 	}
 
-	// 1. 尝试加载策略专属 prompt 模板
+	// 1. 尝试渲染策略专属 prompt 模板（Go 模板，可引用风控参数 / 交易对 / 净值等，见 prompt_template.go）
 	promptLoaded := false
 	if sm != nil {
-		if promptContent, err := sm.GetPromptContent(); err == nil && promptContent != "" {
+		var equity float64
+		if ctx != nil {
+			equity = ctx.Account.TotalEquity
+		}
+		data := newPromptTemplateData(strategy, contextSymbols(ctx), equity, time.Now())
+		if promptContent, err := sm.RenderPrompt(strategy, data); err == nil && promptContent != "" {
 			sb.WriteString(promptContent)
 			sb.WriteString("\n")
 			promptLoaded = true
@@ -141,13 +161,53 @@ func buildSystemPrompt(accountEquity float64) string {
 	sb.WriteString(fmt.Sprintf("策略名称: %s (%s)\n\n", strategyName, strategyDesc))
 	
 	sb.WriteString("## 风控参数（由后端强制执行）\n")
-	sb.WriteString(fmt.Sprintf("- **固定杠杆**: %dx（你不能通过改变杠杆来控制风险，只能通过仓位大小和止损位置）\n", riskCfg.FixedLeverage))
+	sb.WriteString(fmt.Sprintf("- **杠杆**: 由后端按币种强制（策略默认 %dx，单币种设置与交易所上限见每轮的「杠杆」行）；你不能通过改变杠杆来控制风险，只能通过仓位大小和止损位置\n", riskCfg.FixedLeverage))
 	sb.WriteString(fmt.Sprintf("- **单笔最大风险**: %.0f%% 账户净值\n", riskCfg.MaxRiskPerTrade*100))
 	sb.WriteString(fmt.Sprintf("- **总风险上限**: %.0f%% 账户净值（一轮内所有新开仓合计）\n", riskCfg.MaxTotalRisk*100))
 	sb.WriteString(fmt.Sprintf("- **最小风险回报比**: %.1f:1\n", riskCfg.MinRiskRewardRatio))
 	sb.WriteString(fmt.Sprintf("- **最大保证金使用率**: %.0f%%\n", riskCfg.MaxMarginUsage*100))
-	sb.WriteString(fmt.Sprintf("- **止损 ATR 倍数参考**: %.1fx\n", riskCfg.StopLossATRMultiple))
+	if sc := riskCfg.Sizing; sc != nil && sc.Mode != "" && sc.Mode != SizingModeAI {
+		sb.WriteString(fmt.Sprintf("- **仓位由后端计算** (%s)：你只需给出方向、止损、止盈和 confidence (0-1)，position_size_usd / position_percent 会被忽略", sc.Mode))
+		sb.WriteString("\n")
+	}
+	if sc := riskCfg.Sizing; sc != nil && sc.ConvictionScaling {
+		sb.WriteString("- **置信度缩放仓位**: 后端按你给出的 confidence (0-1) 在历史同档位交易中的实际胜率（校准值）缩放仓位（0 对应一半仓位），请如实给出 confidence，过度自信不会带来更大仓位\n")
+	}
+	if riskCfg.StopLossATRMultiple > 0 {
+		tightAction := "自动放宽到该距离"
+		if riskCfg.TightStopAction == tightStopReject {
+			tightAction = "直接拒绝开仓"
+		}
+		sb.WriteString(fmt.Sprintf("- **止损最小距离**: %.1f × ATR14 (%s)，低于该距离将%s；未提供止损时后端按该距离自动设置\n",
+			riskCfg.StopLossATRMultiple, normalizeATRTimeframe(riskCfg.StopATRTimeframe), tightAction))
+	}
+	if rules := formatPositionRules(positionRulesFor(riskCfg)); rules != "" {
+		sb.WriteString(fmt.Sprintf("- **后端持仓管理**: %s（每轮 AI 调用前自动执行）\n", rules))
+	}
+	sb.WriteString(fmt.Sprintf("- **强平距离**: 止损距入场不得超过预估强平距离的 %.0f%%（按维持保证金档位估算），否则后端会降低杠杆并同比例缩小仓位\n", (1-liquidationBuffer)*100))
+	if riskCfg.MaxOpenPositions > 0 || riskCfg.SymbolCooldownMinutes > 0 || riskCfg.MaxOpensPerSymbolPerDay > 0 || riskCfg.NoFlipMinutes > 0 {
+		sb.WriteString(fmt.Sprintf("- **开仓限制**: 最多同时持有 %d 个仓位；止损出局后同币种冷却 %d 分钟；单币种每日最多开仓 %d 次；%d 分钟内禁止同币种反手（0 表示不限制，被限制的开仓会被改为观望）\n",
+			riskCfg.MaxOpenPositions, riskCfg.SymbolCooldownMinutes, riskCfg.MaxOpensPerSymbolPerDay, riskCfg.NoFlipMinutes))
+	}
+	if riskCfg.FundingShrinkRate > 0 || riskCfg.FundingBlockRate > 0 || riskCfg.FundingAvoidMinutes > 0 {
+		sb.WriteString(fmt.Sprintf("- **资金费率**: 付费方向（费率为正时做多、为负时做空）单次费率 ≥ %.3f%% 新开仓减半，≥ %.3f%% 禁止开仓；距结算不足 %d 分钟不开付费方向仓位；风险回报比按预期持仓 %.0f 小时扣除资金费成本\n",
+			riskCfg.FundingShrinkRate*100, riskCfg.FundingBlockRate*100, riskCfg.FundingAvoidMinutes, riskCfg.FundingHoldHours))
+	}
 	
+	// 失效条件语法：后端会解析并在每个周期及实时价格推送时求值，成立即自动平仓
+	sb.WriteString("\n## 失效条件 (invalidation_condition) 语法\n")
+	sb.WriteString("开仓 / hold / update_* 时可给出 invalidation_condition，后端会持续监控，条件成立立即自动平仓，无需等待下一轮决策。\n")
+	sb.WriteString("- 格式: `字段 比较符 数值或字段`，可用 AND / OR / 括号组合，支持 + - * / 运算；比较符: < <= > >=\n")
+	sb.WriteString(fmt.Sprintf("- 可用字段: %s（close_* 为该周期最近一根已收盘 K 线收盘价）\n", strings.Join(conditionFieldNames(), ", ")))
+	sb.WriteString("- 示例: `close_4h < 62000`、`close_1h < ema20_1h - 1.5 * atr14_1h AND rsi14_1h < 40`\n")
+	sb.WriteString("- 无法解析的条件只会保存为文本，不会被执行\n")
+
+	// 启用平仓复盘时：开仓可附带形态标签，复盘与经验教训按形态归类
+	if GetReflectionJournal() != nil {
+		sb.WriteString("\n## 形态标签 (setup)\n")
+		sb.WriteString("开仓时可给出 setup（小写下划线，如 breakout / pullback / mean_reversion / trend_follow / funding_fade），平仓复盘和「经验教训」会按形态归类，持仓形态相同的教训优先展示。\n")
+	}
+
 	// 根据策略类型添加特定指导
 	sb.WriteString("\n## 策略指导\n")
 	switch strategyName {
@@ -196,6 +256,19 @@ func buildUserPrompt(ctx *Context) string {
 		ctx.Account.MarginUsedPct,
 		ctx.Account.PositionCount))
 	
+	// 各交易对本轮使用的杠杆（后端强制，模型给出的 leverage 会被覆盖）
+	if len(ctx.Leverage) > 0 {
+		parts := make([]string, 0, len(ctx.Leverage))
+		for _, l := range ctx.Leverage {
+			item := fmt.Sprintf("%s %dx", l.Symbol, l.Leverage)
+			if l.Source != LeverageSourceStrategy {
+				item += " (" + l.Source + ")"
+			}
+			parts = append(parts, item)
+		}
+		sb.WriteString(fmt.Sprintf("杠杆（后端强制）: %s\n", strings.Join(parts, " | ")))
+	}
+	
 	// 板块热度 (新增)
 	if len(ctx.Sectors) > 0 {
 		sb.WriteString("## Sector Heatmap (1h/4h Change)\n")
@@ -206,9 +279,64 @@ func buildUserPrompt(ctx *Context) string {
 		sb.WriteString("\n")
 	}
 
+	// 账户级熔断状态（生效时后端会拦截或缩小新开仓）
+	state := ctx.riskState()
+	if cb := state.Breaker; cb != nil {
+		if r := cb.Restrictions(); len(r.Reasons) > 0 {
+			sb.WriteString(fmt.Sprintf("⚠️ 熔断生效中: %s\n\n", strings.Join(r.Reasons, "; ")))
+		}
+	}
+
+	// 开仓限制：持仓数上限，以及冷却 / 次数上限 / 禁止反手的币种（后端会把这些开仓改为观望）
+	riskCfg := ctx.riskConfig()
+	var blocks []SymbolBlock
+	if g := state.Guard; g != nil {
+		blocks = g.BlockedSymbols(riskCfg, time.Now())
+	}
+	if riskCfg.MaxOpenPositions > 0 || len(blocks) > 0 {
+		sb.WriteString("## 开仓限制\n")
+		if riskCfg.MaxOpenPositions > 0 {
+			sb.WriteString(fmt.Sprintf("持仓数: %d / 上限 %d（达到上限后只能对已有持仓加仓）\n", len(ctx.Positions), riskCfg.MaxOpenPositions))
+		}
+		for _, b := range blocks {
+			scope := "多空均禁止"
+			if b.Side != "" {
+				scope = "禁止 " + strings.ToUpper(b.Side)
+			}
+			sb.WriteString(fmt.Sprintf("- %s %s: %s，剩余 %s（请勿提出该开仓）\n", b.Symbol, scope, b.Reason, formatRemaining(b.Remaining)))
+		}
+		sb.WriteString("\n")
+	}
+
+	// 后端持仓管理规则在本轮 AI 调用前执行的动作
+	if len(ctx.PositionEvents) > 0 {
+		sb.WriteString("## 后端持仓管理动作（本轮已执行）\n")
+		for _, ev := range ctx.PositionEvents {
+			status := "已执行"
+			if !ev.Executed {
+				status = "执行失败: " + ev.ExecErr
+			}
+			sb.WriteString(fmt.Sprintf("- %s %s [%s] %s: %s (%s)\n", ev.Symbol, strings.ToUpper(ev.Side), ev.Rule, ev.Action, ev.Reason, status))
+		}
+		sb.WriteString("\n")
+	}
+
+	// 组合敞口（后端会按策略上限对新开仓缩仓或改为观望）
+	if p := ctx.Portfolio; p != nil && len(p.Legs) > 0 {
+		sb.WriteString(fmt.Sprintf("组合敞口: 多 %.0f U | 空 %.0f U | 净 %+.2fx (上限 %.1fx) | 相关性调整 %.2fx (上限 %.1fx)\n",
+			p.LongNotional, p.ShortNotional, p.NetExposure, riskCfg.MaxNetExposure, p.CorrelatedExposure, riskCfg.MaxCorrelatedExposure))
+		sb.WriteString(fmt.Sprintf("板块敞口 (上限 %.1fx): %s\n\n", riskCfg.MaxSectorExposure, formatPortfolioSectors(p)))
+	}
+
+	// 对冲模式提示：同一币种可能同时存在多空两条腿，调整类动作必须显式指定 side
+	if ctx.HedgeMode {
+		sb.WriteString("持仓模式: 对冲模式 (Hedge Mode) —— 同一币种可同时持有 LONG 和 SHORT 两条腿；partial_close / update_stop_loss / update_take_profit 必须附带 \"side\": \"long\" 或 \"short\" 指明作用的持仓腿\n\n")
+	}
+
 	// 持仓信息
 	if len(ctx.Positions) > 0 {
 		sb.WriteString("## 当前持仓\n")
+		shownMarketData := make(map[string]bool)
 		for i, pos := range ctx.Positions {
 			// 计算持仓时长
 			holdingDuration := ""
@@ -230,31 +358,56 @@ func buildUserPrompt(ctx *Context) string {
 				pos.EntryPrice, pos.MarkPrice, pos.Quantity, positionValue,
 				pos.UnrealizedPnL, pos.UnrealizedPnLPct, pos.PeakPnLPct, 
 				pos.Leverage, pos.LiquidationPrice, holdingDuration))
+			if u := GetSymbolUniverse(); u != nil && !u.IsTradable(pos.Symbol) {
+				sb.WriteString("   ⚠️ 该交易对已不在当前策略交易对中：只能持有、调整止损止盈或平仓，不能加仓\n")
+			}
+			if monitor := state.Invalidation; monitor != nil {
+				if rule, ok := monitor.Get(pos.Symbol, pos.Side); ok {
+					if rule.ParseError != "" {
+						sb.WriteString(fmt.Sprintf("   失效条件: %s (无法解析，未监控: %s)\n\n", rule.Expr, rule.ParseError))
+					} else {
+						sb.WriteString(fmt.Sprintf("   失效条件: %s (监控中)\n\n", rule.Expr))
+					}
+				}
+			}
 			
-			// 附带该持仓币种的最新市场数据
+			// 附带该持仓币种的最新市场数据（对冲模式下同一币种两条腿只展示一次）
+			if shownMarketData[pos.Symbol] {
+				continue
+			}
 			if marketData, ok := ctx.MarketDataMap[pos.Symbol]; ok {
 				sb.WriteString(formatMarketData(marketData))
 				sb.WriteString("\n")
+				shownMarketData[pos.Symbol] = true
 			}
 		}
 	} else {
 		sb.WriteString("当前持仓: 无\n\n")
 	}
 
-	// 候选币种 (排除已持仓的)
-	sb.WriteString(fmt.Sprintf("## 候选币种 (%d个)\n\n", len(ctx.MarketDataMap)-len(ctx.Positions)))
-	displayedCount := 0
-	
-	// 先建立持仓索引
+	// 交易记忆（策略开启 memory 时）：持仓的开仓理由、连胜 / 连败、最近平仓
+	sb.WriteString(buildTradeMemory(ctx))
+	// 平仓复盘提炼的相关教训（启用 reflection 时）
+	sb.WriteString(buildLessonsSection(ctx))
+
+	// 先建立持仓索引（按币种，对冲模式下一个币种可能对应两条持仓腿）
 	holdingMap := make(map[string]bool)
 	for _, p := range ctx.Positions {
 		holdingMap[p.Symbol] = true
 	}
 
-	for symbol, data := range ctx.MarketDataMap {
-		if holdingMap[symbol] {
-			continue // 已在持仓部分展示过
+	// 候选币种 (本轮交易对中排除已持仓的；已移出策略的交易对只在持仓部分展示)
+	var candidates []string
+	for _, symbol := range contextSymbols(ctx) {
+		if data, ok := ctx.MarketDataMap[symbol]; ok && data != nil && !holdingMap[symbol] {
+			candidates = append(candidates, symbol)
 		}
+	}
+	sb.WriteString(fmt.Sprintf("## 候选币种 (%d个)\n\n", len(candidates)))
+	displayedCount := 0
+
+	for _, symbol := range candidates {
+		data := ctx.MarketDataMap[symbol]
 		displayedCount++
 		
 		// 模拟 nofx 的 Source 标签展示
@@ -306,7 +459,11 @@ func formatMarketData(data *MarketData) string {
 			oiLatestStr, oiAverageStr, data.OpenInterest.Change1h, data.OpenInterest.Change4h))
 	}
 
-	sb.WriteString(fmt.Sprintf("Funding Rate: %.2e\\n\\n", data.FundingRate))
+	if left, ok := untilNextFunding(data, time.Now()); ok {
+		sb.WriteString(fmt.Sprintf("Funding Rate: %.2e (next settlement in %s)\\n\\n", data.FundingRate, formatRemaining(left)))
+	} else {
+		sb.WriteString(fmt.Sprintf("Funding Rate: %.2e\\n\\n", data.FundingRate))
+	}
 
 	// 成交量与情绪分析
 	if data.VolumeAnalysis != nil {
@@ -412,9 +569,32 @@ func formatFloatSlice(values []float64) string {
 	return "[" + strings.Join(strValues, ", ") + "]"
 }
 
-func (b *AIBrain) callAI(systemPrompt, userPrompt string) (string, error) {
+// callAI 调用 OpenAI 兼容接口；每次调用（含失败）的 token、耗时与成本记入调用计量，
+// 超出每日预算且配置了降级时实盘决策改用 fallback 模型（影子策略 / 复盘可能使用其他 API，保持原模型）。
+// strategy 为本次调用所属的策略
+func (b *AIBrain) callAI(strategy, systemPrompt, userPrompt string) (content string, usage *ModelUsage, err error) {
+	tracker := GetUsageTracker()
+	model := b.Model
+	if b.Source == "" {
+		model = tracker.EffectiveModel(b.Model)
+	}
+	start := time.Now()
+	usage = &ModelUsage{Model: model}
+	defer func() {
+		usage.LatencyMs = time.Since(start).Milliseconds()
+		usage.CostUSD = tracker.Cost(model, usage.PromptTokens, usage.CompletionTokens)
+		rec := ModelCallRecord{Time: start, Source: b.Source, Strategy: strategy, ModelUsage: *usage}
+		if rec.Source == "" {
+			rec.Source = "live"
+		}
+		if err != nil {
+			rec.Error = err.Error()
+		}
+		tracker.Record(rec)
+	}()
+
 	requestBody, _ := json.Marshal(map[string]interface{}{
-		"model": b.Model,
+		"model": model,
 		"messages": []map[string]string{
 			{"role": "system", "content": systemPrompt},
 			{"role": "user", "content": userPrompt},
@@ -428,17 +608,17 @@ func (b *AIBrain) callAI(systemPrompt, userPrompt string) (string, error) {
 
 	resp, err := b.Client.Do(req)
 	if err != nil {
-		return "", err
+		return "", usage, err
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("failed to read response body: %w", err)
+		return "", usage, fmt.Errorf("failed to read response body: %w", err)
 	}
 
 	if resp.StatusCode != 200 {
-		return "", fmt.Errorf("API Error (Status %d): %s", resp.StatusCode, string(body))
+		return "", usage, fmt.Errorf("API Error (Status %d): %s", resp.StatusCode, string(body))
 	}
 
 	// 首先按 OpenAI/DeepSeek 兼容结构解析
@@ -448,19 +628,25 @@ func (b *AIBrain) callAI(systemPrompt, userPrompt string) (string, error) {
 				Content string `json:"content"`
 			} `json:"message"`
 		} `json:"choices"`
+		Usage struct {
+			PromptTokens     int `json:"prompt_tokens"`
+			CompletionTokens int `json:"completion_tokens"`
+		} `json:"usage"`
 	}
 	if err := json.Unmarshal(body, &result); err != nil {
 		log.Printf("AI JSON 解析失败: %v, body=%s", err, string(body))
-		return "", fmt.Errorf("AI response parse error")
+		return "", usage, fmt.Errorf("AI response parse error")
 	}
+	usage.PromptTokens = result.Usage.PromptTokens
+	usage.CompletionTokens = result.Usage.CompletionTokens
 	
 	if len(result.Choices) == 0 {
 		// 打印原始响应，帮助诊断是配额/鉴权还是其他错误
 		log.Printf("AI 返回了空 choices，原始响应: %s", string(body))
-		return "", fmt.Errorf("No response from AI: empty choices")
+		return "", usage, fmt.Errorf("No response from AI: empty choices")
 	}
 
-	return result.Choices[0].Message.Content, nil
+	return result.Choices[0].Message.Content, usage, nil
 }
 
 func parseAIResponse(response string) (*FullDecision, error) {
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// calibrationBucketWidth 置信度分桶宽度（0-1 区间按 0.1 分为 10 桶）
+	calibrationBucketWidth = 0.1
+	// calibrationPriorWeight 校准时对 AI 声明置信度的先验权重（相当于 N 笔虚拟样本），样本少时结果接近声明值
+	calibrationPriorWeight = 10.0
+	// maxCalibrationSamples 最多保留的已平仓样本数
+	maxCalibrationSamples = 1000
+)
+
+// calibrationOpen 一条已开仓、尚未平仓的持仓腿及开仓时声明的置信度
+type calibrationOpen struct {
+	Symbol     string    `json:"symbol"`
+	Side       string    `json:"side"`
+	Confidence float64   `json:"confidence"` // 归一化到 0-1
+	Entry      float64   `json:"entry"`
+	Stop       float64   `json:"stop"`
+	OpenedAt   time.Time `json:"opened_at"`
+	LastMark   float64   `json:"last_mark"`            // 最近一次观察到的标记价格，找不到平仓记录时用作平仓价
+	ExitPrice  float64   `json:"exit_price,omitempty"` // 上次 Observe 之后新增的该腿平仓记录中最新的平仓价
+}
+
+// CalibrationSample 一笔已平仓交易的声明置信度与实际结果
+type CalibrationSample struct {
+	Symbol     string    `json:"symbol"`
+	Side       string    `json:"side"`
+	Confidence float64   `json:"confidence"`
+	Win        bool      `json:"win"`
+	RMultiple  float64   `json:"r_multiple"` // (平仓价 - 入场价) / 初始止损距离，按方向取符号
+	ClosedAt   time.Time `json:"closed_at"`
+}
+
+// CalibrationBucket 校准曲线上的一个置信度区间
+type CalibrationBucket struct {
+	Lower         float64 `json:"lower"`
+	Upper         float64 `json:"upper"`
+	Count         int     `json:"count"`
+	AvgStated     float64 `json:"avg_stated"`     // 区间内声明置信度均值
+	WinRate       float64 `json:"win_rate"`       // 实际胜率 (0-1)
+	AvgR          float64 `json:"avg_r"`          // 平均 R 倍数
+	CalibratedWin float64 `json:"calibrated_win"` // 以声明置信度为先验平滑后的胜率，用于仓位缩放
+}
+
+type calibrationState struct {
+	Open       map[string]*calibrationOpen `json:"open"` // key: positionKey(symbol, side)
+	Samples    []CalibrationSample         `json:"samples"`
+	SeenTrades []string                    `json:"seen_trades"` // 已处理的平仓记录键，用于识别新增平仓记录（见 unseenTradeRecords）
+}
+
+// ConfidenceCalibrator 按 AI 声明的置信度分桶统计实际胜率与 R 倍数，
+// 并用校准后的置信度（而非声明值）缩放仓位
+type ConfidenceCalibrator struct {
+	mu       sync.Mutex
+	filePath string
+	state    calibrationState
+}
+
+// NewConfidenceCalibrator 创建校准器并从 filePath 恢复状态
+func NewConfidenceCalibrator(filePath string) *ConfidenceCalibrator {
+	c := &ConfidenceCalibrator{
+		filePath: filePath,
+		state:    calibrationState{Open: make(map[string]*calibrationOpen)},
+	}
+	if data, err := os.ReadFile(filePath); err == nil {
+		var st calibrationState
+		if err := json.Unmarshal(data, &st); err != nil {
+			log.Printf("⚠️ [Calibration] 解析 %s 失败: %v", filePath, err)
+		} else {
+			if st.Open == nil {
+				st.Open = make(map[string]*calibrationOpen)
+			}
+			c.state = st
+		}
+	}
+	return c
+}
+
+// saveLocked 持久化状态（调用方需持有锁）
+func (c *ConfidenceCalibrator) saveLocked() {
+	if c.filePath == "" {
+		return
+	}
+	if err := writeJSONFile(c.filePath, c.state); err != nil {
+		log.Printf("⚠️ [Calibration] 保存 %s 失败: %v", c.filePath, err)
+	}
+}
+
+// RecordOpen 记录一次带置信度的开仓；同一持仓腿加仓时保留首次开仓的置信度。
+// 先消费 history 中新增的平仓记录：持仓腿在上次 Observe 之后已平仓（同一周期内平仓后重开）时，
+// 先按该平仓记录结算旧腿，再按新的一笔交易记录
+func (c *ConfidenceCalibrator) RecordOpen(d Decision, entry float64, history []TradeRecord, now time.Time) {
+	if d.Confidence <= 0 || entry <= 0 {
+		return
+	}
+	side := decisionPositionSide(d)
+	key := positionKey(d.Symbol, side)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.consumeClosesLocked(history)
+	if o, ok := c.state.Open[key]; ok {
+		if o.ExitPrice <= 0 {
+			return
+		}
+		delete(c.state.Open, key)
+		c.settleLocked(o, o.ExitPrice, now)
+	}
+	c.state.Open[key] = &calibrationOpen{
+		Symbol:     d.Symbol,
+		Side:       side,
+		Confidence: normalizedConfidence(d.Confidence),
+		Entry:      entry,
+		Stop:       d.StopLoss,
+		OpenedAt:   now,
+		LastMark:   entry,
+	}
+	c.saveLocked()
+}
+
+// consumeClosesLocked 新增的平仓记录按持仓腿记下最新的平仓价（调用方需持有锁）
+func (c *ConfidenceCalibrator) consumeClosesLocked(history []TradeRecord) {
+	var newRecords []TradeRecord
+	newRecords, c.state.SeenTrades = unseenTradeRecords(history, c.state.SeenTrades)
+	for _, r := range newRecords {
+		if o, ok := c.state.Open[positionKey(r.Symbol, normalizePositionSide(r.Side))]; ok && r.ExitPrice > 0 {
+			o.ExitPrice = r.ExitPrice
+		}
+	}
+}
+
+// Observe 每个周期调用：已消失的持仓腿视为平仓，平仓价优先取新增的平仓记录，否则取最近一次标记价格
+func (c *ConfidenceCalibrator) Observe(positions []PositionInfo, history []TradeRecord, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.consumeClosesLocked(history)
+
+	alive := make(map[string]bool, len(positions))
+	for _, p := range positions {
+		key := positionKey(p.Symbol, normalizePositionSide(p.Side))
+		alive[key] = true
+		if o, ok := c.state.Open[key]; ok {
+			// 仍在持仓：新增的平仓记录只是部分平仓
+			o.ExitPrice = 0
+			if p.MarkPrice > 0 {
+				o.LastMark = p.MarkPrice
+			}
+		}
+	}
+
+	for key, o := range c.state.Open {
+		if alive[key] {
+			continue
+		}
+		exit := o.LastMark
+		if o.ExitPrice > 0 {
+			exit = o.ExitPrice
+		}
+		delete(c.state.Open, key)
+		c.settleLocked(o, exit, now)
+	}
+	c.saveLocked()
+}
+
+// settleLocked 按平仓价计算 R 倍数并记为一个校准样本；没有有效初始止损的持仓腿不计入（调用方需持有锁）
+func (c *ConfidenceCalibrator) settleLocked(o *calibrationOpen, exit float64, now time.Time) {
+	dir := 1.0
+	if o.Side == "short" {
+		dir = -1.0
+	}
+	risk := math.Abs(o.Entry - o.Stop)
+	if o.Stop <= 0 || risk <= 0 {
+		return
+	}
+	r := dir * (exit - o.Entry) / risk
+	c.state.Samples = append(c.state.Samples, CalibrationSample{
+		Symbol:     o.Symbol,
+		Side:       o.Side,
+		Confidence: o.Confidence,
+		Win:        r > 0,
+		RMultiple:  r,
+		ClosedAt:   now,
+	})
+	if n := len(c.state.Samples); n > maxCalibrationSamples {
+		c.state.Samples = c.state.Samples[n-maxCalibrationSamples:]
+	}
+	log.Printf("📐 [Calibration] %s %s 平仓: 声明置信度 %.2f, R=%.2f", o.Symbol, o.Side, o.Confidence, r)
+}
+
+// bucketIndex 置信度所在分桶
+func bucketIndex(conf float64) int {
+	n := int(math.Round(1 / calibrationBucketWidth))
+	i := int(conf / calibrationBucketWidth)
+	if i >= n {
+		i = n - 1
+	}
+	if i < 0 {
+		i = 0
+	}
+	return i
+}
+
+// Curve 返回校准曲线（每个置信度区间的样本数、实际胜率、平均 R 和平滑后的胜率）
+func (c *ConfidenceCalibrator) Curve() []CalibrationBucket {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := int(math.Round(1 / calibrationBucketWidth))
+	buckets := make([]CalibrationBucket, n)
+	wins := make([]int, n)
+	sumR := make([]float64, n)
+	sumStated := make([]float64, n)
+	for i := range buckets {
+		buckets[i].Lower = float64(i) * calibrationBucketWidth
+		buckets[i].Upper = float64(i+1) * calibrationBucketWidth
+	}
+	for _, s := range c.state.Samples {
+		i := bucketIndex(s.Confidence)
+		buckets[i].Count++
+		sumStated[i] += s.Confidence
+		sumR[i] += s.RMultiple
+		if s.Win {
+			wins[i]++
+		}
+	}
+	for i := range buckets {
+		b := &buckets[i]
+		prior := (b.Lower + b.Upper) / 2
+		if b.Count > 0 {
+			cnt := float64(b.Count)
+			b.AvgStated = sumStated[i] / cnt
+			b.WinRate = float64(wins[i]) / cnt
+			b.AvgR = sumR[i] / cnt
+			prior = b.AvgStated
+		}
+		b.CalibratedWin = (float64(wins[i]) + calibrationPriorWeight*prior) / (float64(b.Count) + calibrationPriorWeight)
+	}
+	return buckets
+}
+
+// Calibrate 将 AI 声明的置信度映射为校准后的置信度：所在区间的实际胜率，以声明值为先验做平滑
+func (c *ConfidenceCalibrator) Calibrate(stated float64) float64 {
+	conf := normalizedConfidence(stated)
+	c.mu.Lock()
+	var wins, count int
+	for _, s := range c.state.Samples {
+		if bucketIndex(s.Confidence) == bucketIndex(conf) {
+			count++
+			if s.Win {
+				wins++
+			}
+		}
+	}
+	c.mu.Unlock()
+	return (float64(wins) + calibrationPriorWeight*conf) / (float64(count) + calibrationPriorWeight)
+}
+
+// Summary 用于 Web API：整体样本数、胜率以及校准曲线
+func (c *ConfidenceCalibrator) Summary() map[string]interface{} {
+	curve := c.Curve()
+	total, wins := 0, 0.0
+	for _, b := range curve {
+		total += b.Count
+		wins += b.WinRate * float64(b.Count)
+	}
+	c.mu.Lock()
+	open := len(c.state.Open)
+	c.mu.Unlock()
+	summary := map[string]interface{}{
+		"samples":      total,
+		"open":         open,
+		"curve":        curve,
+		"prior_weight": calibrationPriorWeight,
+	}
+	if total > 0 {
+		summary["win_rate"] = wins / float64(total)
+	}
+	return summary
+}
+
+// calibratedConfidence 仓位缩放使用的置信度：c 不为 nil 时返回校准值，否则返回归一化后的声明值
+func calibratedConfidence(c *ConfidenceCalibrator, stated float64) float64 {
+	if c != nil && stated > 0 {
+		return c.Calibrate(stated)
+	}
+	return normalizedConfidence(stated)
+}
+
+// formatConfidence 用于日志 / 风控调整原因
+func formatConfidence(stated, calibrated float64) string {
+	return fmt.Sprintf("confidence %.2f (校准后 %.2f)", normalizedConfidence(stated), calibrated)
+}
+
+// 全局置信度校准器
+var globalCalibrator *ConfidenceCalibrator
+
+// InitGlobalCalibrator 初始化全局置信度校准器
+func InitGlobalCalibrator(filePath string) {
+	globalCalibrator = NewConfidenceCalibrator(filePath)
+}
+
+// GetCalibrator 获取全局置信度校准器（未初始化时返回 nil，例如回测）
+func GetCalibrator() *ConfidenceCalibrator {
+	return globalCalibrator
+}
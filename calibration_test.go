@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// 交易历史新记录插在最前且只保留 100 条：超过上限后仍能取到本次平仓的记录作为平仓价
+func TestCalibratorObserveUsesNewestCloseBeyondHistoryCap(t *testing.T) {
+	history := NewMemoryTradeHistoryManager()
+	c := NewConfidenceCalibrator("")
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 120; i++ {
+		history.AddRecord(TradeRecord{
+			Time:       now.Add(time.Duration(i) * time.Minute).Format("2006-01-02 15:04:05"),
+			Symbol:     "BTCUSDT",
+			Side:       "long",
+			Action:     "close_long",
+			EntryPrice: 100,
+			ExitPrice:  50, // 旧记录：若被误用，R 会是 -5
+			Quantity:   1,
+			PnL:        float64(-i - 1),
+		})
+	}
+	if n := len(history.GetHistory()); n != 100 {
+		t.Fatalf("history len = %d, want 100", n)
+	}
+	// 首次观察：已有记录全部视为旧记录
+	c.Observe(nil, history.GetHistory(), now)
+
+	for i := 0; i < 3; i++ {
+		c.RecordOpen(Decision{Symbol: "BTCUSDT", Action: "open_long", StopLoss: 90, Confidence: 0.8}, 100, history.GetHistory(), now)
+		c.Observe([]PositionInfo{{Symbol: "BTCUSDT", Side: "long", MarkPrice: 101}}, history.GetHistory(), now)
+
+		exit := 110.0 + float64(i)*10
+		history.AddRecord(TradeRecord{
+			Time:       now.Add(time.Duration(200+i) * time.Minute).Format("2006-01-02 15:04:05"),
+			Symbol:     "BTCUSDT",
+			Side:       "long",
+			Action:     "close_long",
+			EntryPrice: 100,
+			ExitPrice:  exit,
+			Quantity:   1,
+			PnL:        exit - 100,
+		})
+		c.Observe(nil, history.GetHistory(), now)
+
+		samples := c.state.Samples
+		if len(samples) != i+1 {
+			t.Fatalf("round %d: samples = %d, want %d", i, len(samples), i+1)
+		}
+		want := (exit - 100) / 10
+		if got := samples[i].RMultiple; fmt.Sprintf("%.4f", got) != fmt.Sprintf("%.4f", want) {
+			t.Fatalf("round %d: R = %.4f, want %.4f", i, got, want)
+		}
+	}
+}
+
+// 同一周期内平仓后重开：旧腿按本周期的平仓记录结算，新腿使用新的置信度、入场与止损
+func TestCalibratorRecordOpenSettlesSameCycleCloseAndReopen(t *testing.T) {
+	history := NewMemoryTradeHistoryManager()
+	c := NewConfidenceCalibrator("")
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	c.Observe(nil, history.GetHistory(), now)
+	c.RecordOpen(Decision{Symbol: "BTCUSDT", Action: "open_long", StopLoss: 90, Confidence: 0.8}, 100, history.GetHistory(), now)
+	c.Observe([]PositionInfo{{Symbol: "BTCUSDT", Side: "long", MarkPrice: 101}}, history.GetHistory(), now)
+
+	// 同一周期：AI 平仓后立即重开，两者之间没有 Observe
+	history.AddRecord(TradeRecord{
+		Time:       "00:05:00",
+		Symbol:     "BTCUSDT",
+		Side:       "long",
+		Action:     "close_long",
+		EntryPrice: 100,
+		ExitPrice:  120,
+		Quantity:   1,
+		PnL:        20,
+	})
+	c.RecordOpen(Decision{Symbol: "BTCUSDT", Action: "open_long", StopLoss: 110, Confidence: 0.3}, 120, history.GetHistory(), now)
+
+	if n := len(c.state.Samples); n != 1 {
+		t.Fatalf("samples after reopen = %d, want 1", n)
+	}
+	if s := c.state.Samples[0]; s.Confidence != 0.8 || s.RMultiple != 2 {
+		t.Fatalf("closed sample = %+v, want confidence 0.8 and R 2", s)
+	}
+	o := c.state.Open[positionKey("BTCUSDT", "long")]
+	if o == nil || o.Confidence != 0.3 || o.Entry != 120 || o.Stop != 110 {
+		t.Fatalf("reopened leg = %+v, want confidence 0.3, entry 120, stop 110", o)
+	}
+
+	// 新腿平仓：按新腿的入场与止损计算 R
+	history.AddRecord(TradeRecord{
+		Time:       "00:10:00",
+		Symbol:     "BTCUSDT",
+		Side:       "long",
+		Action:     "close_long",
+		EntryPrice: 120,
+		ExitPrice:  115,
+		Quantity:   1,
+		PnL:        -5,
+	})
+	c.Observe(nil, history.GetHistory(), now)
+	if n := len(c.state.Samples); n != 2 {
+		t.Fatalf("samples after second close = %d, want 2", n)
+	}
+	if s := c.state.Samples[1]; s.Confidence != 0.3 || s.RMultiple != -0.5 || s.Win {
+		t.Fatalf("second sample = %+v, want confidence 0.3 and R -0.5", s)
+	}
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BreakerAction 熔断触发后的处置动作
+type BreakerAction string
+
+const (
+	BreakerBlockOpens BreakerAction = "block_opens" // 禁止新开仓，允许平仓 / 调整止损
+	BreakerHalveSize  BreakerAction = "halve_size"  // 新开仓仓位减半
+	BreakerCloseAll   BreakerAction = "close_all"   // 立即平掉所有持仓，并禁止新开仓
+	BreakerHalt       BreakerAction = "halt"        // 停止交易循环，直到手动重置
+)
+
+// 熔断器名称
+const (
+	BreakerDailyLoss     = "daily_loss"
+	BreakerLosingStreak  = "losing_streak"
+	BreakerTradesPerHour = "trades_per_hour"
+	BreakerMaxDrawdown   = "max_drawdown"
+)
+
+// BreakerRule 单个熔断器配置
+//   - Threshold <= 0 表示禁用
+//   - CooldownMinutes: 触发后至少保持的时间；到期且条件解除后自动恢复（halt 动作只能手动重置）
+type BreakerRule struct {
+	Threshold       float64       `json:"threshold"`
+	Action          BreakerAction `json:"action"`
+	CooldownMinutes int           `json:"cooldown_minutes"`
+}
+
+// CircuitBreakerConfig 账户级熔断配置
+type CircuitBreakerConfig struct {
+	DailyLoss     BreakerRule `json:"daily_loss"`      // 当日亏损（已实现 + 未实现）占日初净值比例，如 0.10
+	LosingStreak  BreakerRule `json:"losing_streak"`   // 连续亏损平仓笔数
+	TradesPerHour BreakerRule `json:"trades_per_hour"` // 最近 1 小时内最多开仓次数
+	MaxDrawdown   BreakerRule `json:"max_drawdown"`    // 相对历史最高净值的回撤比例（原 defensiveMode）
+}
+
+// DefaultCircuitBreakerConfig 默认熔断配置
+func DefaultCircuitBreakerConfig() CircuitBreakerConfig {
+	return CircuitBreakerConfig{
+		DailyLoss:     BreakerRule{Threshold: 0.10, Action: BreakerBlockOpens},
+		LosingStreak:  BreakerRule{Threshold: 4, Action: BreakerHalveSize, CooldownMinutes: 120},
+		TradesPerHour: BreakerRule{Threshold: 6, Action: BreakerBlockOpens},
+		MaxDrawdown:   BreakerRule{Threshold: 0.25, Action: BreakerBlockOpens},
+	}
+}
+
+// BreakerTrip 一次熔断触发记录
+type BreakerTrip struct {
+	Name      string        `json:"name"`
+	Action    BreakerAction `json:"action"`
+	Reason    string        `json:"reason"`
+	Value     float64       `json:"value"`
+	Threshold float64       `json:"threshold"`
+	TrippedAt time.Time     `json:"tripped_at"`
+	Until     time.Time     `json:"until,omitempty"` // 冷却结束时间，零值表示条件解除即恢复
+	Executed  bool          `json:"executed"`        // close_all 是否已执行
+}
+
+// CircuitBreakerState 持久化的熔断状态
+type CircuitBreakerState struct {
+	Day               string                  `json:"day"` // 当前统计日 (UTC, YYYY-MM-DD)
+	DayStartEquity    float64                 `json:"day_start_equity"`
+	PeakEquity        float64                 `json:"peak_equity"`
+	Equity            float64                 `json:"equity"`
+	DailyLossPct      float64                 `json:"daily_loss_pct"`
+	DrawdownPct       float64                 `json:"drawdown_pct"`
+	ConsecutiveLosses int                     `json:"consecutive_losses"`
+	OpenTimes         []time.Time             `json:"open_times"`
+	SeenTrades        []string                `json:"seen_trades"`
+	Trips             map[string]*BreakerTrip `json:"trips"`
+	UpdatedAt         time.Time               `json:"updated_at"`
+}
+
+// BreakerRestrictions 当前生效的交易限制
+type BreakerRestrictions struct {
+	Halted     bool
+	BlockOpens bool
+	SizeFactor float64 // 新开仓仓位系数，1 表示不缩
+	CloseAll   bool    // 需要立即执行一次全部平仓
+	Reasons    []string
+}
+
+// CircuitBreaker 账户级熔断器
+type CircuitBreaker struct {
+	mu       sync.Mutex
+	config   CircuitBreakerConfig
+	state    CircuitBreakerState
+	filePath string
+}
+
+// NewCircuitBreaker 创建熔断器并加载持久化状态
+func NewCircuitBreaker(config CircuitBreakerConfig, filePath string) *CircuitBreaker {
+	cb := &CircuitBreaker{
+		config:   config,
+		filePath: filePath,
+		state:    CircuitBreakerState{Trips: make(map[string]*BreakerTrip)},
+	}
+	cb.load()
+	return cb
+}
+
+func (cb *CircuitBreaker) load() {
+	data, err := os.ReadFile(cb.filePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("⚠️ 加载熔断状态失败: %v", err)
+		}
+		return
+	}
+	var st CircuitBreakerState
+	if err := json.Unmarshal(data, &st); err != nil {
+		log.Printf("⚠️ 解析熔断状态失败: %v", err)
+		return
+	}
+	if st.Trips == nil {
+		st.Trips = make(map[string]*BreakerTrip)
+	}
+	cb.state = st
+	if len(st.Trips) > 0 {
+		log.Printf("⚠️ [Circuit Breaker] 已恢复 %d 个生效中的熔断: %s", len(st.Trips), strings.Join(cb.activeNamesLocked(), ", "))
+	}
+}
+
+// saveLocked 持久化当前状态（调用方需持有锁）
+func (cb *CircuitBreaker) saveLocked() {
+	if cb.filePath == "" {
+		return
+	}
+	if err := writeJSONFile(cb.filePath, cb.state); err != nil {
+		log.Printf("⚠️ 保存熔断状态失败: %v", err)
+	}
+}
+
+// Update 每个周期调用：根据净值、交易历史更新统计并评估所有熔断器，返回当前生效的限制
+func (cb *CircuitBreaker) Update(equity float64, history []TradeRecord, now time.Time) BreakerRestrictions {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	st := &cb.state
+	st.Equity = equity
+	st.UpdatedAt = now
+
+	// 日切：以 UTC 自然日为统计周期
+	today := now.UTC().Format("2006-01-02")
+	if st.Day != today || st.DayStartEquity <= 0 {
+		st.Day = today
+		st.DayStartEquity = equity
+	}
+	if equity > st.PeakEquity {
+		st.PeakEquity = equity
+	}
+	st.DailyLossPct, st.DrawdownPct = 0, 0
+	if st.DayStartEquity > 0 && equity < st.DayStartEquity {
+		st.DailyLossPct = (st.DayStartEquity - equity) / st.DayStartEquity
+	}
+	if st.PeakEquity > 0 && equity < st.PeakEquity {
+		st.DrawdownPct = (st.PeakEquity - equity) / st.PeakEquity
+	}
+
+	cb.consumeTradesLocked(history)
+
+	// 滑动窗口：仅保留最近 1 小时内的开仓时间
+	cutoff := now.Add(-time.Hour)
+	kept := st.OpenTimes[:0]
+	for _, t := range st.OpenTimes {
+		if t.After(cutoff) {
+			kept = append(kept, t)
+		}
+	}
+	st.OpenTimes = kept
+
+	cb.evaluateLocked(BreakerDailyLoss, cb.config.DailyLoss, st.DailyLossPct, now,
+		fmt.Sprintf("当日亏损 %.2f%% ≥ %.2f%%", st.DailyLossPct*100, cb.config.DailyLoss.Threshold*100))
+	cb.evaluateLocked(BreakerLosingStreak, cb.config.LosingStreak, float64(st.ConsecutiveLosses), now,
+		fmt.Sprintf("连续亏损 %d 笔 ≥ %.0f", st.ConsecutiveLosses, cb.config.LosingStreak.Threshold))
+	cb.evaluateLocked(BreakerTradesPerHour, cb.config.TradesPerHour, float64(len(st.OpenTimes)), now,
+		fmt.Sprintf("最近 1 小时开仓 %d 次 ≥ %.0f", len(st.OpenTimes), cb.config.TradesPerHour.Threshold))
+	cb.evaluateLocked(BreakerMaxDrawdown, cb.config.MaxDrawdown, st.DrawdownPct, now,
+		fmt.Sprintf("回撤 %.2f%% ≥ %.2f%%", st.DrawdownPct*100, cb.config.MaxDrawdown.Threshold*100))
+
+	cb.saveLocked()
+	return cb.restrictionsLocked()
+}
+
+// consumeTradesLocked 处理新增的平仓记录，更新连续亏损计数（history 按最新在前排列）。
+// 首次运行（新部署或新的状态文件）时已有历史只做预热、不计入连续亏损，避免一启动就触发熔断；
+// 重启后连续亏损计数随状态文件恢复
+func (cb *CircuitBreaker) consumeTradesLocked(history []TradeRecord) {
+	st := &cb.state
+	var fresh []TradeRecord
+	fresh, st.SeenTrades = unseenTradeRecords(history, st.SeenTrades)
+	for _, t := range fresh {
+		if t.PnL < 0 {
+			st.ConsecutiveLosses++
+		} else if t.PnL > 0 {
+			st.ConsecutiveLosses = 0
+		}
+	}
+}
+
+// evaluateLocked 评估单个熔断器：条件满足时触发；已触发的在冷却结束且条件解除后自动恢复（halt 除外）
+func (cb *CircuitBreaker) evaluateLocked(name string, rule BreakerRule, value float64, now time.Time, reason string) {
+	st := &cb.state
+	triggered := rule.Threshold > 0 && value >= rule.Threshold
+	trip, active := st.Trips[name]
+
+	if active {
+		if trip.Action == BreakerHalt || now.Before(trip.Until) {
+			return
+		}
+		// 连续亏损只有盈利平仓才会清零：配置了冷却时，冷却结束即解除并重新计数，
+		// 否则没有新交易时会永久处于熔断状态
+		if triggered && (name != BreakerLosingStreak || trip.Until.IsZero()) {
+			return
+		}
+		delete(st.Trips, name)
+		if name == BreakerLosingStreak {
+			st.ConsecutiveLosses = 0
+		}
+		log.Printf("✅ [Circuit Breaker] %s 已自动解除", name)
+		return
+	}
+	if !triggered {
+		return
+	}
+
+	action := rule.Action
+	if action == "" {
+		action = BreakerBlockOpens
+	}
+	trip = &BreakerTrip{
+		Name:      name,
+		Action:    action,
+		Reason:    reason,
+		Value:     value,
+		Threshold: rule.Threshold,
+		TrippedAt: now,
+	}
+	if rule.CooldownMinutes > 0 {
+		trip.Until = now.Add(time.Duration(rule.CooldownMinutes) * time.Minute)
+	}
+	st.Trips[name] = trip
+	log.Printf("🚨 [Circuit Breaker] %s 触发: %s，动作=%s", name, reason, action)
+	if n := GetNotifier(); n != nil {
+		n.NotifyCircuitBreaker(name, string(action), reason)
+	}
+}
+
+// restrictionsLocked 汇总所有生效中的熔断，得到当前交易限制
+func (cb *CircuitBreaker) restrictionsLocked() BreakerRestrictions {
+	r := BreakerRestrictions{SizeFactor: 1}
+	for _, name := range cb.activeNamesLocked() {
+		trip := cb.state.Trips[name]
+		switch trip.Action {
+		case BreakerHalt:
+			r.Halted = true
+			r.BlockOpens = true
+		case BreakerCloseAll:
+			r.BlockOpens = true
+			if !trip.Executed {
+				r.CloseAll = true
+			}
+		case BreakerHalveSize:
+			r.SizeFactor *= 0.5
+		default:
+			r.BlockOpens = true
+		}
+		r.Reasons = append(r.Reasons, fmt.Sprintf("%s(%s): %s", name, trip.Action, trip.Reason))
+	}
+	return r
+}
+
+// Restrictions 返回当前生效的交易限制（不重新评估）
+func (cb *CircuitBreaker) Restrictions() BreakerRestrictions {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.restrictionsLocked()
+}
+
+// activeNamesLocked 按名称排序的生效中熔断列表
+func (cb *CircuitBreaker) activeNamesLocked() []string {
+	names := make([]string, 0, len(cb.state.Trips))
+	for name := range cb.state.Trips {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RecordOpen 记录一次成功开仓，用于每小时开仓次数统计
+func (cb *CircuitBreaker) RecordOpen(t time.Time) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.state.OpenTimes = append(cb.state.OpenTimes, t)
+	cb.saveLocked()
+}
+
+// MarkCloseAllExecuted 标记 close_all 动作已执行，避免每个周期重复平仓
+func (cb *CircuitBreaker) MarkCloseAllExecuted() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	for _, trip := range cb.state.Trips {
+		if trip.Action == BreakerCloseAll {
+			trip.Executed = true
+		}
+	}
+	cb.saveLocked()
+}
+
+// Reset 手动重置熔断。name 为空时重置全部；同时清零对应计数，避免下一周期立即再次触发
+func (cb *CircuitBreaker) Reset(name string) error {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	st := &cb.state
+	if name != "" {
+		if _, ok := st.Trips[name]; !ok {
+			return fmt.Errorf("circuit breaker not active: %s", name)
+		}
+	}
+	for n := range st.Trips {
+		if name != "" && n != name {
+			continue
+		}
+		delete(st.Trips, n)
+		switch n {
+		case BreakerDailyLoss:
+			st.DayStartEquity = st.Equity
+		case BreakerLosingStreak:
+			st.ConsecutiveLosses = 0
+		case BreakerTradesPerHour:
+			st.OpenTimes = nil
+		case BreakerMaxDrawdown:
+			st.PeakEquity = st.Equity
+		}
+		log.Printf("🔓 [Circuit Breaker] %s 已手动重置", n)
+	}
+	cb.saveLocked()
+	return nil
+}
+
+// Snapshot 返回当前配置与状态副本，用于 Web 展示
+func (cb *CircuitBreaker) Snapshot() map[string]interface{} {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	trips := make([]BreakerTrip, 0, len(cb.state.Trips))
+	for _, name := range cb.activeNamesLocked() {
+		trips = append(trips, *cb.state.Trips[name])
+	}
+	return map[string]interface{}{
+		"config":             cb.config,
+		"day":                cb.state.Day,
+		"day_start_equity":   cb.state.DayStartEquity,
+		"peak_equity":        cb.state.PeakEquity,
+		"daily_loss_pct":     cb.state.DailyLossPct,
+		"drawdown_pct":       cb.state.DrawdownPct,
+		"consecutive_losses": cb.state.ConsecutiveLosses,
+		"opens_last_hour":    len(cb.state.OpenTimes),
+		"trips":              trips,
+		"updated_at":         cb.state.UpdatedAt,
+	}
+}
+
+// 全局熔断器
+var globalCircuitBreaker *CircuitBreaker
+
+// InitGlobalCircuitBreaker 初始化全局熔断器
+func InitGlobalCircuitBreaker(config CircuitBreakerConfig, filePath string) {
+	globalCircuitBreaker = NewCircuitBreaker(config, filePath)
+}
+
+// GetCircuitBreaker 获取全局熔断器
+func GetCircuitBreaker() *CircuitBreaker {
+	return globalCircuitBreaker
+}
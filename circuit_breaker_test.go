@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// closeRecord 构造一条平仓记录，seq 用于区分记录键
+func closeRecord(seq int, symbol string, pnl float64) TradeRecord {
+	return TradeRecord{
+		Time:       fmt.Sprintf("2024-01-01 00:%02d:00", seq),
+		Symbol:     symbol,
+		Side:       "long",
+		Action:     "close_long",
+		EntryPrice: 100,
+		ExitPrice:  100 + pnl,
+		Quantity:   1,
+		PnL:        pnl,
+	}
+}
+
+// losingStreakConfig 只启用连续亏损熔断的配置
+func losingStreakConfig(threshold float64, action BreakerAction, cooldown int) CircuitBreakerConfig {
+	return CircuitBreakerConfig{LosingStreak: BreakerRule{Threshold: threshold, Action: action, CooldownMinutes: cooldown}}
+}
+
+func TestCircuitBreakerLosingStreak(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing []float64 // 首次 Update 前已有的平仓盈亏（应只预热、不计入）
+		fresh    []float64 // 之后按时间顺序新增的平仓盈亏
+		want     int
+	}{
+		{name: "existing history is primed", existing: []float64{-1, -2, -3, -4, -5}, want: 0},
+		{name: "new losses after priming", existing: []float64{-1, -2}, fresh: []float64{-1, -1}, want: 2},
+		{name: "win resets streak", fresh: []float64{-1, -1, 2, -1}, want: 1},
+		{name: "breakeven keeps streak", fresh: []float64{-1, 0, -1}, want: 2},
+		{name: "trailing win", fresh: []float64{-1, -1, -1, 3}, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			history := NewMemoryTradeHistoryManager()
+			cb := NewCircuitBreaker(losingStreakConfig(100, BreakerBlockOpens, 0), "")
+			now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+			seq := 0
+			for _, pnl := range tt.existing {
+				history.AddRecord(closeRecord(seq, "BTCUSDT", pnl))
+				seq++
+			}
+			cb.Update(1000, history.GetHistory(), now)
+			for _, pnl := range tt.fresh {
+				history.AddRecord(closeRecord(seq, "BTCUSDT", pnl))
+				seq++
+				cb.Update(1000, history.GetHistory(), now)
+			}
+			if got := cb.state.ConsecutiveLosses; got != tt.want {
+				t.Fatalf("consecutive losses = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// 冷却结束且条件解除后自动恢复；halt 只能手动重置
+func TestCircuitBreakerCooldownReleaseVersusHalt(t *testing.T) {
+	tests := []struct {
+		action     BreakerAction
+		wantActive bool
+	}{
+		{action: BreakerHalveSize, wantActive: false},
+		{action: BreakerBlockOpens, wantActive: false},
+		{action: BreakerHalt, wantActive: true},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.action), func(t *testing.T) {
+			history := NewMemoryTradeHistoryManager()
+			cb := NewCircuitBreaker(losingStreakConfig(2, tt.action, 10), "")
+			now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+			cb.Update(1000, history.GetHistory(), now)
+			history.AddRecord(closeRecord(0, "BTCUSDT", -1))
+			history.AddRecord(closeRecord(1, "ETHUSDT", -1))
+			cb.Update(1000, history.GetHistory(), now)
+			if _, ok := cb.state.Trips[BreakerLosingStreak]; !ok {
+				t.Fatalf("losing_streak not tripped after 2 losses")
+			}
+
+			// 冷却期内保持
+			cb.Update(1000, history.GetHistory(), now.Add(5*time.Minute))
+			if _, ok := cb.state.Trips[BreakerLosingStreak]; !ok {
+				t.Fatalf("losing_streak released before cooldown")
+			}
+
+			cb.Update(1000, history.GetHistory(), now.Add(11*time.Minute))
+			_, active := cb.state.Trips[BreakerLosingStreak]
+			if active != tt.wantActive {
+				t.Fatalf("after cooldown active = %v, want %v", active, tt.wantActive)
+			}
+			if r := cb.Restrictions(); r.Halted != (tt.action == BreakerHalt) {
+				t.Fatalf("halted = %v, want %v", r.Halted, tt.action == BreakerHalt)
+			}
+		})
+	}
+}
+
+// 手动重置回撤熔断后以当前净值为新的峰值，下一次 Update 不会立即再次触发
+func TestCircuitBreakerResetMaxDrawdownRebasesPeak(t *testing.T) {
+	cb := NewCircuitBreaker(CircuitBreakerConfig{MaxDrawdown: BreakerRule{Threshold: 0.25, Action: BreakerBlockOpens}}, "")
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	cb.Update(1000, nil, now)
+	if r := cb.Update(700, nil, now.Add(time.Minute)); !r.BlockOpens {
+		t.Fatalf("max_drawdown not tripped at 30%% drawdown")
+	}
+	if err := cb.Reset(BreakerMaxDrawdown); err != nil {
+		t.Fatalf("reset: %v", err)
+	}
+	if cb.state.PeakEquity != 700 {
+		t.Fatalf("peak equity after reset = %.2f, want 700", cb.state.PeakEquity)
+	}
+	if r := cb.Update(700, nil, now.Add(2*time.Minute)); r.BlockOpens {
+		t.Fatalf("max_drawdown re-tripped after reset: %v", r.Reasons)
+	}
+	if r := cb.Update(500, nil, now.Add(3*time.Minute)); !r.BlockOpens {
+		t.Fatalf("max_drawdown not tripped at 28%% drawdown from rebased peak")
+	}
+}
@@ -26,14 +26,26 @@ type Config struct {
     BTCETHLeverage  int      `json:"btc_eth_leverage"`  // BTC/ETH 最大杠杆
     AltcoinLeverage int      `json:"altcoin_leverage"`  // 山寨币最大杠杆
 
+    // 动态交易对扫描：定期选出固定交易对之外的候选（默认不启用）
+    Scanner ScannerConfig `json:"scanner"`
+
+    // 资产分类（层级 / 板块 / 杠杆与风控上限），覆盖内置分类；运行时可通过 /api/assets 编辑
+    Assets []AssetInfo `json:"assets"`
+
     // 币安实盘相关（可选，不填则使用模拟盘）
     BinanceAPIKey    string `json:"binance_api_key"`
     BinanceSecretKey string `json:"binance_secret_key"`
     BinanceProxyURL  string `json:"binance_proxy_url"`
 
+    // 模拟盘是否按对冲模式撮合（同一交易对可同时持有多空两条腿）；实盘以交易所账户设置为准
+    HedgeMode bool `json:"hedge_mode"`
+
     // 通知配置
     Notifications NotificationConfig `json:"notifications"`
 
+    // 账户级熔断配置（不填则使用 DefaultCircuitBreakerConfig）
+    CircuitBreakers *CircuitBreakerConfig `json:"circuit_breakers"`
+
     // 多 AI 模型配置
     AIModels []AIModelConfig `json:"ai_models"`
     AIMode   string          `json:"ai_mode"` // "primary" | "vote" | "compare"
@@ -41,6 +53,18 @@ type Config struct {
     // 策略配置
     ActiveStrategy string `json:"active_strategy"`
 
+    // 市场状态识别与自动切换策略（默认不启用）
+    Regime RegimeConfig `json:"regime"`
+
+    // 影子策略：与实盘共用行情和上下文，在隔离的模拟账户中运行（见 shadow.go）
+    ShadowStrategies []ShadowConfig `json:"shadow_strategies"`
+
+    // 平仓复盘：平仓后异步请求模型复盘并提炼教训，相关教训注入后续 prompt（默认不启用，见 reflection.go）
+    Reflection ReflectionConfig `json:"reflection"`
+
+    // 模型调用计量：token / 耗时 / 成本按日、模型、策略汇总，超出每日预算时降级模型或延长循环周期（见 usage.go）
+    Usage UsageConfig `json:"usage"`
+
     // 数据库路径
     DatabasePath string `json:"database_path"`
 }
@@ -103,6 +127,12 @@ func LoadConfig() (*Config, error) {
 		cfg.AltcoinLeverage = 10
 	}
 
+    // 熔断配置默认值
+    if cfg.CircuitBreakers == nil {
+        def := DefaultCircuitBreakerConfig()
+        cfg.CircuitBreakers = &def
+    }
+
     // 至少要有 AIAPIKey
     if cfg.AIAPIKey == "" {
         return nil, fmt.Errorf("请在 config.local.json 或环境变量中配置 AI_API_KEY")
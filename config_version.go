@@ -0,0 +1,360 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ConfigLeverage 参与版本计算的杠杆配置
+type ConfigLeverage struct {
+	DefaultBTCETH int            `json:"default_btc_eth"`
+	DefaultAlt    int            `json:"default_alt"`
+	Overrides     map[string]int `json:"overrides,omitempty"`
+}
+
+// ConfigState 影响交易行为的运行时配置：策略、风控参数、交易对、prompt、杠杆、循环周期和模型。
+// 任一字段变化都会产生一个新的配置版本。
+type ConfigState struct {
+	Strategy            string         `json:"strategy"`
+	RiskParams          RiskConfig     `json:"risk_params"`
+	Symbols             []string       `json:"symbols,omitempty"`
+	PromptFile          string         `json:"prompt_file,omitempty"`
+	PromptHash          string         `json:"prompt_hash"`
+	Leverage            ConfigLeverage `json:"leverage"`
+	LoopIntervalSeconds int            `json:"loop_interval_seconds"`
+	AIModel             string         `json:"ai_model"`
+}
+
+// Hash 配置状态的短哈希（JSON 序列化后取 sha256 前 12 位）
+func (s ConfigState) Hash() string {
+	data, _ := json.Marshal(s)
+	return shortHash(data)
+}
+
+// ConfigVersion 一个配置版本
+type ConfigVersion struct {
+	Version    string    `json:"version"` // "v1", "v2", ...
+	ConfigHash string    `json:"config_hash"`
+	PromptHash string    `json:"prompt_hash"`
+	Strategy   string    `json:"strategy"`
+	Since      time.Time `json:"since"`
+	Reason     string    `json:"reason"`
+}
+
+// configVersionState 持久化状态
+type configVersionState struct {
+	Seq     int               `json:"seq"`
+	Current ConfigVersion     `json:"current"`
+	Config  ConfigState       `json:"config"` // 当前版本对应的配置，用于生成变更说明
+	Legs    map[string]string `json:"legs"`   // key: positionKey(symbol, side) -> 开仓时的版本号
+}
+
+// ConfigVersionTracker 跟踪运行时配置的变化：每次变化分配新版本号并写入 Storage 配置快照，
+// 同时记录每条持仓腿开仓时的版本，平仓记录据此标记，便于按策略版本统计表现。
+type ConfigVersionTracker struct {
+	mu       sync.Mutex
+	source   func() ConfigState
+	filePath string
+	state    configVersionState
+}
+
+// NewConfigVersionTracker 创建配置版本跟踪器并从 filePath 恢复状态（filePath 为空时只保存在内存中）
+func NewConfigVersionTracker(source func() ConfigState, filePath string) *ConfigVersionTracker {
+	t := &ConfigVersionTracker{
+		source:   source,
+		filePath: filePath,
+		state:    configVersionState{Legs: make(map[string]string)},
+	}
+	t.load()
+	return t
+}
+
+func (t *ConfigVersionTracker) load() {
+	if t.filePath == "" {
+		return
+	}
+	data, err := os.ReadFile(t.filePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("⚠️ 加载配置版本状态失败: %v", err)
+		}
+		return
+	}
+	var st configVersionState
+	if err := json.Unmarshal(data, &st); err != nil {
+		log.Printf("⚠️ 解析配置版本状态失败: %v", err)
+		return
+	}
+	if st.Legs == nil {
+		st.Legs = make(map[string]string)
+	}
+	t.state = st
+}
+
+// saveLocked 持久化当前状态（调用方需持有锁）
+func (t *ConfigVersionTracker) saveLocked() {
+	if t.filePath == "" {
+		return
+	}
+	if err := writeJSONFile(t.filePath, t.state); err != nil {
+		log.Printf("⚠️ 保存配置版本状态失败: %v", err)
+	}
+}
+
+// Check 读取当前配置，与当前版本不同时分配新版本并保存配置快照。
+// reason 为变更来源（如 "web: strategy_switch"），为空时只记录自动生成的变更说明。
+func (t *ConfigVersionTracker) Check(reason string) ConfigVersion {
+	if t == nil {
+		return ConfigVersion{}
+	}
+	cfg := t.source()
+	hash := cfg.Hash()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.state.Current.ConfigHash == hash {
+		return t.state.Current
+	}
+
+	changes := "startup"
+	if t.state.Current.Version != "" {
+		changes = diffConfigState(t.state.Config, cfg)
+	}
+	if reason != "" {
+		changes = reason + ": " + changes
+	}
+
+	t.state.Seq = nextConfigVersionSeq(t.state.Seq)
+	v := ConfigVersion{
+		Version:    "v" + strconv.Itoa(t.state.Seq),
+		ConfigHash: hash,
+		PromptHash: cfg.PromptHash,
+		Strategy:   cfg.Strategy,
+		Since:      time.Now(),
+		Reason:     changes,
+	}
+	t.state.Current = v
+	t.state.Config = cfg
+	t.saveLocked()
+
+	if st := GetStorage(); st != nil {
+		if err := st.SaveConfigVersion(v.Version, v.ConfigHash, v.PromptHash, cfg, changes); err != nil {
+			log.Printf("⚠️ [ConfigVersion] 保存配置快照失败: %v", err)
+		}
+	}
+	log.Printf("🔖 [ConfigVersion] %s (config %s, prompt %s): %s", v.Version, v.ConfigHash, v.PromptHash, changes)
+	return v
+}
+
+// nextConfigVersionSeq 版本号在本地状态与 Storage 已有快照中取最大值后递增，
+// 避免状态文件丢失后版本号重复
+func nextConfigVersionSeq(seq int) int {
+	if st := GetStorage(); st != nil {
+		if latest := st.GetConfigVersions(1); len(latest) > 0 {
+			if n, err := strconv.Atoi(strings.TrimPrefix(latest[0].Version, "v")); err == nil && n > seq {
+				seq = n
+			}
+		}
+	}
+	return seq + 1
+}
+
+// Current 返回当前配置版本（尚未 Check 过时为零值）
+func (t *ConfigVersionTracker) Current() ConfigVersion {
+	if t == nil {
+		return ConfigVersion{}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.state.Current
+}
+
+// StampDecision 用当前版本标记 AI 决策
+func (t *ConfigVersionTracker) StampDecision(d *FullDecision) {
+	if t == nil || d == nil {
+		return
+	}
+	cur := t.Current()
+	d.ConfigVersion = cur.Version
+	d.PromptHash = cur.PromptHash
+}
+
+// RecordOpen 记录持仓腿开仓时的配置版本
+func (t *ConfigVersionTracker) RecordOpen(symbol, side string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.state.Current.Version == "" {
+		return
+	}
+	t.state.Legs[positionKey(symbol, normalizePositionSide(side))] = t.state.Current.Version
+	t.saveLocked()
+}
+
+// StampTrade 用开仓时的配置版本标记平仓记录：优先取平仓前最近一次开仓决策上记录的版本
+// （同一周期内平仓后又重开同一条腿时，Legs 已被新开仓覆盖）。实时平仓记录只有时分秒、没有日期，
+// 此时不限时间取最近一次开仓决策：本周期的决策在保存交易记录之后才写入 Storage，不会被匹配到。
+// 没有 Storage 或找不到开仓决策时取 Legs，都没有时（如本功能上线前的持仓）使用当前版本
+func (t *ConfigVersionTracker) StampTrade(r *TradeRecord) {
+	if t == nil || r == nil || r.ConfigVersion != "" {
+		return
+	}
+	if st := GetStorage(); st != nil {
+		if open, ok := st.LastOpenDecision(r.Symbol, r.Side, tradeRecordTime(*r)); ok && open.ConfigVersion != "" {
+			r.ConfigVersion, r.PromptHash = open.ConfigVersion, open.PromptHash
+			return
+		}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	version := t.state.Legs[positionKey(r.Symbol, normalizePositionSide(r.Side))]
+	if version == "" {
+		version = t.state.Current.Version
+	}
+	r.ConfigVersion = version
+	r.PromptHash = t.promptHashLocked(version)
+}
+
+// promptHashLocked 查找版本对应的 prompt 哈希（当前版本直接取，历史版本从 Storage 快照中查）
+func (t *ConfigVersionTracker) promptHashLocked(version string) string {
+	if version == t.state.Current.Version {
+		return t.state.Current.PromptHash
+	}
+	if st := GetStorage(); st != nil {
+		for _, snap := range st.GetConfigVersions(0) {
+			if snap.Version == version {
+				return snap.PromptHash
+			}
+		}
+	}
+	return ""
+}
+
+// diffConfigState 生成配置变更说明，如 "strategy aggressive -> conservative; risk_params: fixed_leverage"
+func diffConfigState(old, cur ConfigState) string {
+	var parts []string
+	if old.Strategy != cur.Strategy {
+		parts = append(parts, fmt.Sprintf("strategy %s -> %s", old.Strategy, cur.Strategy))
+	}
+	if keys := changedJSONKeys(old.RiskParams, cur.RiskParams); len(keys) > 0 {
+		parts = append(parts, "risk_params: "+strings.Join(keys, ", "))
+	}
+	if strings.Join(old.Symbols, ",") != strings.Join(cur.Symbols, ",") {
+		parts = append(parts, "symbols")
+	}
+	if old.PromptFile != cur.PromptFile || old.PromptHash != cur.PromptHash {
+		parts = append(parts, fmt.Sprintf("prompt %s -> %s", old.PromptHash, cur.PromptHash))
+	}
+	if old.Leverage.DefaultBTCETH != cur.Leverage.DefaultBTCETH || old.Leverage.DefaultAlt != cur.Leverage.DefaultAlt {
+		parts = append(parts, fmt.Sprintf("leverage defaults %d/%d -> %d/%d",
+			old.Leverage.DefaultBTCETH, old.Leverage.DefaultAlt, cur.Leverage.DefaultBTCETH, cur.Leverage.DefaultAlt))
+	}
+	if keys := changedJSONKeys(old.Leverage.Overrides, cur.Leverage.Overrides); len(keys) > 0 {
+		parts = append(parts, "leverage: "+strings.Join(keys, ", "))
+	}
+	if old.LoopIntervalSeconds != cur.LoopIntervalSeconds {
+		parts = append(parts, fmt.Sprintf("loop_interval %ds -> %ds", old.LoopIntervalSeconds, cur.LoopIntervalSeconds))
+	}
+	if old.AIModel != cur.AIModel {
+		parts = append(parts, fmt.Sprintf("model %s -> %s", old.AIModel, cur.AIModel))
+	}
+	if len(parts) == 0 {
+		return "config changed"
+	}
+	return strings.Join(parts, "; ")
+}
+
+// changedJSONKeys 比较两个值序列化后的顶层字段，返回有变化的字段名（已排序）
+func changedJSONKeys(old, cur interface{}) []string {
+	var a, b map[string]json.RawMessage
+	da, _ := json.Marshal(old)
+	db, _ := json.Marshal(cur)
+	_ = json.Unmarshal(da, &a)
+	_ = json.Unmarshal(db, &b)
+
+	var keys []string
+	for k, v := range b {
+		if string(a[k]) != string(v) {
+			keys = append(keys, k)
+		}
+	}
+	for k := range a {
+		if _, ok := b[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// shortHash sha256 的前 12 位十六进制
+func shortHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// currentConfigState 从全局组件读取当前生效的配置
+func currentConfigState(aiModel string, loopInterval func() int) ConfigState {
+	var cs ConfigState
+	if sm := GetStrategyManager(); sm != nil {
+		if s := sm.GetActiveStrategy(); s != nil {
+			cs.Strategy = s.Name
+			cs.Symbols = s.Symbols
+			cs.PromptFile = s.PromptFile
+		}
+		cs.RiskParams = sm.GetRiskConfig()
+		cs.PromptHash = sm.PromptHash(nil)
+	}
+	if lm := GetLeverageManager(); lm != nil {
+		lm.mu.RLock()
+		cs.Leverage.DefaultBTCETH, cs.Leverage.DefaultAlt = lm.DefaultBTCETH, lm.DefaultAlt
+		lm.mu.RUnlock()
+		cs.Leverage.Overrides = lm.GetAllSpecific()
+	}
+	if loopInterval != nil {
+		cs.LoopIntervalSeconds = loopInterval()
+	}
+	cs.AIModel = aiModel
+	return cs
+}
+
+// lastRecordedConfigState 从 Storage 中最近一个配置版本快照恢复配置状态（没有版本记录时返回 false）
+func lastRecordedConfigState(st *Storage) (ConfigState, bool) {
+	if st == nil {
+		return ConfigState{}, false
+	}
+	versions := st.GetConfigVersions(1)
+	if len(versions) == 0 {
+		return ConfigState{}, false
+	}
+	var cs ConfigState
+	if err := json.Unmarshal([]byte(versions[0].ConfigJSON), &cs); err != nil || cs.Strategy == "" {
+		return ConfigState{}, false
+	}
+	return cs, true
+}
+
+// 全局配置版本跟踪器
+var globalConfigVersions *ConfigVersionTracker
+
+// InitGlobalConfigVersions 初始化全局配置版本跟踪器并记录启动时的版本
+func InitGlobalConfigVersions(source func() ConfigState, filePath string) {
+	globalConfigVersions = NewConfigVersionTracker(source, filePath)
+	globalConfigVersions.Check("")
+}
+
+// GetConfigVersions 获取全局配置版本跟踪器（未初始化时为 nil）
+func GetConfigVersions() *ConfigVersionTracker {
+	return globalConfigVersions
+}
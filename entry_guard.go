@@ -0,0 +1,333 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// symbolActivity 单个币种的开平仓记录
+type symbolActivity struct {
+	Opens           []time.Time `json:"opens"`                       // 最近 48 小时内的开仓时间
+	LastOpenSide    string      `json:"last_open_side"`              // 最近一次开仓方向
+	LastOpenTime    time.Time   `json:"last_open_time"`              // 最近一次开仓时间
+	LastCloseSide   string      `json:"last_close_side"`             // 最近一次平仓方向
+	LastCloseTime   time.Time   `json:"last_close_time"`             // 最近一次平仓时间
+	LastClosePnL    float64     `json:"last_close_pnl"`              // 最近一次平仓的已实现盈亏（无平仓记录时取平仓前观察到的未实现盈亏）
+	LastCloseReason string      `json:"last_close_reason,omitempty"` // 最近一次平仓记录的原因，为空表示没有平仓记录（交易所侧触发），见 isStopOutClose
+}
+
+// observedLeg 上一周期看到的持仓腿
+type observedLeg struct {
+	Symbol string  `json:"symbol"`
+	Side   string  `json:"side"`
+	PnL    float64 `json:"pnl"`
+}
+
+// entryGuardState 持久化状态
+type entryGuardState struct {
+	Symbols    map[string]*symbolActivity `json:"symbols"`
+	Legs       map[string]observedLeg     `json:"legs"`        // key: positionKey(symbol, side)
+	SeenTrades []string                   `json:"seen_trades"` // 已处理的平仓记录键，用于识别新增平仓记录（见 unseenTradeRecords）
+}
+
+// SymbolBlock 某个币种当前被禁止开仓的原因
+type SymbolBlock struct {
+	Symbol    string        `json:"symbol"`
+	Side      string        `json:"side,omitempty"` // 为空表示多空均禁止
+	Rule      string        `json:"rule"`
+	Reason    string        `json:"reason"`
+	Remaining time.Duration `json:"remaining"`
+}
+
+// EntryGuard 跟踪每个币种的开平仓历史，用于冷却期、每日开仓次数和禁止反手规则。
+// 平仓优先取新增的平仓记录（已实现盈亏与原因），没有记录时通过对比相邻周期的持仓腿识别，
+// 因此交易所侧触发的止损同样会被记录。
+type EntryGuard struct {
+	mu       sync.Mutex
+	filePath string
+	state    entryGuardState
+}
+
+// NewEntryGuard 创建开仓守卫并从 filePath 恢复状态（filePath 为空时只保存在内存中）
+func NewEntryGuard(filePath string) *EntryGuard {
+	g := &EntryGuard{
+		filePath: filePath,
+		state: entryGuardState{
+			Symbols: make(map[string]*symbolActivity),
+			Legs:    make(map[string]observedLeg),
+		},
+	}
+	g.load()
+	return g
+}
+
+func (g *EntryGuard) load() {
+	if g.filePath == "" {
+		return
+	}
+	data, err := os.ReadFile(g.filePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("⚠️ 加载开仓守卫状态失败: %v", err)
+		}
+		return
+	}
+	var st entryGuardState
+	if err := json.Unmarshal(data, &st); err != nil {
+		log.Printf("⚠️ 解析开仓守卫状态失败: %v", err)
+		return
+	}
+	if st.Symbols == nil {
+		st.Symbols = make(map[string]*symbolActivity)
+	}
+	if st.Legs == nil {
+		st.Legs = make(map[string]observedLeg)
+	}
+	g.state = st
+}
+
+// saveLocked 持久化当前状态（调用方需持有锁）
+func (g *EntryGuard) saveLocked() {
+	if g.filePath == "" {
+		return
+	}
+	if err := writeJSONFile(g.filePath, g.state); err != nil {
+		log.Printf("⚠️ 保存开仓守卫状态失败: %v", err)
+	}
+}
+
+func (g *EntryGuard) activityLocked(symbol string) *symbolActivity {
+	a, ok := g.state.Symbols[symbol]
+	if !ok {
+		a = &symbolActivity{}
+		g.state.Symbols[symbol] = a
+	}
+	return a
+}
+
+// Observe 每个周期调用：新增的平仓记录（部分平仓除外）按已实现盈亏记为一次平仓；
+// 与上一周期的持仓对比，消失且没有平仓记录的持仓腿按平仓前的未实现盈亏记为一次平仓
+func (g *EntryGuard) Observe(positions []PositionInfo, history []TradeRecord, now time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var fresh []TradeRecord
+	fresh, g.state.SeenTrades = unseenTradeRecords(history, g.state.SeenTrades)
+	recorded := make(map[string]bool, len(fresh))
+	for _, r := range fresh {
+		side := normalizePositionSide(r.Side)
+		if side == "" || r.Action == "partial_close" {
+			continue
+		}
+		closedAt := tradeRecordTime(r)
+		if closedAt.IsZero() || closedAt.After(now) {
+			closedAt = now
+		}
+		a := g.activityLocked(r.Symbol)
+		a.LastCloseSide = side
+		a.LastCloseTime = closedAt
+		a.LastClosePnL = r.PnL
+		a.LastCloseReason = r.Reason
+		recorded[positionKey(r.Symbol, side)] = true
+	}
+
+	current := make(map[string]observedLeg, len(positions))
+	for _, p := range positions {
+		side := normalizePositionSide(p.Side)
+		if side == "" {
+			continue
+		}
+		current[positionKey(p.Symbol, side)] = observedLeg{Symbol: p.Symbol, Side: side, PnL: p.UnrealizedPnL}
+	}
+	for key, leg := range g.state.Legs {
+		if _, ok := current[key]; ok || recorded[key] {
+			continue
+		}
+		a := g.activityLocked(leg.Symbol)
+		a.LastCloseSide = leg.Side
+		a.LastCloseTime = now
+		a.LastClosePnL = leg.PnL
+		a.LastCloseReason = ""
+	}
+	g.state.Legs = current
+
+	// 只保留 48 小时内的开仓记录
+	cutoff := now.Add(-48 * time.Hour)
+	for _, a := range g.state.Symbols {
+		kept := a.Opens[:0]
+		for _, t := range a.Opens {
+			if t.After(cutoff) {
+				kept = append(kept, t)
+			}
+		}
+		a.Opens = kept
+	}
+	g.saveLocked()
+}
+
+// RecordOpen 记录一次成功开仓
+func (g *EntryGuard) RecordOpen(symbol, side string, now time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	a := g.activityLocked(symbol)
+	a.Opens = append(a.Opens, now)
+	a.LastOpenSide = normalizePositionSide(side)
+	a.LastOpenTime = now
+	// 先登记持仓腿，下一周期前即被平掉且没有平仓记录时也能识别为一次平仓
+	key := positionKey(symbol, a.LastOpenSide)
+	if _, ok := g.state.Legs[key]; !ok {
+		g.state.Legs[key] = observedLeg{Symbol: symbol, Side: a.LastOpenSide}
+	}
+	g.saveLocked()
+}
+
+// CooldownRemaining 止损出局（平仓已实现亏损）后的剩余冷却时间
+func (g *EntryGuard) CooldownRemaining(symbol string, cooldown time.Duration, now time.Time) time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	a, ok := g.state.Symbols[symbol]
+	if !ok || cooldown <= 0 || a.LastCloseTime.IsZero() || !isStopOutClose(a.LastClosePnL, a.LastCloseReason) {
+		return 0
+	}
+	if left := a.LastCloseTime.Add(cooldown).Sub(now); left > 0 {
+		return left
+	}
+	return 0
+}
+
+// isStopOutClose 亏损平仓且由止损触发：交易所侧止损（模拟盘的止损触发记录，或没有平仓记录的实盘止损单成交）、
+// 持仓管理的硬止损 / 回撤收紧平仓。AI 主动平仓、时间止损、失效条件平仓不算止损出局
+func isStopOutClose(pnl float64, reason string) bool {
+	if pnl >= 0 {
+		return false
+	}
+	return reason == "" ||
+		strings.HasPrefix(reason, simulatedStopReasonPrefix) ||
+		strings.HasPrefix(reason, positionRuleReason(PositionRuleHardStop, "")) ||
+		strings.HasPrefix(reason, positionRuleReason(PositionRuleTrailing, ""))
+}
+
+// cooldownReason 冷却原因：最近一次平仓的盈亏与平仓原因
+func (g *EntryGuard) cooldownReason(symbol string) string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	a, ok := g.state.Symbols[symbol]
+	if !ok {
+		return "止损出局后冷却"
+	}
+	reason := fmt.Sprintf("止损出局后冷却 (平仓盈亏 %+.2f U", a.LastClosePnL)
+	if a.LastCloseReason != "" {
+		reason += ": " + truncateRunes(a.LastCloseReason, 40)
+	}
+	return reason + ")"
+}
+
+// OpensToday 当日（UTC）已开仓次数
+func (g *EntryGuard) OpensToday(symbol string, now time.Time) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	a, ok := g.state.Symbols[symbol]
+	if !ok {
+		return 0
+	}
+	dayStart := utcDayStart(now)
+	n := 0
+	for _, t := range a.Opens {
+		if !t.Before(dayStart) {
+			n++
+		}
+	}
+	return n
+}
+
+// FlipRemaining 在 window 内出现过反方向的开仓或平仓时，返回禁止开 side 方向的剩余时间
+func (g *EntryGuard) FlipRemaining(symbol, side string, window time.Duration, now time.Time) time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	a, ok := g.state.Symbols[symbol]
+	if !ok || window <= 0 {
+		return 0
+	}
+	opp := oppositeSide(normalizePositionSide(side))
+	var last time.Time
+	if a.LastOpenSide == opp && a.LastOpenTime.After(last) {
+		last = a.LastOpenTime
+	}
+	if a.LastCloseSide == opp && a.LastCloseTime.After(last) {
+		last = a.LastCloseTime
+	}
+	if last.IsZero() {
+		return 0
+	}
+	if left := last.Add(window).Sub(now); left > 0 {
+		return left
+	}
+	return 0
+}
+
+// BlockedSymbols 按当前策略配置列出禁止开仓的币种（用于 Prompt）
+func (g *EntryGuard) BlockedSymbols(cfg RiskConfig, now time.Time) []SymbolBlock {
+	g.mu.Lock()
+	symbols := make([]string, 0, len(g.state.Symbols))
+	for s := range g.state.Symbols {
+		symbols = append(symbols, s)
+	}
+	g.mu.Unlock()
+	sort.Strings(symbols)
+
+	var blocks []SymbolBlock
+	for _, s := range symbols {
+		if left := g.CooldownRemaining(s, time.Duration(cfg.SymbolCooldownMinutes)*time.Minute, now); left > 0 {
+			blocks = append(blocks, SymbolBlock{Symbol: s, Rule: "symbol_cooldown", Reason: g.cooldownReason(s), Remaining: left})
+			continue
+		}
+		if cfg.MaxOpensPerSymbolPerDay > 0 {
+			if n := g.OpensToday(s, now); n >= cfg.MaxOpensPerSymbolPerDay {
+				blocks = append(blocks, SymbolBlock{Symbol: s, Rule: "reentry_limit",
+					Reason: fmt.Sprintf("今日已开仓 %d 次 (上限 %d)", n, cfg.MaxOpensPerSymbolPerDay), Remaining: utcDayStart(now).Add(24 * time.Hour).Sub(now)})
+				continue
+			}
+		}
+		window := time.Duration(cfg.NoFlipMinutes) * time.Minute
+		for _, side := range []string{"long", "short"} {
+			if left := g.FlipRemaining(s, side, window, now); left > 0 {
+				blocks = append(blocks, SymbolBlock{Symbol: s, Side: side, Rule: "no_flip", Reason: "禁止短时间内反手", Remaining: left})
+			}
+		}
+	}
+	return blocks
+}
+
+// utcDayStart 返回 now 所在 UTC 日的 00:00
+func utcDayStart(now time.Time) time.Time {
+	y, m, d := now.UTC().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// formatRemaining 将剩余时间格式化为 "1h05m" / "12m"
+func formatRemaining(d time.Duration) string {
+	mins := int(d.Round(time.Minute).Minutes())
+	if mins >= 60 {
+		return fmt.Sprintf("%dh%02dm", mins/60, mins%60)
+	}
+	return fmt.Sprintf("%dm", mins)
+}
+
+// 全局开仓守卫
+var globalEntryGuard *EntryGuard
+
+// InitGlobalEntryGuard 初始化全局开仓守卫
+func InitGlobalEntryGuard(filePath string) {
+	globalEntryGuard = NewEntryGuard(filePath)
+}
+
+// GetEntryGuard 获取全局开仓守卫
+func GetEntryGuard() *EntryGuard {
+	return globalEntryGuard
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// 冷却期只在亏损的止损出局后生效：以新增平仓记录的已实现盈亏与原因为准，没有记录时按消失前的浮动盈亏
+func TestEntryGuardObserveCooldown(t *testing.T) {
+	const cooldown = 30 * time.Minute
+	losingStop := TradeRecord{Time: "00:01:00", Symbol: "BTCUSDT", Side: "long", Action: "close_long", EntryPrice: 100, ExitPrice: 90, Quantity: 1, PnL: -10, Reason: "止损触发 @ 90.0000"}
+	withReason := func(r TradeRecord, action, reason string, pnl float64) TradeRecord {
+		r.Action, r.Reason, r.PnL = action, reason, pnl
+		return r
+	}
+
+	tests := []struct {
+		name     string
+		existing []TradeRecord  // 首次 Observe 前已有的记录（只预热）
+		before   []PositionInfo // 上一周期的持仓
+		records  []TradeRecord  // 两次 Observe 之间新增的记录
+		after    []PositionInfo // 本周期的持仓
+		opened   bool           // 上一周期之后才开仓（RecordOpen 登记，浮动盈亏为 0）
+		want     bool           // 是否处于冷却期
+	}{
+		{name: "existing stop is primed", existing: []TradeRecord{losingStop}},
+		{name: "losing exchange stop", before: []PositionInfo{{Symbol: "BTCUSDT", Side: "long", UnrealizedPnL: -8}}, records: []TradeRecord{losingStop}, want: true},
+		{name: "green last cycle then stopped", before: []PositionInfo{{Symbol: "BTCUSDT", Side: "long", UnrealizedPnL: 5}}, records: []TradeRecord{losingStop}, want: true},
+		{name: "opened and stopped before next cycle", opened: true, records: []TradeRecord{losingStop}, want: true},
+		{name: "hard stop", records: []TradeRecord{withReason(losingStop, "close_long", positionRuleReason(PositionRuleHardStop, "亏损超过 5%"), -10)}, want: true},
+		{name: "trailing stop at a loss", records: []TradeRecord{withReason(losingStop, "close_long", positionRuleReason(PositionRuleTrailing, "回吐"), -1)}, want: true},
+		{name: "trailing stop in profit", records: []TradeRecord{withReason(losingStop, "close_long", positionRuleReason(PositionRuleTrailing, "回吐"), 3)}},
+		{name: "discretionary close at a loss", records: []TradeRecord{withReason(losingStop, "close_long", "趋势转弱，主动平仓", -0.01)}},
+		{name: "time stop at a loss", records: []TradeRecord{withReason(losingStop, "close_long", positionRuleReason(PositionRuleTimeStop, "持仓超时"), -2)}},
+		{name: "partial close at a loss", before: []PositionInfo{{Symbol: "BTCUSDT", Side: "long"}}, records: []TradeRecord{withReason(losingStop, "partial_close", "减仓", -3)}, after: []PositionInfo{{Symbol: "BTCUSDT", Side: "long"}}},
+		{name: "vanished without record at a loss", before: []PositionInfo{{Symbol: "BTCUSDT", Side: "long", UnrealizedPnL: -4}}, want: true},
+		{name: "vanished without record in profit", before: []PositionInfo{{Symbol: "BTCUSDT", Side: "long", UnrealizedPnL: 4}}},
+		{name: "opened and vanished without record", opened: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			history := NewMemoryTradeHistoryManager()
+			for _, r := range tt.existing {
+				history.AddRecord(r)
+			}
+			g := NewEntryGuard("")
+			now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+			g.Observe(tt.before, history.GetHistory(), now)
+			if tt.opened {
+				g.RecordOpen("BTCUSDT", "long", now)
+			}
+			for _, r := range tt.records {
+				history.AddRecord(r)
+			}
+			g.Observe(tt.after, history.GetHistory(), now.Add(time.Minute))
+
+			left := g.CooldownRemaining("BTCUSDT", cooldown, now.Add(2*time.Minute))
+			if got := left > 0; got != tt.want {
+				t.Fatalf("cooldown active = %v (remaining %v), want %v", got, left, tt.want)
+			}
+		})
+	}
+}
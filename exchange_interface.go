@@ -19,4 +19,7 @@ type Exchange interface {
 
 	// GetTradeHistory 获取历史交易记录
 	GetTradeHistory() []TradeRecord
+
+	// IsHedgeMode 是否为对冲模式（同一交易对可同时持有多空两条腿，持仓按 symbol+side 区分）
+	IsHedgeMode() bool
 }
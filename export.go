@@ -244,7 +244,7 @@ func (e *Exporter) exportTradesToCSV(records []TradeRecord, filepath string) err
 	writer := csv.NewWriter(file)
 	defer writer.Flush()
 
-	headers := []string{"time", "symbol", "side", "action", "entry_price", "exit_price", "quantity", "pnl", "pnl_pct", "reason"}
+	headers := []string{"time", "symbol", "side", "action", "entry_price", "exit_price", "quantity", "pnl", "pnl_pct", "reason", "config_version", "prompt_hash"}
 	if err := writer.Write(headers); err != nil {
 		return fmt.Errorf("write header: %w", err)
 	}
@@ -261,6 +261,8 @@ func (e *Exporter) exportTradesToCSV(records []TradeRecord, filepath string) err
 			strconv.FormatFloat(r.PnL, 'f', 2, 64),
 			strconv.FormatFloat(r.PnLPct, 'f', 2, 64),
 			r.Reason,
+			r.ConfigVersion,
+			r.PromptHash,
 		}
 		if err := writer.Write(row); err != nil {
 			return fmt.Errorf("write row: %w", err)
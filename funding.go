@@ -0,0 +1,56 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// fundingIntervalHours 资金费结算间隔（币安 USDT 永续绝大多数为 8 小时）
+const fundingIntervalHours = 8.0
+
+// fundingPaysSide 当前资金费率下 side 方向是否需要支付资金费：费率为正多头付费，为负空头付费
+func fundingPaysSide(side string, rate float64) bool {
+	switch normalizePositionSide(side) {
+	case "long":
+		return rate > 0
+	case "short":
+		return rate < 0
+	}
+	return false
+}
+
+// untilNextFunding 距下次资金费结算的时间；交易所未提供结算时间（模拟盘 / 回测）时返回 false
+func untilNextFunding(md *MarketData, now time.Time) (time.Duration, bool) {
+	if md == nil || md.NextFundingTime.IsZero() {
+		return 0, false
+	}
+	left := md.NextFundingTime.Sub(now)
+	if left < 0 {
+		return 0, false
+	}
+	return left, true
+}
+
+// fundingEventsWithin 持有 holdHours 小时内预计经历的资金费结算次数（至少按一次计）
+func fundingEventsWithin(md *MarketData, holdHours float64, now time.Time) int {
+	if holdHours <= 0 {
+		return 0
+	}
+	hold := time.Duration(holdHours * float64(time.Hour))
+	left, ok := untilNextFunding(md, now)
+	if !ok {
+		return int(math.Max(1, math.Ceil(holdHours/fundingIntervalHours)))
+	}
+	if left > hold {
+		return 0
+	}
+	return 1 + int((hold-left)/time.Duration(fundingIntervalHours*float64(time.Hour)))
+}
+
+// expectedFundingCostPct 按当前费率估算持有期内需支付的资金费（占名义价值 %）；收取资金费的一方返回 0
+func expectedFundingCostPct(side string, md *MarketData, holdHours float64, now time.Time) float64 {
+	if md == nil || !fundingPaysSide(side, md.FundingRate) {
+		return 0
+	}
+	return math.Abs(md.FundingRate) * float64(fundingEventsWithin(md, holdHours, now)) * 100
+}
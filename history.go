@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"os"
 	"sync"
@@ -41,6 +42,14 @@ func NewTradeHistoryManager() *TradeHistoryManager {
 	return m
 }
 
+// NewMemoryTradeHistoryManager 创建只保存在内存中的历史管理器（不读写 trade_history.json），用于影子策略的模拟账户
+func NewMemoryTradeHistoryManager() *TradeHistoryManager {
+	return &TradeHistoryManager{
+		history:     make([]TradeRecord, 0),
+		maxInMemory: 100,
+	}
+}
+
 // AddRecord 添加一条交易记录（带简单去重）
 func (m *TradeHistoryManager) AddRecord(record TradeRecord) {
 	m.mu.Lock()
@@ -64,7 +73,9 @@ func (m *TradeHistoryManager) AddRecord(record TradeRecord) {
 	}
 	
 	// 异步保存到文件
-	go m.saveToFile()
+	if m.filePath != "" {
+		go m.saveToFile()
+	}
 }
 
 // GetHistory 获取当前历史记录的副本
@@ -78,6 +89,42 @@ func (m *TradeHistoryManager) GetHistory() []TradeRecord {
 	return result
 }
 
+// maxSeenTrades 去重用的已处理交易键最多保留条数（需大于交易历史在内存中保留的条数）
+const maxSeenTrades = 500
+
+// tradeRecordKey 平仓记录的稳定键，用于在长度封顶的交易历史中识别已处理过的记录
+func tradeRecordKey(t TradeRecord) string {
+	return fmt.Sprintf("%s|%s|%s|%s|%.8f|%.8f|%.8f", t.Time, t.Symbol, t.Side, t.Action, t.ExitPrice, t.Quantity, t.PnL)
+}
+
+// unseenTradeRecords 找出 history（最新在前，见 AddRecord）中键不在 seen 里的记录，按从旧到新返回，
+// 同时返回更新后的已处理键（最多保留 maxSeenTrades 条）。
+// seen 为 nil 表示首次使用：当前历史全部视为已处理，避免把之前的旧记录当作本次平仓
+func unseenTradeRecords(history []TradeRecord, seen []string) ([]TradeRecord, []string) {
+	prime := seen == nil
+	known := make(map[string]bool, len(seen))
+	for _, k := range seen {
+		known[k] = true
+	}
+	seen = append([]string{}, seen...)
+	var fresh []TradeRecord
+	for i := len(history) - 1; i >= 0; i-- {
+		key := tradeRecordKey(history[i])
+		if known[key] {
+			continue
+		}
+		known[key] = true
+		seen = append(seen, key)
+		if !prime {
+			fresh = append(fresh, history[i])
+		}
+	}
+	if len(seen) > maxSeenTrades {
+		seen = seen[len(seen)-maxSeenTrades:]
+	}
+	return fresh, seen
+}
+
 // loadFromFile 从文件加载历史记录
 func (m *TradeHistoryManager) loadFromFile() {
 	data, err := os.ReadFile(m.filePath)
@@ -259,6 +259,17 @@ func calculateLongerTermData(klines []Kline) *LongerTermData {
 	return data
 }
 
+// lastClosedCloses 各周期最近一根已收盘 K 线的收盘价（最后一根视为未收盘），缺失的周期不写入
+func lastClosedCloses(series map[string][]Kline) map[string]float64 {
+	closes := make(map[string]float64, len(series))
+	for tf, klines := range series {
+		if len(klines) >= 2 {
+			closes[tf] = klines[len(klines)-2].Close
+		}
+	}
+	return closes
+}
+
 // aggregateKlines 将低周期K线按固定数量聚合为高周期K线，例如 20 根3m 聚合为 1 根1h。
 func aggregateKlines(klines []Kline, groupSize int) []Kline {
 	if groupSize <= 1 || len(klines) == 0 {
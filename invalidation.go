@@ -0,0 +1,685 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// PositionRuleInvalidation AI 失效条件触发的平仓（TradeRecord.Reason 前缀标签）
+const PositionRuleInvalidation = "invalidation"
+
+// ===== 失效条件表达式 =====
+//
+// 语法（不区分大小写）：
+//
+//	expr    := and { ("OR" | "||") and }
+//	and     := cmp { ("AND" | "&&") cmp }
+//	cmp     := "(" expr ")" | sum op sum        op: < <= > >=
+//	sum     := product { ("+" | "-") product }
+//	product := unary { ("*" | "/") unary }
+//	unary   := ["-"] (number | field | "(" sum ")")
+//
+// 字段见 conditionFields，例如: close_4h < 62000 AND rsi14_1h < 40
+
+// conditionFields 表达式可引用的 MarketData 字段；第二个返回值为 false 表示数据缺失（此时条件不触发）
+var conditionFields = map[string]func(md *MarketData) (float64, bool){
+	"price":        func(md *MarketData) (float64, bool) { return md.CurrentPrice, md.CurrentPrice > 0 },
+	"close_3m":     func(md *MarketData) (float64, bool) { return lastCloseField(md, "3m") },
+	"close_5m":     func(md *MarketData) (float64, bool) { return lastCloseField(md, "5m") },
+	"close_15m":    func(md *MarketData) (float64, bool) { return lastCloseField(md, "15m") },
+	"close_30m":    func(md *MarketData) (float64, bool) { return lastCloseField(md, "30m") },
+	"close_1h":     func(md *MarketData) (float64, bool) { return lastCloseField(md, "1h") },
+	"close_4h":     func(md *MarketData) (float64, bool) { return lastCloseField(md, "4h") },
+	"ema20":        func(md *MarketData) (float64, bool) { return md.CurrentEMA20, md.CurrentEMA20 > 0 },
+	"ema20_5m":     func(md *MarketData) (float64, bool) { return md.EMA20_5m, md.EMA20_5m > 0 },
+	"ema20_15m":    func(md *MarketData) (float64, bool) { return md.EMA20_15m, md.EMA20_15m > 0 },
+	"ema20_30m":    func(md *MarketData) (float64, bool) { return md.EMA20_30m, md.EMA20_30m > 0 },
+	"ema20_1h":     func(md *MarketData) (float64, bool) { return md.EMA20_1h, md.EMA20_1h > 0 },
+	"ema20_4h":     func(md *MarketData) (float64, bool) { return longerTermField(md, func(l *LongerTermData) float64 { return l.EMA20 }) },
+	"ema50_4h":     func(md *MarketData) (float64, bool) { return longerTermField(md, func(l *LongerTermData) float64 { return l.EMA50 }) },
+	"rsi7":         func(md *MarketData) (float64, bool) { return md.CurrentRSI7, md.CurrentRSI7 > 0 },
+	"rsi14_5m":     func(md *MarketData) (float64, bool) { return md.RSI14_5m, md.RSI14_5m > 0 },
+	"rsi14_15m":    func(md *MarketData) (float64, bool) { return md.RSI14_15m, md.RSI14_15m > 0 },
+	"rsi14_30m":    func(md *MarketData) (float64, bool) { return md.RSI14_30m, md.RSI14_30m > 0 },
+	"rsi14_1h":     func(md *MarketData) (float64, bool) { return md.RSI14_1h, md.RSI14_1h > 0 },
+	"macd":         func(md *MarketData) (float64, bool) { return md.CurrentMACD, true },
+	"macd_5m":      func(md *MarketData) (float64, bool) { return md.MACD_5m, true },
+	"macd_15m":     func(md *MarketData) (float64, bool) { return md.MACD_15m, true },
+	"macd_30m":     func(md *MarketData) (float64, bool) { return md.MACD_30m, true },
+	"macd_1h":      func(md *MarketData) (float64, bool) { return md.MACD_1h, true },
+	"atr14_5m":     func(md *MarketData) (float64, bool) { return md.ATR14_5m, md.ATR14_5m > 0 },
+	"atr14_15m":    func(md *MarketData) (float64, bool) { return md.ATR14_15m, md.ATR14_15m > 0 },
+	"atr14_30m":    func(md *MarketData) (float64, bool) { return md.ATR14_30m, md.ATR14_30m > 0 },
+	"atr14_1h":     func(md *MarketData) (float64, bool) { return md.ATR14_1h, md.ATR14_1h > 0 },
+	"change_1h":    func(md *MarketData) (float64, bool) { return md.PriceChange1h, true },
+	"change_4h":    func(md *MarketData) (float64, bool) { return md.PriceChange4h, true },
+	"funding_rate": func(md *MarketData) (float64, bool) { return md.FundingRate, true },
+}
+
+func lastCloseField(md *MarketData, tf string) (float64, bool) {
+	v := md.LastClose[tf]
+	return v, v > 0
+}
+
+func longerTermField(md *MarketData, get func(l *LongerTermData) float64) (float64, bool) {
+	if md.LongerTermContext == nil {
+		return 0, false
+	}
+	v := get(md.LongerTermContext)
+	return v, v > 0
+}
+
+// conditionFieldNames 返回排序后的可用字段列表（用于 Prompt 和错误提示）
+func conditionFieldNames() []string {
+	names := make([]string, 0, len(conditionFields))
+	for name := range conditionFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type numExpr interface {
+	value(md *MarketData) (float64, bool)
+}
+
+type boolExpr interface {
+	holds(md *MarketData) (bool, bool)
+}
+
+type numLit float64
+
+func (n numLit) value(*MarketData) (float64, bool) { return float64(n), true }
+
+type fieldRef string
+
+func (f fieldRef) value(md *MarketData) (float64, bool) { return conditionFields[string(f)](md) }
+
+type binaryNum struct {
+	op   byte
+	l, r numExpr
+}
+
+func (b binaryNum) value(md *MarketData) (float64, bool) {
+	l, ok1 := b.l.value(md)
+	r, ok2 := b.r.value(md)
+	if !ok1 || !ok2 {
+		return 0, false
+	}
+	switch b.op {
+	case '+':
+		return l + r, true
+	case '-':
+		return l - r, true
+	case '*':
+		return l * r, true
+	case '/':
+		if r == 0 {
+			return 0, false
+		}
+		return l / r, true
+	}
+	return 0, false
+}
+
+type compareExpr struct {
+	op   string
+	l, r numExpr
+}
+
+func (c compareExpr) holds(md *MarketData) (bool, bool) {
+	l, ok1 := c.l.value(md)
+	r, ok2 := c.r.value(md)
+	if !ok1 || !ok2 {
+		return false, false
+	}
+	switch c.op {
+	case "<":
+		return l < r, true
+	case "<=":
+		return l <= r, true
+	case ">":
+		return l > r, true
+	case ">=":
+		return l >= r, true
+	}
+	return false, false
+}
+
+type logicExpr struct {
+	and  bool
+	l, r boolExpr
+}
+
+// holds 数据缺失的子条件视为不成立：AND 需两侧都可求值且成立，OR 任一侧成立即可
+func (e logicExpr) holds(md *MarketData) (bool, bool) {
+	l, okL := e.l.holds(md)
+	r, okR := e.r.holds(md)
+	if e.and {
+		return okL && okR && l && r, okL && okR
+	}
+	return (okL && l) || (okR && r), okL || okR
+}
+
+// ParseCondition 解析失效条件表达式
+func ParseCondition(src string) (boolExpr, error) {
+	toks, err := tokenizeCondition(src)
+	if err != nil {
+		return nil, err
+	}
+	if len(toks) == 0 {
+		return nil, fmt.Errorf("空表达式")
+	}
+	p := &condParser{toks: toks}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.toks) {
+		return nil, fmt.Errorf("多余的符号 %q", p.toks[p.pos])
+	}
+	return expr, nil
+}
+
+func tokenizeCondition(src string) ([]string, error) {
+	var toks []string
+	rs := []rune(src)
+	for i := 0; i < len(rs); {
+		c := rs[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case unicode.IsDigit(c) || c == '.':
+			j := i
+			for j < len(rs) && (unicode.IsDigit(rs[j]) || rs[j] == '.' || rs[j] == 'e' || rs[j] == 'E' ||
+				((rs[j] == '+' || rs[j] == '-') && j > i && (rs[j-1] == 'e' || rs[j-1] == 'E'))) {
+				j++
+			}
+			toks = append(toks, string(rs[i:j]))
+			i = j
+		case unicode.IsLetter(c) || c == '_':
+			j := i
+			for j < len(rs) && (unicode.IsLetter(rs[j]) || unicode.IsDigit(rs[j]) || rs[j] == '_') {
+				j++
+			}
+			toks = append(toks, strings.ToLower(string(rs[i:j])))
+			i = j
+		case c == '<' || c == '>':
+			if i+1 < len(rs) && rs[i+1] == '=' {
+				toks = append(toks, string(rs[i:i+2]))
+				i += 2
+			} else {
+				toks = append(toks, string(c))
+				i++
+			}
+		case c == '&' || c == '|':
+			if i+1 >= len(rs) || rs[i+1] != c {
+				return nil, fmt.Errorf("无效符号 %q", string(c))
+			}
+			toks = append(toks, string(rs[i:i+2]))
+			i += 2
+		case strings.ContainsRune("+-*/()", c):
+			toks = append(toks, string(c))
+			i++
+		default:
+			return nil, fmt.Errorf("无效字符 %q", string(c))
+		}
+	}
+	return toks, nil
+}
+
+type condParser struct {
+	toks []string
+	pos  int
+}
+
+func (p *condParser) peek() string {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos]
+	}
+	return ""
+}
+
+func (p *condParser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *condParser) parseOr() (boolExpr, error) {
+	l, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for t := p.peek(); t == "or" || t == "||"; t = p.peek() {
+		p.next()
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l = logicExpr{and: false, l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *condParser) parseAnd() (boolExpr, error) {
+	l, err := p.parseCompare()
+	if err != nil {
+		return nil, err
+	}
+	for t := p.peek(); t == "and" || t == "&&"; t = p.peek() {
+		p.next()
+		r, err := p.parseCompare()
+		if err != nil {
+			return nil, err
+		}
+		l = logicExpr{and: true, l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *condParser) parseCompare() (boolExpr, error) {
+	// "(" 既可能包裹布尔表达式，也可能包裹算术表达式：先按布尔表达式尝试，失败再回退
+	if p.peek() == "(" {
+		save := p.pos
+		p.next()
+		if inner, err := p.parseOr(); err == nil && p.peek() == ")" {
+			p.next()
+			return inner, nil
+		}
+		p.pos = save
+	}
+	l, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	op := p.next()
+	switch op {
+	case "<", "<=", ">", ">=":
+	default:
+		return nil, fmt.Errorf("缺少比较运算符，得到 %q", op)
+	}
+	r, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	return compareExpr{op: op, l: l, r: r}, nil
+}
+
+func (p *condParser) parseSum() (numExpr, error) {
+	l, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for t := p.peek(); t == "+" || t == "-"; t = p.peek() {
+		p.next()
+		r, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		l = binaryNum{op: t[0], l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *condParser) parseProduct() (numExpr, error) {
+	l, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for t := p.peek(); t == "*" || t == "/"; t = p.peek() {
+		p.next()
+		r, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l = binaryNum{op: t[0], l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *condParser) parseUnary() (numExpr, error) {
+	t := p.next()
+	switch {
+	case t == "":
+		return nil, fmt.Errorf("表达式不完整")
+	case t == "-":
+		inner, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return binaryNum{op: '-', l: numLit(0), r: inner}, nil
+	case t == "(":
+		inner, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("缺少右括号")
+		}
+		return inner, nil
+	case unicode.IsDigit(rune(t[0])) || t[0] == '.':
+		v, err := strconv.ParseFloat(t, 64)
+		if err != nil {
+			return nil, fmt.Errorf("无效数字 %q", t)
+		}
+		return numLit(v), nil
+	default:
+		if _, ok := conditionFields[t]; !ok {
+			return nil, fmt.Errorf("未知字段 %q", t)
+		}
+		return fieldRef(t), nil
+	}
+}
+
+// ===== 失效条件监控 =====
+
+// InvalidationRule 持仓腿上登记的失效条件
+type InvalidationRule struct {
+	Symbol     string    `json:"symbol"`
+	Side       string    `json:"side"`
+	Expr       string    `json:"expr"`
+	ParseError string    `json:"parse_error,omitempty"` // 无法解析时只保存文本，不参与监控
+	CreatedAt  time.Time `json:"created_at"`
+	Triggered  bool      `json:"triggered"` // 已触发、等待执行平仓
+
+	cond boolExpr
+	gen  uint64 // 代次：每次登记递增，替换或移除后旧条件触发的事件不再执行
+}
+
+// InvalidationMonitor 按持仓腿保存 AI 给出的失效条件，每个周期和每个实时价格推送时求值，触发即平仓
+type InvalidationMonitor struct {
+	mu         sync.Mutex
+	filePath   string
+	rules      map[string]*InvalidationRule // key: positionKey(symbol, side)
+	marketData map[string]*MarketData       // 最近一个周期的行情快照，实时推送只覆盖价格
+	fired      chan PositionEvent
+	gen        uint64
+}
+
+// NewInvalidationMonitor 创建监控器并从 filePath 恢复已登记的条件（filePath 为空时只保存在内存中）
+func NewInvalidationMonitor(filePath string) *InvalidationMonitor {
+	m := &InvalidationMonitor{
+		filePath:   filePath,
+		rules:      make(map[string]*InvalidationRule),
+		marketData: make(map[string]*MarketData),
+		fired:      make(chan PositionEvent, 16),
+	}
+	m.load()
+	return m
+}
+
+func (m *InvalidationMonitor) load() {
+	if m.filePath == "" {
+		return
+	}
+	data, err := os.ReadFile(m.filePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("⚠️ 加载失效条件失败: %v", err)
+		}
+		return
+	}
+	var rules map[string]*InvalidationRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		log.Printf("⚠️ 解析失效条件文件失败: %v", err)
+		return
+	}
+	for key, r := range rules {
+		r.Triggered = false
+		if r.ParseError == "" {
+			if cond, err := ParseCondition(r.Expr); err == nil {
+				r.cond = cond
+			} else {
+				r.ParseError = err.Error()
+			}
+		}
+		m.gen++
+		r.gen = m.gen
+		m.rules[key] = r
+	}
+	if len(m.rules) > 0 {
+		log.Printf("✅ [Invalidation] 已恢复 %d 条失效条件", len(m.rules))
+	}
+}
+
+// saveLocked 持久化当前条件（调用方需持有锁）
+func (m *InvalidationMonitor) saveLocked() {
+	if m.filePath == "" {
+		return
+	}
+	if err := writeJSONFile(m.filePath, m.rules); err != nil {
+		log.Printf("⚠️ 保存失效条件失败: %v", err)
+	}
+}
+
+// Set 为持仓腿登记（或替换）失效条件；无法解析时仍保存文本并返回错误
+func (m *InvalidationMonitor) Set(symbol, side, expr string) error {
+	side = normalizePositionSide(side)
+	expr = strings.TrimSpace(expr)
+	if side == "" || expr == "" {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := positionKey(symbol, side)
+	if old, ok := m.rules[key]; ok && old.Expr == expr {
+		return nil
+	}
+	m.gen++
+	r := &InvalidationRule{Symbol: symbol, Side: side, Expr: expr, CreatedAt: time.Now(), gen: m.gen}
+	cond, err := ParseCondition(expr)
+	if err != nil {
+		r.ParseError = err.Error()
+	} else {
+		r.cond = cond
+	}
+	m.rules[key] = r
+	m.saveLocked()
+	if err != nil {
+		return fmt.Errorf("失效条件 %q 无法解析: %w", expr, err)
+	}
+	log.Printf("🎯 [Invalidation] %s %s 登记失效条件: %s", symbol, side, expr)
+	return nil
+}
+
+// Remove 移除持仓腿的失效条件；持仓腿被平仓或重新开仓时调用，旧条件已触发但尚未执行的事件随之作废
+func (m *InvalidationMonitor) Remove(symbol, side string) {
+	side = normalizePositionSide(side)
+	if side == "" {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := positionKey(symbol, side)
+	if _, ok := m.rules[key]; ok {
+		delete(m.rules, key)
+		m.saveLocked()
+	}
+}
+
+// Get 返回持仓腿的失效条件副本
+func (m *InvalidationMonitor) Get(symbol, side string) (InvalidationRule, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if r, ok := m.rules[positionKey(symbol, normalizePositionSide(side))]; ok {
+		return *r, true
+	}
+	return InvalidationRule{}, false
+}
+
+// Sync 清理已不存在的持仓腿的条件，并更新行情快照
+func (m *InvalidationMonitor) Sync(positions []PositionInfo, mdMap map[string]*MarketData) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	active := make(map[string]bool, len(positions))
+	for _, p := range positions {
+		active[positionKey(p.Symbol, normalizePositionSide(p.Side))] = true
+	}
+	changed := false
+	for key := range m.rules {
+		if !active[key] {
+			delete(m.rules, key)
+			changed = true
+		}
+	}
+	if changed {
+		m.saveLocked()
+	}
+	m.marketData = make(map[string]*MarketData, len(mdMap))
+	for symbol, md := range mdMap {
+		if md != nil {
+			snapshot := *md
+			m.marketData[symbol] = &snapshot
+		}
+	}
+}
+
+// Evaluate 用当前行情快照评估所有条件，返回新触发的平仓事件
+func (m *InvalidationMonitor) Evaluate() []PositionEvent {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var events []PositionEvent
+	for _, r := range m.rules {
+		if ev, ok := m.checkLocked(r, m.marketData[r.Symbol]); ok {
+			events = append(events, ev)
+		}
+	}
+	return events
+}
+
+// OnTick 实时价格推送：只覆盖价格后重新评估该币种的条件，触发的事件投递到 Fired() 由主循环执行
+func (m *InvalidationMonitor) OnTick(symbol string, price float64) {
+	if price <= 0 {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	md, ok := m.marketData[symbol]
+	if !ok {
+		return
+	}
+	md.CurrentPrice = price
+	for _, r := range m.rules {
+		if r.Symbol != symbol {
+			continue
+		}
+		if ev, ok := m.checkLocked(r, md); ok {
+			select {
+			case m.fired <- ev:
+			default:
+				// 通道已满时撤销触发标记，留待下个周期评估
+				r.Triggered = false
+			}
+		}
+	}
+}
+
+// checkLocked 评估单条条件，成立时标记为已触发（调用方需持有锁）
+func (m *InvalidationMonitor) checkLocked(r *InvalidationRule, md *MarketData) (PositionEvent, bool) {
+	if r.cond == nil || r.Triggered || md == nil {
+		return PositionEvent{}, false
+	}
+	holds, ok := r.cond.holds(md)
+	if !ok || !holds {
+		return PositionEvent{}, false
+	}
+	r.Triggered = true
+	return PositionEvent{
+		Time:    time.Now(),
+		Rule:    PositionRuleInvalidation,
+		Symbol:  r.Symbol,
+		Side:    r.Side,
+		Action:  "close_" + r.Side,
+		Reason:  fmt.Sprintf("失效条件成立: %s (price=%.4f)", r.Expr, md.CurrentPrice),
+		ruleGen: r.gen,
+	}, true
+}
+
+// Fired 实时推送触发的平仓事件
+func (m *InvalidationMonitor) Fired() <-chan PositionEvent {
+	return m.fired
+}
+
+// DrainFired 取出周期运行期间实时推送触发、尚未执行的事件，由本周期与 Evaluate 的结果一并 Apply
+func (m *InvalidationMonitor) DrainFired() []PositionEvent {
+	var events []PositionEvent
+	for {
+		select {
+		case ev := <-m.fired:
+			events = append(events, ev)
+		default:
+			return events
+		}
+	}
+}
+
+// current 触发事件对应的条件是否仍是当前登记的那一条（未被替换或移除）
+func (m *InvalidationMonitor) current(ev PositionEvent) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.rules[positionKey(ev.Symbol, ev.Side)]
+	return ok && r.gen == ev.ruleGen
+}
+
+// Apply 执行触发的平仓：成功后移除条件，失败则撤销触发标记以便下次重试。
+// 条件已被替换或移除（持仓腿已平仓或重新开仓）的事件直接丢弃，不出现在返回结果中
+func (m *InvalidationMonitor) Apply(events []PositionEvent, exchange Exchange) []PositionEvent {
+	var applied []PositionEvent
+	for i := range events {
+		ev := &events[i]
+		if !m.current(*ev) {
+			log.Printf("ℹ️ [Invalidation] %s %s 的失效条件已变更，忽略过期的触发事件", ev.Symbol, ev.Side)
+			continue
+		}
+		log.Printf("🎯 [Invalidation] %s %s %s", ev.Symbol, ev.Side, ev.Reason)
+		err := exchange.ExecuteDecision(Decision{
+			Symbol:    ev.Symbol,
+			Action:    ev.Action,
+			Side:      ev.Side,
+			Reasoning: positionRuleReason(ev.Rule, ev.Reason),
+		})
+
+		m.mu.Lock()
+		key := positionKey(ev.Symbol, ev.Side)
+		if err != nil {
+			ev.ExecErr = err.Error()
+			log.Printf("❌ [Invalidation] %s %s 平仓失败: %v", ev.Symbol, ev.Side, err)
+			if r, ok := m.rules[key]; ok && r.gen == ev.ruleGen {
+				r.Triggered = false
+			}
+		} else {
+			ev.Executed = true
+			if r, ok := m.rules[key]; ok && r.gen == ev.ruleGen {
+				delete(m.rules, key)
+				m.saveLocked()
+			}
+		}
+		m.mu.Unlock()
+		applied = append(applied, *ev)
+	}
+	return applied
+}
+
+// 全局失效条件监控器
+var globalInvalidationMonitor *InvalidationMonitor
+
+// InitGlobalInvalidationMonitor 初始化全局失效条件监控器
+func InitGlobalInvalidationMonitor(filePath string) {
+	globalInvalidationMonitor = NewInvalidationMonitor(filePath)
+}
+
+// GetInvalidationMonitor 获取全局失效条件监控器
+func GetInvalidationMonitor() *InvalidationMonitor {
+	return globalInvalidationMonitor
+}
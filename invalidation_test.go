@@ -0,0 +1,84 @@
+package main
+
+import "testing"
+
+func TestParseCondition(t *testing.T) {
+	// ema20 / ema20_4h 缺失，用于验证数据缺失时的判定
+	md := &MarketData{
+		CurrentPrice: 100,
+		CurrentRSI7:  30,
+		LastClose:    map[string]float64{"1h": 99},
+	}
+
+	tests := []struct {
+		src     string
+		wantErr bool
+		holds   bool
+		ok      bool // 是否可求值
+	}{
+		// AND 优先级高于 OR，且 && / || 与 AND / OR 等价（不区分大小写）
+		{src: "price > 50 OR price < 10 AND rsi7 > 90", holds: true, ok: true},
+		{src: "price < 10 && rsi7 > 90 || price > 50", holds: true, ok: true},
+		{src: "price > 50 and rsi7 > 90", holds: false, ok: true},
+		{src: "price > 50 Or rsi7 > 90", holds: true, ok: true},
+		{src: "close_1h < 100 AND price >= 100", holds: true, ok: true},
+
+		// "(" 先按布尔表达式尝试，失败后回退为算术括号
+		{src: "(price + 20) * 2 < 300", holds: true, ok: true},
+		{src: "(price < 1 OR rsi7 < 40) AND price > 50", holds: true, ok: true},
+		{src: "(price < 1 AND rsi7 > 2)", holds: false, ok: true},
+		{src: "(price > 1 AND (rsi7 + 10) / 2 > 19)", holds: true, ok: true},
+		{src: "((price)) <= 100", holds: true, ok: true},
+
+		// 算术优先级与结合性
+		{src: "2 + 3 * 4 > 13", holds: true, ok: true},
+		{src: "2 - 3 - 4 < -4", holds: true, ok: true},
+
+		// 一元负号与科学计数法
+		{src: "-price < -99", holds: true, ok: true},
+		{src: "- -price > 99", holds: true, ok: true},
+		{src: "price > 1e2 - 1", holds: true, ok: true},
+		{src: "price < 1.5E+2", holds: true, ok: true},
+		{src: "change_1h > -2.5e-1", holds: true, ok: true},
+
+		// 数据缺失：AND 任一侧不可求值即不触发；OR 可由可求值的一侧触发
+		{src: "ema20 > 0 AND price > 50", holds: false, ok: false},
+		{src: "price > 50 AND ema20 > 0", holds: false, ok: false},
+		{src: "ema20 > 0 OR price > 50", holds: true, ok: true},
+		{src: "ema20 > 0 OR price < 50", holds: false, ok: true},
+		{src: "ema20 > 0 OR ema20_4h > 0", holds: false, ok: false},
+		{src: "price / 0 > 1", holds: false, ok: false},
+
+		// 语法错误
+		{src: "", wantErr: true},
+		{src: "foo > 1", wantErr: true},
+		{src: "price >", wantErr: true},
+		{src: "price > 1 AND", wantErr: true},
+		{src: "price > 1 ||", wantErr: true},
+		{src: "price + > 1", wantErr: true},
+		{src: "price 1", wantErr: true},
+		{src: "(price > 1", wantErr: true},
+		{src: "price > 1)", wantErr: true},
+		{src: "price & 1", wantErr: true},
+		{src: "price > 1 $", wantErr: true},
+		{src: "price > 1..2", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		cond, err := ParseCondition(tt.src)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseCondition(%q): want error, got nil", tt.src)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseCondition(%q): unexpected error: %v", tt.src, err)
+			continue
+		}
+		holds, ok := cond.holds(md)
+		if holds != tt.holds || ok != tt.ok {
+			t.Errorf("%q: holds = (%v, %v), want (%v, %v)", tt.src, holds, ok, tt.holds, tt.ok)
+		}
+	}
+}
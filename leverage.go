@@ -1,56 +1,215 @@
 package main
 
 import (
+	"encoding/json"
+	"log"
+	"os"
+	"sort"
 	"sync"
+	"time"
 )
 
-// LeverageManager 管理全局杠杆配置，支持并发读写
+// 杠杆来源（用于日志、风控调整原因和 Prompt 展示）
+const (
+	LeverageSourceStrategy = "strategy"     // 策略固定杠杆
+	LeverageSourceTier     = "tier_default" // 策略未配置杠杆时按层级（major / alt）的默认值
+	LeverageSourceOverride = "override"     // CLI / Web 手动设置的单币种杠杆
+	LeverageSourceAssetCap = "asset_cap"    // 被资产注册表 max_leverage 限制
+	LeverageSourceExchange = "exchange_max" // 被交易所档位允许的最大杠杆限制
+)
+
+// LeverageResolution 某个币种最终使用的杠杆及其来源
+type LeverageResolution struct {
+	Symbol   string `json:"symbol"`
+	Leverage int    `json:"leverage"`
+	Source   string `json:"source"`
+}
+
+// LeverageManager 杠杆的唯一来源：策略固定杠杆 / 层级默认值 < 单币种手动设置，
+// 再受资产注册表 max_leverage 与交易所档位最大杠杆约束。风控、执行和 Prompt 均通过 Resolve 取值。
+// 单币种设置持久化到 filePath，CLI（独立进程）写入后主循环通过 Reload 同步。
 type LeverageManager struct {
 	mu            sync.RWMutex
 	DefaultBTCETH int
 	DefaultAlt    int
 	Specific      map[string]int
+
+	filePath string
+	modTime  time.Time
 }
 
-// NewLeverageManager 创建新的杠杆管理器
-func NewLeverageManager(defaultBTCETH, defaultAlt int) *LeverageManager {
-	return &LeverageManager{
+// NewLeverageManager 创建新的杠杆管理器；filePath 非空时从中加载单币种设置
+func NewLeverageManager(defaultBTCETH, defaultAlt int, filePath string) *LeverageManager {
+	l := &LeverageManager{
 		DefaultBTCETH: defaultBTCETH,
 		DefaultAlt:    defaultAlt,
 		Specific:      make(map[string]int),
+		filePath:      filePath,
+	}
+	l.Reload()
+	return l
+}
+
+// Reload 单币种设置文件有更新时重新加载（例如 CLI set-lev 修改后）
+func (l *LeverageManager) Reload() {
+	if l.filePath == "" {
+		return
+	}
+	info, err := os.Stat(l.filePath)
+	if err != nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !info.ModTime().After(l.modTime) {
+		return
 	}
+	data, err := os.ReadFile(l.filePath)
+	if err != nil {
+		return
+	}
+	specific := make(map[string]int)
+	if err := json.Unmarshal(data, &specific); err != nil {
+		log.Printf("⚠️ [Leverage] 解析 %s 失败: %v", l.filePath, err)
+		return
+	}
+	l.Specific = specific
+	l.modTime = info.ModTime()
+	log.Printf("✅ [Leverage] 从 %s 加载 %d 个单币种杠杆设置", l.filePath, len(specific))
+}
+
+// saveLocked 持久化单币种设置（调用方需持有写锁）
+func (l *LeverageManager) saveLocked() {
+	if l.filePath == "" {
+		return
+	}
+	if err := writeJSONFile(l.filePath, l.Specific); err != nil {
+		log.Printf("⚠️ [Leverage] 保存 %s 失败: %v", l.filePath, err)
+		return
+	}
+	if info, err := os.Stat(l.filePath); err == nil {
+		l.modTime = info.ModTime()
+	}
+}
+
+// exchangeMaxLeverage 交易所档位允许的最大杠杆（取最低名义档位），无档位数据时返回 0
+func exchangeMaxLeverage(symbol string) int {
+	max := 0
+	for _, b := range GetMarginBrackets().Get(symbol) {
+		if b.InitialLeverage > max {
+			max = b.InitialLeverage
+		}
+	}
+	return max
 }
 
-// Get 获取指定币种的最大杠杆
+// Resolve 计算币种最终使用的杠杆；strategyLeverage 为当前策略的固定杠杆（<= 0 表示未配置）
+func (l *LeverageManager) Resolve(symbol string, strategyLeverage int) LeverageResolution {
+	l.mu.RLock()
+	override, hasOverride := l.Specific[symbol]
+	defaultBTCETH, defaultAlt := l.DefaultBTCETH, l.DefaultAlt
+	l.mu.RUnlock()
+
+	asset := GetAssetRegistry().Get(symbol)
+	res := LeverageResolution{Symbol: symbol}
+	switch {
+	case hasOverride && override > 0:
+		res.Leverage, res.Source = override, LeverageSourceOverride
+	case strategyLeverage > 0:
+		res.Leverage, res.Source = strategyLeverage, LeverageSourceStrategy
+	case asset.Tier == AssetTierMajor:
+		res.Leverage, res.Source = defaultBTCETH, LeverageSourceTier
+	default:
+		res.Leverage, res.Source = defaultAlt, LeverageSourceTier
+	}
+
+	if asset.MaxLeverage > 0 && res.Leverage > asset.MaxLeverage {
+		res.Leverage, res.Source = asset.MaxLeverage, LeverageSourceAssetCap
+	}
+	if max := exchangeMaxLeverage(symbol); max > 0 && res.Leverage > max {
+		res.Leverage, res.Source = max, LeverageSourceExchange
+	}
+	if res.Leverage <= 0 {
+		res.Leverage = 1
+	}
+	return res
+}
+
+// Get 获取指定币种在当前策略下使用的杠杆
 func (l *LeverageManager) Get(symbol string) int {
+	return l.Resolve(symbol, getRiskConfig().FixedLeverage).Leverage
+}
+
+// ResolveAll 批量计算多个币种的杠杆（用于 Prompt 与 Web 展示）
+func (l *LeverageManager) ResolveAll(symbols []string, strategyLeverage int) []LeverageResolution {
+	seen := make(map[string]bool, len(symbols))
+	var out []LeverageResolution
+	for _, s := range symbols {
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, l.Resolve(s, strategyLeverage))
+		}
+	}
+	for _, s := range l.overrideSymbols() {
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, l.Resolve(s, strategyLeverage))
+		}
+	}
+	return out
+}
+
+// overrideSymbols 返回所有有单币种设置的交易对（已排序）
+func (l *LeverageManager) overrideSymbols() []string {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
-	
-	if val, ok := l.Specific[symbol]; ok {
-		return val
-	}
-	
-	if symbol == "BTCUSDT" || symbol == "ETHUSDT" {
-		return l.DefaultBTCETH
+	out := make([]string, 0, len(l.Specific))
+	for s := range l.Specific {
+		out = append(out, s)
 	}
-	return l.DefaultAlt
+	sort.Strings(out)
+	return out
 }
 
-// Set 设置指定币种的杠杆
+// Set 设置指定币种的杠杆并持久化；val <= 0 表示清除单币种设置
 func (l *LeverageManager) Set(symbol string, val int) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
-	l.Specific[symbol] = val
+	if val <= 0 {
+		delete(l.Specific, symbol)
+	} else {
+		l.Specific[symbol] = val
+	}
+	l.saveLocked()
 }
 
 // GetAllSpecific 获取所有特殊配置（用于上下文传递）
 func (l *LeverageManager) GetAllSpecific() map[string]int {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
-	
+
 	copy := make(map[string]int)
 	for k, v := range l.Specific {
 		copy[k] = v
 	}
 	return copy
 }
+
+// leverageOverridesFile 单币种杠杆设置的持久化文件（主进程、CLI 与 Web 共用）
+const leverageOverridesFile = "data/leverage.json"
+
+// 全局杠杆管理器
+var globalLeverageManager *LeverageManager
+
+// InitGlobalLeverageManager 初始化全局杠杆管理器
+func InitGlobalLeverageManager(defaultBTCETH, defaultAlt int, filePath string) {
+	globalLeverageManager = NewLeverageManager(defaultBTCETH, defaultAlt, filePath)
+}
+
+// GetLeverageManager 获取全局杠杆管理器；未初始化时（如回测）返回不带单币种设置的管理器
+func GetLeverageManager() *LeverageManager {
+	if globalLeverageManager == nil {
+		globalLeverageManager = NewLeverageManager(10, 10, "")
+	}
+	return globalLeverageManager
+}
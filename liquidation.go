@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"sync"
+)
+
+// MaintenanceBracket 维持保证金档位（与币安 /fapi/v1/leverageBracket 字段一致）
+type MaintenanceBracket struct {
+	NotionalFloor    float64 `json:"notional_floor"`     // 档位名义价值下限 (USDT)
+	NotionalCap      float64 `json:"notional_cap"`       // 档位名义价值上限 (USDT)，0 表示无上限
+	MaintMarginRatio float64 `json:"maint_margin_ratio"` // 维持保证金率
+	Cum              float64 `json:"cum"`                // 维持保证金速算额
+	InitialLeverage  int     `json:"initial_leverage"`   // 该档位允许的最大杠杆
+}
+
+// withCumulative 按币安规则补齐速算额：cum[i] = cum[i-1] + floor[i] × (mmr[i] - mmr[i-1])
+func withCumulative(brackets []MaintenanceBracket) []MaintenanceBracket {
+	out := make([]MaintenanceBracket, len(brackets))
+	copy(out, brackets)
+	sort.Slice(out, func(i, j int) bool { return out[i].NotionalFloor < out[j].NotionalFloor })
+	for i := 1; i < len(out); i++ {
+		if out[i].Cum == 0 {
+			out[i].Cum = out[i-1].Cum + out[i].NotionalFloor*(out[i].MaintMarginRatio-out[i-1].MaintMarginRatio)
+		}
+	}
+	return out
+}
+
+// defaultMarginBrackets 本地维持保证金档位表（参考币安 USDT 永续），交易所拉取失败或回测时使用
+var defaultMarginBrackets = map[string][]MaintenanceBracket{
+	"BTCUSDT": withCumulative([]MaintenanceBracket{
+		{NotionalFloor: 0, NotionalCap: 50000, MaintMarginRatio: 0.004, InitialLeverage: 125},
+		{NotionalFloor: 50000, NotionalCap: 500000, MaintMarginRatio: 0.005, InitialLeverage: 100},
+		{NotionalFloor: 500000, NotionalCap: 8000000, MaintMarginRatio: 0.01, InitialLeverage: 50},
+		{NotionalFloor: 8000000, NotionalCap: 50000000, MaintMarginRatio: 0.025, InitialLeverage: 20},
+		{NotionalFloor: 50000000, NotionalCap: 80000000, MaintMarginRatio: 0.05, InitialLeverage: 10},
+		{NotionalFloor: 80000000, NotionalCap: 100000000, MaintMarginRatio: 0.1, InitialLeverage: 5},
+		{NotionalFloor: 100000000, NotionalCap: 200000000, MaintMarginRatio: 0.125, InitialLeverage: 4},
+		{NotionalFloor: 200000000, NotionalCap: 300000000, MaintMarginRatio: 0.15, InitialLeverage: 3},
+		{NotionalFloor: 300000000, NotionalCap: 500000000, MaintMarginRatio: 0.25, InitialLeverage: 2},
+		{NotionalFloor: 500000000, MaintMarginRatio: 0.5, InitialLeverage: 1},
+	}),
+	"ETHUSDT": withCumulative([]MaintenanceBracket{
+		{NotionalFloor: 0, NotionalCap: 50000, MaintMarginRatio: 0.005, InitialLeverage: 100},
+		{NotionalFloor: 50000, NotionalCap: 500000, MaintMarginRatio: 0.0065, InitialLeverage: 75},
+		{NotionalFloor: 500000, NotionalCap: 8000000, MaintMarginRatio: 0.01, InitialLeverage: 50},
+		{NotionalFloor: 8000000, NotionalCap: 50000000, MaintMarginRatio: 0.02, InitialLeverage: 25},
+		{NotionalFloor: 50000000, NotionalCap: 80000000, MaintMarginRatio: 0.05, InitialLeverage: 10},
+		{NotionalFloor: 80000000, NotionalCap: 100000000, MaintMarginRatio: 0.1, InitialLeverage: 5},
+		{NotionalFloor: 100000000, NotionalCap: 200000000, MaintMarginRatio: 0.125, InitialLeverage: 4},
+		{NotionalFloor: 200000000, NotionalCap: 300000000, MaintMarginRatio: 0.15, InitialLeverage: 3},
+		{NotionalFloor: 300000000, MaintMarginRatio: 0.25, InitialLeverage: 2},
+	}),
+}
+
+// defaultAltMarginBrackets 未单独配置的币种使用的通用档位（偏保守）
+var defaultAltMarginBrackets = withCumulative([]MaintenanceBracket{
+	{NotionalFloor: 0, NotionalCap: 5000, MaintMarginRatio: 0.01, InitialLeverage: 50},
+	{NotionalFloor: 5000, NotionalCap: 25000, MaintMarginRatio: 0.025, InitialLeverage: 20},
+	{NotionalFloor: 25000, NotionalCap: 100000, MaintMarginRatio: 0.05, InitialLeverage: 10},
+	{NotionalFloor: 100000, NotionalCap: 250000, MaintMarginRatio: 0.1, InitialLeverage: 5},
+	{NotionalFloor: 250000, NotionalCap: 1000000, MaintMarginRatio: 0.125, InitialLeverage: 4},
+	{NotionalFloor: 1000000, NotionalCap: 5000000, MaintMarginRatio: 0.25, InitialLeverage: 2},
+	{NotionalFloor: 5000000, MaintMarginRatio: 0.5, InitialLeverage: 1},
+})
+
+// MarginBracketTable 维持保证金档位表：本地默认值 < 本地文件 < 交易所实时拉取
+type MarginBracketTable struct {
+	mu       sync.RWMutex
+	brackets map[string][]MaintenanceBracket
+}
+
+// NewMarginBracketTable 创建档位表；filePath 存在时从中加载覆盖（格式: {"SOLUSDT": [{...}, ...]}）
+func NewMarginBracketTable(filePath string) *MarginBracketTable {
+	t := &MarginBracketTable{brackets: make(map[string][]MaintenanceBracket)}
+	for symbol, b := range defaultMarginBrackets {
+		t.brackets[symbol] = b
+	}
+	if filePath == "" {
+		return t
+	}
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return t
+	}
+	var loaded map[string][]MaintenanceBracket
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		log.Printf("⚠️ [Margin Brackets] 解析 %s 失败: %v，使用内置档位表", filePath, err)
+		return t
+	}
+	t.Merge(loaded)
+	log.Printf("✅ [Margin Brackets] 从 %s 加载 %d 个币种的维持保证金档位", filePath, len(loaded))
+	return t
+}
+
+// Merge 覆盖指定币种的档位
+func (t *MarginBracketTable) Merge(brackets map[string][]MaintenanceBracket) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for symbol, b := range brackets {
+		if len(b) > 0 {
+			t.brackets[symbol] = withCumulative(b)
+		}
+	}
+}
+
+// Get 返回币种的档位列表，未配置时返回通用山寨档位
+func (t *MarginBracketTable) Get(symbol string) []MaintenanceBracket {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if b, ok := t.brackets[symbol]; ok {
+		return b
+	}
+	return defaultAltMarginBrackets
+}
+
+// 全局档位表
+var globalMarginBrackets *MarginBracketTable
+
+// InitGlobalMarginBrackets 初始化全局维持保证金档位表
+func InitGlobalMarginBrackets(filePath string) {
+	globalMarginBrackets = NewMarginBracketTable(filePath)
+}
+
+// GetMarginBrackets 获取全局档位表；未初始化时（如回测）返回仅含内置档位的表
+func GetMarginBrackets() *MarginBracketTable {
+	if globalMarginBrackets == nil {
+		globalMarginBrackets = NewMarginBracketTable("")
+	}
+	return globalMarginBrackets
+}
+
+// bracketFor 返回名义价值所在的档位
+func bracketFor(symbol string, notional float64) MaintenanceBracket {
+	brackets := GetMarginBrackets().Get(symbol)
+	for _, b := range brackets {
+		if b.NotionalCap <= 0 || notional <= b.NotionalCap {
+			return b
+		}
+	}
+	return brackets[len(brackets)-1]
+}
+
+// estimateLiquidationPrice 按币安逐仓公式估算强平价：
+//
+//	LP = (WB + cum - s·Q·E) / (Q·MMR - s·Q)，s = 1 (多) / -1 (空)
+//
+// WB 为该仓位的保证金。全仓模式下实际强平价离现价更远，因此这里的估算偏保守。
+func estimateLiquidationPrice(symbol, side string, entry, quantity, margin float64) float64 {
+	qty := math.Abs(quantity)
+	if entry <= 0 || qty <= 0 || margin <= 0 {
+		return 0
+	}
+	b := bracketFor(symbol, entry*qty)
+	s := 1.0
+	if normalizePositionSide(side) == "short" {
+		s = -1.0
+	}
+	denom := qty*b.MaintMarginRatio - s*qty
+	if denom == 0 {
+		return 0
+	}
+	lp := (margin + b.Cum - s*qty*entry) / denom
+	if lp < 0 {
+		return 0
+	}
+	return lp
+}
+
+// positionLiquidationPrice 估算持仓的强平价（模拟盘 / 回测使用）
+func positionLiquidationPrice(p PositionInfo) float64 {
+	return estimateLiquidationPrice(p.Symbol, p.Side, p.EntryPrice, p.Quantity, p.MarginUsed)
+}
+
+// liquidationCheck 开仓决策的强平距离检查结果
+type liquidationCheck struct {
+	LiqPrice     float64
+	StopDistPct  float64 // 入场到止损的距离 (%)
+	LiqDistPct   float64 // 入场到强平价的距离 (%)
+	MaxLeverage  int     // 档位允许的最大杠杆
+	StopIsInside bool    // 止损是否在强平价内侧且保留了足够缓冲
+}
+
+// checkLiquidationDistance 以 entry 开仓、给定杠杆时，止损是否安全地位于强平价内侧。
+// buffer 为强平距离中需要保留的比例，例如 0.2 表示止损距离不得超过强平距离的 80%。
+func checkLiquidationDistance(d *Decision, entry float64, leverage int, buffer float64) liquidationCheck {
+	var c liquidationCheck
+	if entry <= 0 || leverage <= 0 || d.PositionSizeUSD <= 0 {
+		return c
+	}
+	side := "long"
+	if d.Action == "open_short" {
+		side = "short"
+	}
+	c.MaxLeverage = bracketFor(d.Symbol, d.PositionSizeUSD).InitialLeverage
+	c.LiqPrice = estimateLiquidationPrice(d.Symbol, side, entry, d.PositionSizeUSD/entry, d.PositionSizeUSD/float64(leverage))
+	if side == "long" {
+		c.StopDistPct = (entry - d.StopLoss) / entry * 100
+		c.LiqDistPct = (entry - c.LiqPrice) / entry * 100
+	} else {
+		c.StopDistPct = (d.StopLoss - entry) / entry * 100
+		c.LiqDistPct = (c.LiqPrice - entry) / entry * 100
+	}
+	// 做多强平价为 0 表示无法被强平（如 1x），视为安全
+	if side == "long" && c.LiqPrice <= 0 {
+		c.LiqDistPct = 100
+	}
+	c.StopIsInside = c.LiqDistPct > 0 && c.StopDistPct <= c.LiqDistPct*(1-buffer)
+	if c.MaxLeverage > 0 && leverage > c.MaxLeverage {
+		c.StopIsInside = false
+	}
+	return c
+}
+
+// formatLiquidationCheck 用于日志和风控调整原因
+func formatLiquidationCheck(c liquidationCheck) string {
+	return fmt.Sprintf("强平价≈%.4f (距入场 %.2f%%)，止损距入场 %.2f%%", c.LiqPrice, c.LiqDistPct, c.StopDistPct)
+}
@@ -11,29 +11,47 @@ import (
 )
 
 func main() {
-	// 运行期最高净值，用于计算回撤并触发 Drawdown Kill Switch
-	var peakEquity float64
-	// CLI 子命令：手动设置某个交易对杠杆
+	// CLI 子命令：手动设置某个交易对杠杆（写入 data/leverage.json，运行中的主循环下一周期同步）
+	// 传入 0 清除该交易对的单币种设置，恢复使用策略杠杆
 	if len(os.Args) == 4 && os.Args[1] == "set-lev" {
 		symbol := os.Args[2]
 		lev, err := strconv.Atoi(os.Args[3])
-		if err != nil {
-			log.Fatalf("无效的杠杆倍数: %v", err)
+		if err != nil || lev < 0 {
+			log.Fatalf("无效的杠杆倍数: %s", os.Args[3])
 		}
 
 		cfg, err := LoadConfig()
 		if err != nil {
 			log.Fatalf("加载配置失败: %v", err)
 		}
-		if cfg.BinanceAPIKey == "" || cfg.BinanceSecretKey == "" {
-			log.Fatalf("set-lev 只能在实盘模式下使用，请在 config.local.json 中配置 binance_api_key / binance_secret_key")
+		if lev > 0 && cfg.BinanceAPIKey != "" && cfg.BinanceSecretKey != "" {
+			ex := NewBinanceExchange(cfg.BinanceAPIKey, cfg.BinanceSecretKey, cfg.BinanceProxyURL)
+			if err := ex.SetLeverage(symbol, lev); err != nil {
+				log.Fatalf("设置杠杆失败: %v", err)
+			}
+		}
+		NewLeverageManager(cfg.BTCETHLeverage, cfg.AltcoinLeverage, leverageOverridesFile).Set(symbol, lev)
+		if lev == 0 {
+			fmt.Printf("已清除 %s 的单币种杠杆设置\n", symbol)
+		} else {
+			fmt.Printf("已将 %s 杠杆设置为 %dx\n", symbol, lev)
 		}
+		return
+	}
 
-		ex := NewBinanceExchange(cfg.BinanceAPIKey, cfg.BinanceSecretKey, cfg.BinanceProxyURL)
-		if err := ex.SetLeverage(symbol, lev); err != nil {
-			log.Fatalf("设置杠杆失败: %v", err)
+	// CLI 子命令：预览策略 prompt 模板渲染后的 system prompt（不传策略名时使用最近一个配置版本记录的实盘策略）
+	if len(os.Args) >= 2 && os.Args[1] == "preview-prompt" {
+		cfg, err := LoadConfig()
+		if err != nil {
+			log.Fatalf("加载配置失败: %v", err)
+		}
+		name := ""
+		if len(os.Args) >= 3 {
+			name = os.Args[2]
+		}
+		if err := runPromptPreviewCLI(cfg, name); err != nil {
+			log.Fatalf("预览 prompt 失败: %v", err)
 		}
-		fmt.Printf("已将 %s 杠杆设置为 %dx\n", symbol, lev)
 		return
 	}
 
@@ -60,11 +78,27 @@ func main() {
 		exchange = NewBinanceExchange(binanceKey, binanceSecret, cfg.BinanceProxyURL)
 	} else {
 		fmt.Println("🧪 使用模拟交易所 (Simulation Mode)")
-		exchange = NewSimulatedExchange(1000.0) // 1000 U 初始资金
+		simExchange := NewSimulatedExchange(1000.0) // 1000 U 初始资金
+		simExchange.HedgeMode = cfg.HedgeMode
+		exchange = simExchange
 	}
 
+	// 资产分类：内置分类 < config.local.json 的 assets < data/assets.json（Web 编辑）
+	InitGlobalAssetRegistry("data/assets.json", cfg.Assets)
+
+	// 杠杆管理器：策略固定杠杆 / 层级默认值 < 单币种设置（data/leverage.json），受资产与交易所上限约束
+	InitGlobalLeverageManager(cfg.BTCETHLeverage, cfg.AltcoinLeverage, leverageOverridesFile)
+	leverage := GetLeverageManager()
+
+	// 维持保证金档位：内置表 < data/margin_brackets.json < 实盘从交易所拉取
+	// 实盘下交易对加入交易集合时再从交易所拉取（见主循环）
+	InitGlobalMarginBrackets("data/margin_brackets.json")
+
 	brain := NewAIBrain(cfg.AIAPIKey, cfg.AIAPIURL, cfg.AIModel, cfg.BinanceProxyURL)
 
+	// 模型调用计量：记录每次调用的 token / 耗时 / 成本，超出每日预算时降级模型或延长循环周期
+	InitGlobalUsageTracker(cfg.Usage, "data/usage.json")
+
 	// 初始化全局存储
 	if err := InitGlobalStorage("data/storage.db"); err != nil {
 		log.Printf("⚠️ 初始化存储失败: %v (部分功能可能不可用)", err)
@@ -76,18 +110,66 @@ func main() {
 	InitGlobalStrategyManager("strategies")
 	log.Println("✅ 策略管理器已初始化")
 
+	// 初始化通知与账户级熔断（熔断状态持久化，重启后保持）
+	InitGlobalNotifier(cfg.Notifications)
+	InitGlobalCircuitBreaker(*cfg.CircuitBreakers, "data/circuit_breaker.json")
+	breaker := GetCircuitBreaker()
+	log.Println("✅ 熔断器已初始化")
+
+	// 初始化持仓管理器（记录初始止损，用于保本 / 回撤收紧等规则）
+	InitGlobalPositionManager("data/position_manager.json")
+	positionManager := GetPositionManager()
+
+	// 初始化失效条件监控：每个周期评估一次，实盘下另外订阅标记价格推送实时评估
+	InitGlobalInvalidationMonitor("data/invalidation.json")
+	invalidation := GetInvalidationMonitor()
+
+	// 初始化开仓守卫：记录各币种开平仓历史，用于冷却期 / 每日开仓次数 / 禁止反手
+	InitGlobalEntryGuard("data/entry_guard.json")
+	entryGuard := GetEntryGuard()
+
+	// 初始化置信度校准：记录开仓时 AI 声明的 confidence，平仓后统计实际胜率与 R 倍数
+	InitGlobalCalibrator("data/calibration.json")
+	calibrator := GetCalibrator()
+
+	// 平仓复盘：记录开仓决策与持仓期间价格路径，平仓后异步请求模型复盘（reflection.enabled 为 false 时为 nil）
+	InitGlobalReflectionJournal(cfg, "data/reflections.json")
+	reflections := GetReflectionJournal()
+
 	// 启动 Web 监控（携带默认循环周期配置）
 	server := NewWebServer(cfg.LoopIntervalSeconds)
 	server.Start(8080)
 
-	// 从配置文件读取交易设置
-	tradingCoins := cfg.TradingSymbols
-	btcEthLeverage := cfg.BTCETHLeverage
-	altcoinLeverage := cfg.AltcoinLeverage
+	// 配置版本：策略 / 风控参数 / prompt / 杠杆 / 循环周期变化时生成新版本，决策与交易记录据此标记
+	InitGlobalConfigVersions(func() ConfigState {
+		return currentConfigState(GetUsageTracker().EffectiveModel(cfg.AIModel), server.GetLoopIntervalSeconds)
+	}, "data/config_versions.json")
+	configVersions := GetConfigVersions()
+
+	// 交易对集合：每个周期按当前策略解析（策略未配置 symbols 时使用 trading_symbols）
+	InitGlobalSymbolUniverse()
+	universe := GetSymbolUniverse()
+	// 动态扫描：定期选出固定交易对之外的候选（scanner.enabled 为 false 时为 nil）
+	InitGlobalSymbolScanner(cfg.Scanner, exchange)
+	scanner := GetSymbolScanner()
+	// 市场状态识别：按趋势 / 震荡 / 高波动 / 低流动性自动切换策略（regime.enabled 为 false 时为 nil）
+	InitGlobalRegimeDetector(cfg.Regime, "data/regime.json")
+	regime := GetRegimeDetector()
+	// 影子策略：每个周期以实盘上下文异步运行，在各自的模拟账户中执行（未配置时为 nil）
+	InitGlobalShadowManager(cfg, exchange.IsHedgeMode())
+	shadows := GetShadowManager()
+	if bex, ok := exchange.(*BinanceExchange); ok {
+		// 订阅集合在第一个周期解析交易对后设置
+		bex.StreamMarkPrices(nil, invalidation.OnTick)
+	}
 
 	callCount := 0
 	runtimeStart := time.Now()
 	var equityHistory []float64
+	// 上一次等待期间由实时价格推送触发的失效条件平仓，展示在下一轮 Prompt 中
+	var tickEvents []PositionEvent
+	// 拉取维持保证金档位失败的交易对，后续周期重试
+	var bracketRetry []string
 
 	for {
 		callCount++
@@ -95,27 +177,65 @@ func main() {
 		fmt.Printf("⏰ 周期 #%d | 时间: %s\n", callCount, time.Now().Format("15:04:05"))
 		fmt.Printf("%s\n", strings.Repeat("=", 60))
 
-		// 1. 获取行情
+		// 同步 CLI / Web 修改的单币种杠杆
+		leverage.Reload()
+		// 重新加载 strategies/*.json 中有变化的策略文件，修改从本周期生效
+		GetStrategyManager().Reload()
+
+		// 按当前策略解析交易对（排除资产黑名单），启用扫描时追加扫描候选：
+		// 新增的交易对先拉取档位并预热行情，移出的交易对只管理已有持仓
+		symbols := filterBlacklisted(GetStrategyManager().GetSymbols(cfg.TradingSymbols))
+		if scanner != nil {
+			scanner.Refresh(symbols, time.Now())
+			symbols = scanner.Symbols(symbols)
+		}
+		added, removed := universe.Update(symbols)
+		if len(added) > 0 || len(removed) > 0 {
+			log.Printf("ℹ️ [Universe] 交易对变更: 新增 %v，移出 %v", added, removed)
+		}
+		if bex, ok := exchange.(*BinanceExchange); ok {
+			if pending := pendingBracketSymbols(added, bracketRetry, universe.FetchSymbols()); len(pending) > 0 {
+				brackets, err := bex.FetchMarginBrackets(pending)
+				if err != nil {
+					log.Printf("⚠️ 拉取维持保证金档位失败，未拉取到的币种暂用本地档位表，下个周期重试: %v", err)
+				}
+				GetMarginBrackets().Merge(brackets)
+				bracketRetry = bracketRetry[:0]
+				for _, sym := range pending {
+					if len(brackets[sym]) == 0 {
+						bracketRetry = append(bracketRetry, sym)
+					}
+				}
+			}
+			if len(added) > 0 || len(removed) > 0 {
+				bex.UpdateMarkPriceSymbols(universe.FetchSymbols())
+			}
+		}
+
+		// 1. 获取行情（含已移出策略但仍有持仓的交易对）
 		fmt.Print("📡 正在获取真实市场行情...")
-		if err := exchange.FetchMarketData(tradingCoins); err != nil {
+		if err := exchange.FetchMarketData(universe.FetchSymbols()); err != nil {
 			log.Printf("获取行情失败: %v", err)
 			time.Sleep(5 * time.Second)
 			continue
 		}
 		fmt.Println("完成")
+		if scanner != nil {
+			scanner.TagMarketData(exchange.GetMarketData())
+		}
+		universe.MarkWarm(exchange.GetMarketData())
+		// 本轮提供给 AI 的候选交易对
+		tradingCoins := universe.Tradable()
+		// 识别市场状态，确认变化时按映射切换策略：风控参数与 prompt 本周期生效，交易对下一周期生效
+		if regime != nil {
+			regime.Evaluate(exchange.GetMarketData(), tradingCoins, time.Now())
+		}
+		// 兜底识别 CLI 修改杠杆、策略文件热加载等未经 Web 接口的配置变化
+		configVersions.Check("")
 
 		// 2. 构建上下文
 		accountInfo := exchange.GetAccountInfo()
 
-		// 运行期高点与回撤跟踪（用于 Drawdown Kill Switch）
-		if peakEquity <= 0 || accountInfo.TotalEquity > peakEquity {
-			peakEquity = accountInfo.TotalEquity
-		}
-		drawdown := 0.0
-		if peakEquity > 0 {
-			drawdown = 1 - accountInfo.TotalEquity/peakEquity
-		}
-		
 		// 更新权益历史并计算夏普比率
 		equityHistory = append(equityHistory, accountInfo.TotalEquity)
 		sharpeRatio := CalculateRuntimeSharpe(equityHistory)
@@ -124,22 +244,46 @@ func main() {
 		positions := exchange.GetPositions()
 		marketData := exchange.GetMarketData()
 
-		// 在进入 AI 决策前执行一次硬止损检查：如果某些持仓浮亏已深于阈值，则直接由后端强制止损平仓。
-		enforceHardStopLoss(positions, exchange)
+		// 在进入 AI 决策前执行持仓管理规则（硬止损 / 时间止损 / 回撤收紧 / 保本），结果会展示在本轮 Prompt 中
+		positionEvents := positionManager.Apply(positionManager.Evaluate(positions, getPositionRules(), time.Now()), exchange)
+		// 再评估 AI 登记的失效条件，成立则直接平仓（含上一周期运行期间实时推送触发、尚未执行的事件）
+		invalidation.Sync(positions, marketData)
+		positionEvents = append(positionEvents, invalidation.Apply(append(invalidation.DrainFired(), invalidation.Evaluate()...), exchange)...)
+		for _, ev := range positionEvents {
+			if ev.Executed && ev.Action != "update_stop_loss" {
+				// 有持仓被平掉，刷新账户与持仓
+				accountInfo = exchange.GetAccountInfo()
+				positions = exchange.GetPositions()
+				break
+			}
+		}
+		positionEvents = append(tickEvents, positionEvents...)
+		tickEvents = nil
+
+		// 按新增平仓记录与上一周期持仓识别平仓（含交易所侧止损），用于冷却期与禁止反手
+		entryGuard.Observe(positions, exchange.GetTradeHistory(), time.Now())
+		calibrator.Observe(positions, exchange.GetTradeHistory(), time.Now())
+		reflections.Observe(positions, exchange.GetTradeHistory(), time.Now())
 
 		ctx := &Context{
 			CurrentTime:     time.Now().Format("2006-01-02 15:04:05"),
 			RuntimeMinutes:  int(time.Since(runtimeStart).Minutes()),
 			CallCount:       callCount,
+			Symbols:         tradingCoins,
 			Account:         accountInfo,
 			Positions:       positions,
 			MarketDataMap:   marketData,
 			Sectors:         calculateSectorHeat(marketData), // 计算板块热度
-			BTCETHLeverage:  btcEthLeverage,
-			AltcoinLeverage: altcoinLeverage,
+			Leverage:        leverage.ResolveAll(tradingCoins, getRiskConfig().FixedLeverage),
 			SharpeRatio:     sharpeRatio,
+			HedgeMode:       exchange.IsHedgeMode(),
+			Portfolio:       buildPortfolioExposure(positionLegs(positions), marketData, accountInfo.TotalEquity, defaultPortfolioCorrelation),
+			PositionEvents:  positionEvents,
 		}
 
+		// 影子策略使用同一份上下文与行情，在实盘决策之前启动，熔断暂停实盘时照常运行
+		shadows.Launch(ctx, time.Now())
+
 		// 打印账户状态
 		fmt.Printf("💰 账户: 净值 $%.2f | 可用 $%.2f | 盈亏 %+.2f%% | 夏普: %.2f\n", 
 			accountInfo.TotalEquity, accountInfo.AvailableBalance, accountInfo.TotalPnLPct, sharpeRatio)
@@ -150,6 +294,27 @@ func main() {
 			}
 		}
 
+		// 评估账户级熔断：当日亏损 / 连续亏损 / 开仓频率 / 最大回撤
+		restrictions := breaker.Update(accountInfo.TotalEquity, exchange.GetTradeHistory(), time.Now())
+		if restrictions.CloseAll {
+			log.Printf("🚨 [Circuit Breaker] 触发全部平仓: %s", strings.Join(restrictions.Reasons, "; "))
+			closeAllPositions(positions, exchange)
+			breaker.MarkCloseAllExecuted()
+			// 持仓已全部平掉，刷新账户与持仓并重建上下文，避免 AI 基于已平仓的持仓决策与风控
+			accountInfo = exchange.GetAccountInfo()
+			positions = exchange.GetPositions()
+			entryGuard.Observe(positions, exchange.GetTradeHistory(), time.Now())
+			ctx.Account = accountInfo
+			ctx.Positions = positions
+			ctx.Portfolio = buildPortfolioExposure(positionLegs(positions), marketData, accountInfo.TotalEquity, defaultPortfolioCorrelation)
+		}
+		if restrictions.Halted {
+			fmt.Printf("🛑 [Circuit Breaker] 交易已暂停，需手动重置 (POST /api/circuit_breakers/reset): %s\n", strings.Join(restrictions.Reasons, "; "))
+			server.UpdateState(ctx, nil, marketData)
+			tickEvents = sleepLoopInterval(server, cfg, exchange)
+			continue
+		}
+
 		// 3. AI 思考与决策
 		fmt.Println("🧠 AI 正在思考中...")
 		decision, err := brain.GetDecision(ctx)
@@ -170,20 +335,10 @@ func main() {
 		// 在风控验证和实盘执行之前，先对 AI 输出的 action 做一层宽松归一化：
 		// - 将 close_position 根据当前持仓方向映射为 close_long/close_short；
 		// - 将 open_position + side=long/short 映射为 open_long/open_short；
+		// - 为 partial_close / update_* 补齐 side，确保对冲模式下作用于正确的持仓腿；
 		// - 其余未知别名保持不变，由风控层再做兜底处理。
 		normalizeDecisionActions(decision.Decisions, positions)
-
-		// 若当前回撤过大，进入防御模式：不再允许新开仓，所有 open_long/open_short 自动视为 wait。
-		defensiveMode := peakEquity > 0 && drawdown >= 0.25
-		if defensiveMode {
-			for i := range decision.Decisions {
-				d := &decision.Decisions[i]
-				if d.Action == "open_long" || d.Action == "open_short" {
-					log.Printf("⚠️ [Drawdown Wait] 回撤已达 %.1f%%, 自动忽略新开仓 %s %s (size=%.2f)", drawdown*100, d.Symbol, d.Action, d.PositionSizeUSD)
-					d.Action = "wait"
-				}
-			}
-		}
+		configVersions.StampDecision(decision)
 
 		// 更新 Web 状态（带上本轮 AI 决策，便于前端展示）
 		server.UpdateState(ctx, decision, marketData)
@@ -201,7 +356,7 @@ func main() {
 			fmt.Println("📋 [AI 决策列表]:")
 			
 			// 验证所有决策（传入当前市场价格，用于风险评估和全局风险控制）
-			if err := ValidateDecisions(decision.Decisions, accountInfo, marketData); err != nil {
+			if err := ValidateDecisions(ctx.riskConfig(), ctx.riskState(), decision.Decisions, accountInfo, positions, marketData); err != nil {
 				fmt.Printf("❌ 风控拒绝: %v\n", err)
 			} else {
 				// 执行决策（使用索引，方便在 FullDecision 中记录执行结果，供前端展示）
@@ -211,12 +366,15 @@ func main() {
 					// 对于非交易类动作，直接标记并跳过执行，避免调用交易所API
 					if d.Action == "wait" {
 						fmt.Printf("   ⏸️  %s: 观望 (Wait)\n", d.Symbol)
+						fmt.Print(formatRiskAdjustmentLines(*d))
 						d.ExecStatus = "success"
 						continue
 					}
 					if d.Action == "hold" {
 						fmt.Printf("   ✊  %s: 持仓 (Hold)\n", d.Symbol)
+						fmt.Print(formatRiskAdjustmentLines(*d))
 						d.ExecStatus = "success"
+						registerInvalidationCondition(invalidation, *d, positions)
 						continue
 					}
 
@@ -248,7 +406,25 @@ func main() {
 						fmt.Printf(" -> ✅ 成功\n")
 						d.ExecStatus = "success"
 						d.ExecError = ""
+						switch d.Action {
+						case "open_long", "open_short":
+							breaker.RecordOpen(time.Now())
+							positionManager.RecordOpen(*d, time.Now())
+							entryGuard.RecordOpen(d.Symbol, decisionPositionSide(*d), time.Now())
+							configVersions.RecordOpen(d.Symbol, decisionPositionSide(*d))
+							if md, ok := marketData[d.Symbol]; ok && md != nil {
+								// 传入执行后的交易历史：本周期内先平仓再重开时，旧腿先按平仓记录结算
+								closes := exchange.GetTradeHistory()
+								calibrator.RecordOpen(*d, md.CurrentPrice, closes, time.Now())
+								reflections.RecordOpen(*d, md.CurrentPrice, closes, time.Now())
+							}
+						case "update_stop_loss":
+							positionManager.RecordStop(d.Symbol, decisionPositionSide(*d), d.NewStopLoss)
+						}
+						resetInvalidationCondition(invalidation, *d, positions)
+						registerInvalidationCondition(invalidation, *d, positions)
 					}
+					fmt.Print(formatRiskAdjustmentLines(*d))
 				}
 			}
 		}
@@ -273,30 +449,50 @@ func main() {
 				log.Printf("⚠️ 保存净值快照失败: %v", err)
 			}
 
-			// 保存 AI 决策记录
-			if decision != nil && len(decision.Decisions) > 0 {
-				if err := storage.SaveAIDecision(decision); err != nil {
-					log.Printf("⚠️ 保存 AI 决策记录失败: %v", err)
-				}
-			}
-
 			// 保存交易记录（如果有新的平仓记录）
+			// 在本轮执行之后、本轮决策写入 Storage 之前保存：新平仓记录关联的开仓决策只能来自之前的周期
 			if history != nil && len(history) > 0 {
 				for _, record := range history {
+					configVersions.StampTrade(&record)
 					if err := storage.SaveTradeRecord(record); err != nil {
 						log.Printf("⚠️ 保存交易记录失败: %v", err)
 					}
 				}
 			}
+
+			// 保存 AI 决策记录
+			if decision != nil && len(decision.Decisions) > 0 {
+				if err := storage.SaveAIDecision(decision); err != nil {
+					log.Printf("⚠️ 保存 AI 决策记录失败: %v", err)
+				}
+			}
 		}
 
 		// 如果是在真实币安模式下：当某个交易对已经没有持仓时，清理遗留的止损/止盈挂单
+		// 对冲模式下按 symbol+side 判断，某条腿已平仓时只清理该方向的挂单
+		// 使用执行后的最新持仓，避免误撤本周期新开仓的挂单；已移出策略的交易对同样清理
+		livePositions := exchange.GetPositions()
 		if be, ok := exchange.(*BinanceExchange); ok {
 			positionMap := make(map[string]bool)
-			for _, p := range positions {
+			for _, p := range livePositions {
 				positionMap[p.Symbol] = true
+				positionMap[positionKey(p.Symbol, p.Side)] = true
 			}
-			for _, sym := range tradingCoins {
+			for _, sym := range universe.FetchSymbols() {
+				if be.IsHedgeMode() && positionMap[sym] {
+					for _, side := range []string{"long", "short"} {
+						if positionMap[positionKey(sym, side)] {
+							continue
+						}
+						if err := be.CancelStopLossOrdersForSide(sym, side); err != nil {
+							log.Printf("⚠️ Cleanup StopLoss orders failed for %s %s: %v", sym, side, err)
+						}
+						if err := be.CancelTakeProfitOrdersForSide(sym, side); err != nil {
+							log.Printf("⚠️ Cleanup TakeProfit orders failed for %s %s: %v", sym, side, err)
+						}
+					}
+					continue
+				}
 				if !positionMap[sym] {
 					if err := be.CancelStopLossOrders(sym); err != nil {
 						log.Printf("⚠️ Cleanup StopLoss orders failed for %s: %v", sym, err)
@@ -308,13 +504,96 @@ func main() {
 			}
 		}
 
-		// 根据当前配置的循环周期休眠（前端可动态修改）
-		intervalSec := server.GetLoopIntervalSeconds()
-		if intervalSec <= 0 {
-			intervalSec = cfg.LoopIntervalSeconds
+		universe.Prune(livePositions)
+
+		tickEvents = sleepLoopInterval(server, cfg, exchange)
+	}
+}
+
+// pendingBracketSymbols 本周期需要拉取维持保证金档位的交易对：新增的交易对，以及之前拉取失败且仍在 universe 中的交易对
+func pendingBracketSymbols(added, retry, universe []string) []string {
+	pending := append([]string(nil), added...)
+	for _, sym := range retry {
+		if containsString(universe, sym) && !containsString(pending, sym) {
+			pending = append(pending, sym)
+		}
+	}
+	return pending
+}
+
+// sleepLoopInterval 根据当前配置的循环周期休眠（前端可动态修改）。
+// 休眠期间实时价格推送触发的失效条件平仓在主协程中立即执行，不必等待下一轮 AI 调用；
+// 返回这些平仓事件，由下一轮并入 PositionEvents 展示在 Prompt 中。
+func sleepLoopInterval(server *WebServer, cfg *Config, exchange Exchange) []PositionEvent {
+	// 每个周期持久化一次模型调用计量
+	GetUsageTracker().Flush()
+
+	intervalSec := server.GetLoopIntervalSeconds()
+	if intervalSec <= 0 {
+		intervalSec = cfg.LoopIntervalSeconds
+	}
+	if slow := GetUsageTracker().LoopInterval(intervalSec); slow != intervalSec {
+		log.Printf("💸 [Usage] 超出每日预算，循环周期由 %d 秒延长至 %d 秒", intervalSec, slow)
+		intervalSec = slow
+	}
+	fmt.Printf("\n⏳ 等待 %d 秒（%.2f 分钟）进入下一周期...\n", intervalSec, float64(intervalSec)/60.0)
+
+	timer := time.NewTimer(time.Duration(intervalSec) * time.Second)
+	defer timer.Stop()
+	var fired <-chan PositionEvent
+	monitor := GetInvalidationMonitor()
+	if monitor != nil {
+		fired = monitor.Fired()
+	}
+	var events []PositionEvent
+	for {
+		select {
+		case <-timer.C:
+			return events
+		case ev := <-fired:
+			events = append(events, monitor.Apply([]PositionEvent{ev}, exchange)...)
+		}
+	}
+}
+
+// resetInvalidationCondition 持仓腿平仓或新开仓后移除旧失效条件，避免旧条件作用于之后的新持仓腿
+func resetInvalidationCondition(monitor *InvalidationMonitor, d Decision, positions []PositionInfo) {
+	if monitor == nil {
+		return
+	}
+	side := decisionPositionSide(d)
+	switch d.Action {
+	case "close_long", "close_short":
+	case "open_long", "open_short":
+		if p, _ := findPosition(positions, d.Symbol, side); p != nil {
+			return // 加仓：仍是同一持仓腿
+		}
+	default:
+		return
+	}
+	monitor.Remove(d.Symbol, side)
+}
+
+// registerInvalidationCondition 将决策附带的失效条件登记到对应持仓腿（开仓 / 持有 / 调整止损止盈时均可更新）
+func registerInvalidationCondition(monitor *InvalidationMonitor, d Decision, positions []PositionInfo) {
+	if monitor == nil || d.InvalidationCondition == "" {
+		return
+	}
+	switch d.Action {
+	case "open_long", "open_short", "hold", "update_stop_loss", "update_take_profit":
+	default:
+		return
+	}
+	side := decisionPositionSide(d)
+	if side == "" {
+		p, err := findPosition(positions, d.Symbol, "")
+		if err != nil {
+			return
 		}
-		fmt.Printf("\n⏳ 等待 %d 秒（%.2f 分钟）进入下一周期...\n", intervalSec, float64(intervalSec)/60.0)
-		time.Sleep(time.Duration(intervalSec) * time.Second)
+		side = p.Side
+	}
+	if err := monitor.Set(d.Symbol, side, d.InvalidationCondition); err != nil {
+		log.Printf("⚠️ [Invalidation] %s: %v", d.Symbol, err)
 	}
 }
 
@@ -402,10 +681,12 @@ func appendDetailedLog(path string, ctx *Context, full *FullDecision, marketData
 			for _, d := range full.Decisions {
 				if d.Action == "wait" {
 					sb.WriteString(fmt.Sprintf("   ⏸️  %s: 观望 (Wait)\n", d.Symbol))
+					sb.WriteString(formatRiskAdjustmentLines(d))
 					continue
 				}
 				if d.Action == "hold" {
 					sb.WriteString(fmt.Sprintf("   ✊  %s: 持仓 (Hold)\n", d.Symbol))
+					sb.WriteString(formatRiskAdjustmentLines(d))
 					continue
 				}
 				
@@ -437,6 +718,8 @@ func appendDetailedLog(path string, ctx *Context, full *FullDecision, marketData
 				} else {
 					sb.WriteString("\n")
 				}
+				// 风控调整记录（缩仓 / 改写 / 拒绝）
+				sb.WriteString(formatRiskAdjustmentLines(d))
 			}
 		}
 		
@@ -509,20 +792,38 @@ func wrapText(text string, width int) string {
 // 当前主要处理：
 //   - action == "close_position" 时，根据当前持仓方向自动映射为 close_long / close_short；
 //   - action == "open_position" 且提供了 side 字段（long/short/buy/sell）时，映射为 open_long / open_short；
-//   - 若无法安全判断，则将该决策视为观望（wait），不影响同一批中的其它决策。
+//   - partial_close / update_stop_loss / update_take_profit 未给出 side 时，若该交易对只有一条持仓腿则自动补齐；
+//   - 若无法安全判断（例如对冲模式下多空两条腿同时存在且未指定 side），则将该决策视为观望（wait），不影响同一批中的其它决策。
 func normalizeDecisionActions(decisions []Decision, positions []PositionInfo) {
 	if len(decisions) == 0 {
 		return
 	}
 
-	// 建立 symbol -> side 的索引，便于后续快速查找当前持仓方向
-	posSide := make(map[string]string)
+	// 建立 symbol -> sides 的索引，对冲模式下同一交易对可能同时存在 long 和 short 两条腿
+	posSides := make(map[string][]string)
 	for _, p := range positions {
 		// Binance 返回的 Side 已经是 "long" / "short"，统一转为小写
 		if p.Symbol == "" {
 			continue
 		}
-		posSide[p.Symbol] = strings.ToLower(p.Side)
+		posSides[p.Symbol] = append(posSides[p.Symbol], strings.ToLower(p.Side))
+	}
+
+	// resolveSide 根据显式 side 与当前持仓推断目标持仓腿，无法唯一确定时返回空字符串
+	resolveSide := func(d *Decision) string {
+		sides := posSides[d.Symbol]
+		if side := normalizePositionSide(d.Side); side != "" {
+			for _, s := range sides {
+				if s == side {
+					return side
+				}
+			}
+			return ""
+		}
+		if len(sides) == 1 {
+			return sides[0]
+		}
+		return ""
 	}
 
 	for i := range decisions {
@@ -536,24 +837,39 @@ func normalizeDecisionActions(decisions []Decision, positions []PositionInfo) {
 				continue
 			}
 
-			if side, ok := posSide[d.Symbol]; ok {
-				switch side {
-				case "long":
-					log.Printf("⚠️ [Action Fallback] %s 使用 close_position，自动映射为 close_long", d.Symbol)
-					d.Action = "close_long"
-				case "short":
-					log.Printf("⚠️ [Action Fallback] %s 使用 close_position，自动映射为 close_short", d.Symbol)
-					d.Action = "close_short"
-				default:
-					log.Printf("⚠️ [Action Reject] %s close_position 但持仓方向未知(%s)，已忽略（视为 wait）", d.Symbol, side)
-					d.Action = "wait"
-				}
-			} else {
+			sides := posSides[d.Symbol]
+			if len(sides) == 0 {
 				// 当前无持仓，close_position 没有意义
 				log.Printf("⚠️ [Action Reject] %s close_position 但当前无持仓，已忽略（视为 wait）", d.Symbol)
 				d.Action = "wait"
+				continue
+			}
+
+			switch side := resolveSide(d); side {
+			case "long":
+				log.Printf("⚠️ [Action Fallback] %s 使用 close_position，自动映射为 close_long", d.Symbol)
+				d.Action = "close_long"
+			case "short":
+				log.Printf("⚠️ [Action Fallback] %s 使用 close_position，自动映射为 close_short", d.Symbol)
+				d.Action = "close_short"
+			default:
+				log.Printf("⚠️ [Action Reject] %s close_position 但无法确定持仓方向(持仓=%v, side=%q)，已忽略（视为 wait）", d.Symbol, sides, d.Side)
+				d.Action = "wait"
 			}
 
+		case "partial_close", "update_stop_loss", "update_take_profit":
+			if len(posSides[d.Symbol]) == 0 {
+				// 无持仓时交给执行层报错，保持原有行为
+				continue
+			}
+			side := resolveSide(d)
+			if side == "" {
+				log.Printf("⚠️ [Action Reject] %s %s 无法确定作用的持仓方向(持仓=%v, side=%q)，已忽略（视为 wait）", d.Symbol, d.Action, posSides[d.Symbol], d.Side)
+				d.Action = "wait"
+				continue
+			}
+			d.Side = side
+
 		case "open_position":
 			// 兼容 open_position + side 方案，仅在 side 明确时做映射
 			side := strings.ToLower(d.Side)
@@ -578,48 +894,27 @@ func normalizeDecisionActions(decisions []Decision, positions []PositionInfo) {
 	}
 }
 
-// calculateSectorHeat 计算板块热度
-// enforceHardStopLoss 对当前持仓执行一次硬止损检查：当浮亏超过预设阈值时，直接由后端强制平仓，避免单笔仓位出现极深回撤。
-func enforceHardStopLoss(positions []PositionInfo, exchange Exchange) {
+// closeAllPositions 由后端强制平掉所有持仓（熔断 close_all 动作）
+func closeAllPositions(positions []PositionInfo, exchange Exchange) {
 	for _, p := range positions {
-		// 只关注有亏损的仓位
-		if p.UnrealizedPnLPct >= 0 {
-			continue
-		}
-
-		// 区分主流币与 Altcoin
-		isMajor := p.Symbol == "BTCUSDT" || p.Symbol == "ETHUSDT"
-		threshold := -30.0 // 主流币默认 -30%
-		if !isMajor {
-			threshold = -25.0 // Altcoin 更保守 -25%
+		action := "close_long"
+		if strings.ToLower(p.Side) == "short" {
+			action = "close_short"
 		}
-
-		if p.UnrealizedPnLPct <= threshold {
-			action := "close_long"
-			if strings.ToLower(p.Side) == "short" {
-				action = "close_short"
-			}
-			log.Printf("⚠️ [Hard SL] %s %s 浮亏 %.2f%% 低于阈值 %.2f%%, 触发硬止损强制平仓", p.Symbol, p.Side, p.UnrealizedPnLPct, threshold)
-
-			if err := exchange.ExecuteDecision(Decision{
-				Symbol:    p.Symbol,
-				Action:    action,
-				Reasoning: "Hard stop loss triggered by backend due to deep unrealized loss",
-			}); err != nil {
-				log.Printf("❌ [Hard SL Error] %s %s 平仓失败: %v", p.Symbol, action, err)
-			}
+		if err := exchange.ExecuteDecision(Decision{
+			Symbol:    p.Symbol,
+			Action:    action,
+			Reasoning: "Circuit breaker close_all",
+		}); err != nil {
+			log.Printf("❌ [Circuit Breaker] 平仓 %s %s 失败: %v", p.Symbol, p.Side, err)
 		}
 	}
 }
 
+// calculateSectorHeat 计算板块热度
 func calculateSectorHeat(dataMap map[string]*MarketData) []SectorInfo {
-	// 定义板块 (你可以根据需要扩展)
-	sectors := []SectorInfo{
-		{Name: "Major", Symbols: []string{"BTCUSDT", "ETHUSDT", "BNBUSDT", "SOLUSDT"}},
-		{Name: "Meme", Symbols: []string{"DOGEUSDT", "SHIBUSDT", "PEPEUSDT", "BONKUSDT", "WIFUSDT"}},
-		{Name: "AI", Symbols: []string{"FETUSDT", "RNDRUSDT", "WLDUSDT", "ARKMUSDT"}},
-		{Name: "L2", Symbols: []string{"ARBUSDT", "OPUSDT", "MATICUSDT"}},
-	}
+	// 板块定义来自资产注册表（与组合板块敞口限制共用）
+	sectors := GetAssetRegistry().Sectors()
 
 	var results []SectorInfo
 
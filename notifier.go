@@ -26,6 +26,9 @@ const (
 	EventSystemStart    NotifyEvent = "system_start"    // 系统启动
 	EventSystemStop     NotifyEvent = "system_stop"     // 系统停止
 	EventHighDrawdown   NotifyEvent = "high_drawdown"   // 高回撤警告
+	EventCircuitBreaker NotifyEvent = "circuit_breaker" // 熔断触发
+	EventRegimeSwitch   NotifyEvent = "regime_switch"   // 市场状态变化自动切换策略
+	EventUsageBudget    NotifyEvent = "usage_budget"    // 模型调用超出每日预算
 )
 
 // NotifyMessage 通知消息
@@ -150,6 +153,12 @@ func (t *TelegramNotifier) getEmoji(event NotifyEvent) string {
 		return "🔴"
 	case EventHighDrawdown:
 		return "📉"
+	case EventCircuitBreaker:
+		return "🚨"
+	case EventRegimeSwitch:
+		return "🔀"
+	case EventUsageBudget:
+		return "💸"
 	default:
 		return "📢"
 	}
@@ -235,11 +244,11 @@ func (d *DiscordNotifier) getColor(event NotifyEvent) int {
 	switch event {
 	case EventOpenPosition, EventTakeProfit, EventSystemStart:
 		return 0x00FF00 // 绿色
-	case EventClosePosition:
+	case EventClosePosition, EventRegimeSwitch:
 		return 0x0099FF // 蓝色
-	case EventStopLoss, EventHighDrawdown:
+	case EventStopLoss, EventHighDrawdown, EventUsageBudget:
 		return 0xFF9900 // 橙色
-	case EventRiskRejected, EventError, EventSystemStop:
+	case EventRiskRejected, EventError, EventSystemStop, EventCircuitBreaker:
 		return 0xFF0000 // 红色
 	default:
 		return 0x808080 // 灰色
@@ -504,6 +513,33 @@ func (nm *NotifyManager) NotifyHighDrawdown(drawdownPct, equity float64) {
 	})
 }
 
+// NotifyCircuitBreaker 通知熔断触发
+func (nm *NotifyManager) NotifyCircuitBreaker(name, action, reason string) {
+	nm.Send(NotifyMessage{
+		Event:   EventCircuitBreaker,
+		Title:   fmt.Sprintf("Circuit Breaker Tripped: %s", name),
+		Content: fmt.Sprintf("Reason: %s\nAction: %s", reason, action),
+	})
+}
+
+// NotifyRegimeSwitch 通知市场状态变化导致的策略切换
+func (nm *NotifyManager) NotifyRegimeSwitch(fromRegime, toRegime, fromStrategy, toStrategy, reason string) {
+	nm.Send(NotifyMessage{
+		Event:   EventRegimeSwitch,
+		Title:   fmt.Sprintf("Regime Switch: %s -> %s", fromRegime, toRegime),
+		Content: fmt.Sprintf("Strategy: %s -> %s\nReason: %s", fromStrategy, toStrategy, reason),
+	})
+}
+
+// NotifyUsageBudget 通知模型调用超出每日预算
+func (nm *NotifyManager) NotifyUsageBudget(reason, action string) {
+	nm.Send(NotifyMessage{
+		Event:   EventUsageBudget,
+		Title:   "Model Usage Budget Exceeded",
+		Content: fmt.Sprintf("Reason: %s\nAction: %s", reason, action),
+	})
+}
+
 // 全局通知管理器
 var globalNotifier *NotifyManager
 
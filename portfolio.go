@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+)
+
+// otherSector 不属于任何已定义板块的币种归入该组（板块定义见 assets.go 的资产注册表）
+const otherSector = "Other"
+
+// minCorrelationSamples 计算相关性所需的最少收益率样本数
+const minCorrelationSamples = 5
+
+// ExposureLeg 组合中的一条敞口（现有持仓或本轮待开仓）
+type ExposureLeg struct {
+	Symbol   string  `json:"symbol"`
+	Side     string  `json:"side"`     // "long" / "short"
+	Notional float64 `json:"notional"` // 名义价值 (USDT)，始终为正
+	Pending  bool    `json:"pending"`  // 是否为本轮尚未成交的新开仓
+}
+
+// SectorExposure 单个板块的敞口
+type SectorExposure struct {
+	Sector   string  `json:"sector"`
+	Long     float64 `json:"long"`
+	Short    float64 `json:"short"`
+	Gross    float64 `json:"gross"`
+	GrossPct float64 `json:"gross_pct"` // 占净值倍数
+}
+
+// PortfolioExposure 组合层面的敞口状态
+type PortfolioExposure struct {
+	Equity             float64          `json:"equity"`
+	LongNotional       float64          `json:"long_notional"`
+	ShortNotional      float64          `json:"short_notional"`
+	NetNotional        float64          `json:"net_notional"`         // 多 - 空
+	GrossNotional      float64          `json:"gross_notional"`       // 多 + 空
+	NetExposure        float64          `json:"net_exposure"`         // 净敞口 / 净值（倍数，带符号）
+	GrossExposure      float64          `json:"gross_exposure"`       // 总敞口 / 净值（倍数）
+	CorrelatedNotional float64          `json:"correlated_notional"`  // 相关性调整后的等效敞口
+	CorrelatedExposure float64          `json:"correlated_exposure"`  // 相关性调整后的等效敞口 / 净值（倍数）
+	Sectors            []SectorExposure `json:"sectors"`
+	Legs               []ExposureLeg    `json:"legs"`
+}
+
+// sectorGross 返回某个板块的总敞口
+func (p *PortfolioExposure) sectorGross(sector string) float64 {
+	for _, s := range p.Sectors {
+		if s.Sector == sector {
+			return s.Gross
+		}
+	}
+	return 0
+}
+
+// positionLegs 将持仓转换为敞口腿
+func positionLegs(positions []PositionInfo) []ExposureLeg {
+	legs := make([]ExposureLeg, 0, len(positions))
+	for _, p := range positions {
+		side := normalizePositionSide(p.Side)
+		notional := math.Abs(p.Quantity) * p.MarkPrice
+		if side == "" || notional <= 0 {
+			continue
+		}
+		legs = append(legs, ExposureLeg{Symbol: p.Symbol, Side: side, Notional: notional})
+	}
+	return legs
+}
+
+// buildPortfolioExposure 根据敞口腿汇总组合状态。
+// 相关性调整敞口 = sqrt(Σi Σj wi·wj·ρij)，其中 w 为带方向的名义价值（多为正、空为负），
+// ρ 基于 3m K 线的滚动收益率计算；样本不足时使用 defaultCorr。
+func buildPortfolioExposure(legs []ExposureLeg, mdMap map[string]*MarketData, equity, defaultCorr float64) *PortfolioExposure {
+	p := &PortfolioExposure{Equity: equity, Legs: legs}
+
+	sectorMap := make(map[string]*SectorExposure)
+	for _, leg := range legs {
+		sector := sectorOf(leg.Symbol)
+		se, ok := sectorMap[sector]
+		if !ok {
+			se = &SectorExposure{Sector: sector}
+			sectorMap[sector] = se
+		}
+		if leg.Side == "long" {
+			p.LongNotional += leg.Notional
+			se.Long += leg.Notional
+		} else {
+			p.ShortNotional += leg.Notional
+			se.Short += leg.Notional
+		}
+		se.Gross += leg.Notional
+	}
+	p.NetNotional = p.LongNotional - p.ShortNotional
+	p.GrossNotional = p.LongNotional + p.ShortNotional
+
+	for _, se := range sectorMap {
+		if equity > 0 {
+			se.GrossPct = se.Gross / equity
+		}
+		p.Sectors = append(p.Sectors, *se)
+	}
+	sort.Slice(p.Sectors, func(i, j int) bool { return p.Sectors[i].Gross > p.Sectors[j].Gross })
+
+	// 按币种合并带方向的名义价值，再做相关性加权
+	weights := make(map[string]float64)
+	var symbols []string
+	for _, leg := range legs {
+		if _, ok := weights[leg.Symbol]; !ok {
+			symbols = append(symbols, leg.Symbol)
+		}
+		if leg.Side == "long" {
+			weights[leg.Symbol] += leg.Notional
+		} else {
+			weights[leg.Symbol] -= leg.Notional
+		}
+	}
+	var variance float64
+	for _, a := range symbols {
+		for _, b := range symbols {
+			corr := 1.0
+			if a != b {
+				corr = symbolCorrelation(mdMap, a, b, defaultCorr)
+			}
+			variance += weights[a] * weights[b] * corr
+		}
+	}
+	if variance > 0 {
+		p.CorrelatedNotional = math.Sqrt(variance)
+	}
+
+	if equity > 0 {
+		p.NetExposure = p.NetNotional / equity
+		p.GrossExposure = p.GrossNotional / equity
+		p.CorrelatedExposure = p.CorrelatedNotional / equity
+	}
+	return p
+}
+
+// symbolCorrelation 基于两个币种 3m 收盘价序列的收益率计算皮尔逊相关系数
+func symbolCorrelation(mdMap map[string]*MarketData, a, b string, defaultCorr float64) float64 {
+	ra := symbolReturns(mdMap, a)
+	rb := symbolReturns(mdMap, b)
+	n := len(ra)
+	if len(rb) < n {
+		n = len(rb)
+	}
+	if n < minCorrelationSamples {
+		return defaultCorr
+	}
+	// 对齐到最近的 n 个样本
+	ra, rb = ra[len(ra)-n:], rb[len(rb)-n:]
+
+	var meanA, meanB float64
+	for i := 0; i < n; i++ {
+		meanA += ra[i]
+		meanB += rb[i]
+	}
+	meanA /= float64(n)
+	meanB /= float64(n)
+
+	var cov, varA, varB float64
+	for i := 0; i < n; i++ {
+		da, db := ra[i]-meanA, rb[i]-meanB
+		cov += da * db
+		varA += da * da
+		varB += db * db
+	}
+	if varA == 0 || varB == 0 {
+		return defaultCorr
+	}
+	return cov / math.Sqrt(varA*varB)
+}
+
+// symbolReturns 从日内序列中提取简单收益率
+func symbolReturns(mdMap map[string]*MarketData, symbol string) []float64 {
+	md, ok := mdMap[symbol]
+	if !ok || md == nil || md.IntradaySeries == nil {
+		return nil
+	}
+	prices := md.IntradaySeries.MidPrices
+	if len(prices) < 2 {
+		return nil
+	}
+	returns := make([]float64, 0, len(prices)-1)
+	for i := 1; i < len(prices); i++ {
+		if prices[i-1] <= 0 {
+			continue
+		}
+		returns = append(returns, prices[i]/prices[i-1]-1)
+	}
+	return returns
+}
+
+// formatPortfolioSectors 将板块敞口格式化为单行文本，如 "Meme 2.10x (L 2.10x / S 0.00x), Major 1.00x (...)"
+func formatPortfolioSectors(p *PortfolioExposure) string {
+	var parts []string
+	for _, s := range p.Sectors {
+		long, short := 0.0, 0.0
+		if p.Equity > 0 {
+			long, short = s.Long/p.Equity, s.Short/p.Equity
+		}
+		parts = append(parts, fmt.Sprintf("%s %.2fx (L %.2fx / S %.2fx)", s.Sector, s.GrossPct, long, short))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// defaultPortfolioCorrelation 收益率样本不足时假设的币种间相关系数
+const defaultPortfolioCorrelation = 0.5
+
+// portfolioWith 计算 现有持仓 + 本批次已通过的新开仓 (+ 可选候选开仓) 构成的组合敞口
+func (rc *RiskRuleContext) portfolioWith(candidate *ExposureLeg, defaultCorr float64) *PortfolioExposure {
+	legs := positionLegs(rc.Positions)
+	legs = append(legs, rc.PendingLegs...)
+	if candidate != nil {
+		legs = append(legs, *candidate)
+	}
+	return buildPortfolioExposure(legs, rc.MDMap, rc.Account.TotalEquity, defaultCorr)
+}
+
+// fitExposureHeadroom 按剩余敞口额度处理开仓决策：额度充足放行；额度不足最小开仓金额时改写为 wait；否则缩仓到剩余额度
+func fitExposureHeadroom(d *Decision, headroom float64, limitDesc string) (RiskOutcome, string) {
+	if headroom >= d.PositionSizeUSD {
+		return RiskApprove, ""
+	}
+	if headroom < minPositionSizeGeneral {
+		log.Printf("⚠️ [Exposure Wait] %s %s 剩余额度 %.2f USDT 不足，本轮放弃开仓", d.Symbol, limitDesc, math.Max(headroom, 0))
+		d.Action = "wait"
+		return RiskRewrite, fmt.Sprintf("%s 剩余额度 %.2f USDT 不足，改为 wait", limitDesc, math.Max(headroom, 0))
+	}
+	log.Printf("⚠️ [Exposure Fallback] %s %s 剩余额度 %.2f USDT，仓位从 %.2f 缩至 %.2f USDT",
+		d.Symbol, limitDesc, headroom, d.PositionSizeUSD, headroom)
+	orig := d.PositionSizeUSD
+	d.PositionSizeUSD = headroom
+	return RiskResize, fmt.Sprintf("%s 剩余额度 %.2f USDT，仓位 %.2f -> %.2f", limitDesc, headroom, orig, headroom)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// positionKey 生成持仓的唯一键：交易对 + 方向。
+// 对冲模式（Hedge Mode）下同一交易对可以同时存在多空两条腿，仅用 symbol 作为键会互相覆盖。
+// 键格式与 position_open_time.json 中已持久化的 "side:symbol" 保持一致，重启后可直接复用。
+func positionKey(symbol, side string) string {
+	return fmt.Sprintf("%s:%s", strings.ToLower(side), symbol)
+}
+
+// normalizePositionSide 将模型/交易所可能给出的方向写法统一为 "long" / "short"，无法识别时返回空字符串
+func normalizePositionSide(side string) string {
+	switch strings.ToLower(strings.TrimSpace(side)) {
+	case "long", "buy":
+		return "long"
+	case "short", "sell":
+		return "short"
+	default:
+		return ""
+	}
+}
+
+// decisionPositionSide 推断一个决策作用于哪条持仓腿：
+//   - open_long / close_long 固定为 long，open_short / close_short 固定为 short；
+//   - partial_close / update_stop_loss / update_take_profit 等依赖 Side 字段显式指定；
+//   - 无法判断时返回空字符串，由调用方结合当前持仓进一步消歧。
+func decisionPositionSide(d Decision) string {
+	switch d.Action {
+	case "open_long", "close_long":
+		return "long"
+	case "open_short", "close_short":
+		return "short"
+	}
+	return normalizePositionSide(d.Side)
+}
+
+// findPosition 在持仓列表中按 symbol + side 查找目标持仓。
+// side 为空时：若该交易对只有一条腿则直接返回；若多空两条腿同时存在则视为歧义并返回错误，
+// 避免在对冲模式下误操作另一条腿。
+func findPosition(positions []PositionInfo, symbol, side string) (*PositionInfo, error) {
+	side = normalizePositionSide(side)
+
+	var matches []PositionInfo
+	for _, p := range positions {
+		if p.Symbol != symbol {
+			continue
+		}
+		if side != "" && strings.ToLower(p.Side) != side {
+			continue
+		}
+		matches = append(matches, p)
+	}
+
+	switch len(matches) {
+	case 0:
+		if side != "" {
+			return nil, fmt.Errorf("no %s position found for %s", side, symbol)
+		}
+		return nil, fmt.Errorf("no position found for %s", symbol)
+	case 1:
+		return &matches[0], nil
+	default:
+		return nil, fmt.Errorf("ambiguous position for %s: both long and short legs are open, side is required", symbol)
+	}
+}
+
+// oppositeSide 返回相反方向
+func oppositeSide(side string) string {
+	if normalizePositionSide(side) == "short" {
+		return "long"
+	}
+	return "short"
+}
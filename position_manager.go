@@ -0,0 +1,373 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 持仓管理规则名称（同时作为 TradeRecord.Reason 的前缀标签）
+const (
+	PositionRuleHardStop  = "hard_stop"
+	PositionRuleTimeStop  = "time_stop"
+	PositionRuleTrailing  = "trailing_stop"
+	PositionRuleBreakeven = "breakeven"
+)
+
+// PositionRulesConfig 后端持仓管理规则（随策略切换），所有阈值 <= 0 表示关闭该规则
+type PositionRulesConfig struct {
+	// 保本：价格朝有利方向运行 BreakevenAtR 倍初始风险 (R = 入场到初始止损的距离) 后，止损移至入场价 ± BreakevenOffsetPct%
+	BreakevenAtR       float64 `json:"breakeven_at_r"`
+	BreakevenOffsetPct float64 `json:"breakeven_offset_pct"`
+
+	// 回撤收紧：峰值收益率 (PeakPnLPct，基于保证金) 达到 TrailActivatePct 后，
+	// 若当前收益率较峰值回吐超过 TrailRetracePct（比例，如 0.4 = 回吐 40%），把止损收紧到锁定峰值收益的 TrailLockPct；
+	// 当前收益已低于锁定水平时直接平仓
+	TrailActivatePct float64 `json:"trail_activate_pct"`
+	TrailRetracePct  float64 `json:"trail_retrace_pct"`
+	TrailLockPct     float64 `json:"trail_lock_pct"`
+
+	// 时间止损：持仓超过该分钟数后平仓
+	MaxHoldingMinutes int `json:"max_holding_minutes"`
+
+	// 硬止损：浮亏（保证金百分比）超过阈值时强制平仓，主流币 / 山寨币分开设置，如 30 表示 -30%
+	HardStopMajorPct float64 `json:"hard_stop_major_pct"`
+	HardStopAltPct   float64 `json:"hard_stop_alt_pct"`
+}
+
+// DefaultPositionRules 策略未配置 position_rules 时的默认值：仅保留原有的硬止损（主流币 -30%，山寨币 -25%）
+func DefaultPositionRules() PositionRulesConfig {
+	return PositionRulesConfig{
+		HardStopMajorPct: 30,
+		HardStopAltPct:   25,
+	}
+}
+
+// getPositionRules 获取当前策略的持仓管理规则
+func getPositionRules() PositionRulesConfig {
+	return positionRulesFor(getRiskConfig())
+}
+
+// positionRulesFor 获取指定风控配置的持仓管理规则，未配置时使用默认规则
+func positionRulesFor(cfg RiskConfig) PositionRulesConfig {
+	if rules := cfg.PositionRules; rules != nil {
+		return *rules
+	}
+	return DefaultPositionRules()
+}
+
+// formatPositionRules 单行描述当前持仓管理规则，用于 Prompt
+func formatPositionRules(r PositionRulesConfig) string {
+	var parts []string
+	if r.BreakevenAtR > 0 {
+		parts = append(parts, fmt.Sprintf("浮盈 %.1fR 后止损移至保本", r.BreakevenAtR))
+	}
+	if r.TrailActivatePct > 0 && r.TrailRetracePct > 0 {
+		parts = append(parts, fmt.Sprintf("峰值收益 ≥%.0f%% 且回吐 %.0f%% 后锁定峰值收益的 %.0f%%", r.TrailActivatePct, r.TrailRetracePct*100, r.TrailLockPct*100))
+	}
+	if r.MaxHoldingMinutes > 0 {
+		parts = append(parts, fmt.Sprintf("持仓超过 %d 分钟平仓", r.MaxHoldingMinutes))
+	}
+	if r.HardStopMajorPct > 0 || r.HardStopAltPct > 0 {
+		parts = append(parts, fmt.Sprintf("浮亏硬止损 主流币 -%.0f%% / 山寨币 -%.0f%%", r.HardStopMajorPct, r.HardStopAltPct))
+	}
+	return strings.Join(parts, "；")
+}
+
+// PositionEvent 一次持仓管理规则触发记录，会展示在下一轮 Prompt 中
+type PositionEvent struct {
+	Time     time.Time `json:"time"`
+	Rule     string    `json:"rule"`
+	Symbol   string    `json:"symbol"`
+	Side     string    `json:"side"`
+	Action   string    `json:"action"` // close_long / close_short / update_stop_loss
+	NewStop  float64   `json:"new_stop,omitempty"`
+	Reason   string    `json:"reason"`
+	ExecErr  string    `json:"exec_error,omitempty"`
+	Executed bool      `json:"executed"`
+
+	ruleGen uint64 // 失效条件的代次，执行前据此确认条件仍属于同一持仓腿（见 invalidation.go）
+}
+
+// positionRuleReason 生成带规则标签的原因，写入 Decision.Reasoning / TradeRecord.Reason
+func positionRuleReason(rule, detail string) string {
+	return fmt.Sprintf("[PM:%s] %s", rule, detail)
+}
+
+// managedPosition 持仓管理器跟踪的单条持仓腿
+type managedPosition struct {
+	Symbol        string    `json:"symbol"`
+	Side          string    `json:"side"`
+	InitialStop   float64   `json:"initial_stop"`   // 开仓时的止损，用于计算 R
+	CurrentStop   float64   `json:"current_stop"`   // 当前已知止损（只允许收紧）
+	BreakevenDone bool      `json:"breakeven_done"` // 是否已移至保本
+	OpenedAt      time.Time `json:"opened_at"`      // 首次开仓时间，用于时间止损（重启后仍有效）
+}
+
+// PositionManager 在每次 AI 调用前按策略规则管理持仓：保本、回撤收紧、时间止损、硬止损
+type PositionManager struct {
+	mu       sync.Mutex
+	filePath string
+	tracked  map[string]*managedPosition // key: positionKey(symbol, side)
+}
+
+// NewPositionManager 创建持仓管理器，并从 filePath 恢复初始止损等状态
+func NewPositionManager(filePath string) *PositionManager {
+	pm := &PositionManager{filePath: filePath, tracked: make(map[string]*managedPosition)}
+	pm.load()
+	return pm
+}
+
+func (pm *PositionManager) load() {
+	if pm.filePath == "" {
+		return
+	}
+	data, err := os.ReadFile(pm.filePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("⚠️ 加载持仓管理状态失败: %v", err)
+		}
+		return
+	}
+	var tracked map[string]*managedPosition
+	if err := json.Unmarshal(data, &tracked); err != nil {
+		log.Printf("⚠️ 解析持仓管理状态失败: %v", err)
+		return
+	}
+	if tracked != nil {
+		pm.tracked = tracked
+	}
+}
+
+// saveLocked 持久化当前状态（调用方需持有锁）
+func (pm *PositionManager) saveLocked() {
+	if pm.filePath == "" {
+		return
+	}
+	if err := writeJSONFile(pm.filePath, pm.tracked); err != nil {
+		log.Printf("⚠️ 保存持仓管理状态失败: %v", err)
+	}
+}
+
+// RecordOpen 记录成功开仓的初始止损与开仓时间，加仓时保留首次的初始止损与开仓时间
+func (pm *PositionManager) RecordOpen(d Decision, now time.Time) {
+	side := decisionPositionSide(d)
+	if side == "" {
+		return
+	}
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	key := positionKey(d.Symbol, side)
+	mp, ok := pm.tracked[key]
+	if !ok {
+		mp = &managedPosition{Symbol: d.Symbol, Side: side, InitialStop: d.StopLoss}
+		pm.tracked[key] = mp
+	}
+	if mp.OpenedAt.IsZero() {
+		mp.OpenedAt = now
+	}
+	if d.StopLoss > 0 {
+		mp.CurrentStop = d.StopLoss
+	}
+	pm.saveLocked()
+}
+
+// RecordStop 记录成功执行的止损调整（AI 的 update_stop_loss 或本管理器自身的调整）
+func (pm *PositionManager) RecordStop(symbol, side string, stop float64) {
+	side = normalizePositionSide(side)
+	if side == "" || stop <= 0 {
+		return
+	}
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	key := positionKey(symbol, side)
+	mp, ok := pm.tracked[key]
+	if !ok {
+		mp = &managedPosition{Symbol: symbol, Side: side}
+		pm.tracked[key] = mp
+	}
+	mp.CurrentStop = stop
+	pm.saveLocked()
+}
+
+// Evaluate 根据规则为当前持仓生成管理动作（平仓或收紧止损），每条持仓腿最多一个动作。
+// 已不存在的持仓腿会从跟踪状态中移除。
+func (pm *PositionManager) Evaluate(positions []PositionInfo, rules PositionRulesConfig, now time.Time) []PositionEvent {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	active := make(map[string]bool, len(positions))
+	var events []PositionEvent
+	for _, p := range positions {
+		side := normalizePositionSide(p.Side)
+		if side == "" || p.EntryPrice <= 0 || p.MarkPrice <= 0 {
+			continue
+		}
+		key := positionKey(p.Symbol, side)
+		active[key] = true
+		mp := pm.tracked[key]
+		if mp == nil {
+			mp = &managedPosition{Symbol: p.Symbol, Side: side}
+			pm.tracked[key] = mp
+		}
+		if ev, ok := evaluatePosition(p, side, mp, rules, now); ok {
+			events = append(events, ev)
+		}
+	}
+	for key := range pm.tracked {
+		if !active[key] {
+			delete(pm.tracked, key)
+		}
+	}
+	pm.saveLocked()
+	return events
+}
+
+// evaluatePosition 按 硬止损 → 时间止损 → 回撤收紧 → 保本 的优先级评估单条持仓腿
+func evaluatePosition(p PositionInfo, side string, mp *managedPosition, rules PositionRulesConfig, now time.Time) (PositionEvent, bool) {
+	ev := PositionEvent{Time: now, Symbol: p.Symbol, Side: side, Action: "close_" + side}
+	dir := 1.0
+	if side == "short" {
+		dir = -1.0
+	}
+
+	// 1. 硬止损
+	threshold := rules.HardStopMajorPct
+	if isAltSymbol(p.Symbol) {
+		threshold = rules.HardStopAltPct
+	}
+	if threshold > 0 && p.UnrealizedPnLPct <= -threshold {
+		ev.Rule = PositionRuleHardStop
+		ev.Reason = fmt.Sprintf("浮亏 %.2f%% 超过硬止损阈值 -%.0f%%", p.UnrealizedPnLPct, threshold)
+		return ev, true
+	}
+
+	// 2. 时间止损：以记录的开仓时间为准；没有开仓记录的持仓腿才退回交易所的 UpdateTime
+	openedAt := mp.OpenedAt
+	if openedAt.IsZero() && p.UpdateTime > 0 {
+		openedAt = time.UnixMilli(p.UpdateTime)
+	}
+	if rules.MaxHoldingMinutes > 0 && !openedAt.IsZero() {
+		held := now.Sub(openedAt)
+		if held >= time.Duration(rules.MaxHoldingMinutes)*time.Minute {
+			ev.Rule = PositionRuleTimeStop
+			ev.Reason = fmt.Sprintf("持仓 %d 分钟超过上限 %d 分钟 (当前收益 %+.2f%%)", int(held.Minutes()), rules.MaxHoldingMinutes, p.UnrealizedPnLPct)
+			return ev, true
+		}
+	}
+
+	// 3. 回撤收紧：锁定峰值收益的一部分
+	leverage := float64(p.Leverage)
+	if leverage <= 0 {
+		leverage = 1
+	}
+	if rules.TrailActivatePct > 0 && rules.TrailRetracePct > 0 && p.PeakPnLPct >= rules.TrailActivatePct {
+		retrace := (p.PeakPnLPct - p.UnrealizedPnLPct) / p.PeakPnLPct
+		if retrace >= rules.TrailRetracePct {
+			lockPct := p.PeakPnLPct * rules.TrailLockPct
+			if p.UnrealizedPnLPct <= lockPct {
+				ev.Rule = PositionRuleTrailing
+				ev.Reason = fmt.Sprintf("收益从峰值 %.2f%% 回撤至 %.2f%%，已低于锁定水平 %.2f%%，平仓", p.PeakPnLPct, p.UnrealizedPnLPct, lockPct)
+				return ev, true
+			}
+			stop := p.EntryPrice * (1 + dir*lockPct/100/leverage)
+			if tightens(side, mp.CurrentStop, stop) {
+				ev.Rule = PositionRuleTrailing
+				ev.Action = "update_stop_loss"
+				ev.NewStop = stop
+				ev.Reason = fmt.Sprintf("收益从峰值 %.2f%% 回撤 %.0f%% 至 %.2f%%，止损收紧至 %.4f（锁定 %.2f%%）",
+					p.PeakPnLPct, retrace*100, p.UnrealizedPnLPct, stop, lockPct)
+				return ev, true
+			}
+		}
+	}
+
+	// 4. 保本：需要已知初始止损才能计算 R
+	if rules.BreakevenAtR > 0 && !mp.BreakevenDone && mp.InitialStop > 0 {
+		risk := dir * (p.EntryPrice - mp.InitialStop)
+		gain := dir * (p.MarkPrice - p.EntryPrice)
+		if risk > 0 && gain >= risk*rules.BreakevenAtR {
+			stop := p.EntryPrice * (1 + dir*rules.BreakevenOffsetPct/100)
+			if !tightens(side, mp.CurrentStop, stop) {
+				mp.BreakevenDone = true
+				return ev, false
+			}
+			ev.Rule = PositionRuleBreakeven
+			ev.Action = "update_stop_loss"
+			ev.NewStop = stop
+			ev.Reason = fmt.Sprintf("浮盈 %.2fR ≥ %.1fR，止损移至保本 %.4f", gain/risk, rules.BreakevenAtR, stop)
+			return ev, true
+		}
+	}
+	return ev, false
+}
+
+// tightens 新止损是否比当前止损更紧（当前止损未知时视为更紧）
+func tightens(side string, current, next float64) bool {
+	if next <= 0 {
+		return false
+	}
+	if current <= 0 {
+		return true
+	}
+	if side == "short" {
+		return next < current
+	}
+	return next > current
+}
+
+// Apply 执行管理动作并返回带执行结果的事件。平仓的 Reasoning 带规则标签，会写入 TradeRecord.Reason。
+func (pm *PositionManager) Apply(events []PositionEvent, exchange Exchange) []PositionEvent {
+	for i := range events {
+		ev := &events[i]
+		d := Decision{
+			Symbol:    ev.Symbol,
+			Action:    ev.Action,
+			Side:      ev.Side,
+			Reasoning: positionRuleReason(ev.Rule, ev.Reason),
+		}
+		if ev.Action == "update_stop_loss" {
+			d.NewStopLoss = ev.NewStop
+		}
+		log.Printf("🧭 [Position Manager] %s %s %s: %s", ev.Symbol, ev.Side, ev.Rule, ev.Reason)
+		if err := exchange.ExecuteDecision(d); err != nil {
+			ev.ExecErr = err.Error()
+			log.Printf("❌ [Position Manager] %s %s %s 执行失败: %v", ev.Symbol, ev.Side, ev.Action, err)
+			continue
+		}
+		ev.Executed = true
+		if ev.Action == "update_stop_loss" {
+			pm.RecordStop(ev.Symbol, ev.Side, ev.NewStop)
+			if ev.Rule == PositionRuleBreakeven {
+				pm.markBreakeven(ev.Symbol, ev.Side)
+			}
+		}
+	}
+	return events
+}
+
+func (pm *PositionManager) markBreakeven(symbol, side string) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	if mp, ok := pm.tracked[positionKey(symbol, side)]; ok {
+		mp.BreakevenDone = true
+		pm.saveLocked()
+	}
+}
+
+// 全局持仓管理器
+var globalPositionManager *PositionManager
+
+// InitGlobalPositionManager 初始化全局持仓管理器
+func InitGlobalPositionManager(filePath string) {
+	globalPositionManager = NewPositionManager(filePath)
+}
+
+// GetPositionManager 获取全局持仓管理器
+func GetPositionManager() *PositionManager {
+	return globalPositionManager
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// PromptTemplateData 策略 prompt 模板（Go text/template）可用的变量，例如：
+//
+//	风险回报比至少 {{printf "%.1f" .Risk.MinRiskRewardRatio}}:1，单笔风险不超过 {{pct .Risk.MaxRiskPerTrade}} 净值
+//	交易对: {{join .Symbols ", "}}；{{with asset "SOLUSDT"}}{{.Sector}}{{end}}
+type PromptTemplateData struct {
+	Strategy    string               // 策略名称
+	Description string               // 策略描述
+	Risk        RiskConfig           // 策略风控参数（与风控流水线使用同一份配置）
+	Symbols     []string             // 本轮交易对
+	Equity      float64              // 账户净值 (USDT)
+	Assets      []AssetInfo          // 资产注册表
+	Leverage    []LeverageResolution // 各交易对最终杠杆
+	Now         time.Time            // 当前时间
+}
+
+// promptTemplateFuncs 模板辅助函数
+var promptTemplateFuncs = template.FuncMap{
+	// pct 比例格式化为百分比，如 0.25 -> "25%"、0.005 -> "0.5%"
+	"pct": func(v float64) string {
+		s := strings.TrimRight(strings.TrimRight(fmt.Sprintf("%.2f", v*100), "0"), ".")
+		return s + "%"
+	},
+	"join": strings.Join,
+	"mul":  func(a, b float64) float64 { return a * b },
+	// asset 按交易对查询资产注册表（未登记的按 alt 处理）
+	"asset": func(symbol string) AssetInfo {
+		return GetAssetRegistry().Get(symbol)
+	},
+}
+
+// renderPromptTemplate 渲染 prompt 模板；引用不存在的字段或函数时返回错误
+func renderPromptTemplate(name, content string, data PromptTemplateData) (string, error) {
+	tmpl, err := template.New(name).Funcs(promptTemplateFuncs).Option("missingkey=error").Parse(content)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// newPromptTemplateData 构建模板变量；symbols 为空时使用策略交易对
+func newPromptTemplateData(s *Strategy, symbols []string, equity float64, now time.Time) PromptTemplateData {
+	data := PromptTemplateData{
+		Symbols: symbols,
+		Equity:  equity,
+		Assets:  GetAssetRegistry().List(),
+		Now:     now,
+	}
+	if s != nil {
+		data.Strategy, data.Description, data.Risk = s.Name, s.Description, s.RiskParams
+		if len(data.Symbols) == 0 {
+			data.Symbols = s.Symbols
+		}
+	}
+	data.Leverage = GetLeverageManager().ResolveAll(data.Symbols, data.Risk.FixedLeverage)
+	return data
+}
+
+// contextSymbols 本轮交易对：优先使用 Context.Symbols，否则取行情数据中的交易对
+func contextSymbols(ctx *Context) []string {
+	if ctx == nil {
+		return nil
+	}
+	if len(ctx.Symbols) > 0 {
+		return ctx.Symbols
+	}
+	symbols := make([]string, 0, len(ctx.MarketDataMap))
+	for s := range ctx.MarketDataMap {
+		symbols = append(symbols, s)
+	}
+	sort.Strings(symbols)
+	return symbols
+}
+
+// validatePromptTemplate 加载时用示例数据试渲染策略 prompt，尽早暴露模板错误
+func validatePromptTemplate(path string, s *Strategy) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	symbols := s.Symbols
+	if len(symbols) == 0 {
+		symbols = []string{"BTCUSDT", "ETHUSDT"}
+	}
+	_, err = renderPromptTemplate(path, string(content), newPromptTemplateData(s, symbols, 1000, time.Now()))
+	return err
+}
+
+// runPromptPreviewCLI 渲染指定策略在当前配置下的 system prompt 并输出到终端。
+// 不传策略名时按 Storage 中最近一个配置版本恢复实盘的当前策略与风控参数（没有版本记录时为默认策略）；
+// 交易对按该策略解析并排除黑名单，杠杆取单币种设置。扫描器候选与行情只存在于运行中的进程，
+// 需要与实盘本轮完全一致的 prompt 时使用 Web 接口 /api/prompt/preview。
+// 净值取最近一次净值快照，没有快照时为 0
+func runPromptPreviewCLI(cfg *Config, strategyName string) error {
+	InitGlobalAssetRegistry("data/assets.json", cfg.Assets)
+	InitGlobalLeverageManager(cfg.BTCETHLeverage, cfg.AltcoinLeverage, leverageOverridesFile)
+	InitGlobalStrategyManager("strategies")
+	sm := GetStrategyManager()
+	storageErr := InitGlobalStorage("data/storage.db")
+
+	var live *ConfigState
+	if strategyName == "" && storageErr == nil {
+		if cs, ok := lastRecordedConfigState(GetStorage()); ok {
+			live = &cs
+			strategyName = cs.Strategy
+		}
+	}
+	if strategyName != "" {
+		if err := sm.SetActiveStrategy(strategyName); err != nil {
+			return err
+		}
+	}
+	strategy := *sm.GetActiveStrategy()
+	if live != nil {
+		// 运行时通过 Web 修改过的风控参数以版本快照为准
+		strategy.RiskParams = live.RiskParams
+	}
+
+	symbols := filterBlacklisted(sm.GetSymbols(cfg.TradingSymbols))
+	ctx := &Context{
+		CurrentTime: time.Now().Format("2006-01-02 15:04:05"),
+		Symbols:     symbols,
+		Leverage:    GetLeverageManager().ResolveAll(symbols, strategy.RiskParams.FixedLeverage),
+	}
+	if storageErr == nil {
+		if snaps, err := GetStorage().GetEquityHistory(1); err == nil && len(snaps) > 0 {
+			ctx.Account.TotalEquity = snaps[len(snaps)-1].Equity
+		}
+	}
+
+	fmt.Println(buildSystemPromptFor(&strategy, ctx))
+	return nil
+}
@@ -0,0 +1,671 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// ReflectionConfig 平仓复盘配置：每笔持仓平仓后异步请求模型复盘并提炼教训（默认不启用）
+type ReflectionConfig struct {
+	Enabled       bool   `json:"enabled"`
+	Model         string `json:"model"`           // 为空时使用主配置
+	APIKey        string `json:"api_key"`         // 为空时使用主配置
+	APIURL        string `json:"api_url"`         // 为空时使用主配置
+	PromptLessons int    `json:"prompt_lessons"`  // 每轮注入 prompt 的相关教训条数，默认 5，< 0 表示不注入
+	MaxPathPoints int    `json:"max_path_points"` // 持仓期间保留的价格采样点数，默认 48
+}
+
+const (
+	defaultPromptLessons = 5
+	defaultMaxPathPoints = 48
+	// maxReflections 最多保留的复盘记录数
+	maxReflections = 500
+	// reflectionQueueSize 待复盘队列长度，队列满时丢弃新的复盘请求
+	reflectionQueueSize = 32
+	// lessonSimilarity 两条教训字符二元组 Jaccard 相似度不低于该值时视为重复
+	lessonSimilarity = 0.6
+)
+
+// pricePoint 持仓期间的一个价格采样
+type pricePoint struct {
+	Time  time.Time `json:"t"`
+	Price float64   `json:"p"`
+}
+
+// reflectionLeg 一条尚未平仓的持仓腿：开仓决策与持仓期间的价格路径
+type reflectionLeg struct {
+	Symbol   string       `json:"symbol"`
+	Side     string       `json:"side"`
+	Decision Decision     `json:"decision"`
+	Entry    float64      `json:"entry"`
+	OpenedAt time.Time    `json:"opened_at"`
+	Strategy string       `json:"strategy"`
+	Regime   string       `json:"regime,omitempty"`
+	Path     []pricePoint `json:"path"`
+
+	// 持仓期间新增的平仓记录（含部分平仓）的累计结果，见 consumeClosesLocked
+	Closes      int     `json:"closes,omitempty"`
+	RealizedPnL float64 `json:"realized_pnl,omitempty"`
+	RecordEntry float64 `json:"record_entry,omitempty"`
+	ExitPrice   float64 `json:"exit_price,omitempty"`
+	CloseReason string  `json:"close_reason,omitempty"`
+}
+
+// TradeReflection 一笔已平仓交易的复盘结果
+type TradeReflection struct {
+	ID          string    `json:"id"`
+	Symbol      string    `json:"symbol"`
+	Side        string    `json:"side"`
+	Strategy    string    `json:"strategy"`
+	Regime      string    `json:"regime,omitempty"`
+	OpenedAt    time.Time `json:"opened_at"`
+	ClosedAt    time.Time `json:"closed_at"`
+	Entry       float64   `json:"entry"`
+	Exit        float64   `json:"exit"`
+	PnL         float64   `json:"pnl"`
+	RMultiple   float64   `json:"r_multiple"`
+	CloseReason string    `json:"close_reason"`
+
+	// 模型给出的结构化复盘
+	Verdict    string `json:"verdict"` // good_process / bad_process / lucky / unlucky
+	WhatWorked string `json:"what_worked"`
+	WhatFailed string `json:"what_failed"`
+	Lesson     string `json:"lesson"`
+	Setup      string `json:"setup"` // 形态标签，如 breakout / pullback / mean_reversion
+	LessonID   int    `json:"lesson_id,omitempty"`
+	Error      string `json:"error,omitempty"` // 模型调用或解析失败的原因
+}
+
+// Lesson 去重后的一条教训；相似的教训合并计数，并累积出现过的币种 / 策略 / 市场状态
+type Lesson struct {
+	ID         int       `json:"id"`
+	Text       string    `json:"text"`
+	Setup      string    `json:"setup"`
+	Symbols    []string  `json:"symbols"`
+	Strategies []string  `json:"strategies"`
+	Regimes    []string  `json:"regimes,omitempty"`
+	Count      int       `json:"count"`
+	FirstSeen  time.Time `json:"first_seen"`
+	LastSeen   time.Time `json:"last_seen"`
+}
+
+type reflectionState struct {
+	Legs         map[string]*reflectionLeg `json:"legs"` // key: positionKey(symbol, side)
+	Reflections  []TradeReflection         `json:"reflections"`
+	Lessons      []*Lesson                 `json:"lessons"`
+	NextLessonID int                       `json:"next_lesson_id"`
+	SeenTrades   []string                  `json:"seen_trades"` // 已处理的平仓记录键，用于识别新增平仓记录（见 unseenTradeRecords）
+}
+
+// reflectionJob 一笔待复盘的平仓
+type reflectionJob struct {
+	leg         reflectionLeg
+	closedAt    time.Time
+	entry       float64
+	exit        float64
+	pnl         float64
+	closeReason string
+}
+
+// ReflectionJournal 平仓复盘日志：记录开仓决策与持仓期间的价格路径，持仓平仓后异步请求模型复盘，
+// 提炼的教训去重保存，并把与本轮交易对相关的教训注入后续 prompt
+type ReflectionJournal struct {
+	mu       sync.Mutex
+	cfg      ReflectionConfig
+	brain    *AIBrain
+	filePath string
+	state    reflectionState
+	queue    chan reflectionJob
+}
+
+// NewReflectionJournal 创建复盘日志并从 filePath 恢复状态，启动后台复盘协程
+func NewReflectionJournal(cfg ReflectionConfig, brain *AIBrain, filePath string) *ReflectionJournal {
+	if cfg.PromptLessons == 0 {
+		cfg.PromptLessons = defaultPromptLessons
+	}
+	if cfg.MaxPathPoints <= 0 {
+		cfg.MaxPathPoints = defaultMaxPathPoints
+	}
+	j := &ReflectionJournal{
+		cfg:      cfg,
+		brain:    brain,
+		filePath: filePath,
+		state:    reflectionState{Legs: make(map[string]*reflectionLeg)},
+		queue:    make(chan reflectionJob, reflectionQueueSize),
+	}
+	j.load()
+	go j.worker()
+	return j
+}
+
+func (j *ReflectionJournal) load() {
+	if j.filePath == "" {
+		return
+	}
+	data, err := os.ReadFile(j.filePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("⚠️ [Reflection] 加载 %s 失败: %v", j.filePath, err)
+		}
+		return
+	}
+	var st reflectionState
+	if err := json.Unmarshal(data, &st); err != nil {
+		log.Printf("⚠️ [Reflection] 解析 %s 失败: %v", j.filePath, err)
+		return
+	}
+	if st.Legs == nil {
+		st.Legs = make(map[string]*reflectionLeg)
+	}
+	j.state = st
+}
+
+// saveLocked 持久化状态（调用方需持有锁）
+func (j *ReflectionJournal) saveLocked() {
+	if j.filePath == "" {
+		return
+	}
+	if err := writeJSONFile(j.filePath, j.state); err != nil {
+		log.Printf("⚠️ [Reflection] 保存 %s 失败: %v", j.filePath, err)
+	}
+}
+
+// RecordOpen 记录一次成功开仓的决策；同一持仓腿加仓时保留首次开仓的决策。
+// 先消费 history 中新增的平仓记录：已记录到平仓的持仓腿（同一周期内平仓后重开）先提交复盘，再按新的一笔交易记录
+func (j *ReflectionJournal) RecordOpen(d Decision, entry float64, history []TradeRecord, now time.Time) {
+	if j == nil || entry <= 0 {
+		return
+	}
+	side := decisionPositionSide(d)
+	key := positionKey(d.Symbol, side)
+	d.Setup = normalizeSetup(d.Setup)
+	leg := &reflectionLeg{
+		Symbol:   d.Symbol,
+		Side:     side,
+		Decision: d,
+		Entry:    entry,
+		OpenedAt: now,
+		Path:     []pricePoint{{Time: now, Price: entry}},
+	}
+	if sm := GetStrategyManager(); sm != nil {
+		leg.Strategy = sm.GetActiveStrategyName()
+	}
+	if rd := GetRegimeDetector(); rd != nil {
+		leg.Regime = rd.Status().Regime
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.consumeClosesLocked(history)
+	if old, ok := j.state.Legs[key]; ok {
+		if old.Closes == 0 {
+			return
+		}
+		j.submitLocked(old, now)
+	}
+	j.state.Legs[key] = leg
+	j.saveLocked()
+}
+
+// Observe 每个周期调用：新增的平仓记录计入对应持仓腿，为持仓中的腿追加价格采样；
+// 已消失的持仓腿视为平仓，按持仓期间累计的平仓记录汇总结果后提交异步复盘
+func (j *ReflectionJournal) Observe(positions []PositionInfo, history []TradeRecord, now time.Time) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.consumeClosesLocked(history)
+
+	alive := make(map[string]bool, len(positions))
+	for _, p := range positions {
+		key := positionKey(p.Symbol, normalizePositionSide(p.Side))
+		alive[key] = true
+		if leg, ok := j.state.Legs[key]; ok && p.MarkPrice > 0 {
+			leg.Path = append(leg.Path, pricePoint{Time: now, Price: p.MarkPrice})
+			if len(leg.Path) > j.cfg.MaxPathPoints {
+				leg.Path = downsamplePath(leg.Path)
+			}
+		}
+	}
+
+	for key, leg := range j.state.Legs {
+		if alive[key] {
+			continue
+		}
+		delete(j.state.Legs, key)
+		j.submitLocked(leg, now)
+	}
+	j.saveLocked()
+}
+
+// consumeClosesLocked 新增的平仓记录计入对应持仓腿（调用方需持有锁）
+func (j *ReflectionJournal) consumeClosesLocked(history []TradeRecord) {
+	var newRecords []TradeRecord
+	newRecords, j.state.SeenTrades = unseenTradeRecords(history, j.state.SeenTrades)
+	for _, r := range newRecords {
+		leg, ok := j.state.Legs[positionKey(r.Symbol, normalizePositionSide(r.Side))]
+		if !ok {
+			continue
+		}
+		leg.Closes++
+		leg.RealizedPnL += r.PnL
+		if r.EntryPrice > 0 {
+			leg.RecordEntry = r.EntryPrice
+		}
+		if r.ExitPrice > 0 {
+			leg.ExitPrice = r.ExitPrice
+		}
+		leg.CloseReason = r.Reason
+	}
+}
+
+// submitLocked 按持仓期间累计的平仓记录汇总结果，提交异步复盘（调用方需持有锁）
+func (j *ReflectionJournal) submitLocked(leg *reflectionLeg, now time.Time) {
+	job := reflectionJob{leg: *leg, closedAt: now, entry: leg.Entry, exit: leg.Path[len(leg.Path)-1].Price}
+	if leg.Closes > 0 {
+		job.pnl = leg.RealizedPnL
+		if leg.RecordEntry > 0 {
+			job.entry = leg.RecordEntry
+		}
+		if leg.ExitPrice > 0 {
+			job.exit = leg.ExitPrice
+		}
+		job.closeReason = leg.CloseReason
+	} else {
+		job.closeReason = "未找到平仓记录（可能为交易所侧止损 / 强平），按最后标记价格估算"
+	}
+	select {
+	case j.queue <- job:
+	default:
+		log.Printf("⚠️ [Reflection] 复盘队列已满，跳过 %s %s", leg.Symbol, leg.Side)
+	}
+}
+
+// downsamplePath 采样点过多时隔点保留（始终保留首尾），越早的路径分辨率越低
+func downsamplePath(path []pricePoint) []pricePoint {
+	out := make([]pricePoint, 0, len(path)/2+2)
+	for i := 0; i < len(path)-1; i += 2 {
+		out = append(out, path[i])
+	}
+	return append(out, path[len(path)-1])
+}
+
+// worker 串行处理复盘请求，避免并发占用模型额度
+func (j *ReflectionJournal) worker() {
+	for job := range j.queue {
+		j.reflect(job)
+	}
+}
+
+// reflect 请求模型复盘一笔交易，保存结果并合并教训
+func (j *ReflectionJournal) reflect(job reflectionJob) {
+	leg := job.leg
+	r := TradeReflection{
+		ID:          fmt.Sprintf("%s-%s-%d", leg.Symbol, leg.Side, job.closedAt.Unix()),
+		Symbol:      leg.Symbol,
+		Side:        leg.Side,
+		Strategy:    leg.Strategy,
+		Regime:      leg.Regime,
+		OpenedAt:    leg.OpenedAt,
+		ClosedAt:    job.closedAt,
+		Entry:       job.entry,
+		Exit:        job.exit,
+		PnL:         job.pnl,
+		RMultiple:   tradeRMultiple(TradeRecord{Side: leg.Side, EntryPrice: job.entry, ExitPrice: job.exit}, leg.Decision.StopLoss),
+		CloseReason: job.closeReason,
+	}
+
+	response, _, err := j.brain.callAI(leg.Strategy, reflectionSystemPrompt, buildReflectionPrompt(job, r.RMultiple))
+	if err == nil {
+		err = parseReflection(response, &r)
+	}
+	if r.Setup == "" {
+		r.Setup = leg.Decision.Setup
+	}
+	if err != nil {
+		r.Error = err.Error()
+		log.Printf("⚠️ [Reflection] %s %s 复盘失败: %v", leg.Symbol, leg.Side, err)
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if r.Lesson != "" {
+		r.LessonID = j.mergeLessonLocked(r)
+	}
+	j.state.Reflections = append(j.state.Reflections, r)
+	if n := len(j.state.Reflections); n > maxReflections {
+		j.state.Reflections = j.state.Reflections[n-maxReflections:]
+	}
+	j.saveLocked()
+	if r.Error == "" {
+		log.Printf("📝 [Reflection] %s %s R=%.2f [%s] 教训: %s", leg.Symbol, leg.Side, r.RMultiple, r.Verdict, r.Lesson)
+	}
+}
+
+const reflectionSystemPrompt = `你是一名加密货币永续合约交易的复盘教练。根据开仓时的决策、持仓期间的价格路径和最终结果，简短评价这笔交易的决策过程（而不是只看盈亏），并提炼一条可执行的教训。
+只输出一个 JSON 对象，不要输出其它内容：
+{"verdict": "good_process | bad_process | lucky | unlucky", "what_worked": "做对了什么（不超过 60 字）", "what_failed": "做错了什么（不超过 60 字）", "lesson": "一条以后可直接执行的规则（不超过 60 字，不要提具体价格）", "setup": "形态标签，小写下划线，如 breakout / pullback / mean_reversion / trend_follow / funding_fade"}`
+
+// buildReflectionPrompt 复盘请求：开仓决策、持仓期间价格路径（相对入场价的涨跌幅）与结果
+func buildReflectionPrompt(job reflectionJob, rMultiple float64) string {
+	leg := job.leg
+	d := leg.Decision
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("## 开仓决策\n%s %s | 策略 %s", leg.Symbol, strings.ToUpper(leg.Side), leg.Strategy))
+	if leg.Regime != "" {
+		sb.WriteString(" | 市场状态 " + leg.Regime)
+	}
+	sb.WriteString("\n入场 " + formatPriceWithDynamicPrecision(job.entry))
+	if d.StopLoss > 0 {
+		sb.WriteString(" | 止损 " + formatPriceWithDynamicPrecision(d.StopLoss))
+	}
+	if d.TakeProfit > 0 {
+		sb.WriteString(" | 止盈 " + formatPriceWithDynamicPrecision(d.TakeProfit))
+	}
+	if d.Confidence > 0 {
+		sb.WriteString(fmt.Sprintf(" | 置信度 %.2f", normalizedConfidence(d.Confidence)))
+	}
+	sb.WriteString("\n")
+	sb.WriteString("理由: " + d.Reasoning + "\n")
+	if d.InvalidationCondition != "" {
+		sb.WriteString("失效条件: " + d.InvalidationCondition + "\n")
+	}
+	if d.Setup != "" {
+		sb.WriteString("开仓时标注的形态: " + d.Setup + "\n")
+	}
+
+	sb.WriteString("\n## 持仓期间价格路径（时间 价格 相对入场）\n")
+	for _, p := range leg.Path {
+		pct := 0.0
+		if job.entry > 0 {
+			pct = (p.Price - job.entry) / job.entry * 100
+		}
+		sb.WriteString(fmt.Sprintf("%s %s (%+.2f%%)\n", p.Time.Local().Format("01-02 15:04"), formatPriceWithDynamicPrecision(p.Price), pct))
+	}
+
+	sb.WriteString(fmt.Sprintf("\n## 结果\n持仓 %s | 平仓 %s | 盈亏 %+.2f U | R=%+.2f\n",
+		formatRemaining(job.closedAt.Sub(leg.OpenedAt)), formatPriceWithDynamicPrecision(job.exit), job.pnl, rMultiple))
+	if job.closeReason != "" {
+		sb.WriteString("平仓原因: " + job.closeReason + "\n")
+	}
+	return sb.String()
+}
+
+// parseReflection 从模型回复中提取 JSON 对象并填入复盘结果
+func parseReflection(response string, r *TradeReflection) error {
+	start, end := strings.Index(response, "{"), strings.LastIndex(response, "}")
+	if start < 0 || end <= start {
+		return fmt.Errorf("reflection response has no JSON object")
+	}
+	var out struct {
+		Verdict    string `json:"verdict"`
+		WhatWorked string `json:"what_worked"`
+		WhatFailed string `json:"what_failed"`
+		Lesson     string `json:"lesson"`
+		Setup      string `json:"setup"`
+	}
+	if err := json.Unmarshal([]byte(response[start:end+1]), &out); err != nil {
+		return fmt.Errorf("parse reflection: %w", err)
+	}
+	r.Verdict = strings.TrimSpace(out.Verdict)
+	r.WhatWorked = strings.TrimSpace(out.WhatWorked)
+	r.WhatFailed = strings.TrimSpace(out.WhatFailed)
+	r.Lesson = strings.TrimSpace(out.Lesson)
+	r.Setup = normalizeSetup(out.Setup)
+	return nil
+}
+
+// normalizeSetup 形态标签统一为小写、去掉首尾空白
+func normalizeSetup(s string) string {
+	return strings.ToLower(strings.TrimSpace(s))
+}
+
+// mergeLessonLocked 合并到相似的已有教训，或新建一条；返回教训 ID（调用方需持有锁）
+func (j *ReflectionJournal) mergeLessonLocked(r TradeReflection) int {
+	var lesson *Lesson
+	for _, l := range j.state.Lessons {
+		if lessonSimilar(l.Text, r.Lesson) {
+			lesson = l
+			break
+		}
+	}
+	if lesson == nil {
+		j.state.NextLessonID++
+		lesson = &Lesson{ID: j.state.NextLessonID, Text: r.Lesson, Setup: r.Setup, FirstSeen: r.ClosedAt}
+		j.state.Lessons = append(j.state.Lessons, lesson)
+	}
+	lesson.Count++
+	lesson.LastSeen = r.ClosedAt
+	if lesson.Setup == "" {
+		lesson.Setup = r.Setup
+	}
+	lesson.Symbols = appendUnique(lesson.Symbols, r.Symbol)
+	lesson.Strategies = appendUnique(lesson.Strategies, r.Strategy)
+	lesson.Regimes = appendUnique(lesson.Regimes, r.Regime)
+	return lesson.ID
+}
+
+// lessonSimilar 归一化后相同，或字符二元组 Jaccard 相似度不低于 lessonSimilarity
+func lessonSimilar(a, b string) bool {
+	na, nb := normalizeLesson(a), normalizeLesson(b)
+	if na == "" || nb == "" {
+		return false
+	}
+	if na == nb {
+		return true
+	}
+	ga, gb := runeBigrams(na), runeBigrams(nb)
+	inter := 0
+	for g := range ga {
+		if gb[g] {
+			inter++
+		}
+	}
+	union := len(ga) + len(gb) - inter
+	return union > 0 && float64(inter)/float64(union) >= lessonSimilarity
+}
+
+// normalizeLesson 转小写并去掉空白与标点
+func normalizeLesson(s string) string {
+	var sb strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+func runeBigrams(s string) map[string]bool {
+	rs := []rune(s)
+	grams := make(map[string]bool, len(rs))
+	for i := 0; i+1 < len(rs); i++ {
+		grams[string(rs[i:i+2])] = true
+	}
+	if len(rs) == 1 {
+		grams[s] = true
+	}
+	return grams
+}
+
+func appendUnique(list []string, v string) []string {
+	if v == "" {
+		return list
+	}
+	for _, x := range list {
+		if x == v {
+			return list
+		}
+	}
+	return append(list, v)
+}
+
+// SearchLessons 按关键字（匹配教训内容与形态标签）和币种筛选教训，按出现次数、最近出现时间排序；limit <= 0 表示全部
+func (j *ReflectionJournal) SearchLessons(query, symbol string, limit int) []Lesson {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	query = strings.ToLower(strings.TrimSpace(query))
+	var out []Lesson
+	for _, l := range j.state.Lessons {
+		if query != "" && !strings.Contains(strings.ToLower(l.Text), query) && !strings.Contains(l.Setup, query) {
+			continue
+		}
+		if symbol != "" && !containsString(l.Symbols, symbol) {
+			continue
+		}
+		out = append(out, *l)
+	}
+	sort.SliceStable(out, func(a, b int) bool {
+		if out[a].Count != out[b].Count {
+			return out[a].Count > out[b].Count
+		}
+		return out[a].LastSeen.After(out[b].LastSeen)
+	})
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
+
+// Reflections 最近的复盘记录（新→旧），symbol 非空时只返回该币种
+func (j *ReflectionJournal) Reflections(symbol string, limit int) []TradeReflection {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var out []TradeReflection
+	for i := len(j.state.Reflections) - 1; i >= 0; i-- {
+		r := j.state.Reflections[i]
+		if symbol != "" && r.Symbol != symbol {
+			continue
+		}
+		out = append(out, r)
+		if limit > 0 && len(out) >= limit {
+			break
+		}
+	}
+	return out
+}
+
+// RelevantLessons 与本轮相关的教训：币种命中 +3，形态与持仓中某条腿开仓时标注的形态相同 +2，
+// 策略命中 +1，市场状态命中 +1，同分按出现次数与最近出现时间排序
+func (j *ReflectionJournal) RelevantLessons(symbols []string, strategy, regime string, limit int) []Lesson {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	type scored struct {
+		lesson Lesson
+		score  int
+	}
+	setups := make(map[string]bool)
+	for _, leg := range j.state.Legs {
+		if leg.Decision.Setup != "" {
+			setups[leg.Decision.Setup] = true
+		}
+	}
+	var list []scored
+	for _, l := range j.state.Lessons {
+		score := 0
+		for _, s := range symbols {
+			if containsString(l.Symbols, s) {
+				score += 3
+				break
+			}
+		}
+		if l.Setup != "" && setups[l.Setup] {
+			score += 2
+		}
+		if strategy != "" && containsString(l.Strategies, strategy) {
+			score++
+		}
+		if regime != "" && containsString(l.Regimes, regime) {
+			score++
+		}
+		if score > 0 {
+			list = append(list, scored{*l, score})
+		}
+	}
+	sort.SliceStable(list, func(a, b int) bool {
+		if list[a].score != list[b].score {
+			return list[a].score > list[b].score
+		}
+		if list[a].lesson.Count != list[b].lesson.Count {
+			return list[a].lesson.Count > list[b].lesson.Count
+		}
+		return list[a].lesson.LastSeen.After(list[b].lesson.LastSeen)
+	})
+	out := make([]Lesson, 0, limit)
+	for i := 0; i < len(list) && i < limit; i++ {
+		out = append(out, list[i].lesson)
+	}
+	return out
+}
+
+// buildLessonsSection 本轮 prompt 中的经验教训（仅实盘；影子策略不注入，避免对比失真）
+func buildLessonsSection(ctx *Context) string {
+	j := GetReflectionJournal()
+	if j == nil || ctx.Risk != nil || j.cfg.PromptLessons < 0 {
+		return ""
+	}
+	symbols := append([]string(nil), contextSymbols(ctx)...)
+	for _, p := range ctx.Positions {
+		symbols = append(symbols, p.Symbol)
+	}
+	var strategy, regime string
+	if sm := GetStrategyManager(); sm != nil {
+		strategy = sm.GetActiveStrategyName()
+	}
+	if rd := GetRegimeDetector(); rd != nil {
+		regime = rd.Status().Regime
+	}
+	lessons := j.RelevantLessons(symbols, strategy, regime, j.cfg.PromptLessons)
+	if len(lessons) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString("## 经验教训（来自过往交易复盘）\n")
+	for _, l := range lessons {
+		tags := strings.Join(l.Symbols, ",")
+		if l.Setup != "" {
+			tags += " · " + l.Setup
+		}
+		sb.WriteString(fmt.Sprintf("- [%s · ×%d] %s\n", tags, l.Count, l.Text))
+	}
+	sb.WriteString("\n")
+	return sb.String()
+}
+
+// 全局复盘日志
+var globalReflectionJournal *ReflectionJournal
+
+// InitGlobalReflectionJournal 按配置创建复盘日志（reflection.enabled 为 false 时不创建）；模型与 API 未配置时沿用主配置
+func InitGlobalReflectionJournal(cfg *Config, filePath string) {
+	rc := cfg.Reflection
+	if !rc.Enabled {
+		return
+	}
+	if rc.Model == "" {
+		rc.Model = cfg.AIModel
+	}
+	if rc.APIKey == "" {
+		rc.APIKey = cfg.AIAPIKey
+	}
+	if rc.APIURL == "" {
+		rc.APIURL = cfg.AIAPIURL
+	}
+	brain := NewAIBrain(rc.APIKey, rc.APIURL, rc.Model, cfg.BinanceProxyURL)
+	brain.Source = "reflection"
+	globalReflectionJournal = NewReflectionJournal(rc, brain, filePath)
+	log.Printf("✅ [Reflection] 平仓复盘已启用：模型 %s", rc.Model)
+}
+
+// GetReflectionJournal 获取全局复盘日志（未启用时返回 nil）
+func GetReflectionJournal() *ReflectionJournal {
+	return globalReflectionJournal
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 市场状态
+const (
+	RegimeTrending     = "trending"      // 多周期 EMA 同向且 4h 均线发散
+	RegimeRanging      = "ranging"       // 其它情况
+	RegimeHighVol      = "high_vol"      // 已实现波动率或 ATR 过高
+	RegimeLowLiquidity = "low_liquidity" // 24h 成交额过低
+)
+
+// regimePriority 单个交易对同时满足多个条件时的优先级，也用于投票平局
+var regimePriority = []string{RegimeLowLiquidity, RegimeHighVol, RegimeTrending, RegimeRanging}
+
+// maxRegimeHistory 保留的自动切换记录条数
+const maxRegimeHistory = 100
+
+// RegimeThresholds 市场状态分类阈值（0 表示使用默认值）
+type RegimeThresholds struct {
+	HighVolatility float64 `json:"high_volatility"`  // 1h 已实现波动率上限，默认 0.012
+	HighATRPct     float64 `json:"high_atr_pct"`     // ATR14(1h) / 价格上限，默认 0.02
+	MinQuoteVolume float64 `json:"min_quote_volume"` // 24h 成交额下限 (USDT)，默认 2000 万；未知时用 4h 均量估算
+	TrendSpread    float64 `json:"trend_spread"`     // 4h EMA20 与 EMA50 的最小间距（占价格），默认 0.004
+}
+
+// RegimeConfig 市场状态识别与自动切换策略配置
+type RegimeConfig struct {
+	Enabled          bool              `json:"enabled"`
+	ReferenceSymbols []string          `json:"reference_symbols"` // 参与投票的交易对，为空时使用本轮交易对中的主流币（没有则全部）
+	Strategies       map[string]string `json:"strategies"`        // 市场状态 -> 策略名称，未配置的状态不切换
+	ConfirmCycles    int               `json:"confirm_cycles"`    // 新状态需连续出现的周期数，默认 3
+	MinHoldMinutes   int               `json:"min_hold_minutes"`  // 两次状态切换的最小间隔（分钟），默认 60
+	ExitBuffer       float64           `json:"exit_buffer"`       // 当前状态的阈值放宽比例（退出比进入更难），默认 0.2
+	Thresholds       RegimeThresholds  `json:"thresholds"`
+}
+
+// withDefaults 补全默认值
+func (c RegimeConfig) withDefaults() RegimeConfig {
+	if c.Strategies == nil {
+		c.Strategies = map[string]string{
+			RegimeTrending:     "aggressive",
+			RegimeRanging:      "scalping",
+			RegimeHighVol:      "conservative",
+			RegimeLowLiquidity: "conservative",
+		}
+	}
+	if c.ConfirmCycles <= 0 {
+		c.ConfirmCycles = 3
+	}
+	if c.MinHoldMinutes <= 0 {
+		c.MinHoldMinutes = 60
+	}
+	if c.ExitBuffer <= 0 || c.ExitBuffer >= 1 {
+		c.ExitBuffer = 0.2
+	}
+	t := &c.Thresholds
+	if t.HighVolatility <= 0 {
+		t.HighVolatility = 0.012
+	}
+	if t.HighATRPct <= 0 {
+		t.HighATRPct = 0.02
+	}
+	if t.MinQuoteVolume <= 0 {
+		t.MinQuoteVolume = 20_000_000
+	}
+	if t.TrendSpread <= 0 {
+		t.TrendSpread = 0.004
+	}
+	return c
+}
+
+// RegimeReading 单个交易对的分类结果及所用指标
+type RegimeReading struct {
+	Symbol         string  `json:"symbol"`
+	Regime         string  `json:"regime"`
+	Reason         string  `json:"reason"`
+	Volatility1h   float64 `json:"volatility_1h"`
+	ATRPct         float64 `json:"atr_pct"`
+	QuoteVolume24h float64 `json:"quote_volume_24h"`
+	TrendScore     int     `json:"trend_score"` // 15m / 1h / 4h 价格相对 EMA20 的方向之和，±3 表示完全同向
+	EMASpread      float64 `json:"ema_spread"`  // 4h (EMA20 - EMA50) / 价格
+}
+
+// classifyRegime 按规则对单个交易对分类；current 为当前已确认的市场状态，其阈值按 buffer 放宽
+func classifyRegime(md *MarketData, cfg RegimeConfig, current string) RegimeReading {
+	r := RegimeReading{Symbol: md.Symbol}
+	price := md.CurrentPrice
+	if md.Sentiment != nil {
+		r.Volatility1h = md.Sentiment.Volatility1h
+	}
+	if price > 0 {
+		r.ATRPct = md.ATR14_1h / price
+	}
+	r.QuoteVolume24h = md.Volume24h
+	if lt := md.LongerTermContext; r.QuoteVolume24h == 0 && lt != nil {
+		r.QuoteVolume24h = lt.AverageVolume * 6 * price
+	}
+	var ema4h20, ema4h50 float64
+	if lt := md.LongerTermContext; lt != nil {
+		ema4h20, ema4h50 = lt.EMA20, lt.EMA50
+	}
+	for _, ema := range []float64{md.EMA20_15m, md.EMA20_1h, ema4h20} {
+		switch {
+		case ema <= 0:
+		case price > ema:
+			r.TrendScore++
+		case price < ema:
+			r.TrendScore--
+		}
+	}
+	if price > 0 && ema4h20 > 0 && ema4h50 > 0 {
+		r.EMASpread = (ema4h20 - ema4h50) / price
+	}
+
+	// 当前状态的进入条件放宽，避免指标在阈值附近来回切换
+	loosen := func(regime string) float64 {
+		if regime == current {
+			return cfg.ExitBuffer
+		}
+		return 0
+	}
+	t := cfg.Thresholds
+
+	b := loosen(RegimeLowLiquidity)
+	if r.QuoteVolume24h > 0 && r.QuoteVolume24h < t.MinQuoteVolume*(1+b) {
+		r.Regime, r.Reason = RegimeLowLiquidity, fmt.Sprintf("24h 成交额 %.0fM < %.0fM", r.QuoteVolume24h/1e6, t.MinQuoteVolume*(1+b)/1e6)
+		return r
+	}
+
+	b = loosen(RegimeHighVol)
+	if r.Volatility1h >= t.HighVolatility*(1-b) {
+		r.Regime, r.Reason = RegimeHighVol, fmt.Sprintf("1h 波动率 %.2f%% ≥ %.2f%%", r.Volatility1h*100, t.HighVolatility*(1-b)*100)
+		return r
+	}
+	if r.ATRPct >= t.HighATRPct*(1-b) {
+		r.Regime, r.Reason = RegimeHighVol, fmt.Sprintf("ATR14(1h) %.2f%% ≥ %.2f%%", r.ATRPct*100, t.HighATRPct*(1-b)*100)
+		return r
+	}
+
+	b = loosen(RegimeTrending)
+	aligned := (r.TrendScore == 3 && r.EMASpread > 0) || (r.TrendScore == -3 && r.EMASpread < 0)
+	if aligned && math.Abs(r.EMASpread) >= t.TrendSpread*(1-b) {
+		dir := "上涨"
+		if r.TrendScore < 0 {
+			dir = "下跌"
+		}
+		r.Regime, r.Reason = RegimeTrending, fmt.Sprintf("%s趋势: 15m/1h/4h 同向，4h 均线间距 %.2f%%", dir, r.EMASpread*100)
+		return r
+	}
+
+	r.Regime, r.Reason = RegimeRanging, fmt.Sprintf("趋势分 %+d，4h 均线间距 %.2f%%", r.TrendScore, r.EMASpread*100)
+	return r
+}
+
+// voteRegime 多数投票，平局按 regimePriority 取更保守的状态
+func voteRegime(readings []RegimeReading) string {
+	counts := make(map[string]int)
+	for _, r := range readings {
+		counts[r.Regime]++
+	}
+	best, bestCount := "", 0
+	for _, regime := range regimePriority {
+		if counts[regime] > bestCount {
+			best, bestCount = regime, counts[regime]
+		}
+	}
+	return best
+}
+
+// RegimeSwitch 一次已确认的市场状态变化（及随之进行的策略切换）
+type RegimeSwitch struct {
+	Time         time.Time       `json:"time"`
+	FromRegime   string          `json:"from_regime"`
+	ToRegime     string          `json:"to_regime"`
+	FromStrategy string          `json:"from_strategy"`
+	ToStrategy   string          `json:"to_strategy"` // 与 FromStrategy 相同表示未切换
+	Reason       string          `json:"reason"`
+	Readings     []RegimeReading `json:"readings"`
+}
+
+// regimeState 持久化状态
+type regimeState struct {
+	Regime         string         `json:"regime"` // 当前已确认的市场状态，为空表示尚未确认
+	Since          time.Time      `json:"since"`
+	Candidate      string         `json:"candidate"` // 等待确认的新状态
+	CandidateCount int            `json:"candidate_count"`
+	History        []RegimeSwitch `json:"history"`
+}
+
+// RegimeStatus 供 Web 展示的当前状态
+type RegimeStatus struct {
+	Regime         string            `json:"regime"`
+	Since          time.Time         `json:"since"`
+	Candidate      string            `json:"candidate"`
+	CandidateCount int               `json:"candidate_count"`
+	ConfirmCycles  int               `json:"confirm_cycles"`
+	Observed       string            `json:"observed"` // 本周期投票结果
+	Readings       []RegimeReading   `json:"readings"`
+	Strategies     map[string]string `json:"strategies"`
+	History        []RegimeSwitch    `json:"history"`
+}
+
+// RegimeDetector 每个周期根据行情识别市场状态；新状态连续出现 confirm_cycles 个周期、且距上次切换超过 min_hold_minutes 后才确认，
+// 确认后按 strategies 映射切换当前策略。只在状态变化时切换，因此手动切换的策略会保持到下一次状态变化
+type RegimeDetector struct {
+	mu       sync.Mutex
+	cfg      RegimeConfig
+	filePath string
+	state    regimeState
+	observed string
+	readings []RegimeReading
+}
+
+// NewRegimeDetector 创建市场状态识别器并从 filePath 恢复状态
+func NewRegimeDetector(cfg RegimeConfig, filePath string) *RegimeDetector {
+	d := &RegimeDetector{cfg: cfg.withDefaults(), filePath: filePath}
+	d.load()
+	return d
+}
+
+func (d *RegimeDetector) load() {
+	data, err := os.ReadFile(d.filePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("⚠️ 加载市场状态失败: %v", err)
+		}
+		return
+	}
+	if err := json.Unmarshal(data, &d.state); err != nil {
+		log.Printf("⚠️ 解析市场状态失败: %v", err)
+	}
+}
+
+// saveLocked 持久化当前状态（调用方需持有锁）
+func (d *RegimeDetector) saveLocked() {
+	if d.filePath == "" {
+		return
+	}
+	if err := writeJSONFile(d.filePath, d.state); err != nil {
+		log.Printf("⚠️ 保存市场状态失败: %v", err)
+	}
+}
+
+// referenceSymbols 参与投票的交易对
+func (d *RegimeDetector) referenceSymbols(tradable []string) []string {
+	if len(d.cfg.ReferenceSymbols) > 0 {
+		return d.cfg.ReferenceSymbols
+	}
+	var majors []string
+	for _, s := range tradable {
+		if !isAltSymbol(s) {
+			majors = append(majors, s)
+		}
+	}
+	if len(majors) > 0 {
+		return majors
+	}
+	return tradable
+}
+
+// Evaluate 每个周期调用：分类、投票并按迟滞规则确认状态；状态确认变化时切换策略并返回切换记录，否则返回 nil
+func (d *RegimeDetector) Evaluate(md map[string]*MarketData, tradable []string, now time.Time) *RegimeSwitch {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var readings []RegimeReading
+	for _, s := range d.referenceSymbols(tradable) {
+		if m, ok := md[s]; ok && m != nil && m.CurrentPrice > 0 {
+			readings = append(readings, classifyRegime(m, d.cfg, d.state.Regime))
+		}
+	}
+	d.readings = readings
+	if len(readings) == 0 {
+		d.observed = ""
+		return nil
+	}
+	observed := voteRegime(readings)
+	d.observed = observed
+
+	if observed == d.state.Regime {
+		if d.state.Candidate != "" {
+			d.state.Candidate, d.state.CandidateCount = "", 0
+			d.saveLocked()
+		}
+		return nil
+	}
+	if observed == d.state.Candidate {
+		d.state.CandidateCount++
+	} else {
+		d.state.Candidate, d.state.CandidateCount = observed, 1
+	}
+	if d.state.CandidateCount < d.cfg.ConfirmCycles ||
+		(d.state.Regime != "" && now.Sub(d.state.Since) < time.Duration(d.cfg.MinHoldMinutes)*time.Minute) {
+		d.saveLocked()
+		return nil
+	}
+
+	sw := RegimeSwitch{
+		Time:       now,
+		FromRegime: d.state.Regime,
+		ToRegime:   observed,
+		Reason:     regimeReason(readings, observed),
+		Readings:   readings,
+	}
+	d.state.Regime, d.state.Since = observed, now
+	d.state.Candidate, d.state.CandidateCount = "", 0
+	d.switchStrategy(&sw)
+
+	d.state.History = append(d.state.History, sw)
+	if len(d.state.History) > maxRegimeHistory {
+		d.state.History = d.state.History[len(d.state.History)-maxRegimeHistory:]
+	}
+	d.saveLocked()
+	return &sw
+}
+
+// switchStrategy 按映射切换策略，记录配置版本并发送通知
+func (d *RegimeDetector) switchStrategy(sw *RegimeSwitch) {
+	sm := GetStrategyManager()
+	sw.FromStrategy = sm.GetActiveStrategyName()
+	sw.ToStrategy = sw.FromStrategy
+	target := d.cfg.Strategies[sw.ToRegime]
+	if target == "" || target == sw.FromStrategy {
+		log.Printf("ℹ️ [Regime] 市场状态 %s -> %s（%s），策略保持 %s", displayRegime(sw.FromRegime), sw.ToRegime, sw.Reason, sw.FromStrategy)
+		return
+	}
+	if err := sm.SetActiveStrategy(target); err != nil {
+		log.Printf("⚠️ [Regime] 市场状态 %s -> %s，切换策略 %s 失败: %v", displayRegime(sw.FromRegime), sw.ToRegime, target, err)
+		if n := GetNotifier(); n != nil {
+			n.NotifyError(fmt.Errorf("regime %s: switch to strategy %s failed: %w", sw.ToRegime, target, err))
+		}
+		return
+	}
+	sw.ToStrategy = target
+	log.Printf("🔀 [Regime] 市场状态 %s -> %s（%s），策略 %s -> %s", displayRegime(sw.FromRegime), sw.ToRegime, sw.Reason, sw.FromStrategy, target)
+
+	// 新策略的风控参数 / prompt 形成新的配置版本，快照写入 Storage
+	GetConfigVersions().Check(fmt.Sprintf("regime_switch %s -> %s (%s)", displayRegime(sw.FromRegime), sw.ToRegime, sw.Reason))
+	if n := GetNotifier(); n != nil {
+		n.NotifyRegimeSwitch(displayRegime(sw.FromRegime), sw.ToRegime, sw.FromStrategy, target, sw.Reason)
+	}
+}
+
+// regimeReason 汇总投票结果，如 "trending 2/2: BTCUSDT 上涨趋势...; ETHUSDT ..."
+func regimeReason(readings []RegimeReading, regime string) string {
+	var parts []string
+	for _, r := range readings {
+		if r.Regime == regime {
+			parts = append(parts, r.Symbol+" "+r.Reason)
+		}
+	}
+	sort.Strings(parts)
+	return fmt.Sprintf("%s %d/%d: %s", regime, len(parts), len(readings), strings.Join(parts, "; "))
+}
+
+// displayRegime 尚未确认时显示为 unknown
+func displayRegime(regime string) string {
+	if regime == "" {
+		return "unknown"
+	}
+	return regime
+}
+
+// Status 当前状态
+func (d *RegimeDetector) Status() RegimeStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return RegimeStatus{
+		Regime:         d.state.Regime,
+		Since:          d.state.Since,
+		Candidate:      d.state.Candidate,
+		CandidateCount: d.state.CandidateCount,
+		ConfirmCycles:  d.cfg.ConfirmCycles,
+		Observed:       d.observed,
+		Readings:       append([]RegimeReading(nil), d.readings...),
+		Strategies:     d.cfg.Strategies,
+		History:        append([]RegimeSwitch(nil), d.state.History...),
+	}
+}
+
+// 全局市场状态识别器
+var globalRegimeDetector *RegimeDetector
+
+// InitGlobalRegimeDetector 初始化全局市场状态识别器（regime.enabled 为 false 时不创建）
+func InitGlobalRegimeDetector(cfg RegimeConfig, filePath string) {
+	if !cfg.Enabled {
+		return
+	}
+	globalRegimeDetector = NewRegimeDetector(cfg, filePath)
+	c := globalRegimeDetector.cfg
+	for regime, name := range c.Strategies {
+		if !containsString(regimePriority, regime) {
+			log.Printf("⚠️ [Regime] 忽略未知的市场状态 %q（可选 trending / ranging / high_vol / low_liquidity）", regime)
+		} else if _, ok := GetStrategyManager().GetStrategy(name); !ok {
+			log.Printf("⚠️ [Regime] 市场状态 %s 映射的策略 %s 不存在，该状态下不会切换", regime, name)
+		}
+	}
+	log.Printf("✅ [Regime] 市场状态自动切换已启用：%v（确认 %d 个周期，最短间隔 %d 分钟）", c.Strategies, c.ConfirmCycles, c.MinHoldMinutes)
+}
+
+// GetRegimeDetector 获取全局市场状态识别器（未启用时返回 nil）
+func GetRegimeDetector() *RegimeDetector {
+	return globalRegimeDetector
+}
@@ -4,9 +4,11 @@ import (
 	"fmt"
 	"log"
 	"math"
+	"strings"
+	"time"
 )
 
-// 全局风控常量（不随策略变化的部分）
+// 全局风控常量：作为风控规则参数的默认值，可在策略 risk_params.rules 中按规则覆盖
 const (
 	// 建议的单笔最小名义仓位，用于避免交易所最小名义限制（约 10U）带来的报错
 	minPositionSizeGeneral = 12.0
@@ -28,8 +30,75 @@ const (
 	// 在保持高杠杆抗噪性的前提下略微放松：允许止损靠近一些，以便更积极锁定利润
 	minStopDistancePctFloor  = 0.18 // 至少 0.18%
 	minStopDistanceATRFactor = 0.35 // 至少保留约 35% 的 5m ATR 作为缓冲
+
+	// 止损距离不得超过 (1 - liquidationBuffer) × 强平距离，避免止损落在强平价之外或贴近强平价
+	liquidationBuffer = 0.2
+
+	// 开仓止损的默认 ATR 周期，以及止损过近时的默认处理方式
+	defaultStopATRTimeframe = "15m"
+	tightStopWiden          = "widen"  // 自动放宽到 ATR 倍数距离
+	tightStopReject         = "reject" // 直接拒绝开仓
+
+	// 自动设置 / 重算止盈时在目标 RR 之上保留的余量，避免浮点误差使 RR 落在 min_rr 门槛之下
+	takeProfitRRMargin = 1.02
 )
 
+// stopATRTimeframes 止损 ATR 可选周期；配置周期缺少数据时按此顺序回退
+var stopATRTimeframes = []string{"15m", "5m", "1h", "30m"}
+
+// normalizeATRTimeframe 归一化 ATR 周期配置，兼容 "ATR14_15m" 与 "15m" 两种写法
+func normalizeATRTimeframe(tf string) string {
+	tf = strings.TrimPrefix(strings.TrimSpace(tf), "ATR14_")
+	if tf == "" {
+		return defaultStopATRTimeframe
+	}
+	return tf
+}
+
+// atrForTimeframe 返回指定周期的 ATR14，未知周期或无数据时返回 0
+func atrForTimeframe(md *MarketData, tf string) float64 {
+	switch tf {
+	case "5m":
+		return md.ATR14_5m
+	case "15m":
+		return md.ATR14_15m
+	case "30m":
+		return md.ATR14_30m
+	case "1h":
+		return md.ATR14_1h
+	}
+	return 0
+}
+
+// stopATR 返回配置周期的 ATR 以及实际使用的周期；该周期无数据（如回测只有 30m/1h）时按 stopATRTimeframes 回退
+func stopATR(md *MarketData, tf string) (float64, string) {
+	if md == nil {
+		return 0, ""
+	}
+	tf = normalizeATRTimeframe(tf)
+	if atr := atrForTimeframe(md, tf); atr > 0 {
+		return atr, tf
+	}
+	for _, fb := range stopATRTimeframes {
+		if atr := atrForTimeframe(md, fb); atr > 0 {
+			return atr, fb
+		}
+	}
+	return 0, ""
+}
+
+// takeProfitForRR 按目标风险回报比计算止盈价（止损在入场价哪一侧决定方向），目标 RR 额外乘以 takeProfitRRMargin。
+// fundingPct 为预期资金费（占名义价值 %），与 estimateTradeRisk 口径一致：(收益 - 资金费) / (风险 + 资金费) ≥ RR
+func takeProfitForRR(entry, stop, rr, fundingPct float64) float64 {
+	dir := 1.0
+	if stop > entry {
+		dir = -1.0
+	}
+	riskPct := math.Abs(entry-stop) / entry * 100
+	rewardPct := rr*takeProfitRRMargin*(riskPct+fundingPct) + fundingPct
+	return entry + dir*entry*rewardPct/100
+}
+
 // getRiskConfig 获取当前策略的风险配置，包含动态参数
 func getRiskConfig() RiskConfig {
 	sm := GetStrategyManager()
@@ -47,343 +116,971 @@ func getRiskConfig() RiskConfig {
 	}
 }
 
-// ValidateDecisions 验证所有决策
-func ValidateDecisions(decisions []Decision, account AccountInfo, mdMap map[string]*MarketData) error {
-	var totalRiskPct float64
+// riskConfig 本轮上下文使用的风控配置：指定了策略（影子策略）时取该策略，否则为当前策略
+func (ctx *Context) riskConfig() RiskConfig {
+	if ctx != nil && ctx.Strategy != nil {
+		return ctx.Strategy.RiskParams
+	}
+	return getRiskConfig()
+}
+
+// riskState 本轮上下文使用的账户状态，未指定时为实盘全局组件
+func (ctx *Context) riskState() *RiskState {
+	if ctx != nil && ctx.Risk != nil {
+		return ctx.Risk
+	}
+	state := liveRiskState()
+	if ctx != nil {
+		state.HedgeMode = ctx.HedgeMode
+	}
+	return state
+}
+
+// ValidateDecisions 风控唯一入口：按指定风控配置和账户状态验证所有决策，按风控规则流水线逐条处理，
+// positions 为当前持仓，用于组合敞口类规则。实盘与影子策略走同一条流水线；
+// state 必须由调用方提供（含 HedgeMode，见 Context.riskState），否则单向模式的规则会误用于对冲模式
+func ValidateDecisions(riskCfg RiskConfig, state *RiskState, decisions []Decision, account AccountInfo, positions []PositionInfo, mdMap map[string]*MarketData) error {
+	if state == nil {
+		return fmt.Errorf("风控账户状态为空")
+	}
+	steps := buildRiskPipeline(riskCfg)
+	rc := &RiskRuleContext{Account: account, Positions: positions, MDMap: mdMap, Config: riskCfg, State: state}
+
 	for i := range decisions {
-		if err := validateDecision(&decisions[i], account, mdMap, &totalRiskPct); err != nil {
+		d := &decisions[i]
+		results, err := runRiskPipeline(steps, d, rc)
+		if err != nil {
 			return fmt.Errorf("决策 #%d 验证失败: %w", i+1, err)
 		}
+		if summary := formatRiskResults(results); summary != "" {
+			log.Printf("🛡️ [Risk Pipeline] %s %s -> %s", d.Symbol, d.Action, summary)
+		}
 	}
 	return nil
 }
 
-// validateDecision 验证单个决策的有效性
-func validateDecision(d *Decision, account AccountInfo, mdMap map[string]*MarketData, totalRiskPct *float64) error {
-	accountEquity := account.TotalEquity
-	available := account.AvailableBalance
-
-	// 兼容模型可能输出的少量别名 / 不支持的 action，做宽松处理
-	switch d.Action {
-	case "increase_position":
-		// 将 increase_position 视为在当前方向上追加仓位，这里统一按 open_long 处理
-		// （当前策略几乎只做多，如需做空加仓应在 prompt 中明确使用 open_short）
-		log.Printf("⚠️ [Action Fallback] %s 使用未支持的 action increase_position，自动按 open_long 处理", d.Symbol)
-		d.Action = "open_long"
-	case "limit_order", "limit_long", "limit_short":
-		// 暂不支持真实挂限价单，避免与当前市价执行逻辑冲突：直接忽略为观望
-		log.Printf("⚠️ [Action Reject] %s 使用未支持的限价类 action=%s，已忽略（视为 wait）", d.Symbol, d.Action)
-		d.Action = "wait"
-	}
+// tradeRiskEstimate 开仓决策的风险估算结果
+type tradeRiskEstimate struct {
+	EntryPrice      float64
+	RiskPercent     float64 // 入场到止损的价格距离（%）
+	RewardPercent   float64 // 入场到止盈的价格距离（%）
+	RiskRewardRatio float64
+	RiskUsd         float64 // 按当前仓位估算的止损亏损（USDT）
+	RiskPctOfEquity float64
+	FundingCostPct  float64 // 预期持仓期内需支付的资金费（占名义价值 %），已从风险回报比中扣除
+}
 
-	// 验证action
-	validActions := map[string]bool{
-		"open_long":          true,
-		"open_short":         true,
-		"close_long":         true,
-		"close_short":        true,
-		"update_stop_loss":   true,
-		"update_take_profit": true,
-		"partial_close":      true,
-		"hold":               true,
-		"wait":               true,
-	}
+// estimateTradeRisk 根据当前市价（缺失时回退到止损/止盈区间插值）估算开仓决策的风险
+func estimateTradeRisk(d *Decision, rc *RiskRuleContext) (tradeRiskEstimate, error) {
+	var est tradeRiskEstimate
 
-	if !validActions[d.Action] {
-		// 不再让单个未知 action 让整批决策失败：记录日志并将其视为观望
-		log.Printf("⚠️ [Action Reject] %s 不支持的action=%s，已自动忽略（视为 wait）", d.Symbol, d.Action)
-		d.Action = "wait"
-		return nil
+	// ===== 计算近似入场价：优先使用当前市价，其次退回到区间内插值 =====
+	var md *MarketData
+	if rc.MDMap != nil {
+		md = rc.MDMap[d.Symbol]
+		if md != nil && md.CurrentPrice > 0 {
+			est.EntryPrice = md.CurrentPrice
+		}
 	}
-
-	// 开仓操作必须提供完整参数
-	if d.Action == "open_long" || d.Action == "open_short" {
-		// 判断是否为 Altcoin（非 BTC/ETH），用于后续专属风险控制
-		isAlt := d.Symbol != "BTCUSDT" && d.Symbol != "ETHUSDT"
-		if accountEquity <= 0 {
-			return fmt.Errorf("账户净值为0，无法进行开仓验证")
+	// 回退：仍然使用止损/止盈之间的 20% 位置作为近似
+	if est.EntryPrice <= 0 {
+		if d.Action == "open_long" {
+			est.EntryPrice = d.StopLoss + (d.TakeProfit-d.StopLoss)*0.2
+		} else {
+			est.EntryPrice = d.StopLoss - (d.StopLoss-d.TakeProfit)*0.2
 		}
+	}
+	if est.EntryPrice <= 0 {
+		return est, fmt.Errorf("无法估算入场价，用于风险评估失败")
+	}
 
-		// 从当前策略获取风险配置
-		riskCfg := getRiskConfig()
-		
-		// 固定杠杆模式：所有币种统一使用策略配置的杠杆
-		maxLeverage := riskCfg.FixedLeverage
-		if maxLeverage <= 0 {
-			maxLeverage = 15 // 安全默认值
+	// ===== 基于价格距离和仓位大小估算单笔风险 =====
+	entry := est.EntryPrice
+	if d.Action == "open_long" {
+		est.RiskPercent = (entry - d.StopLoss) / entry * 100
+		est.RewardPercent = (d.TakeProfit - entry) / entry * 100
+	} else {
+		est.RiskPercent = (d.StopLoss - entry) / entry * 100
+		est.RewardPercent = (entry - d.TakeProfit) / entry * 100
+	}
+	if est.RiskPercent > 0 {
+		// 需支付资金费的一方：预期资金费既减少收益也放大亏损
+		est.FundingCostPct = expectedFundingCostPct(decisionPositionSide(*d), md, rc.Config.FundingHoldHours, time.Now())
+		est.RiskRewardRatio = (est.RewardPercent - est.FundingCostPct) / (est.RiskPercent + est.FundingCostPct)
+		// 使用价格风险估算本次交易的资金风险（不依赖杠杆，名义价值 * 价格变动百分比）
+		est.RiskUsd = d.PositionSizeUSD * math.Abs(est.RiskPercent) / 100.0
+		if rc.Account.TotalEquity > 0 {
+			est.RiskPctOfEquity = est.RiskUsd / rc.Account.TotalEquity
 		}
+	}
+	return est, nil
+}
 
-		// 无论模型给出多少杠杆，这里都强制覆盖为策略配置的杠杆，确保实盘和风控一致
-		if d.Leverage != maxLeverage {
-			log.Printf("⚠️ [Leverage Force] %s 强制使用策略杠杆 %dx (模型提出 %dx 已被覆盖)", d.Symbol, maxLeverage, d.Leverage)
-			d.Leverage = maxLeverage
-		}
+func init() {
+	openActions := []string{"open_long", "open_short"}
 
-		maxPositionValue := accountEquity * float64(maxLeverage)
+	// action_alias: 兼容模型可能输出的少量别名 / 不支持的 action，做宽松处理
+	RegisterRiskRule(RiskRule{
+		Name:        "action_alias",
+		Description: "归一化 action 别名，未支持的 action 改写为 wait",
+		Apply: func(d *Decision, rc *RiskRuleContext) (RiskOutcome, string) {
+			switch d.Action {
+			case "increase_position":
+				// 将 increase_position 视为在当前方向上追加仓位，这里统一按 open_long 处理
+				// （当前策略几乎只做多，如需做空加仓应在 prompt 中明确使用 open_short）
+				log.Printf("⚠️ [Action Fallback] %s 使用未支持的 action increase_position，自动按 open_long 处理", d.Symbol)
+				d.Action = "open_long"
+				return RiskRewrite, "increase_position -> open_long"
+			case "limit_order", "limit_long", "limit_short":
+				// 暂不支持真实挂限价单，避免与当前市价执行逻辑冲突：直接忽略为观望
+				log.Printf("⚠️ [Action Reject] %s 使用未支持的限价类 action=%s，已忽略（视为 wait）", d.Symbol, d.Action)
+				orig := d.Action
+				d.Action = "wait"
+				return RiskRewrite, orig + " -> wait"
+			}
 
-		// 如果 position_size_usd 未给出，但提供了 position_percent，则根据相对比例计算
-		if d.PositionSizeUSD <= 0 && d.PositionPercent > 0 {
-			pct := d.PositionPercent
-			if pct > 1 {
-				// 若大于 1，按 0–100 视为百分比
-				pct = pct / 100.0
+			validActions := map[string]bool{
+				"open_long":          true,
+				"open_short":         true,
+				"close_long":         true,
+				"close_short":        true,
+				"update_stop_loss":   true,
+				"update_take_profit": true,
+				"partial_close":      true,
+				"hold":               true,
+				"wait":               true,
 			}
-			if pct <= 0 || pct > 1 {
-				return fmt.Errorf("position_percent 非法: %.4f，应在 (0,100] 或 (0,1]", d.PositionPercent)
+			if !validActions[d.Action] {
+				// 不再让单个未知 action 让整批决策失败：记录日志并将其视为观望
+				log.Printf("⚠️ [Action Reject] %s 不支持的action=%s，已自动忽略（视为 wait）", d.Symbol, d.Action)
+				orig := d.Action
+				d.Action = "wait"
+				return RiskRewrite, fmt.Sprintf("不支持的 action=%s -> wait", orig)
 			}
+			return RiskApprove, ""
+		},
+	})
 
-			if available <= 0 {
-				return fmt.Errorf("账户可用余额不足，无法根据 position_percent 计算仓位")
+	// symbol_universe: 只允许对当前策略交易对中已完成行情预热的交易对开仓（见 universe.go），已移出策略的交易对只能平仓 / 管理
+	RegisterRiskRule(RiskRule{
+		Name:        "symbol_universe",
+		Description: "禁止对不在当前策略交易对中的币种开仓",
+		Actions:     openActions,
+		Apply: func(d *Decision, rc *RiskRuleContext) (RiskOutcome, string) {
+			u := GetSymbolUniverse()
+			if u == nil || u.IsTradable(d.Symbol) {
+				return RiskApprove, ""
 			}
+			log.Printf("⚠️ [Universe] %s 不在当前策略交易对中，忽略 %s", d.Symbol, d.Action)
+			d.Action = "wait"
+			return RiskRewrite, "不在当前策略交易对中"
+		},
+	})
 
-			// 以可用余额为基础，预留一部分缓冲
-			marginBudget := available * safetyReserveFactor * pct
-			if marginBudget <= 0 {
-				return fmt.Errorf("根据 position_percent 计算得到的保证金无效: %.2f", marginBudget)
+	// leverage_force: 固定杠杆模式，无论模型给出多少杠杆都强制覆盖为策略配置的杠杆
+	RegisterRiskRule(RiskRule{
+		Name:        "leverage_force",
+		Description: "强制使用杠杆管理器给出的杠杆（策略固定杠杆 / 单币种设置 / 交易所上限）",
+		Actions:     openActions,
+		Apply: func(d *Decision, rc *RiskRuleContext) (RiskOutcome, string) {
+			if rc.Account.TotalEquity <= 0 {
+				return RiskReject, "账户净值为0，无法进行开仓验证"
 			}
-			d.PositionSizeUSD = marginBudget * float64(d.Leverage)
-			log.Printf("ℹ️ [PositionPercent] %s 使用 position_percent=%.2f 计算得到名义仓位: %.2f USDT (保证金≈%.2f, 杠杆=%dx)",
-				d.Symbol, d.PositionPercent, d.PositionSizeUSD, marginBudget, d.Leverage)
-		}
+			strategyLev := rc.Config.FixedLeverage
+			if strategyLev <= 0 {
+				strategyLev = int(rc.Param("default_leverage", 0)) // 为 0 时使用层级默认值
+			}
+			res := GetLeverageManager().Resolve(d.Symbol, strategyLev)
+			if d.Leverage != res.Leverage {
+				log.Printf("⚠️ [Leverage Force] %s 强制使用杠杆 %dx (%s，模型提出 %dx 已被覆盖)", d.Symbol, res.Leverage, res.Source, d.Leverage)
+				orig := d.Leverage
+				d.Leverage = res.Leverage
+				return RiskRewrite, fmt.Sprintf("杠杆 %dx -> %dx (%s)", orig, res.Leverage, res.Source)
+			}
+			return RiskApprove, ""
+		},
+	})
 
-		if d.PositionSizeUSD <= 0 {
-			return fmt.Errorf("仓位大小必须大于0: %.2f", d.PositionSizeUSD)
-		}
+	// position_sizing: 策略配置了后端仓位模式时，由后端按风险比例 / 波动率 / Kelly 计算仓位，覆盖 AI 给出的仓位（见 sizing.go）
+	RegisterRiskRule(RiskRule{
+		Name:        "position_sizing",
+		Description: "按策略 sizing 模式由后端计算仓位",
+		Actions:     openActions,
+		Apply: func(d *Decision, rc *RiskRuleContext) (RiskOutcome, string) {
+			res, ok, err := computeBackendSize(d, rc.MDMap[d.Symbol], rc.Account.TotalEquity, rc.Config, rc.State.Storage)
+			if err != nil {
+				if d.PositionSizeUSD > 0 || d.PositionPercent > 0 {
+					log.Printf("⚠️ [Sizing] %s %v，沿用 AI 给出的仓位", d.Symbol, err)
+					return RiskApprove, ""
+				}
+				log.Printf("⚠️ [Sizing] %s %v，忽略 %s", d.Symbol, err, d.Action)
+				d.Action = "wait"
+				return RiskRewrite, err.Error()
+			}
+			if !ok {
+				return RiskApprove, ""
+			}
+			orig := d.PositionSizeUSD
+			d.PositionSizeUSD = res.SizeUSD
+			log.Printf("ℹ️ [Sizing] %s 后端仓位 %.2f USDT (AI 给出 %.2f): %s", d.Symbol, res.SizeUSD, orig, res.Detail)
+			return RiskResize, fmt.Sprintf("后端仓位 %.2f -> %.2f USDT，%s", orig, res.SizeUSD, res.Detail)
+		},
+	})
 
-		// 验证最小开仓金额 (仍然仅做警告，不强制拦截)
-		if d.PositionSizeUSD < minPositionSizeGeneral {
-			log.Printf("⚠️ [Warning] 开仓金额过小(%.2f USDT)，建议≥%.2f USDT，但允许执行", d.PositionSizeUSD, minPositionSizeGeneral)
-		}
+	// position_size: 由 position_percent 推导名义仓位，并校验仓位大小
+	RegisterRiskRule(RiskRule{
+		Name:        "position_size",
+		Description: "根据 position_percent 计算仓位并校验最小名义金额",
+		Actions:     openActions,
+		Apply: func(d *Decision, rc *RiskRuleContext) (RiskOutcome, string) {
+			if d.Leverage <= 0 {
+				// leverage_force 被禁用且模型未给出杠杆时无法换算保证金
+				return RiskReject, fmt.Sprintf("杠杆必须大于0: %d", d.Leverage)
+			}
+			outcome, reason := RiskApprove, ""
+			available := rc.Account.AvailableBalance
 
-		// 先按全局净值 + 杠杆上限做一次硬上限，并采用自动缩小仓位的 Fallback，而不是直接拒绝
-		tolerance := maxPositionValue * 0.01 // 1% 容差
-		if d.PositionSizeUSD > maxPositionValue+tolerance {
-			capped := maxPositionValue
-			log.Printf("⚠️ [Size Fallback] %s 仓位名义金额过大(%.2f > %.2f)，自动下调为上限 %.2f",
-				d.Symbol, d.PositionSizeUSD, maxPositionValue, capped)
-			d.PositionSizeUSD = capped
-		}
+			// 如果 position_size_usd 未给出，但提供了 position_percent，则根据相对比例计算
+			if d.PositionSizeUSD <= 0 && d.PositionPercent > 0 {
+				pct := d.PositionPercent
+				if pct > 1 {
+					// 若大于 1，按 0–100 视为百分比
+					pct = pct / 100.0
+				}
+				if pct <= 0 || pct > 1 {
+					return RiskReject, fmt.Sprintf("position_percent 非法: %.4f，应在 (0,100] 或 (0,1]", d.PositionPercent)
+				}
+				if available <= 0 {
+					return RiskReject, "账户可用余额不足，无法根据 position_percent 计算仓位"
+				}
 
-			// 再按单笔最大保证金占用控制，避免一次性吃掉全部可用余额
-			if available > 0 {
-				marginRequired := d.PositionSizeUSD / float64(d.Leverage)
-				maxMarginPerTrade := available * riskCfg.MaxMarginUsage * safetyReserveFactor
-				if isAlt {
-					maxMarginPerTrade = available * maxAltMarginUsagePerTrade * safetyReserveFactor
+				// 以可用余额为基础，预留一部分缓冲
+				marginBudget := available * rc.Param("safety_reserve", safetyReserveFactor) * pct
+				if marginBudget <= 0 {
+					return RiskReject, fmt.Sprintf("根据 position_percent 计算得到的保证金无效: %.2f", marginBudget)
 				}
-				if marginRequired > maxMarginPerTrade {
-				if maxMarginPerTrade <= 0 {
-					return fmt.Errorf("账户可用保证金不足以支撑该仓位: 需要 %.2f, 可用 %.2f", marginRequired, available)
+				d.PositionSizeUSD = marginBudget * float64(d.Leverage)
+				log.Printf("ℹ️ [PositionPercent] %s 使用 position_percent=%.2f 计算得到名义仓位: %.2f USDT (保证金≈%.2f, 杠杆=%dx)",
+					d.Symbol, d.PositionPercent, d.PositionSizeUSD, marginBudget, d.Leverage)
+				outcome, reason = RiskResize, fmt.Sprintf("position_percent=%.2f -> %.2f USDT", d.PositionPercent, d.PositionSizeUSD)
+			}
+
+			if d.PositionSizeUSD <= 0 {
+				return RiskReject, fmt.Sprintf("仓位大小必须大于0: %.2f", d.PositionSizeUSD)
+			}
+
+			// 验证最小开仓金额 (仍然仅做警告，不强制拦截)
+			if minSize := rc.Param("min_size_usd", minPositionSizeGeneral); d.PositionSizeUSD < minSize {
+				log.Printf("⚠️ [Warning] 开仓金额过小(%.2f USDT)，建议≥%.2f USDT，但允许执行", d.PositionSizeUSD, minSize)
+			}
+			return outcome, reason
+		},
+	})
+
+	// confidence_scaling: 开启 conviction_scaling 时按校准后的 confidence（而非 AI 声明值）缩放仓位（见 calibration.go）
+	RegisterRiskRule(RiskRule{
+		Name:        "confidence_scaling",
+		Description: "按校准后的置信度缩放仓位",
+		Actions:     openActions,
+		Apply: func(d *Decision, rc *RiskRuleContext) (RiskOutcome, string) {
+			sc := rc.Config.Sizing
+			if sc == nil || !sc.ConvictionScaling || d.Confidence <= 0 || d.PositionSizeUSD <= 0 {
+				return RiskApprove, ""
+			}
+			floor := rc.Param("floor", convictionFloor)
+			calibrated := calibratedConfidence(rc.State.Calibrator, d.Confidence)
+			factor := floor + (1-floor)*calibrated
+			if factor >= 1 {
+				return RiskApprove, ""
+			}
+			orig := d.PositionSizeUSD
+			d.PositionSizeUSD *= factor
+			detail := formatConfidence(d.Confidence, calibrated)
+			log.Printf("ℹ️ [Confidence] %s %s → 仓位 ×%.2f: %.2f -> %.2f USDT", d.Symbol, detail, factor, orig, d.PositionSizeUSD)
+			return RiskResize, fmt.Sprintf("%s → ×%.2f，%.2f -> %.2f USDT", detail, factor, orig, d.PositionSizeUSD)
+		},
+	})
+
+	// circuit_breaker:账户级熔断生效时禁止新开仓或按系数缩仓（见 circuit_breaker.go）
+	RegisterRiskRule(RiskRule{
+		Name:        "circuit_breaker",
+		Description: "熔断生效时禁止开仓 / 缩仓",
+		Actions:     openActions,
+		Apply: func(d *Decision, rc *RiskRuleContext) (RiskOutcome, string) {
+			cb := rc.State.Breaker
+			if cb == nil {
+				return RiskApprove, ""
+			}
+			r := cb.Restrictions()
+			if r.BlockOpens {
+				log.Printf("⚠️ [Circuit Breaker] 熔断生效中，忽略新开仓 %s %s (size=%.2f)", d.Symbol, d.Action, d.PositionSizeUSD)
+				d.Action = "wait"
+				return RiskRewrite, "熔断生效: " + strings.Join(r.Reasons, "; ")
+			}
+			if r.SizeFactor > 0 && r.SizeFactor < 1 {
+				orig := d.PositionSizeUSD
+				d.PositionSizeUSD *= r.SizeFactor
+				log.Printf("⚠️ [Circuit Breaker] %s 熔断缩仓 %.2f -> %.2f USDT", d.Symbol, orig, d.PositionSizeUSD)
+				return RiskResize, fmt.Sprintf("熔断缩仓 ×%.2f: %s", r.SizeFactor, strings.Join(r.Reasons, "; "))
+			}
+			return RiskApprove, ""
+		},
+	})
+
+	// max_open_positions: 持仓数（含本批次已放行的新开仓）达到上限时忽略新币种开仓，对已有持仓加仓不受限
+	RegisterRiskRule(RiskRule{
+		Name:        "max_open_positions",
+		Description: "同时持仓数不超过 max_open_positions",
+		Actions:     openActions,
+		Apply: func(d *Decision, rc *RiskRuleContext) (RiskOutcome, string) {
+			limit := int(rc.Param("max_positions", float64(rc.Config.MaxOpenPositions)))
+			if limit <= 0 {
+				return RiskApprove, ""
+			}
+			open := make(map[string]bool)
+			for _, p := range rc.Positions {
+				open[positionKey(p.Symbol, p.Side)] = true
+			}
+			for _, leg := range rc.PendingLegs {
+				open[positionKey(leg.Symbol, leg.Side)] = true
+			}
+			if open[positionKey(d.Symbol, decisionPositionSide(*d))] || len(open) < limit {
+				return RiskApprove, ""
+			}
+			log.Printf("⚠️ [Entry Guard] 持仓数 %d 已达上限 %d，忽略新开仓 %s %s", len(open), limit, d.Symbol, d.Action)
+			d.Action = "wait"
+			return RiskRewrite, fmt.Sprintf("持仓数 %d 已达上限 %d", len(open), limit)
+		},
+	})
+
+	// symbol_cooldown: 同币种止损出局后的冷却期内禁止再次开仓（见 entry_guard.go）
+	RegisterRiskRule(RiskRule{
+		Name:        "symbol_cooldown",
+		Description: "止损出局后冷却期内禁止同币种开仓",
+		Actions:     openActions,
+		Apply: func(d *Decision, rc *RiskRuleContext) (RiskOutcome, string) {
+			g := rc.State.Guard
+			if g == nil {
+				return RiskApprove, ""
+			}
+			cooldown := time.Duration(rc.Param("minutes", float64(rc.Config.SymbolCooldownMinutes))) * time.Minute
+			left := g.CooldownRemaining(d.Symbol, cooldown, time.Now())
+			if left <= 0 {
+				return RiskApprove, ""
+			}
+			log.Printf("⚠️ [Entry Guard] %s 止损后冷却中（剩余 %s），忽略 %s", d.Symbol, formatRemaining(left), d.Action)
+			d.Action = "wait"
+			return RiskRewrite, fmt.Sprintf("%s 止损后冷却中，剩余 %s", d.Symbol, formatRemaining(left))
+		},
+	})
+
+	// reentry_limit: 单币种每日开仓次数上限（本批次已放行的同币种开仓也计入）
+	RegisterRiskRule(RiskRule{
+		Name:        "reentry_limit",
+		Description: "单币种每日开仓次数不超过 max_opens_per_symbol_per_day",
+		Actions:     openActions,
+		Apply: func(d *Decision, rc *RiskRuleContext) (RiskOutcome, string) {
+			g := rc.State.Guard
+			limit := int(rc.Param("max_opens", float64(rc.Config.MaxOpensPerSymbolPerDay)))
+			if g == nil || limit <= 0 {
+				return RiskApprove, ""
+			}
+			n := g.OpensToday(d.Symbol, time.Now())
+			for _, leg := range rc.PendingLegs {
+				if leg.Symbol == d.Symbol {
+					n++
 				}
-				newPosSize := maxMarginPerTrade * float64(d.Leverage)
-				log.Printf("⚠️ [Margin Fallback] %s 需要保证金 %.2f 超过单笔上限 %.2f，自动缩小仓位到 %.2f USDT",
-					d.Symbol, marginRequired, maxMarginPerTrade, newPosSize)
-				d.PositionSizeUSD = newPosSize
 			}
-		}
+			if n < limit {
+				return RiskApprove, ""
+			}
+			log.Printf("⚠️ [Entry Guard] %s 今日已开仓 %d 次（上限 %d），忽略 %s", d.Symbol, n, limit, d.Action)
+			d.Action = "wait"
+			return RiskRewrite, fmt.Sprintf("%s 今日已开仓 %d 次，达到上限 %d", d.Symbol, n, limit)
+		},
+	})
 
-		if d.StopLoss <= 0 || d.TakeProfit <= 0 {
-			return fmt.Errorf("止损和止盈必须大于0")
-		}
+	// no_flip: 持有反向仓位，或 N 分钟内刚开 / 平过反向仓位时，禁止反手开仓
+	RegisterRiskRule(RiskRule{
+		Name:        "no_flip",
+		Description: "no_flip_minutes 内禁止同币种反手",
+		Actions:     openActions,
+		Apply: func(d *Decision, rc *RiskRuleContext) (RiskOutcome, string) {
+			minutes := rc.Param("minutes", float64(rc.Config.NoFlipMinutes))
+			if minutes <= 0 {
+				return RiskApprove, ""
+			}
+			side := decisionPositionSide(*d)
+			opp := oppositeSide(side)
+			// 单向模式下开反向仓会直接反手；对冲模式允许两条腿并存，只受下面的时间窗口限制
+			if p, _ := findPosition(rc.Positions, d.Symbol, opp); p != nil && !rc.State.HedgeMode {
+				log.Printf("⚠️ [Entry Guard] %s 持有 %s 仓位，禁止反手 %s", d.Symbol, opp, d.Action)
+				d.Action = "wait"
+				return RiskRewrite, fmt.Sprintf("%s 持有反向 %s 仓位，禁止反手", d.Symbol, opp)
+			}
+			g := rc.State.Guard
+			if g == nil {
+				return RiskApprove, ""
+			}
+			left := g.FlipRemaining(d.Symbol, side, time.Duration(minutes)*time.Minute, time.Now())
+			if left <= 0 {
+				return RiskApprove, ""
+			}
+			log.Printf("⚠️ [Entry Guard] %s 近期有 %s 仓位（剩余 %s），禁止反手 %s", d.Symbol, opp, formatRemaining(left), d.Action)
+			d.Action = "wait"
+			return RiskRewrite, fmt.Sprintf("%s 反手冷却中，剩余 %s", d.Symbol, formatRemaining(left))
+		},
+	})
 
-		// 验证止损止盈的合理性
-		if d.Action == "open_long" {
-			if d.StopLoss >= d.TakeProfit {
-				return fmt.Errorf("做多时止损价必须小于止盈价")
+	// funding_rate: 资金费率过高时，对需要付费的一方缩仓或禁止开仓（见 funding.go）
+	RegisterRiskRule(RiskRule{
+		Name:        "funding_rate",
+		Description: "付费方向资金费率过高时缩仓 / 禁止开仓",
+		Actions:     openActions,
+		Apply: func(d *Decision, rc *RiskRuleContext) (RiskOutcome, string) {
+			md := rc.MDMap[d.Symbol]
+			if md == nil || !fundingPaysSide(decisionPositionSide(*d), md.FundingRate) {
+				return RiskApprove, ""
 			}
-		} else {
-			if d.StopLoss <= d.TakeProfit {
-				return fmt.Errorf("做空时止损价必须大于止盈价")
+			rate := math.Abs(md.FundingRate)
+			if block := rc.Param("block_rate", rc.Config.FundingBlockRate); block > 0 && rate >= block {
+				log.Printf("⚠️ [Funding Guard] %s 资金费率 %.4f%% 超过禁止阈值 %.4f%%，忽略 %s", d.Symbol, md.FundingRate*100, block*100, d.Action)
+				d.Action = "wait"
+				return RiskRewrite, fmt.Sprintf("资金费率 %.4f%% ≥ %.4f%%，付费方向禁止开仓", md.FundingRate*100, block*100)
 			}
-		}
+			if shrink := rc.Param("shrink_rate", rc.Config.FundingShrinkRate); shrink > 0 && rate >= shrink {
+				factor := rc.Param("size_factor", 0.5)
+				orig := d.PositionSizeUSD
+				d.PositionSizeUSD *= factor
+				log.Printf("⚠️ [Funding Guard] %s 资金费率 %.4f%% 偏高，仓位 %.2f -> %.2f USDT", d.Symbol, md.FundingRate*100, orig, d.PositionSizeUSD)
+				return RiskResize, fmt.Sprintf("资金费率 %.4f%% ≥ %.4f%%，仓位 ×%.2f", md.FundingRate*100, shrink*100, factor)
+			}
+			return RiskApprove, ""
+		},
+	})
 
-		// ===== 计算近似入场价：优先使用当前市价，其次退回到区间内插值 =====
-		var entryPrice float64
-		if mdMap != nil {
-			if md, ok := mdMap[d.Symbol]; ok && md != nil && md.CurrentPrice > 0 {
-				entryPrice = md.CurrentPrice
+	// funding_window: 距下次资金费结算不足 N 分钟时，付费方向不开新仓（刚开仓就要支付一次资金费）
+	RegisterRiskRule(RiskRule{
+		Name:        "funding_window",
+		Description: "资金费结算前 funding_avoid_minutes 内付费方向不开仓",
+		Actions:     openActions,
+		Apply: func(d *Decision, rc *RiskRuleContext) (RiskOutcome, string) {
+			minutes := rc.Param("minutes", float64(rc.Config.FundingAvoidMinutes))
+			md := rc.MDMap[d.Symbol]
+			if minutes <= 0 || md == nil || !fundingPaysSide(decisionPositionSide(*d), md.FundingRate) {
+				return RiskApprove, ""
 			}
-		}
-		// 回退：仍然使用止损/止盈之间的 20% 位置作为近似
-		if entryPrice <= 0 {
-			if d.Action == "open_long" {
-				entryPrice = d.StopLoss + (d.TakeProfit-d.StopLoss)*0.2
-			} else {
-				entryPrice = d.StopLoss - (d.StopLoss-d.TakeProfit)*0.2
+			left, ok := untilNextFunding(md, time.Now())
+			if !ok || left > time.Duration(minutes)*time.Minute {
+				return RiskApprove, ""
 			}
-		}
-		if entryPrice <= 0 {
-			return fmt.Errorf("无法估算入场价，用于风险评估失败")
-		}
+			log.Printf("⚠️ [Funding Guard] %s 距资金费结算仅剩 %s，付费方向暂不开仓 %s", d.Symbol, formatRemaining(left), d.Action)
+			d.Action = "wait"
+			return RiskRewrite, fmt.Sprintf("距资金费结算 %s（费率 %.4f%%），付费方向暂不开仓", formatRemaining(left), md.FundingRate*100)
+		},
+	})
 
-		// ===== 基于价格距离和仓位大小估算单笔风险 =====
-		var riskPercent, rewardPercent, riskRewardRatio float64
-		if d.Action == "open_long" {
-			riskPercent = (entryPrice - d.StopLoss) / entryPrice * 100
-			rewardPercent = (d.TakeProfit - entryPrice) / entryPrice * 100
-			if riskPercent > 0 {
-				riskRewardRatio = rewardPercent / riskPercent
+	// max_notional: 按全局净值 + 杠杆上限做硬上限，采用自动缩小仓位的 Fallback，而不是直接拒绝
+	RegisterRiskRule(RiskRule{
+		Name:        "max_notional",
+		Description: "名义仓位不超过 净值 × 杠杆",
+		Actions:     openActions,
+		Apply: func(d *Decision, rc *RiskRuleContext) (RiskOutcome, string) {
+			maxPositionValue := rc.Account.TotalEquity * float64(d.Leverage)
+			tolerance := maxPositionValue * rc.Param("tolerance", 0.01) // 默认 1% 容差
+			if d.PositionSizeUSD > maxPositionValue+tolerance {
+				log.Printf("⚠️ [Size Fallback] %s 仓位名义金额过大(%.2f > %.2f)，自动下调为上限 %.2f",
+					d.Symbol, d.PositionSizeUSD, maxPositionValue, maxPositionValue)
+				orig := d.PositionSizeUSD
+				d.PositionSizeUSD = maxPositionValue
+				return RiskResize, fmt.Sprintf("名义仓位 %.2f 超过上限，下调至 %.2f", orig, maxPositionValue)
 			}
-		} else {
-			riskPercent = (d.StopLoss - entryPrice) / entryPrice * 100
-			rewardPercent = (entryPrice - d.TakeProfit) / entryPrice * 100
-			if riskPercent > 0 {
-				riskRewardRatio = rewardPercent / riskPercent
+			return RiskApprove, ""
+		},
+	})
+
+	// margin_cap: 按单笔最大保证金占用控制，避免一次性吃掉全部可用余额
+	RegisterRiskRule(RiskRule{
+		Name:        "margin_cap",
+		Description: "单笔保证金占用上限（Altcoin 更保守）",
+		Actions:     openActions,
+		Apply: func(d *Decision, rc *RiskRuleContext) (RiskOutcome, string) {
+			available := rc.Account.AvailableBalance
+			if available <= 0 {
+				return RiskApprove, ""
 			}
-		}
+			reserve := rc.Param("safety_reserve", safetyReserveFactor)
+			marginRequired := d.PositionSizeUSD / float64(d.Leverage)
+			maxMarginPerTrade := available * rc.Param("max_margin_usage", rc.Config.MaxMarginUsage) * reserve
+			if usage := GetAssetRegistry().Get(d.Symbol).MaxMarginUsage; usage > 0 {
+				maxMarginPerTrade = available * usage * reserve
+			} else if isAltSymbol(d.Symbol) {
+				maxMarginPerTrade = available * rc.Param("alt_max_margin_usage", maxAltMarginUsagePerTrade) * reserve
+			}
+			if marginRequired <= maxMarginPerTrade {
+				return RiskApprove, ""
+			}
+			if maxMarginPerTrade <= 0 {
+				return RiskReject, fmt.Sprintf("账户可用保证金不足以支撑该仓位: 需要 %.2f, 可用 %.2f", marginRequired, available)
+			}
+			newPosSize := maxMarginPerTrade * float64(d.Leverage)
+			log.Printf("⚠️ [Margin Fallback] %s 需要保证金 %.2f 超过单笔上限 %.2f，自动缩小仓位到 %.2f USDT",
+				d.Symbol, marginRequired, maxMarginPerTrade, newPosSize)
+			d.PositionSizeUSD = newPosSize
+			return RiskResize, fmt.Sprintf("保证金 %.2f 超过单笔上限 %.2f，仓位缩至 %.2f", marginRequired, maxMarginPerTrade, newPosSize)
+		},
+	})
 
-		// 使用价格风险估算本次交易的资金风险（不依赖杠杆，名义价值 * 价格变动百分比）
-		var estimatedRiskUsd, riskPctOfEquity float64
-		if riskPercent > 0 && accountEquity > 0 {
-			// 价格风险为负数说明 SL 在错误一侧
-			if riskPercent < 0 {
-				return fmt.Errorf("止损价格位置异常，导致风险为负: %.2f%%", riskPercent)
+	// atr_stop: 止损距离必须不小于 StopLossATRMultiple × ATR(StopATRTimeframe)。
+	// 过近时按 TightStopAction 放宽或拒绝；未给止损时按 ATR 自动补齐（未给止盈时按最小 RR 补齐）。
+	RegisterRiskRule(RiskRule{
+		Name:        "atr_stop",
+		Description: "止损距离不小于 ATR 倍数，过近时放宽或拒绝，缺失时自动设置",
+		Actions:     openActions,
+		Apply: func(d *Decision, rc *RiskRuleContext) (RiskOutcome, string) {
+			mult := rc.Param("atr_multiple", rc.Config.StopLossATRMultiple)
+			if mult <= 0 {
+				return RiskApprove, ""
+			}
+			md, ok := rc.MDMap[d.Symbol]
+			if !ok || md == nil || md.CurrentPrice <= 0 {
+				return RiskApprove, ""
+			}
+			atr, tf := stopATR(md, rc.Config.StopATRTimeframe)
+			if atr <= 0 {
+				return RiskApprove, ""
 			}
-			estimatedRiskUsd = d.PositionSizeUSD * math.Abs(riskPercent) / 100.0
-			riskPctOfEquity = estimatedRiskUsd / accountEquity
 
-			// 单笔风险上限：既限制百分比，也限制绝对金额（近似固定 risk_usd 风格）
-			maxRiskUsdByPct := accountEquity * riskCfg.MaxRiskPerTrade
-			maxRiskUsd := maxRiskUsdByPct
-			if maxRiskUsdHardPerTrade > 0 && maxRiskUsd > maxRiskUsdHardPerTrade {
-				maxRiskUsd = maxRiskUsdHardPerTrade
+			entry := md.CurrentPrice
+			minDist := atr * mult
+			funding := expectedFundingCostPct(decisionPositionSide(*d), md, rc.Config.FundingHoldHours, time.Now())
+			dir := 1.0 // 做多止损在下方，做空止损在上方
+			if d.Action == "open_short" {
+				dir = -1.0
 			}
 
-			if maxRiskUsd > 0 && estimatedRiskUsd > maxRiskUsd {
-				// 自动缩小仓位以符合单笔风险上限
-				newPos := maxRiskUsd * 100.0 / math.Abs(riskPercent)
-				log.Printf("⚠️ [Risk Fallback] %s 单笔风险 %.2f USDT 超过单笔上限 %.2f，自动缩小仓位到 %.2f USDT",
-					d.Symbol, estimatedRiskUsd, maxRiskUsd, newPos)
-				d.PositionSizeUSD = newPos
-				// 更新缩仓后的风险估算
-				estimatedRiskUsd = maxRiskUsd
-				riskPctOfEquity = estimatedRiskUsd / accountEquity
+			// 未给止损：按 ATR 倍数自动设置
+			if d.StopLoss <= 0 {
+				if rc.Param("auto_stop", 1) <= 0 || entry-dir*minDist <= 0 {
+					return RiskApprove, ""
+				}
+				d.StopLoss = entry - dir*minDist
+				reason := fmt.Sprintf("未提供止损，按 %.1f×ATR(%s) 自动设置为 %.4f", mult, tf, d.StopLoss)
+				if d.TakeProfit <= 0 && rc.Param("auto_take_profit", 1) > 0 {
+					rr := rc.Config.MinRiskRewardRatio
+					if rr <= 0 {
+						rr = 2.0
+					}
+					if tp := takeProfitForRR(entry, d.StopLoss, rr, funding); tp > 0 {
+						d.TakeProfit = tp
+						reason += fmt.Sprintf("，止盈按 %.1f:1 设置为 %.4f", rr, tp)
+					}
+				}
+				log.Printf("⚠️ [ATR Stop] %s %s", d.Symbol, reason)
+				return RiskRewrite, reason
 			}
 
-			// Altcoin 进一步收紧单笔风险上限，使其更偏向“小仓战术单”而非主仓
-			if isAlt && maxRiskUsdAltPerTrade > 0 && estimatedRiskUsd > maxRiskUsdAltPerTrade {
-				newPos := maxRiskUsdAltPerTrade * 100.0 / math.Abs(riskPercent)
-				log.Printf("⚠️ [Alt Risk Fallback] %s Altcoin 单笔风险 %.2f USDT 超过 Alt 上限 %.2f，自动缩小仓位到 %.2f USDT",
-					d.Symbol, estimatedRiskUsd, maxRiskUsdAltPerTrade, newPos)
-				d.PositionSizeUSD = newPos
-				estimatedRiskUsd = maxRiskUsdAltPerTrade
-				riskPctOfEquity = estimatedRiskUsd / accountEquity
+			dist := dir * (entry - d.StopLoss)
+			if dist <= 0 || dist >= minDist {
+				// 方向错误的止损交给 stop_sanity / min_rr 处理
+				return RiskApprove, ""
 			}
-		}
 
-		if totalRiskPct != nil && riskPctOfEquity > 0 {
-			currentTotal := *totalRiskPct
-			proposedTotal := currentTotal + riskPctOfEquity
-			if proposedTotal > riskCfg.MaxTotalRisk {
-				remaining := riskCfg.MaxTotalRisk - currentTotal
-				if remaining <= 0 {
-					return fmt.Errorf("所有新开仓风险之和已达到全局上限 %.2f%%，拒绝 %s", riskCfg.MaxTotalRisk*100, d.Symbol)
+			distPct, minPct := dist/entry*100, minDist/entry*100
+			if rc.Config.TightStopAction == tightStopReject {
+				log.Printf("⚠️ [ATR Stop Reject] %s 止损距现价 %.2f%% < %.1f×ATR(%s) = %.2f%%", d.Symbol, distPct, mult, tf, minPct)
+				return RiskReject, fmt.Sprintf("止损距现价 %.2f%% 过近，必须≥%.1f×ATR(%s)=%.2f%%", distPct, mult, tf, minPct)
+			}
+			orig := d.StopLoss
+			d.StopLoss = entry - dir*minDist
+			log.Printf("⚠️ [ATR Stop] %s 止损 %.4f 距现价 %.2f%% < %.1f×ATR(%s) = %.2f%%，放宽至 %.4f",
+				d.Symbol, orig, distPct, mult, tf, minPct, d.StopLoss)
+			reason := fmt.Sprintf("止损距现价 %.2f%% < %.1f×ATR(%s)=%.2f%%，%.4f -> %.4f", distPct, mult, tf, minPct, orig, d.StopLoss)
+			// 止损放宽后按原风险回报比同比例外移止盈，避免 RR 缩水被 min_rr 拒绝
+			if rr := dir * (d.TakeProfit - entry) / dist; d.TakeProfit > 0 && rr > 0 {
+				origTP := d.TakeProfit
+				if tp := takeProfitForRR(entry, d.StopLoss, rr, funding); tp > 0 {
+					d.TakeProfit = tp
+					reason += fmt.Sprintf("，止盈按原 %.2f:1 %.4f -> %.4f", rr, origTP, tp)
 				}
-				// 将本次仓位缩小到仅使用剩余风险预算
-				allowedRiskUsd := remaining * accountEquity
-				newPos := allowedRiskUsd * 100.0 / math.Abs(riskPercent)
-				log.Printf("⚠️ [Global Risk Fallback] %s 总风险将超出上限，调整本单风险从 %.2f USDT 降至 %.2f USDT，仓位从 %.2f USDT 降至 %.2f USDT",
-					d.Symbol, estimatedRiskUsd, allowedRiskUsd, d.PositionSizeUSD, newPos)
+			}
+			return RiskRewrite, reason
+		},
+	})
+
+	// stop_sanity: 开仓必须提供止损止盈，且方向合理
+	RegisterRiskRule(RiskRule{
+		Name:        "stop_sanity",
+		Description: "校验止损/止盈存在且位于正确一侧",
+		Actions:     openActions,
+		Apply: func(d *Decision, rc *RiskRuleContext) (RiskOutcome, string) {
+			if d.StopLoss <= 0 || d.TakeProfit <= 0 {
+				return RiskReject, "止损和止盈必须大于0"
+			}
+			if d.Action == "open_long" && d.StopLoss >= d.TakeProfit {
+				return RiskReject, "做多时止损价必须小于止盈价"
+			}
+			if d.Action == "open_short" && d.StopLoss <= d.TakeProfit {
+				return RiskReject, "做空时止损价必须大于止盈价"
+			}
+			return RiskApprove, ""
+		},
+	})
+
+	// liquidation_distance: 止损必须安全地位于预估强平价内侧；否则在保证金不变的前提下逐级降低杠杆（同时缩小名义仓位），
+	// 直到满足要求，降到 1x 仍不满足或参数 deleverage=0 时拒绝
+	RegisterRiskRule(RiskRule{
+		Name:        "liquidation_distance",
+		Description: "止损需位于预估强平价内侧并保留缓冲，否则降杠杆或拒绝",
+		Actions:     openActions,
+		Apply: func(d *Decision, rc *RiskRuleContext) (RiskOutcome, string) {
+			md, ok := rc.MDMap[d.Symbol]
+			if !ok || md == nil || md.CurrentPrice <= 0 || d.Leverage <= 0 {
+				return RiskApprove, ""
+			}
+			entry := md.CurrentPrice
+			buffer := rc.Param("buffer", liquidationBuffer)
+			c := checkLiquidationDistance(d, entry, d.Leverage, buffer)
+			if c.StopIsInside {
+				return RiskApprove, ""
+			}
+			if rc.Param("deleverage", 1) <= 0 {
+				log.Printf("⚠️ [Liq Reject] %s %dx %s", d.Symbol, d.Leverage, formatLiquidationCheck(c))
+				return RiskReject, fmt.Sprintf("止损不在强平价安全范围内 (%dx): %s", d.Leverage, formatLiquidationCheck(c))
+			}
+
+			margin := d.PositionSizeUSD / float64(d.Leverage)
+			for lev := d.Leverage - 1; lev >= 1; lev-- {
+				trial := *d
+				trial.Leverage = lev
+				trial.PositionSizeUSD = margin * float64(lev)
+				nc := checkLiquidationDistance(&trial, entry, lev, buffer)
+				if !nc.StopIsInside {
+					continue
+				}
+				if trial.PositionSizeUSD < minPositionSizeGeneral {
+					break
+				}
+				log.Printf("⚠️ [Liq Deleverage] %s %dx 时%s，降至 %dx，名义仓位 %.2f -> %.2f USDT",
+					d.Symbol, d.Leverage, formatLiquidationCheck(c), lev, d.PositionSizeUSD, trial.PositionSizeUSD)
+				reason := fmt.Sprintf("%dx 时%s，杠杆降至 %dx（%s）", d.Leverage, formatLiquidationCheck(c), lev, formatLiquidationCheck(nc))
+				d.Leverage = lev
+				d.PositionSizeUSD = trial.PositionSizeUSD
+				return RiskResize, reason
+			}
+			log.Printf("⚠️ [Liq Reject] %s 降杠杆后仍无法使止损位于强平价安全范围内: %s", d.Symbol, formatLiquidationCheck(c))
+			return RiskReject, fmt.Sprintf("止损距入场 %.2f%% 过远，降杠杆后仍无法位于强平价安全范围内", c.StopDistPct)
+		},
+	})
+
+	// trade_risk_cap: 单笔风险上限，既限制百分比，也限制绝对金额（近似固定 risk_usd 风格）
+	RegisterRiskRule(RiskRule{
+		Name:        "trade_risk_cap",
+		Description: "单笔止损亏损不超过 净值×MaxRiskPerTrade 与绝对上限 max_risk_usd",
+		Actions:     openActions,
+		Apply: func(d *Decision, rc *RiskRuleContext) (RiskOutcome, string) {
+			est, err := estimateTradeRisk(d, rc)
+			if err != nil {
+				return RiskReject, err.Error()
+			}
+			if est.RiskPercent <= 0 {
+				return RiskApprove, ""
+			}
+			maxRiskUsd := rc.Account.TotalEquity * rc.Config.MaxRiskPerTrade
+			if hard := rc.Param("max_risk_usd", maxRiskUsdHardPerTrade); hard > 0 && maxRiskUsd > hard {
+				maxRiskUsd = hard
+			}
+			if maxRiskUsd > 0 && est.RiskUsd > maxRiskUsd {
+				// 自动缩小仓位以符合单笔风险上限
+				newPos := maxRiskUsd * 100.0 / est.RiskPercent
+				log.Printf("⚠️ [Risk Fallback] %s 单笔风险 %.2f USDT 超过单笔上限 %.2f，自动缩小仓位到 %.2f USDT",
+					d.Symbol, est.RiskUsd, maxRiskUsd, newPos)
 				d.PositionSizeUSD = newPos
-				estimatedRiskUsd = allowedRiskUsd
-				riskPctOfEquity = remaining
-				proposedTotal = currentTotal + remaining
+				return RiskResize, fmt.Sprintf("单笔风险 %.2f USDT 超过上限 %.2f，仓位缩至 %.2f", est.RiskUsd, maxRiskUsd, newPos)
 			}
-			*totalRiskPct = proposedTotal
-		}
+			return RiskApprove, ""
+		},
+	})
 
-		// 基于单笔风险占净值的大小，采用两档 RR 要求：
-		//  - 小仓试探单（风险 <= 1.5% 净值）：允许略低的 RR，下限约 1.0:1
-		//  - 正式仓位（风险 > 策略配置的阈值）：使用策略配置的最小 RR
-		probeRiskPct := 0.015 // 1.5%
-		strictMinRR := riskCfg.MinRiskRewardRatio
-		if strictMinRR <= 0 {
-			strictMinRR = 1.8 // 安全默认值
-		}
-		probeMinRR := 1.0
+	// alt_risk_cap: Altcoin 进一步收紧单笔风险上限，使其更偏向“小仓战术单”而非主仓
+	RegisterRiskRule(RiskRule{
+		Name:        "alt_risk_cap",
+		Description: "Altcoin / 资产注册表 max_risk_usd 单笔止损亏损绝对上限",
+		Actions:     openActions,
+		Apply: func(d *Decision, rc *RiskRuleContext) (RiskOutcome, string) {
+			// 资产注册表配置了 max_risk_usd 时对任意层级生效，否则仅对山寨币使用默认上限
+			maxAlt := GetAssetRegistry().Get(d.Symbol).MaxRiskUSD
+			if maxAlt <= 0 {
+				if !isAltSymbol(d.Symbol) {
+					return RiskApprove, ""
+				}
+				maxAlt = rc.Param("max_risk_usd", maxRiskUsdAltPerTrade)
+			}
+			est, err := estimateTradeRisk(d, rc)
+			if err != nil {
+				return RiskReject, err.Error()
+			}
+			if est.RiskPercent <= 0 || maxAlt <= 0 || est.RiskUsd <= maxAlt {
+				return RiskApprove, ""
+			}
+			newPos := maxAlt * 100.0 / est.RiskPercent
+			log.Printf("⚠️ [Alt Risk Fallback] %s 单笔风险 %.2f USDT 超过该币种上限 %.2f，自动缩小仓位到 %.2f USDT",
+				d.Symbol, est.RiskUsd, maxAlt, newPos)
+			d.PositionSizeUSD = newPos
+			return RiskResize, fmt.Sprintf("单笔风险 %.2f USDT 超过该币种上限 %.2f，仓位缩至 %.2f", est.RiskUsd, maxAlt, newPos)
+		},
+	})
 
-		// 如果无法估算风险（例如缺少价格），回退使用严格 RR
-		minRR := strictMinRR
-		if riskPctOfEquity > 0 && riskPctOfEquity <= probeRiskPct {
-			minRR = probeMinRR
-		}
+	// total_risk_budget: 同一批次所有新开仓风险之和不超过 MaxTotalRisk，超出部分缩仓，预算耗尽则拒绝
+	RegisterRiskRule(RiskRule{
+		Name:        "total_risk_budget",
+		Description: "一轮内新开仓风险合计不超过 MaxTotalRisk",
+		Actions:     openActions,
+		Apply: func(d *Decision, rc *RiskRuleContext) (RiskOutcome, string) {
+			est, err := estimateTradeRisk(d, rc)
+			if err != nil {
+				return RiskReject, err.Error()
+			}
+			if est.RiskPctOfEquity <= 0 {
+				return RiskApprove, ""
+			}
+			maxTotal := rc.Param("max_total_risk", rc.Config.MaxTotalRisk)
+			if rc.TotalRiskPct+est.RiskPctOfEquity <= maxTotal {
+				return RiskApprove, ""
+			}
+			remaining := maxTotal - rc.TotalRiskPct
+			if remaining <= 0 {
+				return RiskReject, fmt.Sprintf("所有新开仓风险之和已达到全局上限 %.2f%%，拒绝 %s", maxTotal*100, d.Symbol)
+			}
+			// 将本次仓位缩小到仅使用剩余风险预算
+			allowedRiskUsd := remaining * rc.Account.TotalEquity
+			newPos := allowedRiskUsd * 100.0 / est.RiskPercent
+			log.Printf("⚠️ [Global Risk Fallback] %s 总风险将超出上限，调整本单风险从 %.2f USDT 降至 %.2f USDT，仓位从 %.2f USDT 降至 %.2f USDT",
+				d.Symbol, est.RiskUsd, allowedRiskUsd, d.PositionSizeUSD, newPos)
+			d.PositionSizeUSD = newPos
+			return RiskResize, fmt.Sprintf("总风险预算仅剩 %.2f%%，仓位缩至 %.2f", remaining*100, newPos)
+		},
+	})
 
-		if riskRewardRatio < minRR {
-			// 记录更详细的上下文，便于调试
-			log.Printf("⚠️ [RR Reject] %s RR=%.2f:1 risk=%.2f%% reward=%.2f%% riskPctOfEquity=%.2f%% minRR=%.2f",
-				d.Symbol, riskRewardRatio, riskPercent, rewardPercent, riskPctOfEquity*100, minRR)
-			return fmt.Errorf("风险回报比过低(%.2f:1)，必须≥%.1f:1 [风险:%.2f%% 收益:%.2f%%]",
-				riskRewardRatio, minRR, riskPercent, rewardPercent)
-		}
-	}
+	// net_exposure: 现有持仓 + 本轮新开仓的净多 / 净空名义敞口不超过 MaxNetExposure × 净值
+	RegisterRiskRule(RiskRule{
+		Name:        "net_exposure",
+		Description: "组合净多 / 净空名义敞口上限",
+		Actions:     openActions,
+		Apply: func(d *Decision, rc *RiskRuleContext) (RiskOutcome, string) {
+			limit := rc.Param("max_exposure", rc.Config.MaxNetExposure) * rc.Account.TotalEquity
+			if limit <= 0 {
+				return RiskApprove, ""
+			}
+			p := rc.portfolioWith(nil, defaultPortfolioCorrelation)
+			headroom := limit - p.NetNotional
+			if d.Action == "open_short" {
+				headroom = limit + p.NetNotional
+			}
+			return fitExposureHeadroom(d, headroom, "净敞口")
+		},
+	})
 
-	// 动态调整止损验证
-	if d.Action == "update_stop_loss" {
-		// 兼容模型可能错误使用 stop_loss 字段的情况：
-		// 如果 new_stop_loss 为空但 stop_loss > 0，则自动视为 new_stop_loss
-		if d.NewStopLoss <= 0 && d.StopLoss > 0 {
-			log.Printf("⚠️ [Fallback] update_stop_loss 使用了 stop_loss 字段，自动将 new_stop_loss 设置为 %.4f", d.StopLoss)
-			d.NewStopLoss = d.StopLoss
-		}
-		if d.NewStopLoss <= 0 {
-			return fmt.Errorf("新止损价格必须大于0: %.2f", d.NewStopLoss)
-		}
+	// sector_exposure: 同一板块（见资产注册表 assets.go）的总名义敞口不超过 MaxSectorExposure × 净值
+	RegisterRiskRule(RiskRule{
+		Name:        "sector_exposure",
+		Description: "单板块总名义敞口上限",
+		Actions:     openActions,
+		Apply: func(d *Decision, rc *RiskRuleContext) (RiskOutcome, string) {
+			limit := rc.Param("max_exposure", rc.Config.MaxSectorExposure) * rc.Account.TotalEquity
+			if limit <= 0 {
+				return RiskApprove, ""
+			}
+			sector := sectorOf(d.Symbol)
+			p := rc.portfolioWith(nil, defaultPortfolioCorrelation)
+			return fitExposureHeadroom(d, limit-p.sectorGross(sector), sector+" 板块敞口")
+		},
+	})
 
-		// 额外约束：新止损不能离当前市价过近，否则在 2 分钟循环下容易被噪音频繁扫损
-		if mdMap != nil {
-			if md, ok := mdMap[d.Symbol]; ok && md != nil && md.CurrentPrice > 0 {
-				cur := md.CurrentPrice
-				// 推断方向：正常情况下，做多止损 < 现价，做空止损 > 现价
-				var distPct float64
-				if d.NewStopLoss < cur {
-					// 视为多单：要求当前价到止损至少有一定百分比的缓冲
-					distPct = (cur - d.NewStopLoss) / cur * 100
+	// correlated_exposure: 按滚动收益率相关性加权后的等效敞口不超过 MaxCorrelatedExposure × 净值，
+	// 避免同时堆叠多个高度相关的同向仓位；对冲型开仓（降低等效敞口）始终放行
+	RegisterRiskRule(RiskRule{
+		Name:        "correlated_exposure",
+		Description: "相关性调整后的组合等效敞口上限",
+		Actions:     openActions,
+		Apply: func(d *Decision, rc *RiskRuleContext) (RiskOutcome, string) {
+			limit := rc.Param("max_exposure", rc.Config.MaxCorrelatedExposure) * rc.Account.TotalEquity
+			if limit <= 0 {
+				return RiskApprove, ""
+			}
+			defaultCorr := rc.Param("default_correlation", defaultPortfolioCorrelation)
+			side := decisionPositionSide(*d)
+			correlatedWith := func(notional float64) float64 {
+				leg := &ExposureLeg{Symbol: d.Symbol, Side: side, Notional: notional, Pending: true}
+				return rc.portfolioWith(leg, defaultCorr).CorrelatedNotional
+			}
+
+			full := correlatedWith(d.PositionSizeUSD)
+			base := correlatedWith(0)
+			if full <= limit || full <= base {
+				return RiskApprove, ""
+			}
+			if base >= limit {
+				return fitExposureHeadroom(d, 0, "相关性敞口")
+			}
+			// 等效敞口的平方是新仓位的凸二次函数，且 0 处未超限，二分查找可用的最大仓位
+			lo, hi := 0.0, d.PositionSizeUSD
+			for i := 0; i < 40; i++ {
+				mid := (lo + hi) / 2
+				if correlatedWith(mid) <= limit {
+					lo = mid
 				} else {
-					// 视为空单：要求当前价到止损至少有一定百分比的缓冲
-					distPct = (d.NewStopLoss - cur) / cur * 100
+					hi = mid
 				}
+			}
+			return fitExposureHeadroom(d, lo, "相关性敞口")
+		},
+	})
 
-				// 结合绝对下限 + ATR 估算的动态下限
-				minPct := minStopDistancePctFloor
-				if md.ATR14_5m > 0 && cur > 0 {
-					atrPct := md.ATR14_5m / cur * 100
-					buf := atrPct * minStopDistanceATRFactor
-					if buf > minPct {
-						minPct = buf
-					}
+	// min_rr: 基于单笔风险占净值的大小，采用两档 RR 要求：
+	//  - 小仓试探单（风险 <= probe_risk_pct 净值）：允许略低的 RR（probe_min_rr）
+	//  - 正式仓位：使用策略配置的最小 RR
+	RegisterRiskRule(RiskRule{
+		Name:        "min_rr",
+		Description: "最小风险回报比（试探仓位使用较低门槛）",
+		Actions:     openActions,
+		Apply: func(d *Decision, rc *RiskRuleContext) (RiskOutcome, string) {
+			est, err := estimateTradeRisk(d, rc)
+			if err != nil {
+				return RiskReject, err.Error()
+			}
+			strictMinRR := rc.Config.MinRiskRewardRatio
+			if strictMinRR <= 0 {
+				strictMinRR = rc.Param("default_min_rr", 1.8) // 安全默认值
+			}
+			// 如果无法估算风险（例如缺少价格），回退使用严格 RR
+			minRR := strictMinRR
+			if est.RiskPctOfEquity > 0 && est.RiskPctOfEquity <= rc.Param("probe_risk_pct", 0.015) {
+				minRR = rc.Param("probe_min_rr", 1.0)
+			}
+			if est.RiskRewardRatio < minRR && est.FundingCostPct > 0 && est.RiskPercent > 0 && est.RewardPercent/est.RiskPercent >= minRR {
+				// 仅因资金费不达标：改写为观望，不拒绝整个批次
+				log.Printf("⚠️ [RR Funding] %s 扣除资金费 %.3f%% 后 RR=%.2f:1 < %.2f，忽略 %s", d.Symbol, est.FundingCostPct, est.RiskRewardRatio, minRR, d.Action)
+				d.Action = "wait"
+				return RiskRewrite, fmt.Sprintf("扣除资金费 %.3f%% 后风险回报比 %.2f:1 < %.1f:1，改为观望", est.FundingCostPct, est.RiskRewardRatio, minRR)
+			}
+			if est.RiskRewardRatio < minRR {
+				// 记录更详细的上下文，便于调试
+				log.Printf("⚠️ [RR Reject] %s RR=%.2f:1 risk=%.2f%% reward=%.2f%% funding=%.3f%% riskPctOfEquity=%.2f%% minRR=%.2f",
+					d.Symbol, est.RiskRewardRatio, est.RiskPercent, est.RewardPercent, est.FundingCostPct, est.RiskPctOfEquity*100, minRR)
+				return RiskReject, fmt.Sprintf("风险回报比过低(%.2f:1)，必须≥%.1f:1 [风险:%.2f%% 收益:%.2f%% 资金费:%.3f%%]",
+					est.RiskRewardRatio, minRR, est.RiskPercent, est.RewardPercent, est.FundingCostPct)
+			}
+			return RiskApprove, ""
+		},
+	})
+
+	// stop_update_distance: 动态调整止损时，新止损不能离当前市价过近，否则在 2 分钟循环下容易被噪音频繁扫损
+	RegisterRiskRule(RiskRule{
+		Name:        "stop_update_distance",
+		Description: "移动止损需与现价保留最小缓冲，过近时改写为 hold",
+		Actions:     []string{"update_stop_loss"},
+		Apply: func(d *Decision, rc *RiskRuleContext) (RiskOutcome, string) {
+			outcome, reason := RiskApprove, ""
+			// 兼容模型可能错误使用 stop_loss 字段的情况：
+			// 如果 new_stop_loss 为空但 stop_loss > 0，则自动视为 new_stop_loss
+			if d.NewStopLoss <= 0 && d.StopLoss > 0 {
+				log.Printf("⚠️ [Fallback] update_stop_loss 使用了 stop_loss 字段，自动将 new_stop_loss 设置为 %.4f", d.StopLoss)
+				d.NewStopLoss = d.StopLoss
+				outcome, reason = RiskRewrite, "stop_loss -> new_stop_loss"
+			}
+			if d.NewStopLoss <= 0 {
+				return RiskReject, fmt.Sprintf("新止损价格必须大于0: %.2f", d.NewStopLoss)
+			}
+
+			md, ok := rc.MDMap[d.Symbol]
+			if !ok || md == nil || md.CurrentPrice <= 0 {
+				return outcome, reason
+			}
+			cur := md.CurrentPrice
+			// 推断方向：优先使用归一化阶段补齐的 side（对冲模式下两条腿方向不同），
+			// 否则按常规推断：做多止损 < 现价，做空止损 > 现价
+			side := normalizePositionSide(d.Side)
+			if side == "" {
+				side = "short"
+				if d.NewStopLoss < cur {
+					side = "long"
 				}
+			}
+			var distPct float64
+			if side == "long" {
+				distPct = (cur - d.NewStopLoss) / cur * 100
+			} else {
+				distPct = (d.NewStopLoss - cur) / cur * 100
+			}
 
-				if distPct > 0 && distPct < minPct {
-					// 不再视为硬错误，而是记录信息并将本次止损调整视为 no-op，避免打断整批决策执行。
-					log.Printf("ℹ️ [SL Noop] %s 新止损 %.4f 距当前价约 %.2f%% (< 最小缓冲 %.2f%%)，为避免 2 分钟周期噪音频繁扫损，本轮放弃调整止损，保持原止损不变", d.Symbol, d.NewStopLoss, distPct, minPct)
-					// 将本次动作视为 hold，后续执行层不会真正下发 update_stop_loss 指令。
-					d.Action = "hold"
-					return nil
+			// 结合绝对下限 + ATR 估算的动态下限
+			minPct := rc.Param("min_distance_pct", minStopDistancePctFloor)
+			if md.ATR14_5m > 0 {
+				atrPct := md.ATR14_5m / cur * 100
+				if buf := atrPct * rc.Param("atr_factor", minStopDistanceATRFactor); buf > minPct {
+					minPct = buf
 				}
 			}
-		}
-	}
 
-	// 动态调整止盈验证
-	if d.Action == "update_take_profit" {
-		if d.NewTakeProfit <= 0 {
-			return fmt.Errorf("新止盈价格必须大于0: %.2f", d.NewTakeProfit)
-		}
-	}
+			if distPct > 0 && distPct < minPct {
+				// 不再视为硬错误，而是记录信息并将本次止损调整视为 no-op，避免打断整批决策执行。
+				log.Printf("ℹ️ [SL Noop] %s 新止损 %.4f 距当前价约 %.2f%% (< 最小缓冲 %.2f%%)，为避免 2 分钟周期噪音频繁扫损，本轮放弃调整止损，保持原止损不变", d.Symbol, d.NewStopLoss, distPct, minPct)
+				// 将本次动作视为 hold，后续执行层不会真正下发 update_stop_loss 指令。
+				d.Action = "hold"
+				return RiskRewrite, fmt.Sprintf("新止损距现价 %.2f%% < 最小缓冲 %.2f%%，改为 hold", distPct, minPct)
+			}
+			return outcome, reason
+		},
+	})
 
-	// 部分平仓验证
-	if d.Action == "partial_close" {
-		// 部分平仓本质上是减仓行为，不增加风险，这里只做参数合法性校验
-		// 优先使用 close_percentage；若缺失但给出了 position_size_usd，则允许执行层按金额推导比例
-		if d.ClosePercentage > 0 && d.ClosePercentage <= 100 {
-			// 正常按百分比部分平仓
-		} else if d.ClosePercentage <= 0 && d.PositionSizeUSD > 0 {
-			// 仅提供了按金额部分平仓的信息：在 ExecuteDecision 阶段根据当前持仓名义价值推导百分比
-			log.Printf("⚠️ [Partial Fallback] %s partial_close 未提供 close_percentage，仅提供 position_size_usd=%.2f，将在执行层按金额推导比例", d.Symbol, d.PositionSizeUSD)
-		} else {
-			return fmt.Errorf("平仓百分比必须在0-100之间: %.1f", d.ClosePercentage)
-		}
-	}
+	// take_profit_update: 动态调整止盈验证
+	RegisterRiskRule(RiskRule{
+		Name:        "take_profit_update",
+		Description: "校验新止盈价格",
+		Actions:     []string{"update_take_profit"},
+		Apply: func(d *Decision, rc *RiskRuleContext) (RiskOutcome, string) {
+			if d.NewTakeProfit <= 0 {
+				return RiskReject, fmt.Sprintf("新止盈价格必须大于0: %.2f", d.NewTakeProfit)
+			}
+			return RiskApprove, ""
+		},
+	})
 
-	return nil
+	// partial_close_params: 部分平仓本质上是减仓行为，不增加风险，这里只做参数合法性校验
+	RegisterRiskRule(RiskRule{
+		Name:        "partial_close_params",
+		Description: "校验部分平仓比例",
+		Actions:     []string{"partial_close"},
+		Apply: func(d *Decision, rc *RiskRuleContext) (RiskOutcome, string) {
+			// 优先使用 close_percentage；若缺失但给出了 position_size_usd，则允许执行层按金额推导比例
+			if d.ClosePercentage > 0 && d.ClosePercentage <= 100 {
+				return RiskApprove, ""
+			}
+			if d.ClosePercentage <= 0 && d.PositionSizeUSD > 0 {
+				// 仅提供了按金额部分平仓的信息：在 ExecuteDecision 阶段根据当前持仓名义价值推导百分比
+				log.Printf("⚠️ [Partial Fallback] %s partial_close 未提供 close_percentage，仅提供 position_size_usd=%.2f，将在执行层按金额推导比例", d.Symbol, d.PositionSizeUSD)
+				return RiskApprove, ""
+			}
+			return RiskReject, fmt.Sprintf("平仓百分比必须在0-100之间: %.1f", d.ClosePercentage)
+		},
+	})
+
+	// max_position_usd: 可选规则（默认不在流水线中），限制单笔名义仓位的绝对金额，
+	// 在策略 risk_params.rules 中加入 {"name": "max_position_usd", "params": {"max_usd": 500}} 即可启用
+	RegisterRiskRule(RiskRule{
+		Name:        "max_position_usd",
+		Description: "单笔名义仓位绝对上限（可选）",
+		Actions:     openActions,
+		Apply: func(d *Decision, rc *RiskRuleContext) (RiskOutcome, string) {
+			maxUsd := rc.Param("max_usd", 0)
+			if maxUsd <= 0 || d.PositionSizeUSD <= maxUsd {
+				return RiskApprove, ""
+			}
+			log.Printf("⚠️ [Size Fallback] %s 名义仓位 %.2f 超过绝对上限 %.2f，自动下调", d.Symbol, d.PositionSizeUSD, maxUsd)
+			orig := d.PositionSizeUSD
+			d.PositionSizeUSD = maxUsd
+			return RiskResize, fmt.Sprintf("名义仓位 %.2f 超过绝对上限，下调至 %.2f", orig, maxUsd)
+		},
+	})
 }
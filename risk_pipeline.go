@@ -0,0 +1,400 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// RiskOutcome 风控规则对决策的处理结果
+type RiskOutcome string
+
+const (
+	RiskApprove RiskOutcome = "approve" // 放行，不做任何修改
+	RiskResize  RiskOutcome = "resize"  // 调整仓位大小（缩仓）
+	RiskRewrite RiskOutcome = "rewrite" // 改写决策字段（action / 杠杆 / 止损等）
+	RiskReject  RiskOutcome = "reject"  // 拒绝，整批决策不执行
+)
+
+// RiskRuleResult 单条规则的执行结果
+type RiskRuleResult struct {
+	Rule    string      `json:"rule"`
+	Outcome RiskOutcome `json:"outcome"`
+	Reason  string      `json:"reason,omitempty"`
+}
+
+// RiskRuleConfig 策略配置中的单条规则设置
+//   - Name: 规则名称，需已注册（见 RegisterRiskRule）
+//   - Enabled: 为 nil 时沿用默认（默认流水线中的规则默认启用，追加的规则默认启用）
+//   - Params: 规则参数，未设置的键使用规则内置默认值
+type RiskRuleConfig struct {
+	Name    string             `json:"name"`
+	Enabled *bool              `json:"enabled,omitempty"`
+	Params  map[string]float64 `json:"params,omitempty"`
+}
+
+// RiskRuleFunc 规则实现：可直接修改决策（缩仓 / 改写），返回处理结果和原因
+type RiskRuleFunc func(d *Decision, rc *RiskRuleContext) (RiskOutcome, string)
+
+// RiskRule 已注册的风控规则
+type RiskRule struct {
+	Name        string
+	Description string
+	Actions     []string // 适用的 action，为空表示对所有 action 生效
+	Apply       RiskRuleFunc
+}
+
+// appliesTo 判断规则是否适用于当前 action
+func (r *RiskRule) appliesTo(action string) bool {
+	if len(r.Actions) == 0 {
+		return true
+	}
+	for _, a := range r.Actions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// RiskState 风控规则与 prompt 读取的账户级状态。实盘为全局组件（见 liveRiskState），
+// 影子策略各自持有一份，避免与实盘账户互相影响；字段为 nil 时对应规则跳过
+type RiskState struct {
+	Breaker      *CircuitBreaker
+	Guard        *EntryGuard
+	Invalidation *InvalidationMonitor
+	Storage      *Storage              // 最近交易统计（Kelly 仓位）
+	Calibrator   *ConfidenceCalibrator // 置信度校准（仓位缩放），为 nil 时使用声明的置信度
+	HedgeMode    bool                  // 对冲模式：允许同一交易对同时持有多空两条腿
+}
+
+// liveRiskState 实盘账户使用的全局状态（HedgeMode 由调用方按交易所设置，见 Context.riskState）
+func liveRiskState() *RiskState {
+	return &RiskState{
+		Breaker:      GetCircuitBreaker(),
+		Guard:        GetEntryGuard(),
+		Invalidation: GetInvalidationMonitor(),
+		Storage:      GetStorage(),
+		Calibrator:   GetCalibrator(),
+	}
+}
+
+// RiskRuleContext 规则执行上下文：账户、行情、策略风控参数以及同一批次内共享的风险预算
+type RiskRuleContext struct {
+	Account   AccountInfo
+	Positions []PositionInfo
+	MDMap     map[string]*MarketData
+	Config    RiskConfig
+	// 熔断 / 开仓守卫 / 交易统计等账户状态，影子策略使用各自独立的一份（见 shadow.go）
+	State *RiskState
+
+	// 本批次已通过风控的新开仓，组合敞口规则会把它们与现有持仓合并计算
+	PendingLegs []ExposureLeg
+
+	// 本批次已占用的风险（占净值比例），整条流水线放行后按最终仓位累加
+	TotalRiskPct float64
+
+	params map[string]float64
+}
+
+// Param 读取当前规则的参数，未配置时返回默认值
+func (rc *RiskRuleContext) Param(key string, def float64) float64 {
+	if v, ok := rc.params[key]; ok {
+		return v
+	}
+	return def
+}
+
+var (
+	riskRuleRegistryMu sync.RWMutex
+	riskRuleRegistry   = make(map[string]*RiskRule)
+)
+
+// defaultRiskPipeline 默认规则顺序：动作归一与交易对范围 → 杠杆与仓位计算（含置信度缩放）→
+// 熔断 / 持仓数 / 开仓守卫 → 资金费率 → 名义价值与保证金 → 止损修正与检查 →
+// 单笔 / 批次风险与组合敞口 → 风险回报比 → 调整止损止盈与部分平仓参数
+var defaultRiskPipeline = []string{
+	"action_alias",
+	"symbol_universe",
+	"leverage_force",
+	"position_sizing",
+	"position_size",
+	"confidence_scaling",
+	"circuit_breaker",
+	"max_open_positions",
+	"symbol_cooldown",
+	"reentry_limit",
+	"no_flip",
+	"funding_rate",
+	"funding_window",
+	"max_notional",
+	"margin_cap",
+	"atr_stop",
+	"stop_sanity",
+	"liquidation_distance",
+	"trade_risk_cap",
+	"alt_risk_cap",
+	"total_risk_budget",
+	"net_exposure",
+	"sector_exposure",
+	"correlated_exposure",
+	"min_rr",
+	"stop_update_distance",
+	"take_profit_update",
+	"partial_close_params",
+}
+
+// RegisterRiskRule 注册风控规则。同名规则会被覆盖，便于外部替换内置实现。
+// 注册后的规则可以在策略的 risk_params.rules 中按名称引用。
+func RegisterRiskRule(rule RiskRule) {
+	if rule.Name == "" || rule.Apply == nil {
+		log.Printf("⚠️ [Risk Pipeline] 忽略无效的规则注册: %q", rule.Name)
+		return
+	}
+	riskRuleRegistryMu.Lock()
+	defer riskRuleRegistryMu.Unlock()
+	r := rule
+	riskRuleRegistry[rule.Name] = &r
+}
+
+// getRiskRule 按名称获取已注册规则
+func getRiskRule(name string) (*RiskRule, bool) {
+	riskRuleRegistryMu.RLock()
+	defer riskRuleRegistryMu.RUnlock()
+	r, ok := riskRuleRegistry[name]
+	return r, ok
+}
+
+// ListRiskRules 列出所有已注册规则名称（按字母排序）
+func ListRiskRules() []string {
+	riskRuleRegistryMu.RLock()
+	defer riskRuleRegistryMu.RUnlock()
+	names := make([]string, 0, len(riskRuleRegistry))
+	for name := range riskRuleRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// riskPipelineStep 流水线中的一步：规则 + 该策略下的参数
+type riskPipelineStep struct {
+	rule   *RiskRule
+	params map[string]float64
+}
+
+// buildRiskPipeline 根据策略配置生成规则流水线：
+//   - 以 defaultRiskPipeline 为基础顺序；
+//   - 配置中与默认规则同名的条目用于开关 / 调参；
+//   - 配置中不在默认列表里的规则按出现顺序追加到末尾。
+func buildRiskPipeline(cfg RiskConfig) []riskPipelineStep {
+	overrides := make(map[string]RiskRuleConfig, len(cfg.Rules))
+	var extra []string
+	inDefault := make(map[string]bool, len(defaultRiskPipeline))
+	for _, name := range defaultRiskPipeline {
+		inDefault[name] = true
+	}
+	for _, rc := range cfg.Rules {
+		if _, seen := overrides[rc.Name]; !seen && !inDefault[rc.Name] {
+			extra = append(extra, rc.Name)
+		}
+		overrides[rc.Name] = rc
+	}
+
+	order := append(append([]string{}, defaultRiskPipeline...), extra...)
+	steps := make([]riskPipelineStep, 0, len(order))
+	for _, name := range order {
+		ov, hasOverride := overrides[name]
+		if hasOverride && ov.Enabled != nil && !*ov.Enabled {
+			continue
+		}
+		rule, ok := getRiskRule(name)
+		if !ok {
+			log.Printf("⚠️ [Risk Pipeline] 未注册的风控规则 %q，已跳过", name)
+			continue
+		}
+		steps = append(steps, riskPipelineStep{rule: rule, params: ov.Params})
+	}
+	return steps
+}
+
+// runRiskPipeline 对单个决策依次执行规则。遇到 reject 立即停止并返回错误；
+// 规则改写 action 后，后续规则按新的 action 判断是否适用。
+// 每条规则前后的决策字段差异会按顺序记录到 d.RiskAdjustments。
+func runRiskPipeline(steps []riskPipelineStep, d *Decision, rc *RiskRuleContext) ([]RiskRuleResult, error) {
+	var results []RiskRuleResult
+	d.RiskAdjustments = nil
+	for _, step := range steps {
+		if !step.rule.appliesTo(d.Action) {
+			continue
+		}
+		rc.params = step.params
+		before := *d
+		outcome, reason := step.rule.Apply(d, rc)
+		if outcome == "" {
+			outcome = RiskApprove
+		}
+		results = append(results, RiskRuleResult{Rule: step.rule.Name, Outcome: outcome, Reason: reason})
+		recordRiskAdjustments(d, &before, step.rule.Name, outcome, reason)
+		if outcome == RiskReject {
+			return results, fmt.Errorf("[%s] %s", step.rule.Name, reason)
+		}
+	}
+	rc.params = nil
+	// 被后续规则改写为观望的开仓不再占用批次风险与敞口
+	if d.Action != "open_long" && d.Action != "open_short" {
+		return results, nil
+	}
+	// 按所有规则缩仓后的最终仓位计入批次风险，而不是 total_risk_budget 执行时的中间值
+	if est, err := estimateTradeRisk(d, rc); err == nil && est.RiskPctOfEquity > 0 {
+		rc.TotalRiskPct += est.RiskPctOfEquity
+	}
+	if d.PositionSizeUSD > 0 {
+		rc.PendingLegs = append(rc.PendingLegs, ExposureLeg{Symbol: d.Symbol, Side: decisionPositionSide(*d), Notional: d.PositionSizeUSD, Pending: true})
+	}
+	return results, nil
+}
+
+// formatRiskResults 将非 approve 的规则结果拼接为单行摘要，便于日志输出
+func formatRiskResults(results []RiskRuleResult) string {
+	var parts []string
+	for _, r := range results {
+		if r.Outcome == RiskApprove {
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%s:%s", r.Rule, r.Outcome))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// riskTrackedFields 风控调整时需要追踪差异的决策字段
+var riskTrackedFields = []struct {
+	name  string
+	value func(d *Decision) string
+}{
+	{"action", func(d *Decision) string { return d.Action }},
+	{"leverage", func(d *Decision) string { return fmt.Sprintf("%d", d.Leverage) }},
+	{"position_size_usd", func(d *Decision) string { return fmt.Sprintf("%.2f", d.PositionSizeUSD) }},
+	{"stop_loss", func(d *Decision) string { return fmt.Sprintf("%.4f", d.StopLoss) }},
+	{"take_profit", func(d *Decision) string { return fmt.Sprintf("%.4f", d.TakeProfit) }},
+	{"new_stop_loss", func(d *Decision) string { return fmt.Sprintf("%.4f", d.NewStopLoss) }},
+	{"new_take_profit", func(d *Decision) string { return fmt.Sprintf("%.4f", d.NewTakeProfit) }},
+	{"close_percentage", func(d *Decision) string { return fmt.Sprintf("%.2f", d.ClosePercentage) }},
+}
+
+// recordRiskAdjustments 比较规则执行前后的决策，把变化的字段追加到 d.RiskAdjustments。
+// 规则声明了 resize / rewrite 但没有可追踪字段变化时，仍记录一条不带字段的调整；reject 单独记录。
+func recordRiskAdjustments(d *Decision, before *Decision, rule string, outcome RiskOutcome, reason string) {
+	if outcome == RiskReject {
+		d.RiskAdjustments = append(d.RiskAdjustments, RiskAdjustment{Rule: rule, Outcome: outcome, Reason: reason})
+		return
+	}
+
+	changed := false
+	for _, f := range riskTrackedFields {
+		b, a := f.value(before), f.value(d)
+		if b == a {
+			continue
+		}
+		changed = true
+		d.RiskAdjustments = append(d.RiskAdjustments, RiskAdjustment{
+			Rule:    rule,
+			Outcome: outcome,
+			Field:   f.name,
+			Before:  b,
+			After:   a,
+			Reason:  reason,
+		})
+	}
+	if !changed && outcome != RiskApprove {
+		d.RiskAdjustments = append(d.RiskAdjustments, RiskAdjustment{Rule: rule, Outcome: outcome, Reason: reason})
+	}
+}
+
+// formatRiskAdjustment 将单条调整格式化为可读文本，用于终端 / 详细日志
+func formatRiskAdjustment(a RiskAdjustment) string {
+	if a.Field == "" {
+		return fmt.Sprintf("[%s] %s: %s", a.Rule, a.Outcome, a.Reason)
+	}
+	return fmt.Sprintf("[%s] %s %s -> %s (%s)", a.Rule, a.Field, a.Before, a.After, a.Reason)
+}
+
+// formatRiskAdjustmentLines 将决策的全部风控调整格式化为缩进的多行文本（无调整时返回空字符串）
+func formatRiskAdjustmentLines(d Decision) string {
+	var sb strings.Builder
+	for _, a := range d.RiskAdjustments {
+		sb.WriteString("      🛡️ " + formatRiskAdjustment(a) + "\n")
+	}
+	return sb.String()
+}
+
+// RiskAdjustmentStats 风控调整统计，用于衡量 AI 原始决策被风控覆盖的频率
+type RiskAdjustmentStats struct {
+	Evaluated  int            `json:"evaluated"`   // 经过风控的决策数
+	Adjusted   int            `json:"adjusted"`    // 至少被调整一次的决策数（含拒绝）
+	Resized    int            `json:"resized"`     // 被缩仓的决策数
+	Rewritten  int            `json:"rewritten"`   // 被改写的决策数
+	Rejected   int            `json:"rejected"`    // 被拒绝的决策数
+	ByRule     map[string]int `json:"by_rule"`     // 各规则触发次数
+	SizeBefore float64        `json:"size_before"` // 被缩仓决策的原始名义仓位合计
+	SizeAfter  float64        `json:"size_after"`  // 被缩仓决策的最终名义仓位合计
+}
+
+// Add 累加一个决策的风控调整情况
+func (s *RiskAdjustmentStats) Add(d Decision) {
+	if s.ByRule == nil {
+		s.ByRule = make(map[string]int)
+	}
+	s.Evaluated++
+	if len(d.RiskAdjustments) == 0 {
+		return
+	}
+	s.Adjusted++
+
+	var resized, rewritten, rejected bool
+	var firstSize, lastSize string
+	for _, a := range d.RiskAdjustments {
+		s.ByRule[a.Rule]++
+		switch a.Outcome {
+		case RiskResize:
+			resized = true
+		case RiskRewrite:
+			rewritten = true
+		case RiskReject:
+			rejected = true
+		}
+		if a.Field == "position_size_usd" {
+			if firstSize == "" {
+				firstSize = a.Before
+			}
+			lastSize = a.After
+		}
+	}
+	if resized {
+		s.Resized++
+		var b, a float64
+		fmt.Sscanf(firstSize, "%f", &b)
+		fmt.Sscanf(lastSize, "%f", &a)
+		// position_percent 推导仓位时原始值为 0，不计入缩仓比例
+		if b > 0 {
+			s.SizeBefore += b
+			s.SizeAfter += a
+		}
+	}
+	if rewritten {
+		s.Rewritten++
+	}
+	if rejected {
+		s.Rejected++
+	}
+}
+
+// AvgSizeReductionPct 被缩仓决策的平均名义仓位缩减比例（%）
+func (s *RiskAdjustmentStats) AvgSizeReductionPct() float64 {
+	if s.SizeBefore <= 0 {
+		return 0
+	}
+	return (s.SizeBefore - s.SizeAfter) / s.SizeBefore * 100
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func init() {
+	// 仅供测试的规则：halve 把仓位减半，to_wait 把开仓改写为观望
+	RegisterRiskRule(RiskRule{Name: "test_halve", Actions: []string{"open_long", "open_short"}, Apply: func(d *Decision, rc *RiskRuleContext) (RiskOutcome, string) {
+		d.PositionSizeUSD /= 2
+		return RiskResize, "halve"
+	}})
+	RegisterRiskRule(RiskRule{Name: "test_to_wait", Apply: func(d *Decision, rc *RiskRuleContext) (RiskOutcome, string) {
+		d.Action = "wait"
+		return RiskRewrite, "wait"
+	}})
+}
+
+func pipelineNames(steps []riskPipelineStep) []string {
+	names := make([]string, len(steps))
+	for i, s := range steps {
+		names[i] = s.rule.Name
+	}
+	return names
+}
+
+func TestBuildRiskPipelineOrder(t *testing.T) {
+	off := false
+	without := func(skip string) []string {
+		var out []string
+		for _, name := range defaultRiskPipeline {
+			if name != skip {
+				out = append(out, name)
+			}
+		}
+		return out
+	}
+	tests := []struct {
+		name  string
+		rules []RiskRuleConfig
+		want  []string
+	}{
+		{name: "default", want: defaultRiskPipeline},
+		{name: "disabled rule is dropped", rules: []RiskRuleConfig{{Name: "min_rr", Enabled: &off}}, want: without("min_rr")},
+		{name: "tuning keeps default position", rules: []RiskRuleConfig{{Name: "total_risk_budget", Params: map[string]float64{"max_total_risk": 0.01}}}, want: defaultRiskPipeline},
+		{name: "extra rules appended in config order", rules: []RiskRuleConfig{{Name: "test_to_wait"}, {Name: "min_rr"}, {Name: "test_halve"}}, want: append(append([]string{}, defaultRiskPipeline...), "test_to_wait", "test_halve")},
+		{name: "unregistered rule skipped", rules: []RiskRuleConfig{{Name: "no_such_rule"}}, want: defaultRiskPipeline},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pipelineNames(buildRiskPipeline(RiskConfig{Rules: tt.rules})); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("pipeline = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// 规则改写 action 后，后续规则按新的 action 判断是否适用；reject 立即终止流水线
+func TestRunRiskPipelineRewriteAndReject(t *testing.T) {
+	halve, _ := getRiskRule("test_halve")
+	toWait, _ := getRiskRule("test_to_wait")
+	budget, _ := getRiskRule("total_risk_budget")
+
+	d := Decision{Symbol: "BTCUSDT", Action: "open_long", PositionSizeUSD: 1000, StopLoss: 95, TakeProfit: 120}
+	rc := &RiskRuleContext{Account: AccountInfo{TotalEquity: 10000}, MDMap: map[string]*MarketData{"BTCUSDT": {CurrentPrice: 100}}}
+	results, err := runRiskPipeline([]riskPipelineStep{{rule: toWait}, {rule: halve}}, &d, rc)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(results) != 1 || d.PositionSizeUSD != 1000 {
+		t.Fatalf("open-only rule ran after rewrite to wait: results=%v size=%.2f", results, d.PositionSizeUSD)
+	}
+
+	// 预算已耗尽：total_risk_budget 拒绝，排在其后的规则不再执行
+	d = Decision{Symbol: "BTCUSDT", Action: "open_long", PositionSizeUSD: 1000, StopLoss: 95, TakeProfit: 120}
+	rc.Config.MaxTotalRisk = 0.005
+	rc.TotalRiskPct = 0.005
+	results, err = runRiskPipeline([]riskPipelineStep{{rule: budget}, {rule: halve}}, &d, rc)
+	if err == nil {
+		t.Fatalf("expected rejection once the batch budget is used up")
+	}
+	if last := results[len(results)-1]; last.Rule != "total_risk_budget" || last.Outcome != RiskReject || d.PositionSizeUSD != 1000 {
+		t.Fatalf("pipeline continued after reject: results=%v size=%.2f", results, d.PositionSizeUSD)
+	}
+	if rc.TotalRiskPct != 0.005 || len(rc.PendingLegs) != 0 {
+		t.Fatalf("rejected decision was booked: risk=%.4f legs=%v", rc.TotalRiskPct, rc.PendingLegs)
+	}
+}
+
+// 批次风险按整条流水线结束后的最终仓位累加：后续规则缩仓或改写为观望时，只占用实际剩下的风险
+func TestRunRiskPipelineBooksFinalRisk(t *testing.T) {
+	rule := func(name string) riskPipelineStep {
+		r, ok := getRiskRule(name)
+		if !ok {
+			t.Fatalf("rule %s not registered", name)
+		}
+		return riskPipelineStep{rule: r}
+	}
+	// 入场 100、止损 95：1000 USDT 仓位风险 50 USDT，即净值 10000 的 0.5%
+	open := func(symbol string) Decision {
+		return Decision{Symbol: symbol, Action: "open_long", PositionSizeUSD: 1000, StopLoss: 95, TakeProfit: 120}
+	}
+
+	tests := []struct {
+		name      string
+		after     []string // 排在 total_risk_budget 之后的规则
+		wantFirst float64  // 第一单计入的批次风险
+		wantSize2 float64  // 第二单（同样 0.5% 风险）的最终仓位，0 表示被拒绝
+	}{
+		{name: "approved as is", wantFirst: 0.005, wantSize2: 0},
+		{name: "resized after budget", after: []string{"test_halve"}, wantFirst: 0.0025, wantSize2: 250},
+		{name: "rewritten to wait", after: []string{"test_to_wait"}, wantFirst: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			steps := []riskPipelineStep{rule("total_risk_budget")}
+			for _, name := range tt.after {
+				steps = append(steps, rule(name))
+			}
+			rc := &RiskRuleContext{
+				Account: AccountInfo{TotalEquity: 10000},
+				MDMap:   map[string]*MarketData{"BTCUSDT": {CurrentPrice: 100}, "ETHUSDT": {CurrentPrice: 100}},
+				Config:  RiskConfig{MaxTotalRisk: 0.005},
+			}
+
+			first := open("BTCUSDT")
+			if _, err := runRiskPipeline(steps, &first, rc); err != nil {
+				t.Fatalf("first decision rejected: %v", err)
+			}
+			if math.Abs(rc.TotalRiskPct-tt.wantFirst) > 1e-9 {
+				t.Fatalf("booked risk = %.4f, want %.4f", rc.TotalRiskPct, tt.wantFirst)
+			}
+			if tt.wantFirst == 0 {
+				if len(rc.PendingLegs) != 0 {
+					t.Fatalf("pending legs = %v, want none", rc.PendingLegs)
+				}
+				return
+			}
+
+			// 第二单只能使用剩余预算，若仍被后续规则减半则为剩余预算对应仓位的一半
+			second := open("ETHUSDT")
+			_, err := runRiskPipeline(steps, &second, rc)
+			if tt.wantSize2 == 0 {
+				if err == nil {
+					t.Fatalf("second decision approved with size %.2f, want rejection", second.PositionSizeUSD)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("second decision rejected: %v", err)
+			}
+			if math.Abs(second.PositionSizeUSD-tt.wantSize2) > 1e-6 {
+				t.Fatalf("second size = %.2f, want %.2f", second.PositionSizeUSD, tt.wantSize2)
+			}
+		})
+	}
+}
@@ -0,0 +1,477 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adshao/go-binance/v2/futures"
+)
+
+// 扫描维度
+const (
+	ScanCriterionVolume     = "volume"     // 24h 成交额
+	ScanCriterionOIGrowth   = "oi_growth"  // 24h 持仓量增长
+	ScanCriterionVolatility = "volatility" // 24h 振幅
+	ScanCriterionFunding    = "funding"    // 资金费率极端值（绝对值）
+)
+
+// scanSourceTags 各扫描维度写入 MarketData.Source 的来源标签
+var scanSourceTags = map[string]string{
+	ScanCriterionVolume:     "Volume_Top",
+	ScanCriterionOIGrowth:   "OI_Top",
+	ScanCriterionVolatility: "Volatility_Top",
+	ScanCriterionFunding:    "Funding_Extreme",
+}
+
+// ScannerConfig 动态交易对扫描配置：定期对全部 USDT 永续合约排序，选出前 top_n 个作为固定交易对之外的候选
+type ScannerConfig struct {
+	Enabled         bool     `json:"enabled"`
+	IntervalMinutes int      `json:"interval_minutes"` // 扫描间隔（分钟），默认 60
+	TopN            int      `json:"top_n"`            // 候选数量（不含固定交易对），默认 5
+	Criteria        []string `json:"criteria"`         // 排序维度，按顺序轮流取各维度的第一名，默认全部
+	MinQuoteVolume  float64  `json:"min_quote_volume"` // 24h 成交额下限 (USDT)，默认 5000 万
+	MinAbsFunding   float64  `json:"min_abs_funding"`  // 资金费率维度的绝对值下限，默认 0.0005 (0.05%)
+	OICandidates    int      `json:"oi_candidates"`    // 实盘只对成交额前 N 的币种查询持仓量历史，默认 30
+	FixturePath     string   `json:"fixture_path"`     // 本地行情快照（JSON），设置后不请求交易所
+}
+
+// withDefaults 补全默认值
+func (c ScannerConfig) withDefaults() ScannerConfig {
+	if c.IntervalMinutes <= 0 {
+		c.IntervalMinutes = 60
+	}
+	if c.TopN <= 0 {
+		c.TopN = 5
+	}
+	if len(c.Criteria) == 0 {
+		c.Criteria = []string{ScanCriterionVolume, ScanCriterionOIGrowth, ScanCriterionVolatility, ScanCriterionFunding}
+	}
+	if c.MinQuoteVolume <= 0 {
+		c.MinQuoteVolume = 50_000_000
+	}
+	if c.MinAbsFunding <= 0 {
+		c.MinAbsFunding = 0.0005
+	}
+	if c.OICandidates <= 0 {
+		c.OICandidates = 30
+	}
+	return c
+}
+
+// ScannerTicker 单个合约的 24h 行情快照，也是 fixture 文件的格式
+type ScannerTicker struct {
+	Symbol      string   `json:"symbol"`
+	LastPrice   float64  `json:"last_price"`
+	HighPrice   float64  `json:"high_price"`
+	LowPrice    float64  `json:"low_price"`
+	ChangePct   float64  `json:"change_pct"`              // 24h 涨跌幅 (%)
+	QuoteVolume float64  `json:"quote_volume"`            // 24h 成交额 (USDT)
+	FundingRate float64  `json:"funding_rate"`            // 最近资金费率
+	OIChangePct *float64 `json:"oi_change_pct,omitempty"` // 24h 持仓量变化 (%)，未知时为空
+}
+
+// volatility 24h 振幅（最高 - 最低）/ 最新价
+func (t ScannerTicker) volatility() float64 {
+	if t.LastPrice <= 0 {
+		return 0
+	}
+	return (t.HighPrice - t.LowPrice) / t.LastPrice
+}
+
+// ScannerSource 扫描数据源
+type ScannerSource interface {
+	Name() string
+	// Tickers 返回全部合约的 24h 行情（可不含持仓量变化）
+	Tickers() ([]ScannerTicker, error)
+	// OIChange 查询单个合约的 24h 持仓量变化 (%)
+	OIChange(symbol string) (float64, error)
+}
+
+// ScanCandidate 扫描选出的候选交易对
+type ScanCandidate struct {
+	Symbol string `json:"symbol"`
+	Source string `json:"source"` // 来源标签，进入多个维度前列时用 "+" 连接
+	ScannerTicker
+}
+
+// ScanResult 一次扫描的结果
+type ScanResult struct {
+	Time       time.Time       `json:"time"`
+	Source     string          `json:"source"`
+	Scanned    int             `json:"scanned"`  // 参与排序的合约数（过滤后）
+	Excluded   []string        `json:"excluded"` // 被资产注册表黑名单排除的交易对
+	Candidates []ScanCandidate `json:"candidates"`
+	Error      string          `json:"error,omitempty"`
+}
+
+// isUSDTPerpetual 是否为 USDT 本位永续合约（交割合约带有 _日期 后缀）
+func isUSDTPerpetual(symbol string) bool {
+	return strings.HasSuffix(symbol, "USDT") && !strings.Contains(symbol, "_")
+}
+
+// rankScanCandidates 按各维度排序并轮流选取候选：
+// 依次取每个维度中尚未入选的最高名次，直到选满 topN 或各维度都已取完；
+// 固定交易对与黑名单不参与选取。tickers 需已包含持仓量变化（如有）
+func rankScanCandidates(tickers []ScannerTicker, cfg ScannerConfig, core map[string]bool) []ScanCandidate {
+	rankings := make(map[string][]ScannerTicker, len(cfg.Criteria))
+	for _, c := range cfg.Criteria {
+		var list []ScannerTicker
+		var key func(t ScannerTicker) float64
+		switch c {
+		case ScanCriterionVolume:
+			key = func(t ScannerTicker) float64 { return t.QuoteVolume }
+			list = append(list, tickers...)
+		case ScanCriterionOIGrowth:
+			key = func(t ScannerTicker) float64 { return *t.OIChangePct }
+			for _, t := range tickers {
+				if t.OIChangePct != nil && *t.OIChangePct > 0 {
+					list = append(list, t)
+				}
+			}
+		case ScanCriterionVolatility:
+			key = ScannerTicker.volatility
+			list = append(list, tickers...)
+		case ScanCriterionFunding:
+			key = func(t ScannerTicker) float64 { return math.Abs(t.FundingRate) }
+			for _, t := range tickers {
+				if math.Abs(t.FundingRate) >= cfg.MinAbsFunding {
+					list = append(list, t)
+				}
+			}
+		default:
+			continue
+		}
+		sort.SliceStable(list, func(i, j int) bool {
+			if ki, kj := key(list[i]), key(list[j]); ki != kj {
+				return ki > kj
+			}
+			return list[i].Symbol < list[j].Symbol
+		})
+		filtered := list[:0]
+		for _, t := range list {
+			if !core[t.Symbol] {
+				filtered = append(filtered, t)
+			}
+		}
+		rankings[c] = filtered
+	}
+
+	// 来源标签：进入某维度前 topN 即打上该维度的标签
+	tags := make(map[string][]string)
+	for _, c := range cfg.Criteria {
+		for i, t := range rankings[c] {
+			if i >= cfg.TopN {
+				break
+			}
+			tags[t.Symbol] = append(tags[t.Symbol], scanSourceTags[c])
+		}
+	}
+
+	picked := make(map[string]bool)
+	var out []ScanCandidate
+	cursor := make(map[string]int, len(cfg.Criteria))
+	for len(out) < cfg.TopN {
+		progressed := false
+		for _, c := range cfg.Criteria {
+			if len(out) >= cfg.TopN {
+				break
+			}
+			list := rankings[c]
+			for cursor[c] < len(list) && picked[list[cursor[c]].Symbol] {
+				cursor[c]++
+			}
+			if cursor[c] >= len(list) {
+				continue
+			}
+			t := list[cursor[c]]
+			picked[t.Symbol] = true
+			out = append(out, ScanCandidate{Symbol: t.Symbol, Source: strings.Join(tags[t.Symbol], "+"), ScannerTicker: t})
+			progressed = true
+		}
+		if !progressed {
+			break
+		}
+	}
+	return out
+}
+
+// SymbolScanner 动态交易对扫描器：定期排序选出候选，与固定交易对合并后进入交易对集合（见 universe.go）
+type SymbolScanner struct {
+	mu       sync.RWMutex
+	cfg      ScannerConfig
+	source   ScannerSource
+	last     ScanResult
+	lastScan time.Time
+}
+
+// NewSymbolScanner 创建扫描器
+func NewSymbolScanner(cfg ScannerConfig, source ScannerSource) *SymbolScanner {
+	return &SymbolScanner{cfg: cfg.withDefaults(), source: source}
+}
+
+// Scan 立即执行一次扫描；core 为固定交易对（不参与排名）
+func (s *SymbolScanner) Scan(core []string, now time.Time) (ScanResult, error) {
+	s.mu.RLock()
+	cfg, source := s.cfg, s.source
+	s.mu.RUnlock()
+
+	res := ScanResult{Time: now, Source: source.Name()}
+	tickers, err := source.Tickers()
+	if err != nil {
+		return res, err
+	}
+
+	coreSet := make(map[string]bool, len(core))
+	for _, c := range core {
+		coreSet[c] = true
+	}
+	assets := GetAssetRegistry()
+	eligible := make([]ScannerTicker, 0, len(tickers))
+	for _, t := range tickers {
+		if !isUSDTPerpetual(t.Symbol) || t.QuoteVolume < cfg.MinQuoteVolume || t.LastPrice <= 0 {
+			continue
+		}
+		if assets.Get(t.Symbol).Blacklisted {
+			res.Excluded = append(res.Excluded, t.Symbol)
+			continue
+		}
+		eligible = append(eligible, t)
+	}
+	sort.Strings(res.Excluded)
+
+	// 持仓量变化需要逐个查询，只查成交额靠前的非固定交易对
+	if containsString(cfg.Criteria, ScanCriterionOIGrowth) {
+		sort.SliceStable(eligible, func(i, j int) bool { return eligible[i].QuoteVolume > eligible[j].QuoteVolume })
+		queried := 0
+		for i := range eligible {
+			if queried >= cfg.OICandidates {
+				break
+			}
+			if eligible[i].OIChangePct != nil || coreSet[eligible[i].Symbol] {
+				continue
+			}
+			queried++
+			if v, err := source.OIChange(eligible[i].Symbol); err == nil {
+				eligible[i].OIChangePct = &v
+			}
+		}
+	}
+
+	res.Scanned = len(eligible)
+	res.Candidates = rankScanCandidates(eligible, cfg, coreSet)
+	return res, nil
+}
+
+// Refresh 到达扫描间隔时执行一次扫描；失败时保留上一次的候选
+func (s *SymbolScanner) Refresh(core []string, now time.Time) {
+	s.mu.RLock()
+	due := s.lastScan.IsZero() || now.Sub(s.lastScan) >= time.Duration(s.cfg.IntervalMinutes)*time.Minute
+	s.mu.RUnlock()
+	if !due {
+		return
+	}
+
+	res, err := s.Scan(core, now)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastScan = now
+	if err != nil {
+		log.Printf("⚠️ [Scanner] 扫描失败，沿用上一次候选: %v", err)
+		s.last.Error = err.Error()
+		return
+	}
+	s.last = res
+	parts := make([]string, 0, len(res.Candidates))
+	for _, c := range res.Candidates {
+		parts = append(parts, fmt.Sprintf("%s(%s)", c.Symbol, c.Source))
+	}
+	log.Printf("✅ [Scanner] 扫描 %d 个合约 (%s)，候选: %s", res.Scanned, res.Source, strings.Join(parts, ", "))
+}
+
+// Symbols 固定交易对 + 扫描候选（去重；候选在扫描后被加入黑名单的同样排除）
+func (s *SymbolScanner) Symbols(core []string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := append([]string(nil), core...)
+	for _, c := range s.last.Candidates {
+		out = append(out, c.Symbol)
+	}
+	return filterBlacklisted(out)
+}
+
+// TagMarketData 为扫描候选写入来源标签（固定交易对与已落选的交易对清空标签），并用扫描得到的 24h 成交额补全行情
+func (s *SymbolScanner) TagMarketData(md map[string]*MarketData) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	candidates := make(map[string]ScanCandidate, len(s.last.Candidates))
+	for _, c := range s.last.Candidates {
+		candidates[c.Symbol] = c
+	}
+	for symbol, d := range md {
+		if d == nil {
+			continue
+		}
+		c, ok := candidates[symbol]
+		d.Source = c.Source
+		if ok && d.Volume24h == 0 {
+			d.Volume24h = c.QuoteVolume
+		}
+	}
+}
+
+// Last 最近一次扫描结果
+func (s *SymbolScanner) Last() ScanResult {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.last
+}
+
+// Config 当前扫描配置（已补全默认值）
+func (s *SymbolScanner) Config() ScannerConfig {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cfg
+}
+
+// containsString 切片中是否包含指定字符串
+func containsString(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// fixtureScannerSource 从本地 JSON 文件读取行情快照（[]ScannerTicker），每次扫描重新读取
+type fixtureScannerSource struct {
+	path string
+}
+
+func (f *fixtureScannerSource) Name() string { return "fixture:" + f.path }
+
+func (f *fixtureScannerSource) Tickers() ([]ScannerTicker, error) {
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return nil, err
+	}
+	var tickers []ScannerTicker
+	if err := json.Unmarshal(data, &tickers); err != nil {
+		return nil, fmt.Errorf("解析 %s 失败: %w", f.path, err)
+	}
+	for i := range tickers {
+		tickers[i].Symbol = strings.ToUpper(strings.TrimSpace(tickers[i].Symbol))
+	}
+	return tickers, nil
+}
+
+func (f *fixtureScannerSource) OIChange(symbol string) (float64, error) {
+	return 0, fmt.Errorf("fixture 中没有 %s 的持仓量变化", symbol)
+}
+
+// binanceScannerSource 从币安获取全部合约的 24h 行情与资金费率，持仓量变化按需逐个查询
+type binanceScannerSource struct {
+	client *futures.Client
+}
+
+func (b *binanceScannerSource) Name() string { return "binance" }
+
+func (b *binanceScannerSource) Tickers() ([]ScannerTicker, error) {
+	ctx, cancel := newAPICtx()
+	defer cancel()
+	stats, err := b.client.NewListPriceChangeStatsService().Do(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("获取 24h 行情失败: %w", err)
+	}
+
+	funding := make(map[string]float64)
+	pctx, pcancel := newAPICtx()
+	defer pcancel()
+	if premium, err := b.client.NewPremiumIndexService().Do(pctx); err != nil {
+		log.Printf("⚠️ [Scanner] 获取资金费率失败，本次不使用资金费率维度: %v", err)
+	} else {
+		for _, p := range premium {
+			if rate, err := strconv.ParseFloat(p.LastFundingRate, 64); err == nil {
+				funding[p.Symbol] = rate
+			}
+		}
+	}
+
+	parse := func(s string) float64 {
+		v, _ := strconv.ParseFloat(s, 64)
+		return v
+	}
+	out := make([]ScannerTicker, 0, len(stats))
+	for _, st := range stats {
+		out = append(out, ScannerTicker{
+			Symbol:      st.Symbol,
+			LastPrice:   parse(st.LastPrice),
+			HighPrice:   parse(st.HighPrice),
+			LowPrice:    parse(st.LowPrice),
+			ChangePct:   parse(st.PriceChangePercent),
+			QuoteVolume: parse(st.QuoteVolume),
+			FundingRate: funding[st.Symbol],
+		})
+	}
+	return out, nil
+}
+
+// OIChange 用 1h 粒度的持仓量历史计算 24h 持仓价值变化
+func (b *binanceScannerSource) OIChange(symbol string) (float64, error) {
+	ctx, cancel := newAPICtx()
+	defer cancel()
+	hist, err := b.client.NewOpenInterestStatisticsService().Symbol(symbol).Period("1h").Limit(25).Do(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if len(hist) < 2 {
+		return 0, fmt.Errorf("no data")
+	}
+	first, err1 := strconv.ParseFloat(hist[0].SumOpenInterestValue, 64)
+	last, err2 := strconv.ParseFloat(hist[len(hist)-1].SumOpenInterestValue, 64)
+	if err1 != nil || err2 != nil || first <= 0 {
+		return 0, fmt.Errorf("invalid open interest history for %s", symbol)
+	}
+	return (last - first) / first * 100, nil
+}
+
+// 全局扫描器
+var globalSymbolScanner *SymbolScanner
+
+// InitGlobalSymbolScanner 按配置初始化全局扫描器：设置了 fixture_path 时读取本地快照，否则实盘使用币安行情；
+// 未启用或没有可用数据源时不创建
+func InitGlobalSymbolScanner(cfg ScannerConfig, exchange Exchange) {
+	if !cfg.Enabled {
+		return
+	}
+	for _, c := range cfg.Criteria {
+		if _, ok := scanSourceTags[c]; !ok {
+			log.Printf("⚠️ [Scanner] 忽略未知的扫描维度 %q（可选 volume / oi_growth / volatility / funding）", c)
+		}
+	}
+	var source ScannerSource
+	if cfg.FixturePath != "" {
+		source = &fixtureScannerSource{path: cfg.FixturePath}
+	} else if bex, ok := exchange.(*BinanceExchange); ok {
+		source = &binanceScannerSource{client: bex.Client}
+	} else {
+		log.Printf("⚠️ [Scanner] 模拟盘未配置 scanner.fixture_path，动态扫描未启用")
+		return
+	}
+	globalSymbolScanner = NewSymbolScanner(cfg, source)
+	c := globalSymbolScanner.Config()
+	log.Printf("✅ [Scanner] 动态扫描已启用 (%s)：每 %d 分钟选出 %d 个候选，维度 %v", source.Name(), c.IntervalMinutes, c.TopN, c.Criteria)
+}
+
+// GetSymbolScanner 获取全局扫描器（未启用时返回 nil）
+func GetSymbolScanner() *SymbolScanner {
+	return globalSymbolScanner
+}
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 影子策略：与实盘使用同一份行情和上下文，调用各自的模型，经过同一条风控流水线后在隔离的内存模拟账户中执行。
+// 模拟账户重启后从初始资金重新开始；决策 / 净值 / 交易记录单独保存在 data/shadows/<name>.json。
+
+const (
+	defaultShadowCapital = 1000.0
+	shadowDataDir        = "data/shadows"
+	shadowCurveLimit     = 500 // Web 返回的净值曲线点数上限
+)
+
+// reShadowName 影子策略名称同时用作存储文件名
+var reShadowName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// ShadowConfig 单个影子策略配置
+//   - Strategy: strategies/ 中的策略名，决定 prompt、风控参数和持仓管理规则（交易对沿用实盘本轮的候选）
+//   - Model / APIKey / APIURL: 为空时使用 ai_model / ai_api_key / ai_api_url
+//   - InitialCapital: 模拟账户初始资金，默认 1000 U
+type ShadowConfig struct {
+	Name           string  `json:"name"`
+	Enabled        bool    `json:"enabled"`
+	Strategy       string  `json:"strategy"`
+	Model          string  `json:"model,omitempty"`
+	APIKey         string  `json:"api_key,omitempty"`
+	APIURL         string  `json:"api_url,omitempty"`
+	InitialCapital float64 `json:"initial_capital,omitempty"`
+}
+
+// ShadowStrategy 单个影子策略：独立的模型、模拟账户、开仓守卫、失效条件、持仓管理、置信度校准和存储。
+// 不挂熔断器：熔断是账户级保护，影子账户只用于评估策略本身。
+type ShadowStrategy struct {
+	cfg       ShadowConfig
+	brain     *AIBrain
+	exchange  *SimulatedExchange
+	positions *PositionManager
+	risk      *RiskState
+	storage   *Storage
+
+	busy atomic.Bool // 上一轮尚未结束时跳过本轮，避免模型响应慢时堆积
+
+	mu            sync.Mutex // 保护模拟账户与以下运行状态
+	startedAt     time.Time
+	liveStart     float64 // 启动时的实盘净值，用于对比同期收益
+	liveEquity    float64
+	equityCurve   []float64
+	cycles        int
+	skipped       int
+	lastRun       time.Time
+	lastError     string
+	lastDecisions []Decision
+	lastCoT       string
+}
+
+// NewShadowStrategy 创建影子策略；hedgeMode 与实盘账户保持一致，proxyURL 用于模型请求
+func NewShadowStrategy(cfg ShadowConfig, hedgeMode bool, proxyURL string) (*ShadowStrategy, error) {
+	storage, err := NewStorage(filepath.Join(shadowDataDir, cfg.Name+".json"))
+	if err != nil {
+		return nil, err
+	}
+	exchange := NewPaperExchange(cfg.InitialCapital)
+	exchange.HedgeMode = hedgeMode
+	brain := NewAIBrain(cfg.APIKey, cfg.APIURL, cfg.Model, proxyURL)
+	brain.Source = "shadow:" + cfg.Name
+	return &ShadowStrategy{
+		cfg:       cfg,
+		brain:     brain,
+		exchange:  exchange,
+		positions: NewPositionManager(""),
+		risk: &RiskState{
+			Guard:        NewEntryGuard(""),
+			Invalidation: NewInvalidationMonitor(""),
+			Storage:      storage,
+			Calibrator:   NewConfidenceCalibrator(""), // 按本影子模型自己的历史校准，不使用实盘模型的校准曲线
+			HedgeMode:    hedgeMode,
+		},
+		storage: storage,
+	}, nil
+}
+
+// run 执行一轮：同步行情 → 持仓管理 / 失效条件 → 调用模型 → 风控 → 模拟执行 → 保存。
+// live 为实盘本轮上下文的副本，md 为行情快照（与实盘共用，只读）
+func (s *ShadowStrategy) run(live Context, md map[string]*MarketData, now time.Time) {
+	defer s.busy.Store(false)
+
+	strategy, ok := GetStrategyManager().GetStrategy(s.cfg.Strategy)
+	if !ok {
+		s.fail(now, fmt.Errorf("策略 %s 不存在", s.cfg.Strategy))
+		return
+	}
+
+	ctx, positions := s.prepare(live, strategy, md, now)
+
+	decision, err := s.brain.GetDecision(ctx)
+	if err == nil && decision == nil {
+		err = fmt.Errorf("决策结果为空")
+	}
+	if err != nil {
+		s.fail(now, fmt.Errorf("AI 请求失败: %w", err))
+		return
+	}
+
+	s.mu.Lock()
+	normalizeDecisionActions(decision.Decisions, positions)
+	if err := ValidateDecisions(strategy.RiskParams, s.risk, decision.Decisions, ctx.Account, positions, md); err != nil {
+		s.lastError = "风控拒绝: " + err.Error()
+		log.Printf("⚠️ [Shadow %s] 风控拒绝: %v", s.cfg.Name, err)
+	} else {
+		s.lastError = ""
+		s.executeLocked(decision.Decisions, positions, now)
+	}
+	s.lastDecisions = decision.Decisions
+	s.lastCoT = decision.CoTTrace
+	account := s.exchange.GetAccountInfo()
+	history := s.exchange.GetTradeHistory()
+	s.mu.Unlock()
+
+	if err := s.storage.SaveEquitySnapshot(account.TotalEquity, account.TotalPnL, account.TotalPnLPct); err != nil {
+		log.Printf("⚠️ [Shadow %s] 保存净值快照失败: %v", s.cfg.Name, err)
+	}
+	// 交易记录先于本轮决策保存，与实盘一致
+	for _, record := range history {
+		if err := s.storage.SaveTradeRecord(record); err != nil {
+			log.Printf("⚠️ [Shadow %s] 保存交易记录失败: %v", s.cfg.Name, err)
+		}
+	}
+	if len(decision.Decisions) > 0 {
+		if err := s.storage.SaveAIDecision(decision); err != nil {
+			log.Printf("⚠️ [Shadow %s] 保存 AI 决策记录失败: %v", s.cfg.Name, err)
+		}
+	}
+	log.Printf("👥 [Shadow %s] 净值 %.2f (%+.2f%%) | 持仓 %d | 决策 %d 条",
+		s.cfg.Name, account.TotalEquity, account.TotalPnLPct, account.PositionCount, len(decision.Decisions))
+}
+
+// prepare 同步行情并执行后端持仓管理，返回本影子策略的上下文和持仓
+func (s *ShadowStrategy) prepare(live Context, strategy *Strategy, md map[string]*MarketData, now time.Time) (*Context, []PositionInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.startedAt.IsZero() {
+		s.startedAt = now
+		s.liveStart = live.Account.TotalEquity
+	}
+	s.liveEquity = live.Account.TotalEquity
+	s.cycles++
+	s.lastRun = now
+
+	s.exchange.SetMarketData(md)
+	positions := s.exchange.GetPositions()
+	events := s.positions.Apply(s.positions.Evaluate(positions, positionRulesFor(strategy.RiskParams), now), s.exchange)
+	s.risk.Invalidation.Sync(positions, md)
+	events = append(events, s.risk.Invalidation.Apply(s.risk.Invalidation.Evaluate(), s.exchange)...)
+	positions = s.exchange.GetPositions()
+	s.risk.Guard.Observe(positions, s.exchange.GetTradeHistory(), now)
+	s.risk.Calibrator.Observe(positions, s.exchange.GetTradeHistory(), now)
+
+	account := s.exchange.GetAccountInfo()
+	s.equityCurve = append(s.equityCurve, account.TotalEquity)
+
+	ctx := live
+	ctx.Account = account
+	ctx.Positions = positions
+	ctx.MarketDataMap = md
+	ctx.SharpeRatio = CalculateRuntimeSharpe(s.equityCurve)
+	ctx.Leverage = GetLeverageManager().ResolveAll(live.Symbols, strategy.RiskParams.FixedLeverage)
+	ctx.HedgeMode = s.exchange.IsHedgeMode()
+	ctx.Portfolio = buildPortfolioExposure(positionLegs(positions), md, account.TotalEquity, defaultPortfolioCorrelation)
+	ctx.PositionEvents = events
+	ctx.Strategy = strategy
+	ctx.Risk = s.risk
+	return &ctx, positions
+}
+
+// executeLocked 在模拟账户中执行通过风控的决策（调用方需持有 s.mu）
+func (s *ShadowStrategy) executeLocked(decisions []Decision, positions []PositionInfo, now time.Time) {
+	for i := range decisions {
+		d := &decisions[i]
+		switch d.Action {
+		case "wait":
+			d.ExecStatus = "success"
+			continue
+		case "hold":
+			d.ExecStatus = "success"
+			registerInvalidationCondition(s.risk.Invalidation, *d, positions)
+			continue
+		}
+		if err := s.exchange.ExecuteDecision(*d); err != nil {
+			d.ExecStatus = "failed"
+			d.ExecError = err.Error()
+			log.Printf("⚠️ [Shadow %s] %s %s 执行失败: %v", s.cfg.Name, d.Symbol, d.Action, err)
+			continue
+		}
+		d.ExecStatus = "success"
+		switch d.Action {
+		case "open_long", "open_short":
+			s.positions.RecordOpen(*d, now)
+			s.risk.Guard.RecordOpen(d.Symbol, decisionPositionSide(*d), now)
+			if md := s.exchange.GetMarketData()[d.Symbol]; md != nil {
+				s.risk.Calibrator.RecordOpen(*d, md.CurrentPrice, s.exchange.GetTradeHistory(), now)
+			}
+		case "update_stop_loss":
+			s.positions.RecordStop(d.Symbol, decisionPositionSide(*d), d.NewStopLoss)
+		}
+		resetInvalidationCondition(s.risk.Invalidation, *d, positions)
+		registerInvalidationCondition(s.risk.Invalidation, *d, positions)
+	}
+}
+
+func (s *ShadowStrategy) fail(now time.Time, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastRun = now
+	s.lastError = err.Error()
+	log.Printf("⚠️ [Shadow %s] %v", s.cfg.Name, err)
+}
+
+// ShadowSummary 影子策略当前状态，收益均从本次启动起算，LiveReturnPct 为同期实盘收益
+type ShadowSummary struct {
+	Name           string           `json:"name"`
+	Strategy       string           `json:"strategy"`
+	Model          string           `json:"model"`
+	InitialCapital float64          `json:"initial_capital"`
+	Account        AccountInfo      `json:"account"`
+	ReturnPct      float64          `json:"return_pct"`
+	LiveReturnPct  float64          `json:"live_return_pct"`
+	Positions      []PositionInfo   `json:"positions"`
+	Trades         int              `json:"trades"`
+	WinRate        float64          `json:"win_rate"`
+	RealizedPnL    float64          `json:"realized_pnl"`
+	Cycles         int              `json:"cycles"`
+	Skipped        int              `json:"skipped"`
+	Running        bool             `json:"running"`
+	StartedAt      time.Time        `json:"started_at"`
+	LastRun        time.Time        `json:"last_run"`
+	LastError      string           `json:"last_error,omitempty"`
+	LastDecisions  []Decision       `json:"last_decisions"`
+	LastCoT        string           `json:"last_cot,omitempty"`
+	EquityCurve    []EquitySnapshot `json:"equity_curve"`
+}
+
+// Summary 当前状态（含本次启动以来的净值曲线）
+func (s *ShadowStrategy) Summary() ShadowSummary {
+	s.mu.Lock()
+	sum := ShadowSummary{
+		Name:           s.cfg.Name,
+		Strategy:       s.cfg.Strategy,
+		Model:          s.cfg.Model,
+		InitialCapital: s.cfg.InitialCapital,
+		Account:        s.exchange.GetAccountInfo(),
+		Positions:      s.exchange.GetPositions(),
+		Cycles:         s.cycles,
+		Skipped:        s.skipped,
+		Running:        s.busy.Load(),
+		StartedAt:      s.startedAt,
+		LastRun:        s.lastRun,
+		LastError:      s.lastError,
+		LastDecisions:  s.lastDecisions,
+		LastCoT:        s.lastCoT,
+	}
+	history := s.exchange.GetTradeHistory()
+	sum.ReturnPct = pctChange(s.cfg.InitialCapital, sum.Account.TotalEquity)
+	sum.LiveReturnPct = pctChange(s.liveStart, s.liveEquity)
+	s.mu.Unlock()
+
+	wins := 0
+	for _, r := range history {
+		sum.RealizedPnL += r.PnL
+		if r.PnL > 0 {
+			wins++
+		}
+	}
+	sum.Trades = len(history)
+	if sum.Trades > 0 {
+		sum.WinRate = float64(wins) / float64(sum.Trades) * 100
+	}
+	if !sum.StartedAt.IsZero() {
+		sum.EquityCurve = equityCurveSince(s.storage, sum.StartedAt)
+	}
+	return sum
+}
+
+// pctChange from -> to 的变化百分比，from 无效时返回 0
+func pctChange(from, to float64) float64 {
+	if from <= 0 {
+		return 0
+	}
+	return (to - from) / from * 100
+}
+
+// equityCurveSince 返回 since 之后的净值快照（最多 shadowCurveLimit 个，均匀抽样）
+func equityCurveSince(storage *Storage, since time.Time) []EquitySnapshot {
+	if storage == nil {
+		return nil
+	}
+	snaps, err := storage.GetEquityHistoryByTimeRange(since, time.Now())
+	if err != nil || len(snaps) <= shadowCurveLimit {
+		return snaps
+	}
+	step := float64(len(snaps)-1) / float64(shadowCurveLimit-1)
+	out := make([]EquitySnapshot, 0, shadowCurveLimit)
+	for i := 0; i < shadowCurveLimit; i++ {
+		out = append(out, snaps[int(float64(i)*step+0.5)])
+	}
+	return out
+}
+
+// ShadowManager 管理所有影子策略，每个周期由主循环调用 Launch
+type ShadowManager struct {
+	shadows []*ShadowStrategy
+
+	mu           sync.Mutex
+	startedAt    time.Time
+	liveStart    float64
+	liveEquity   float64
+	liveStrategy string
+}
+
+// Launch 以实盘本轮上下文异步运行所有影子策略；上一轮仍在运行的影子策略跳过本轮
+func (m *ShadowManager) Launch(ctx *Context, now time.Time) {
+	if m == nil || ctx == nil {
+		return
+	}
+	m.mu.Lock()
+	if m.startedAt.IsZero() {
+		m.startedAt = now
+		m.liveStart = ctx.Account.TotalEquity
+	}
+	m.liveEquity = ctx.Account.TotalEquity
+	if sm := GetStrategyManager(); sm != nil {
+		if active := sm.GetActiveStrategy(); active != nil {
+			m.liveStrategy = active.Name
+		}
+	}
+	m.mu.Unlock()
+
+	md := snapshotMarketData(ctx.MarketDataMap)
+	for _, s := range m.shadows {
+		if !s.busy.CompareAndSwap(false, true) {
+			s.mu.Lock()
+			s.skipped++
+			s.mu.Unlock()
+			log.Printf("ℹ️ [Shadow %s] 上一轮尚未完成，跳过本周期", s.cfg.Name)
+			continue
+		}
+		go s.run(*ctx, md, now)
+	}
+}
+
+// snapshotMarketData 复制行情快照：实盘下一周期会原地更新部分字段（如扫描来源），影子策略异步运行时需要独立的副本
+func snapshotMarketData(md map[string]*MarketData) map[string]*MarketData {
+	out := make(map[string]*MarketData, len(md))
+	for k, v := range md {
+		if v == nil {
+			continue
+		}
+		c := *v
+		out[k] = &c
+	}
+	return out
+}
+
+// ShadowLiveSummary 实盘同期表现，用于与影子策略对比
+type ShadowLiveSummary struct {
+	Strategy    string           `json:"strategy"`
+	Equity      float64          `json:"equity"`
+	ReturnPct   float64          `json:"return_pct"`
+	StartedAt   time.Time        `json:"started_at"`
+	EquityCurve []EquitySnapshot `json:"equity_curve"`
+}
+
+// ShadowReport /api/shadows 返回的对比数据
+type ShadowReport struct {
+	Live    ShadowLiveSummary `json:"live"`
+	Shadows []ShadowSummary   `json:"shadows"`
+}
+
+// Report 实盘与所有影子策略的对比
+func (m *ShadowManager) Report() ShadowReport {
+	m.mu.Lock()
+	live := ShadowLiveSummary{
+		Strategy:  m.liveStrategy,
+		Equity:    m.liveEquity,
+		ReturnPct: pctChange(m.liveStart, m.liveEquity),
+		StartedAt: m.startedAt,
+	}
+	m.mu.Unlock()
+	if !live.StartedAt.IsZero() {
+		live.EquityCurve = equityCurveSince(GetStorage(), live.StartedAt)
+	}
+
+	report := ShadowReport{Live: live, Shadows: make([]ShadowSummary, 0, len(m.shadows))}
+	for _, s := range m.shadows {
+		report.Shadows = append(report.Shadows, s.Summary())
+	}
+	return report
+}
+
+// 全局影子策略管理器
+var globalShadowManager *ShadowManager
+
+// InitGlobalShadowManager 按配置创建影子策略（没有启用的影子策略时不创建）；
+// 模型与 API 未配置时沿用主配置，hedgeMode 与实盘账户保持一致
+func InitGlobalShadowManager(cfg *Config, hedgeMode bool) {
+	m := &ShadowManager{}
+	seen := make(map[string]bool)
+	for _, sc := range cfg.ShadowStrategies {
+		if !sc.Enabled {
+			continue
+		}
+		if !reShadowName.MatchString(sc.Name) || seen[sc.Name] {
+			log.Printf("⚠️ [Shadow] 忽略影子策略 %q：名称为空、重复或包含字母数字 / _ / - 以外的字符", sc.Name)
+			continue
+		}
+		if _, ok := GetStrategyManager().GetStrategy(sc.Strategy); !ok {
+			log.Printf("⚠️ [Shadow] 影子策略 %s 引用的策略 %s 不存在，创建策略文件后自动生效", sc.Name, sc.Strategy)
+		}
+		if sc.Model == "" {
+			sc.Model = cfg.AIModel
+		}
+		if sc.APIKey == "" {
+			sc.APIKey = cfg.AIAPIKey
+		}
+		if sc.APIURL == "" {
+			sc.APIURL = cfg.AIAPIURL
+		}
+		if sc.InitialCapital <= 0 {
+			sc.InitialCapital = defaultShadowCapital
+		}
+		s, err := NewShadowStrategy(sc, hedgeMode, cfg.BinanceProxyURL)
+		if err != nil {
+			log.Printf("⚠️ [Shadow] 创建影子策略 %s 失败: %v", sc.Name, err)
+			continue
+		}
+		seen[sc.Name] = true
+		m.shadows = append(m.shadows, s)
+		log.Printf("✅ [Shadow] 影子策略 %s 已启用：策略 %s，模型 %s，模拟资金 %.0f U", sc.Name, sc.Strategy, sc.Model, sc.InitialCapital)
+	}
+	if len(m.shadows) > 0 {
+		globalShadowManager = m
+	}
+}
+
+// GetShadowManager 获取全局影子策略管理器（未配置影子策略时返回 nil）
+func GetShadowManager() *ShadowManager {
+	return globalShadowManager
+}
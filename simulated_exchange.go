@@ -1,4 +1,3 @@
-
 package main
 
 import (
@@ -10,14 +9,37 @@ import (
 // SimulatedExchange 模拟交易所，实现 Exchange 接口
 type SimulatedExchange struct {
 	account       AccountInfo
-	positions     map[string]PositionInfo
+	positions     map[string]PositionInfo // key: positionKey(symbol, side)
+	stops         map[string]legOrders    // 各持仓腿的止损 / 止盈价，key 同 positions
 	marketData    map[string]*MarketData
 	initialEquity float64
 	History       *TradeHistoryManager
+	// HedgeMode 为 true 时模拟对冲模式：同一交易对可同时持有多空两条腿
+	HedgeMode bool
+}
+
+// legOrders 模拟的止损 / 止盈挂单，价格触及时按挂单价平仓
+type legOrders struct {
+	StopLoss   float64
+	TakeProfit float64
 }
 
 // NewSimulatedExchange 创建一个新的模拟交易所实例
 func NewSimulatedExchange(initialCapital float64) *SimulatedExchange {
+	s := newSimulatedExchange(initialCapital)
+	s.History = NewTradeHistoryManager()
+	return s
+}
+
+// NewPaperExchange 创建使用外部行情的模拟账户（影子策略）：交易记录只保存在内存中，
+// 行情通过 SetMarketData 注入，不与实盘的 trade_history.json 混用
+func NewPaperExchange(initialCapital float64) *SimulatedExchange {
+	s := newSimulatedExchange(initialCapital)
+	s.History = NewMemoryTradeHistoryManager()
+	return s
+}
+
+func newSimulatedExchange(initialCapital float64) *SimulatedExchange {
 	return &SimulatedExchange{
 		account: AccountInfo{
 			TotalEquity:      initialCapital,
@@ -30,9 +52,9 @@ func NewSimulatedExchange(initialCapital float64) *SimulatedExchange {
 			PositionCount:    0,
 		},
 		positions:     make(map[string]PositionInfo),
+		stops:         make(map[string]legOrders),
 		marketData:    make(map[string]*MarketData),
 		initialEquity: initialCapital,
-		History:       NewTradeHistoryManager(),
 	}
 }
 
@@ -54,41 +76,57 @@ func (s *SimulatedExchange) FetchMarketData(symbols []string) error {
 		s.marketData[symbol] = md
 	}
 
-	// 2. 更新账户盈亏
+	// 2. 触发止损 / 止盈并更新账户盈亏
+	s.triggerStops()
+	s.revalueAccount()
+	return nil
+}
+
+// SetMarketData 使用外部行情快照（影子策略与实盘共用同一份行情），触发止损 / 止盈并重新估值
+func (s *SimulatedExchange) SetMarketData(md map[string]*MarketData) {
+	s.marketData = md
+	s.triggerStops()
+	s.revalueAccount()
+}
+
+// revalueAccount 按最新行情更新持仓标记价格、未实现盈亏和账户净值
+func (s *SimulatedExchange) revalueAccount() {
 	var totalUnrealizedPnL float64
 	var totalMarginUsed float64
 
 	for k, pos := range s.positions {
+		totalMarginUsed += pos.MarginUsed
 		md, ok := s.marketData[pos.Symbol]
-		if !ok {
+		if !ok || md == nil || md.CurrentPrice <= 0 {
+			totalUnrealizedPnL += pos.UnrealizedPnL
 			continue
 		}
-		
-		// 更新标记价格
+
+		// 更新标记价格与预估强平价
 		pos.MarkPrice = md.CurrentPrice
-		
+		pos.LiquidationPrice = positionLiquidationPrice(pos)
+
 		// 计算未实现盈亏
 		// 多单盈亏 = (当前价 - 开仓价) * 数量
 		// 空单盈亏 = (开仓价 - 当前价) * 数量
-		if pos.Side == "long" {
-			pos.UnrealizedPnL = (pos.MarkPrice - pos.EntryPrice) * pos.Quantity
-		} else {
-			pos.UnrealizedPnL = (pos.EntryPrice - pos.MarkPrice) * pos.Quantity
-		}
-		
+		pos.UnrealizedPnL = legPnL(pos, pos.MarkPrice, pos.Quantity)
+
 		// 更新持仓信息
 		if pos.MarginUsed > 0 {
 			pos.UnrealizedPnLPct = (pos.UnrealizedPnL / pos.MarginUsed) * 100
 		}
+		if pos.UnrealizedPnLPct > pos.PeakPnLPct {
+			pos.PeakPnLPct = pos.UnrealizedPnLPct
+		}
 		s.positions[k] = pos
 
 		totalUnrealizedPnL += pos.UnrealizedPnL
-		totalMarginUsed += pos.MarginUsed
 	}
 
 	// 更新账户信息
 	s.account.UnrealizedPnL = totalUnrealizedPnL
 	s.account.MarginUsed = totalMarginUsed
+	s.account.PositionCount = len(s.positions)
 	s.account.TotalEquity = s.account.AvailableBalance + s.account.MarginUsed + s.account.UnrealizedPnL
 	if s.account.TotalEquity > 0 {
 		s.account.MarginUsedPct = (s.account.MarginUsed / s.account.TotalEquity) * 100
@@ -99,8 +137,83 @@ func (s *SimulatedExchange) FetchMarketData(symbols []string) error {
 		s.account.TotalPnL = s.account.TotalEquity - s.initialEquity
 		s.account.TotalPnLPct = (s.account.TotalPnL / s.initialEquity) * 100
 	}
+}
 
-	return nil
+// triggerStops 当前价触及止损 / 止盈时按挂单价平仓（同一周期同时触及时按止损处理）
+func (s *SimulatedExchange) triggerStops() {
+	for key, o := range s.stops {
+		pos, ok := s.positions[key]
+		if !ok {
+			delete(s.stops, key)
+			continue
+		}
+		md, ok := s.marketData[pos.Symbol]
+		if !ok || md == nil || md.CurrentPrice <= 0 {
+			continue
+		}
+		price := md.CurrentPrice
+		long := pos.Side == "long"
+		switch {
+		case o.StopLoss > 0 && ((long && price <= o.StopLoss) || (!long && price >= o.StopLoss)):
+			s.closeLeg(key, o.StopLoss, 1, "close_"+pos.Side, fmt.Sprintf("%s @ %.4f", simulatedStopReasonPrefix, o.StopLoss))
+		case o.TakeProfit > 0 && ((long && price >= o.TakeProfit) || (!long && price <= o.TakeProfit)):
+			s.closeLeg(key, o.TakeProfit, 1, "close_"+pos.Side, fmt.Sprintf("止盈触发 @ %.4f", o.TakeProfit))
+		}
+	}
+}
+
+// simulatedStopReasonPrefix 模拟盘止损单触发时平仓记录的原因前缀（开仓守卫据此识别止损出局）
+const simulatedStopReasonPrefix = "止损触发"
+
+// legPnL 按指定价格计算持仓腿 qty 数量的盈亏
+func legPnL(pos PositionInfo, price, qty float64) float64 {
+	if pos.Side == "long" {
+		return (price - pos.EntryPrice) * qty
+	}
+	return (pos.EntryPrice - price) * qty
+}
+
+// closeLeg 按 price 平掉持仓腿的 pct 比例（0-1），返还保证金与盈亏并记录历史
+func (s *SimulatedExchange) closeLeg(key string, price, pct float64, action, reason string) {
+	pos := s.positions[key]
+	closeQty := pos.Quantity * pct
+	closedMargin := pos.MarginUsed * pct
+	pnl := legPnL(pos, price, closeQty)
+
+	// 返还资金 = 保证金 + 盈亏
+	s.account.AvailableBalance += closedMargin + pnl
+	s.account.MarginUsed -= closedMargin
+
+	if pct >= 1 {
+		delete(s.positions, key)
+		delete(s.stops, key)
+	} else {
+		pos.Quantity -= closeQty
+		pos.MarginUsed -= closedMargin
+		s.positions[key] = pos
+	}
+	s.account.PositionCount = len(s.positions)
+
+	log.Printf("Closed %.0f%% of %s position for %s. PnL: %.2f", pct*100, pos.Side, pos.Symbol, pnl)
+
+	// 记录历史
+	if s.History != nil {
+		rec := TradeRecord{
+			Time:       time.Now().Format("15:04:05"),
+			Symbol:     pos.Symbol,
+			Side:       pos.Side,
+			Action:     action,
+			EntryPrice: pos.EntryPrice,
+			ExitPrice:  price,
+			Quantity:   closeQty,
+			PnL:        pnl,
+			Reason:     reason,
+		}
+		if closedMargin > 0 {
+			rec.PnLPct = (pnl / closedMargin) * 100
+		}
+		s.History.AddRecord(rec)
+	}
 }
 
 func (s *SimulatedExchange) GetAccountInfo() AccountInfo {
@@ -127,11 +240,16 @@ func (s *SimulatedExchange) GetTradeHistory() []TradeRecord {
 	return nil
 }
 
+// IsHedgeMode 是否模拟对冲模式
+func (s *SimulatedExchange) IsHedgeMode() bool {
+	return s.HedgeMode
+}
+
 func (s *SimulatedExchange) ExecuteDecision(d Decision) error {
 	fmt.Printf("Simulated execution for %s: %s size $%.2f\n", d.Symbol, d.Action, d.PositionSizeUSD)
 
 	md, ok := s.marketData[d.Symbol]
-	if !ok {
+	if !ok || md == nil {
 		return fmt.Errorf("no market data for %s", d.Symbol)
 	}
 	price := md.CurrentPrice
@@ -141,6 +259,9 @@ func (s *SimulatedExchange) ExecuteDecision(d Decision) error {
 
 	switch d.Action {
 	case "open_long", "open_short":
+		if d.Leverage <= 0 {
+			return fmt.Errorf("invalid leverage for %s: %d", d.Symbol, d.Leverage)
+		}
 		// 检查余额
 		marginRequired := d.PositionSizeUSD / float64(d.Leverage)
 		if s.account.AvailableBalance < marginRequired {
@@ -154,11 +275,16 @@ func (s *SimulatedExchange) ExecuteDecision(d Decision) error {
 			side = "short"
 		}
 
-		// 检查是否已有持仓
-		if pos, exists := s.positions[d.Symbol]; exists {
-			if pos.Side != side {
-				return fmt.Errorf("conflict: existing %s position for %s", pos.Side, d.Symbol)
+		// 单向模式下同一交易对只能有一个方向的持仓
+		if !s.HedgeMode {
+			if opp, exists := s.positions[positionKey(d.Symbol, oppositeSide(side))]; exists {
+				return fmt.Errorf("conflict: existing %s position for %s", opp.Side, d.Symbol)
 			}
+		}
+
+		// 检查是否已有同方向持仓
+		key := positionKey(d.Symbol, side)
+		if pos, exists := s.positions[key]; exists {
 			// 加仓逻辑 (简单平均价格)
 			totalCost := pos.EntryPrice * pos.Quantity
 			newCost := price * quantity
@@ -169,10 +295,10 @@ func (s *SimulatedExchange) ExecuteDecision(d Decision) error {
 			pos.Quantity = totalQty
 			pos.MarginUsed += marginRequired
 			pos.Leverage = d.Leverage // 更新杠杆
-			s.positions[d.Symbol] = pos
+			s.positions[key] = pos
 		} else {
 			// 新建仓位
-			s.positions[d.Symbol] = PositionInfo{
+			s.positions[key] = PositionInfo{
 				Symbol:     d.Symbol,
 				Side:       side,
 				EntryPrice: price,
@@ -184,64 +310,72 @@ func (s *SimulatedExchange) ExecuteDecision(d Decision) error {
 			}
 			s.account.PositionCount++
 		}
+		opened := s.positions[key]
+		opened.LiquidationPrice = positionLiquidationPrice(opened)
+		s.positions[key] = opened
+
+		// 与实盘一致：开仓时同时挂止损 / 止盈（加仓时给出的新价格覆盖原挂单）
+		o := s.stops[key]
+		if d.StopLoss > 0 {
+			o.StopLoss = d.StopLoss
+		}
+		if d.TakeProfit > 0 {
+			o.TakeProfit = d.TakeProfit
+		}
+		s.stops[key] = o
 
 		// 扣除可用余额
 		s.account.AvailableBalance -= marginRequired
 		s.account.MarginUsed += marginRequired
 
 	case "close_long", "close_short":
-		pos, exists := s.positions[d.Symbol]
-		if !exists {
-			return fmt.Errorf("no position to close for %s", d.Symbol)
+		key := positionKey(d.Symbol, decisionPositionSide(d))
+		if _, exists := s.positions[key]; !exists {
+			return fmt.Errorf("no %s position to close for %s", decisionPositionSide(d), d.Symbol)
 		}
-		
-		// 验证方向
-		expectedSide := "long"
-		if d.Action == "close_short" {
-			expectedSide = "short"
+		s.closeLeg(key, price, 1, d.Action, d.Reasoning)
+
+	case "partial_close":
+		found, err := findPosition(s.GetPositions(), d.Symbol, decisionPositionSide(d))
+		if err != nil {
+			return fmt.Errorf("partial close: %w", err)
+		}
+		pct := d.ClosePercentage / 100.0
+		if pct <= 0 && d.PositionSizeUSD > 0 {
+			// 兼容仅提供 position_size_usd 的情况：根据当前持仓名义价值推导出比例
+			if notional := found.Quantity * price; notional > 0 {
+				pct = d.PositionSizeUSD / notional
+			}
+		}
+		if pct <= 0 {
+			return fmt.Errorf("invalid close percentage: %.2f", d.ClosePercentage)
 		}
-		if pos.Side != expectedSide {
-			return fmt.Errorf("position side mismatch: have %s, want close %s", pos.Side, expectedSide)
+		if pct > 1 {
+			pct = 1
 		}
+		s.closeLeg(positionKey(found.Symbol, found.Side), price, pct, d.Action, d.Reasoning)
 
-		// 计算平仓盈亏
-		var pnl float64
-		if pos.Side == "long" {
-			pnl = (price - pos.EntryPrice) * pos.Quantity
+	case "update_stop_loss", "update_take_profit":
+		found, err := findPosition(s.GetPositions(), d.Symbol, decisionPositionSide(d))
+		if err != nil {
+			return fmt.Errorf("%s: %w", d.Action, err)
+		}
+		key := positionKey(found.Symbol, found.Side)
+		o := s.stops[key]
+		if d.Action == "update_stop_loss" {
+			if d.NewStopLoss <= 0 {
+				return fmt.Errorf("invalid new_stop_loss for %s: %.4f", d.Symbol, d.NewStopLoss)
+			}
+			o.StopLoss = d.NewStopLoss
 		} else {
-			pnl = (pos.EntryPrice - price) * pos.Quantity
-		}
-
-		// 返还资金 = 保证金 + 盈亏
-		amountToReturn := pos.MarginUsed + pnl
-		
-		s.account.AvailableBalance += amountToReturn
-		s.account.TotalPnL += pnl
-		s.account.MarginUsed -= pos.MarginUsed
-		
-		// 移除持仓
-		delete(s.positions, d.Symbol)
-		s.account.PositionCount--
-		
-		log.Printf("Closed %s position for %s. PnL: %.2f", pos.Side, d.Symbol, pnl)
-
-		// 记录历史
-		if s.History != nil {
-			rec := TradeRecord{
-				Time:       time.Now().Format("15:04:05"),
-				Symbol:     d.Symbol,
-				Side:       pos.Side,
-				Action:     d.Action,
-				EntryPrice: pos.EntryPrice,
-				ExitPrice:  price,
-				Quantity:   pos.Quantity,
-				PnL:        pnl,
-				PnLPct:     (pnl / pos.MarginUsed) * 100,
-				Reason:     d.Reasoning,
+			if d.NewTakeProfit <= 0 {
+				return fmt.Errorf("invalid new_take_profit for %s: %.4f", d.Symbol, d.NewTakeProfit)
 			}
-			s.History.AddRecord(rec)
+			o.TakeProfit = d.NewTakeProfit
 		}
+		s.stops[key] = o
 	}
 
+	s.revalueAccount()
 	return nil
 }
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// 仓位计算模式
+const (
+	SizingModeAI              = "ai"               // 沿用 AI 给出的 position_size_usd / position_percent（默认）
+	SizingModeFixedFractional = "fixed_fractional" // 按止损距离使单笔风险 = risk_pct × 净值
+	SizingModeVolTarget       = "vol_target"       // 1 倍 ATR 波动对应 target_vol_pct × 净值，仓位与波动率成反比
+	SizingModeKelly           = "kelly"            // 按最近交易统计计算分数 Kelly 风险比例，再按止损距离换算仓位
+)
+
+// convictionFloor 开启 conviction_scaling 时校准后 confidence = 0 对应的仓位系数（见 confidence_scaling 规则）
+const convictionFloor = 0.5
+
+// SizingConfig 后端仓位计算配置（为空或 mode 为 "ai" 时仓位由 AI 决定）
+type SizingConfig struct {
+	Mode string `json:"mode"`
+
+	RiskPct float64 `json:"risk_pct"` // fixed_fractional：单笔风险占净值比例，如 0.01 = 1%
+
+	TargetVolPct float64 `json:"target_vol_pct"` // vol_target：1 倍 ATR 波动对应的净值比例，如 0.005 = 0.5%
+	VolTimeframe string  `json:"vol_timeframe"`  // vol_target：ATR 周期，为空时使用 stop_atr_timeframe
+
+	KellyFraction   float64 `json:"kelly_fraction"`     // 分数 Kelly 系数，默认 0.25
+	KellyMaxRiskPct float64 `json:"kelly_max_risk_pct"` // Kelly 风险比例上限，默认 0.02
+	KellyMinRiskPct float64 `json:"kelly_min_risk_pct"` // Kelly 为负（近期无优势）时的最小试探风险比例，默认 0.0025
+	KellyLookback   int     `json:"kelly_lookback"`     // 统计最近 N 笔平仓，默认 50
+	KellyMinTrades  int     `json:"kelly_min_trades"`   // 样本不足时按 risk_pct 计算，默认 20
+
+	ConvictionScaling bool `json:"conviction_scaling"` // 按校准后的 confidence 线性缩放仓位（0.5 ~ 1.0），对 ai 模式同样生效
+}
+
+// sizingResult 后端仓位计算结果
+type sizingResult struct {
+	SizeUSD float64
+	Detail  string
+}
+
+// normalizedConfidence 将 0-1 或 0-100 的 confidence 归一化到 0-1；未给出时返回 1
+func normalizedConfidence(c float64) float64 {
+	if c <= 0 {
+		return 1
+	}
+	if c > 1 {
+		c /= 100
+	}
+	return math.Min(c, 1)
+}
+
+// sizingStopDistance 用于仓位计算的止损距离（价格）：不小于 atr_stop 规则要求的 ATR 倍数距离，
+// 未给止损时与 atr_stop 自动设置的距离一致
+func sizingStopDistance(d *Decision, md *MarketData, cfg RiskConfig) float64 {
+	dist := 0.0
+	if d.StopLoss > 0 {
+		dist = math.Abs(md.CurrentPrice - d.StopLoss)
+	}
+	if cfg.StopLossATRMultiple > 0 {
+		if atr, _ := stopATR(md, cfg.StopATRTimeframe); atr > 0 {
+			dist = math.Max(dist, atr*cfg.StopLossATRMultiple)
+		}
+	}
+	return dist
+}
+
+// kellyRiskPct 根据 storage 中最近交易统计计算分数 Kelly 风险比例：f* = W - (1-W)/R，R = 平均盈利 / 平均亏损
+func kellyRiskPct(sc SizingConfig, storage *Storage) (float64, string, bool) {
+	if storage == nil {
+		return 0, "", false
+	}
+	lookback := sc.KellyLookback
+	if lookback <= 0 {
+		lookback = 50
+	}
+	minTrades := sc.KellyMinTrades
+	if minTrades <= 0 {
+		minTrades = 20
+	}
+	stats, err := storage.GetRecentTradeStats(lookback)
+	if err != nil {
+		return 0, "", false
+	}
+	total, _ := stats["total_trades"].(int)
+	avgWin, _ := stats["avg_win"].(float64)
+	avgLoss, _ := stats["avg_loss"].(float64)
+	winRate, _ := stats["win_rate"].(float64)
+	if total < minTrades || avgWin <= 0 || avgLoss >= 0 {
+		return 0, "", false
+	}
+
+	w := winRate / 100
+	r := avgWin / -avgLoss
+	kelly := w - (1-w)/r
+
+	fraction := sc.KellyFraction
+	if fraction <= 0 {
+		fraction = 0.25
+	}
+	maxRisk := sc.KellyMaxRiskPct
+	if maxRisk <= 0 {
+		maxRisk = 0.02
+	}
+	minRisk := sc.KellyMinRiskPct
+	if minRisk <= 0 {
+		minRisk = 0.0025
+	}
+	riskPct := math.Min(math.Max(kelly*fraction, minRisk), maxRisk)
+	return riskPct, fmt.Sprintf("Kelly f*=%.3f (胜率 %.0f%%, 盈亏比 %.2f, %d 笔) × %.2f", kelly, winRate, r, total, fraction), true
+}
+
+// computeBackendSize 按策略的 sizing 配置计算名义仓位；返回 false 表示沿用 AI 给出的仓位。
+// storage 提供 Kelly 模式所需的交易统计
+func computeBackendSize(d *Decision, md *MarketData, equity float64, cfg RiskConfig, storage *Storage) (sizingResult, bool, error) {
+	sc := cfg.Sizing
+	if sc == nil || sc.Mode == "" || sc.Mode == SizingModeAI {
+		return sizingResult{}, false, nil
+	}
+	if md == nil || md.CurrentPrice <= 0 || equity <= 0 {
+		return sizingResult{}, false, fmt.Errorf("缺少行情或净值，无法按 %s 计算仓位", sc.Mode)
+	}
+	entry := md.CurrentPrice
+
+	var res sizingResult
+	switch sc.Mode {
+	case SizingModeFixedFractional, SizingModeKelly:
+		riskPct, detail := sc.RiskPct, ""
+		if sc.Mode == SizingModeKelly {
+			if k, kd, ok := kellyRiskPct(*sc, storage); ok {
+				riskPct, detail = k, kd
+			} else {
+				detail = "交易样本不足，按 risk_pct"
+			}
+		}
+		if riskPct <= 0 {
+			riskPct = 0.01
+		}
+		dist := sizingStopDistance(d, md, cfg)
+		if dist <= 0 {
+			return res, false, fmt.Errorf("缺少止损和 ATR，无法按止损距离计算仓位")
+		}
+		res.SizeUSD = equity * riskPct * entry / dist
+		res.Detail = fmt.Sprintf("%s: 风险 %.2f%% 净值 / 止损距离 %.2f%%", sc.Mode, riskPct*100, dist/entry*100)
+		if detail != "" {
+			res.Detail += "（" + detail + "）"
+		}
+	case SizingModeVolTarget:
+		target := sc.TargetVolPct
+		if target <= 0 {
+			target = 0.005
+		}
+		tf := sc.VolTimeframe
+		if tf == "" {
+			tf = cfg.StopATRTimeframe
+		}
+		atr, usedTF := stopATR(md, tf)
+		if atr <= 0 {
+			return res, false, fmt.Errorf("缺少 ATR 数据，无法按波动率计算仓位")
+		}
+		res.SizeUSD = equity * target * entry / atr
+		res.Detail = fmt.Sprintf("vol_target: 1×ATR(%s)=%.2f%% 对应 %.2f%% 净值", usedTF, atr/entry*100, target*100)
+	default:
+		return res, false, fmt.Errorf("未知仓位模式: %s", sc.Mode)
+	}
+	return res, true, nil
+}
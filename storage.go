@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"math"
 	"os"
 	"path/filepath"
 	"sort"
@@ -33,6 +34,11 @@ type ConfigSnapshot struct {
 	Timestamp  time.Time `json:"timestamp"`
 	ConfigJSON string    `json:"config_json"`
 	Reason     string    `json:"reason"`
+
+	// 配置版本快照（见 config_version.go）：版本号、配置哈希与策略 prompt 模板哈希
+	Version    string `json:"version,omitempty"`
+	ConfigHash string `json:"config_hash,omitempty"`
+	PromptHash string `json:"prompt_hash,omitempty"`
 }
 
 // EquitySnapshot 净值快照
@@ -52,6 +58,13 @@ type AIDecisionRecord struct {
 	DecisionsJSON string    `json:"decisions_json"`
 	SystemPrompt  string    `json:"system_prompt"`
 	UserPrompt    string    `json:"user_prompt"`
+	// 被风控调整（缩仓 / 改写 / 拒绝）的决策数，明细见 DecisionsJSON 中各决策的 risk_adjustments
+	RiskAdjustedCount int `json:"risk_adjusted_count"`
+	// 决策时生效的配置版本与策略 prompt 模板哈希
+	ConfigVersion string `json:"config_version,omitempty"`
+	PromptHash    string `json:"prompt_hash,omitempty"`
+	// 本次模型调用的 token、耗时与成本
+	Usage *ModelUsage `json:"usage,omitempty"`
 }
 
 // NewStorage 创建存储实例
@@ -225,10 +238,70 @@ func (s *Storage) SaveTradeRecord(record TradeRecord) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	// 主循环每个周期都会传入完整的交易历史，已保存过的记录直接跳过，避免重复统计胜率 / Kelly。
+	// 比较时忽略版本标记：同一笔记录再次传入时标记可能已不同
+	key := unstampedTrade(record)
+	for i := len(s.data.TradeRecords) - 1; i >= 0; i-- {
+		if unstampedTrade(s.data.TradeRecords[i]) == key {
+			return nil
+		}
+	}
+
+	// 首次写入时关联开仓决策并计算 R 倍数（加仓时以最近一次开仓的止损计算）
+	if record.OpenDecisionID == 0 {
+		if open, ok := s.lastOpenDecisionLocked(record.Symbol, record.Side, tradeRecordTime(record)); ok {
+			record.OpenDecisionID = open.DecisionID
+			record.RMultiple = tradeRMultiple(record, open.Decision.StopLoss)
+		}
+	}
+
 	s.data.TradeRecords = append(s.data.TradeRecords, record)
 	return s.save()
 }
 
+// unstampedTrade 去掉配置版本与开仓决策标记，用于去重比较
+func unstampedTrade(r TradeRecord) TradeRecord {
+	r.ConfigVersion, r.PromptHash = "", ""
+	r.OpenDecisionID, r.RMultiple = 0, 0
+	return r
+}
+
+// writeJSONFile 将 v 序列化为缩进 JSON 写入 path，所在目录不存在时先创建；各组件的状态文件都通过它持久化
+func writeJSONFile(path string, v interface{}) error {
+	if dir := filepath.Dir(path); dir != "." && dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// tradeRecordTime 解析带日期的平仓时间；只有时分秒（实时平仓记录）时返回零值，表示不限制开仓决策时间
+func tradeRecordTime(r TradeRecord) time.Time {
+	t, err := time.ParseInLocation("2006-01-02 15:04:05", r.Time, time.Local)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// tradeRMultiple (平仓价 - 入场价) / 初始止损距离，按方向取符号；缺少价格或止损时返回 0
+func tradeRMultiple(r TradeRecord, stop float64) float64 {
+	risk := math.Abs(r.EntryPrice - stop)
+	if r.EntryPrice <= 0 || r.ExitPrice <= 0 || stop <= 0 || risk <= 0 {
+		return 0
+	}
+	dir := 1.0
+	if normalizePositionSide(r.Side) == "short" {
+		dir = -1.0
+	}
+	return dir * (r.ExitPrice - r.EntryPrice) / risk
+}
+
 // GetTradeRecords 获取交易记录（分页）
 func (s *Storage) GetTradeRecords(limit, offset int) ([]TradeRecord, int, error) {
 	s.mu.RLock()
@@ -291,12 +364,22 @@ func (s *Storage) GetTradeRecordsBySymbol(symbol string, limit int) ([]TradeReco
 
 // GetTradeStats 获取交易统计
 func (s *Storage) GetTradeStats() (map[string]interface{}, error) {
+	return s.GetRecentTradeStats(0)
+}
+
+// GetRecentTradeStats 获取最近 limit 笔平仓的交易统计（limit <= 0 表示全部），用于滚动统计
+func (s *Storage) GetRecentTradeStats(limit int) (map[string]interface{}, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
+	records := s.data.TradeRecords
+	if limit > 0 && len(records) > limit {
+		records = records[len(records)-limit:]
+	}
+
 	stats := make(map[string]interface{})
 
-	totalTrades := len(s.data.TradeRecords)
+	totalTrades := len(records)
 	stats["total_trades"] = totalTrades
 
 	winTrades := 0
@@ -304,16 +387,20 @@ func (s *Storage) GetTradeStats() (map[string]interface{}, error) {
 	totalPnL := 0.0
 	maxWin := 0.0
 	maxLoss := 0.0
+	grossWin := 0.0
+	grossLoss := 0.0
 
-	for _, r := range s.data.TradeRecords {
+	for _, r := range records {
 		totalPnL += r.PnL
 		if r.PnL > 0 {
 			winTrades++
+			grossWin += r.PnL
 			if r.PnL > maxWin {
 				maxWin = r.PnL
 			}
 		} else if r.PnL < 0 {
 			loseTrades++
+			grossLoss += r.PnL
 			if r.PnL < maxLoss {
 				maxLoss = r.PnL
 			}
@@ -334,6 +421,16 @@ func (s *Storage) GetTradeStats() (map[string]interface{}, error) {
 		stats["avg_pnl"] = 0.0
 	}
 
+	// 平均盈利 / 平均亏损（亏损为负数）
+	stats["avg_win"] = 0.0
+	stats["avg_loss"] = 0.0
+	if winTrades > 0 {
+		stats["avg_win"] = grossWin / float64(winTrades)
+	}
+	if loseTrades > 0 {
+		stats["avg_loss"] = grossLoss / float64(loseTrades)
+	}
+
 	return stats, nil
 }
 
@@ -350,6 +447,13 @@ func (s *Storage) SaveAIDecision(decision *FullDecision) error {
 
 	decisionsJSON, _ := json.Marshal(decision.Decisions)
 
+	adjusted := 0
+	for _, d := range decision.Decisions {
+		if len(d.RiskAdjustments) > 0 {
+			adjusted++
+		}
+	}
+
 	record := AIDecisionRecord{
 		ID:            s.getNextID(),
 		Timestamp:     decision.Timestamp,
@@ -357,12 +461,79 @@ func (s *Storage) SaveAIDecision(decision *FullDecision) error {
 		DecisionsJSON: string(decisionsJSON),
 		SystemPrompt:  decision.SystemPrompt,
 		UserPrompt:    decision.UserPrompt,
+
+		RiskAdjustedCount: adjusted,
+		ConfigVersion:     decision.ConfigVersion,
+		PromptHash:        decision.PromptHash,
+		Usage:             decision.Usage,
 	}
 
 	s.data.AIDecisions = append(s.data.AIDecisions, record)
 	return s.save()
 }
 
+// maxOpenDecisionScan 查找开仓决策时最多回溯的决策记录数
+const maxOpenDecisionScan = 500
+
+// OpenDecision 一次执行成功的开仓决策
+type OpenDecision struct {
+	DecisionID int64     `json:"decision_id"`
+	Timestamp  time.Time `json:"timestamp"`
+	Decision   Decision  `json:"decision"`
+	// 开仓决策时生效的配置版本与 prompt 哈希
+	ConfigVersion string `json:"config_version,omitempty"`
+	PromptHash    string `json:"prompt_hash,omitempty"`
+}
+
+// LastOpenDecision 查找 symbol + side 持仓腿最近一次执行成功的开仓决策；before 非零时只查该时间之前的决策
+func (s *Storage) LastOpenDecision(symbol, side string, before time.Time) (OpenDecision, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.lastOpenDecisionLocked(symbol, side, before)
+}
+
+func (s *Storage) lastOpenDecisionLocked(symbol, side string, before time.Time) (OpenDecision, bool) {
+	side = normalizePositionSide(side)
+	if side == "" {
+		return OpenDecision{}, false
+	}
+	stop := len(s.data.AIDecisions) - maxOpenDecisionScan
+	for i := len(s.data.AIDecisions) - 1; i >= 0 && i >= stop; i-- {
+		rec := s.data.AIDecisions[i]
+		if !before.IsZero() && rec.Timestamp.After(before) {
+			continue
+		}
+		var decisions []Decision
+		if err := json.Unmarshal([]byte(rec.DecisionsJSON), &decisions); err != nil {
+			continue
+		}
+		for _, d := range decisions {
+			if d.Symbol == symbol && d.ExecStatus == "success" && d.Action == "open_"+side {
+				return OpenDecision{DecisionID: rec.ID, Timestamp: rec.Timestamp, Decision: d, ConfigVersion: rec.ConfigVersion, PromptHash: rec.PromptHash}, true
+			}
+		}
+	}
+	return OpenDecision{}, false
+}
+
+// GetRiskAdjustmentStats 汇总历史决策的风控调整情况，用于观察 AI 仓位被风控覆盖的频率
+func (s *Storage) GetRiskAdjustmentStats() (*RiskAdjustmentStats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stats := &RiskAdjustmentStats{ByRule: make(map[string]int)}
+	for _, rec := range s.data.AIDecisions {
+		var decisions []Decision
+		if err := json.Unmarshal([]byte(rec.DecisionsJSON), &decisions); err != nil {
+			continue
+		}
+		for _, d := range decisions {
+			stats.Add(d)
+		}
+	}
+	return stats, nil
+}
+
 // GetAIDecisions 获取AI决策历史
 func (s *Storage) GetAIDecisions(limit int) ([]AIDecisionRecord, error) {
 	s.mu.RLock()
@@ -392,6 +563,20 @@ func (s *Storage) GetAIDecisions(limit int) ([]AIDecisionRecord, error) {
 
 // SaveConfigSnapshot 保存配置快照
 func (s *Storage) SaveConfigSnapshot(config interface{}, reason string) error {
+	return s.saveConfigSnapshot(ConfigSnapshot{Reason: reason}, config)
+}
+
+// SaveConfigVersion 保存带版本号的配置快照
+func (s *Storage) SaveConfigVersion(version, configHash, promptHash string, config interface{}, reason string) error {
+	return s.saveConfigSnapshot(ConfigSnapshot{
+		Reason:     reason,
+		Version:    version,
+		ConfigHash: configHash,
+		PromptHash: promptHash,
+	}, config)
+}
+
+func (s *Storage) saveConfigSnapshot(snapshot ConfigSnapshot, config interface{}) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -400,17 +585,85 @@ func (s *Storage) SaveConfigSnapshot(config interface{}, reason string) error {
 		return err
 	}
 
-	snapshot := ConfigSnapshot{
-		ID:         s.getNextID(),
-		Timestamp:  time.Now(),
-		ConfigJSON: string(configJSON),
-		Reason:     reason,
-	}
+	snapshot.ID = s.getNextID()
+	snapshot.Timestamp = time.Now()
+	snapshot.ConfigJSON = string(configJSON)
 
 	s.data.ConfigSnapshots = append(s.data.ConfigSnapshots, snapshot)
 	return s.save()
 }
 
+// GetConfigVersions 获取带版本号的配置快照（按时间倒序，limit <= 0 表示全部）
+func (s *Storage) GetConfigVersions(limit int) []ConfigSnapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var versions []ConfigSnapshot
+	for i := len(s.data.ConfigSnapshots) - 1; i >= 0; i-- {
+		if snap := s.data.ConfigSnapshots[i]; snap.Version != "" {
+			versions = append(versions, snap)
+			if limit > 0 && len(versions) >= limit {
+				break
+			}
+		}
+	}
+	return versions
+}
+
+// ConfigVersionStats 单个配置版本下的决策与交易统计
+type ConfigVersionStats struct {
+	Decisions   int     `json:"decisions"`
+	Trades      int     `json:"trades"`
+	WinTrades   int     `json:"win_trades"`
+	LoseTrades  int     `json:"lose_trades"`
+	WinRate     float64 `json:"win_rate"`
+	TotalPnL    float64 `json:"total_pnl"`
+	AvgPnL      float64 `json:"avg_pnl"`
+	GrossProfit float64 `json:"gross_profit"`
+	GrossLoss   float64 `json:"gross_loss"`
+}
+
+// GetConfigVersionStats 按配置版本汇总 AI 决策数与交易表现；未标记版本的记录归入 "unversioned"
+func (s *Storage) GetConfigVersionStats() map[string]*ConfigVersionStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stats := make(map[string]*ConfigVersionStats)
+	get := func(version string) *ConfigVersionStats {
+		if version == "" {
+			version = "unversioned"
+		}
+		st, ok := stats[version]
+		if !ok {
+			st = &ConfigVersionStats{}
+			stats[version] = st
+		}
+		return st
+	}
+	for _, d := range s.data.AIDecisions {
+		get(d.ConfigVersion).Decisions++
+	}
+	for _, r := range s.data.TradeRecords {
+		st := get(r.ConfigVersion)
+		st.Trades++
+		st.TotalPnL += r.PnL
+		if r.PnL > 0 {
+			st.WinTrades++
+			st.GrossProfit += r.PnL
+		} else if r.PnL < 0 {
+			st.LoseTrades++
+			st.GrossLoss += r.PnL
+		}
+	}
+	for _, st := range stats {
+		if st.Trades > 0 {
+			st.WinRate = float64(st.WinTrades) / float64(st.Trades) * 100
+			st.AvgPnL = st.TotalPnL / float64(st.Trades)
+		}
+	}
+	return stats
+}
+
 // ===== 数据清理 =====
 
 // CleanOldData 清理旧数据
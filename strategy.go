@@ -6,26 +6,64 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 )
 
 // RiskConfig 风险配置
 type RiskConfig struct {
-	MaxRiskPerTrade     float64 `json:"max_risk_per_trade"`      // 单笔最大风险比例
-	MaxTotalRisk        float64 `json:"max_total_risk"`          // 总风险上限
-	MinRiskRewardRatio  float64 `json:"min_risk_reward_ratio"`   // 最小风险回报比
-	FixedLeverage       int     `json:"fixed_leverage"`          // 固定杠杆
-	MaxMarginUsage      float64 `json:"max_margin_usage"`        // 最大保证金使用率
-	StopLossATRMultiple float64 `json:"stop_loss_atr_multiple"`  // 止损 ATR 倍数
+	MaxRiskPerTrade     float64 `json:"max_risk_per_trade"`     // 单笔最大风险比例
+	MaxTotalRisk        float64 `json:"max_total_risk"`         // 总风险上限
+	MinRiskRewardRatio  float64 `json:"min_risk_reward_ratio"`  // 最小风险回报比
+	FixedLeverage       int     `json:"fixed_leverage"`         // 固定杠杆
+	MaxMarginUsage      float64 `json:"max_margin_usage"`       // 最大保证金使用率
+	StopLossATRMultiple float64 `json:"stop_loss_atr_multiple"` // 止损 ATR 倍数
+	StopATRTimeframe    string  `json:"stop_atr_timeframe"`     // 止损 ATR 周期: "5m" / "15m" / "1h"（也接受 "ATR14_15m"），默认 15m
+	TightStopAction     string  `json:"tight_stop_action"`      // 止损过近时: "widen"（放宽，默认）/ "reject"（拒绝）
+
+	// 组合敞口上限（以净值倍数表示，含现有持仓与本轮新开仓；0 表示不限制）
+	MaxNetExposure        float64 `json:"max_net_exposure"`        // 净多 / 净空名义敞口上限
+	MaxSectorExposure     float64 `json:"max_sector_exposure"`     // 单板块总名义敞口上限
+	MaxCorrelatedExposure float64 `json:"max_correlated_exposure"` // 相关性调整后的等效敞口上限
+
+	// 开仓频率限制（0 表示不限制，见 entry_guard.go）
+	MaxOpenPositions        int `json:"max_open_positions"`           // 同时持有的最大持仓数（按 币种+方向 计）
+	SymbolCooldownMinutes   int `json:"symbol_cooldown_minutes"`      // 止损出局后同币种的冷却时间
+	MaxOpensPerSymbolPerDay int `json:"max_opens_per_symbol_per_day"` // 单币种每日（UTC）最多开仓次数
+	NoFlipMinutes           int `json:"no_flip_minutes"`              // 反方向开 / 平仓后 N 分钟内禁止反手
+
+	// 资金费率保护（仅作用于需要支付资金费的一方；0 表示不启用，见 funding.go）
+	FundingShrinkRate   float64 `json:"funding_shrink_rate"`   // 单次费率绝对值 ≥ 该值时新开仓减半，如 0.0005 = 0.05%
+	FundingBlockRate    float64 `json:"funding_block_rate"`    // 单次费率绝对值 ≥ 该值时禁止开仓
+	FundingAvoidMinutes int     `json:"funding_avoid_minutes"` // 距下次结算不足 N 分钟时禁止开仓
+	FundingHoldHours    float64 `json:"funding_hold_hours"`    // 预期持仓时长，用于在风险回报比中扣除资金费成本
+
+	// Sizing 后端仓位计算模式（fixed_fractional / vol_target / kelly），为空时仓位由 AI 决定
+	Sizing *SizingConfig `json:"sizing,omitempty"`
+
+	// PositionRules 后端持仓管理规则（保本 / 回撤收紧 / 时间止损 / 硬止损），为空时使用 DefaultPositionRules
+	PositionRules *PositionRulesConfig `json:"position_rules,omitempty"`
+
+	// Rules 风控规则流水线配置：按名称开关 / 调参内置规则，或追加已注册的自定义规则。
+	// 为空时使用默认流水线（见 defaultRiskPipeline）。
+	Rules []RiskRuleConfig `json:"rules,omitempty"`
 }
 
 // Strategy 策略模板
 type Strategy struct {
 	Name        string     `json:"name"`
 	Description string     `json:"description"`
-	PromptFile  string     `json:"prompt_file"`   // prompt 模板文件路径
-	Symbols     []string   `json:"symbols"`       // 可选：覆盖默认交易对
+	PromptFile  string     `json:"prompt_file"` // prompt 模板文件路径
+	Symbols     []string   `json:"symbols"`     // 可选：覆盖默认交易对
 	RiskParams  RiskConfig `json:"risk_params"`
 	Active      bool       `json:"active"`
+
+	// Memory 交易记忆：在 user prompt 中加入最近平仓、连胜 / 连败和持仓的开仓理由，为空或未启用时不加入
+	Memory *TradeMemoryConfig `json:"memory,omitempty"`
+
+	// Extends 仅用于策略文件：以指定内置策略为基础，文件中出现的字段覆盖基础值
+	Extends string `json:"extends,omitempty"`
+	// Source 策略来源：builtin / api / file（由 StrategyManager 设置）
+	Source string `json:"source,omitempty"`
 }
 
 // DefaultStrategies 内置策略列表
@@ -35,12 +73,33 @@ var DefaultStrategies = []Strategy{
 		Description: "中等风险，稳健交易",
 		PromptFile:  "balanced.md",
 		RiskParams: RiskConfig{
-			MaxRiskPerTrade:     0.25,
-			MaxTotalRisk:        0.40,
-			MinRiskRewardRatio:  2.0,
-			FixedLeverage:       15,
-			MaxMarginUsage:      0.70,
-			StopLossATRMultiple: 1.8,
+			MaxRiskPerTrade:         0.25,
+			MaxTotalRisk:            0.40,
+			MinRiskRewardRatio:      2.0,
+			FixedLeverage:           15,
+			MaxMarginUsage:          0.70,
+			StopLossATRMultiple:     1.8,
+			StopATRTimeframe:        "15m",
+			MaxNetExposure:          10,
+			MaxSectorExposure:       6,
+			MaxCorrelatedExposure:   8,
+			MaxOpenPositions:        5,
+			SymbolCooldownMinutes:   60,
+			MaxOpensPerSymbolPerDay: 3,
+			NoFlipMinutes:           30,
+			FundingShrinkRate:       0.0005,
+			FundingBlockRate:        0.001,
+			FundingAvoidMinutes:     10,
+			FundingHoldHours:        8,
+			PositionRules: &PositionRulesConfig{
+				BreakevenAtR:       1.0,
+				BreakevenOffsetPct: 0.05,
+				TrailActivatePct:   20,
+				TrailRetracePct:    0.5,
+				TrailLockPct:       0.4,
+				HardStopMajorPct:   30,
+				HardStopAltPct:     25,
+			},
 		},
 	},
 	{
@@ -48,12 +107,33 @@ var DefaultStrategies = []Strategy{
 		Description: "高风险高收益",
 		PromptFile:  "aggressive.md",
 		RiskParams: RiskConfig{
-			MaxRiskPerTrade:     0.50,
-			MaxTotalRisk:        0.50,
-			MinRiskRewardRatio:  1.8,
-			FixedLeverage:       30,
-			MaxMarginUsage:      0.95,
-			StopLossATRMultiple: 1.5,
+			MaxRiskPerTrade:         0.50,
+			MaxTotalRisk:            0.50,
+			MinRiskRewardRatio:      1.8,
+			FixedLeverage:           30,
+			MaxMarginUsage:          0.95,
+			StopLossATRMultiple:     1.5,
+			StopATRTimeframe:        "15m",
+			MaxNetExposure:          25,
+			MaxSectorExposure:       15,
+			MaxCorrelatedExposure:   20,
+			MaxOpenPositions:        8,
+			SymbolCooldownMinutes:   30,
+			MaxOpensPerSymbolPerDay: 5,
+			NoFlipMinutes:           15,
+			FundingShrinkRate:       0.001,
+			FundingBlockRate:        0.002,
+			FundingAvoidMinutes:     5,
+			FundingHoldHours:        8,
+			PositionRules: &PositionRulesConfig{
+				BreakevenAtR:       1.5,
+				BreakevenOffsetPct: 0.05,
+				TrailActivatePct:   30,
+				TrailRetracePct:    0.5,
+				TrailLockPct:       0.4,
+				HardStopMajorPct:   30,
+				HardStopAltPct:     25,
+			},
 		},
 	},
 	{
@@ -61,12 +141,34 @@ var DefaultStrategies = []Strategy{
 		Description: "低风险稳定收益",
 		PromptFile:  "conservative.md",
 		RiskParams: RiskConfig{
-			MaxRiskPerTrade:     0.10,
-			MaxTotalRisk:        0.30,
-			MinRiskRewardRatio:  2.5,
-			FixedLeverage:       5,
-			MaxMarginUsage:      0.50,
-			StopLossATRMultiple: 2.0,
+			MaxRiskPerTrade:         0.10,
+			MaxTotalRisk:            0.30,
+			MinRiskRewardRatio:      2.5,
+			FixedLeverage:           5,
+			MaxMarginUsage:          0.50,
+			StopLossATRMultiple:     2.0,
+			StopATRTimeframe:        "1h",
+			MaxNetExposure:          3,
+			MaxSectorExposure:       2,
+			MaxCorrelatedExposure:   2.5,
+			MaxOpenPositions:        3,
+			SymbolCooldownMinutes:   120,
+			MaxOpensPerSymbolPerDay: 2,
+			NoFlipMinutes:           60,
+			FundingShrinkRate:       0.0003,
+			FundingBlockRate:        0.0008,
+			FundingAvoidMinutes:     15,
+			FundingHoldHours:        24,
+			PositionRules: &PositionRulesConfig{
+				BreakevenAtR:       1.0,
+				BreakevenOffsetPct: 0.05,
+				TrailActivatePct:   15,
+				TrailRetracePct:    0.4,
+				TrailLockPct:       0.5,
+				MaxHoldingMinutes:  24 * 60,
+				HardStopMajorPct:   20,
+				HardStopAltPct:     15,
+			},
 		},
 	},
 	{
@@ -74,23 +176,50 @@ var DefaultStrategies = []Strategy{
 		Description: "超短线快进快出",
 		PromptFile:  "scalping.md",
 		RiskParams: RiskConfig{
-			MaxRiskPerTrade:     0.05,
-			MaxTotalRisk:        0.20,
-			MinRiskRewardRatio:  1.5,
-			FixedLeverage:       20,
-			MaxMarginUsage:      0.30,
-			StopLossATRMultiple: 0.8,
+			MaxRiskPerTrade:         0.05,
+			MaxTotalRisk:            0.20,
+			MinRiskRewardRatio:      1.5,
+			FixedLeverage:           20,
+			MaxMarginUsage:          0.30,
+			StopLossATRMultiple:     0.8,
+			StopATRTimeframe:        "5m",
+			MaxNetExposure:          8,
+			MaxSectorExposure:       5,
+			MaxCorrelatedExposure:   6,
+			MaxOpenPositions:        4,
+			SymbolCooldownMinutes:   15,
+			MaxOpensPerSymbolPerDay: 8,
+			NoFlipMinutes:           10,
+			FundingShrinkRate:       0.0005,
+			FundingBlockRate:        0.001,
+			FundingAvoidMinutes:     15,
+			FundingHoldHours:        2,
+			PositionRules: &PositionRulesConfig{
+				BreakevenAtR:       0.8,
+				BreakevenOffsetPct: 0.05,
+				TrailActivatePct:   10,
+				TrailRetracePct:    0.4,
+				TrailLockPct:       0.5,
+				MaxHoldingMinutes:  120,
+				HardStopMajorPct:   20,
+				HardStopAltPct:     15,
+			},
 		},
 	},
 }
 
 // StrategyManager 策略管理器
 type StrategyManager struct {
-	mu              sync.RWMutex
-	strategies      map[string]*Strategy
-	activeStrategy  string
-	strategiesDir   string
-	defaultPrompt   string // 默认 prompt 文件路径
+	mu             sync.RWMutex
+	strategies     map[string]*Strategy
+	activeStrategy string
+	strategiesDir  string
+	defaultPrompt  string // 默认 prompt 文件路径
+
+	added map[string]*Strategy     // 通过 AddStrategy 添加的策略
+	files map[string]*strategyFile // 策略目录中的 JSON 文件（key: 路径），见 strategy_files.go
+
+	promptModTimes map[string]time.Time // prompt 模板文件修改时间，修改后重新校验模板
 }
 
 // NewStrategyManager 创建策略管理器
@@ -99,22 +228,26 @@ func NewStrategyManager(strategiesDir string) *StrategyManager {
 		strategies:    make(map[string]*Strategy),
 		strategiesDir: strategiesDir,
 		defaultPrompt: "extracted_prompts.md",
-	}
+		added:         make(map[string]*Strategy),
+		files:         make(map[string]*strategyFile),
 
-	// 初始化默认策略
-	for i := range DefaultStrategies {
-		s := &DefaultStrategies[i]
-		sm.strategies[s.Name] = s
+		promptModTimes: make(map[string]time.Time),
 	}
 
 	// 设置默认活跃策略
 	sm.activeStrategy = "balanced"
 
+	// 初始化默认策略
+	sm.rebuildLocked()
+
 	// 确保策略目录存在
 	if err := os.MkdirAll(strategiesDir, 0755); err != nil {
 		log.Printf("Warning: Failed to create strategies directory: %v", err)
 	}
 
+	// 加载策略目录中的 JSON 策略文件
+	sm.Reload()
+
 	return sm
 }
 
@@ -187,8 +320,13 @@ func (sm *StrategyManager) AddStrategy(s Strategy) error {
 	if s.Name == "" {
 		return fmt.Errorf("strategy name cannot be empty")
 	}
+	if err := validateStrategy(&s, sm.strategiesDir); err != nil {
+		return err
+	}
 
-	sm.strategies[s.Name] = &s
+	s.Source = StrategySourceAPI
+	sm.added[s.Name] = &s
+	sm.rebuildLocked()
 	log.Printf("✅ 添加策略: %s", s.Name)
 	return nil
 }
@@ -210,30 +348,47 @@ func (sm *StrategyManager) RemoveStrategy(name string) error {
 		}
 	}
 
-	delete(sm.strategies, name)
+	// 文件定义的策略需删除对应文件
+	if s, ok := sm.strategies[name]; ok && s.Source == StrategySourceFile {
+		return fmt.Errorf("strategy %s is defined in a file under %s, delete the file instead", name, sm.strategiesDir)
+	}
+
+	delete(sm.added, name)
+	sm.rebuildLocked()
 	log.Printf("✅ 删除策略: %s", name)
 	return nil
 }
 
-// GetPromptContent 获取策略的 prompt 内容
-func (sm *StrategyManager) GetPromptContent() (string, error) {
-	strategy := sm.GetActiveStrategy()
+// RenderPrompt 读取策略的 prompt 文件并按 Go 模板渲染；strategy 为 nil 时使用当前策略。
+// 策略 prompt 读取或渲染失败时回退到默认 prompt。
+func (sm *StrategyManager) RenderPrompt(strategy *Strategy, data PromptTemplateData) (string, error) {
+	if strategy == nil {
+		strategy = sm.GetActiveStrategy()
+	}
 	if strategy == nil || strategy.PromptFile == "" {
 		// 使用默认 prompt
-		return sm.readPromptFile(sm.defaultPrompt)
+		return sm.renderPromptFile(sm.defaultPrompt, data)
 	}
 
-	// 尝试读取策略专用 prompt
+	// 尝试渲染策略专用 prompt
 	promptPath := filepath.Join(sm.strategiesDir, strategy.PromptFile)
-	content, err := sm.readPromptFile(promptPath)
+	content, err := sm.renderPromptFile(promptPath, data)
 	if err != nil {
-		log.Printf("⚠️ 无法读取策略 prompt %s: %v，回退到默认", promptPath, err)
+		log.Printf("⚠️ 无法渲染策略 prompt %s: %v，回退到默认", promptPath, err)
 		// 回退到默认 prompt
-		return sm.readPromptFile(sm.defaultPrompt)
+		return sm.renderPromptFile(sm.defaultPrompt, data)
 	}
 	return content, nil
 }
 
+func (sm *StrategyManager) renderPromptFile(path string, data PromptTemplateData) (string, error) {
+	content, err := sm.readPromptFile(path)
+	if err != nil {
+		return "", err
+	}
+	return renderPromptTemplate(path, content, data)
+}
+
 func (sm *StrategyManager) readPromptFile(path string) (string, error) {
 	content, err := os.ReadFile(path)
 	if err != nil {
@@ -242,6 +397,23 @@ func (sm *StrategyManager) readPromptFile(path string) (string, error) {
 	return string(content), nil
 }
 
+// PromptHash 返回策略 prompt 模板源文件的短哈希；策略文件不可读时与 RenderPrompt 一致回退到默认 prompt
+func (sm *StrategyManager) PromptHash(strategy *Strategy) string {
+	if strategy == nil {
+		strategy = sm.GetActiveStrategy()
+	}
+	if strategy != nil && strategy.PromptFile != "" {
+		if content, err := sm.readPromptFile(filepath.Join(sm.strategiesDir, strategy.PromptFile)); err == nil {
+			return shortHash([]byte(content))
+		}
+	}
+	content, err := sm.readPromptFile(sm.defaultPrompt)
+	if err != nil {
+		return ""
+	}
+	return shortHash([]byte(content))
+}
+
 // GetRiskConfig 获取当前策略的风险配置
 func (sm *StrategyManager) GetRiskConfig() RiskConfig {
 	strategy := sm.GetActiveStrategy()
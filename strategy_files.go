@@ -0,0 +1,390 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 策略来源
+const (
+	StrategySourceBuiltin = "builtin" // DefaultStrategies
+	StrategySourceAPI     = "api"     // 运行时通过 AddStrategy 添加（不持久化）
+	StrategySourceFile    = "file"    // strategies 目录下的 JSON 文件
+)
+
+// strategyNamePattern 策略名称只允许字母、数字、下划线和连字符
+var strategyNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// strategyFile 一个策略 JSON 文件的加载状态
+type strategyFile struct {
+	path     string
+	modTime  time.Time
+	size     int64
+	strategy *Strategy // 最近一次有效的定义；文件无效时保留上一次有效版本
+	err      string    // 最近一次加载错误
+	loadedAt time.Time
+
+	promptErr bool // err 来自 prompt 模板修改后的重新校验（模板修复后清除）
+}
+
+// StrategyFileStatus 策略文件加载状态（用于 Web 展示）
+type StrategyFileStatus struct {
+	Path     string    `json:"path"`
+	Name     string    `json:"name,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	LoadedAt time.Time `json:"loaded_at,omitempty"`
+}
+
+// cloneStrategy 深拷贝策略，避免 extends 时修改内置策略的指针 / 切片字段
+func cloneStrategy(s Strategy) Strategy {
+	s.Symbols = append([]string(nil), s.Symbols...)
+	if s.RiskParams.Sizing != nil {
+		sc := *s.RiskParams.Sizing
+		s.RiskParams.Sizing = &sc
+	}
+	if s.RiskParams.PositionRules != nil {
+		pr := *s.RiskParams.PositionRules
+		s.RiskParams.PositionRules = &pr
+	}
+	if s.RiskParams.Rules != nil {
+		rules := make([]RiskRuleConfig, len(s.RiskParams.Rules))
+		copy(rules, s.RiskParams.Rules)
+		s.RiskParams.Rules = rules
+	}
+	return s
+}
+
+// loadStrategyFile 解析并校验单个策略文件。设置了 extends 时以对应内置策略为基础，文件中的字段覆盖基础值。
+func loadStrategyFile(path, strategiesDir string) (*Strategy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var head struct {
+		Extends string `json:"extends"`
+	}
+	if err := json.Unmarshal(data, &head); err != nil {
+		return nil, fmt.Errorf("JSON 解析失败: %w", err)
+	}
+
+	var s Strategy
+	if head.Extends != "" {
+		base, ok := builtinStrategy(head.Extends)
+		if !ok {
+			return nil, fmt.Errorf("extends 引用了未知的内置策略: %s", head.Extends)
+		}
+		s = cloneStrategy(base)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&s); err != nil {
+		return nil, fmt.Errorf("JSON 解析失败: %w", err)
+	}
+	s.Active = false
+
+	if err := validateStrategy(&s, strategiesDir); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// builtinStrategy 按名称查找内置策略
+func builtinStrategy(name string) (Strategy, bool) {
+	for _, s := range DefaultStrategies {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return Strategy{}, false
+}
+
+// validateStrategy 校验策略定义，并规范化交易对（大写、去重）
+func validateStrategy(s *Strategy, strategiesDir string) error {
+	if s.Name == "" {
+		return fmt.Errorf("name 不能为空")
+	}
+	if !strategyNamePattern.MatchString(s.Name) {
+		return fmt.Errorf("name %q 只能包含字母、数字、下划线和连字符", s.Name)
+	}
+	seen := make(map[string]bool, len(s.Symbols))
+	symbols := make([]string, 0, len(s.Symbols))
+	for _, sym := range s.Symbols {
+		sym = strings.ToUpper(strings.TrimSpace(sym))
+		if sym == "" || strings.ContainsAny(sym, " \t/") {
+			return fmt.Errorf("symbols 中有非法交易对: %q", sym)
+		}
+		if !seen[sym] {
+			seen[sym] = true
+			symbols = append(symbols, sym)
+		}
+	}
+	s.Symbols = symbols
+
+	if err := validateRiskConfig(s.RiskParams); err != nil {
+		return err
+	}
+	if m := s.Memory; m != nil && (m.RecentTrades < 0 || m.StreakWindow < 0 || m.TokenBudget < 0) {
+		return fmt.Errorf("memory 的 recent_trades / streak_window / token_budget 不能为负数")
+	}
+	if s.PromptFile != "" {
+		path := filepath.Join(strategiesDir, s.PromptFile)
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("prompt_file %s 不存在", s.PromptFile)
+		}
+		if err := validatePromptTemplate(path, s); err != nil {
+			return fmt.Errorf("prompt_file %s 模板渲染失败: %w", s.PromptFile, err)
+		}
+	}
+	return nil
+}
+
+// validateRiskConfig 校验风控参数的取值范围
+func validateRiskConfig(rc RiskConfig) error {
+	if rc.MaxRiskPerTrade <= 0 || rc.MaxRiskPerTrade > 1 {
+		return fmt.Errorf("max_risk_per_trade 应在 (0, 1]: %.4f", rc.MaxRiskPerTrade)
+	}
+	if rc.MaxTotalRisk < rc.MaxRiskPerTrade || rc.MaxTotalRisk > 1 {
+		return fmt.Errorf("max_total_risk 应在 [max_risk_per_trade, 1]: %.4f", rc.MaxTotalRisk)
+	}
+	if rc.MaxMarginUsage <= 0 || rc.MaxMarginUsage > 1 {
+		return fmt.Errorf("max_margin_usage 应在 (0, 1]: %.4f", rc.MaxMarginUsage)
+	}
+	if rc.FixedLeverage < 0 || rc.FixedLeverage > 125 {
+		return fmt.Errorf("fixed_leverage 应在 [0, 125]: %d", rc.FixedLeverage)
+	}
+
+	nonNegative := []struct {
+		key string
+		val float64
+	}{
+		{"min_risk_reward_ratio", rc.MinRiskRewardRatio},
+		{"stop_loss_atr_multiple", rc.StopLossATRMultiple},
+		{"max_net_exposure", rc.MaxNetExposure},
+		{"max_sector_exposure", rc.MaxSectorExposure},
+		{"max_correlated_exposure", rc.MaxCorrelatedExposure},
+		{"max_open_positions", float64(rc.MaxOpenPositions)},
+		{"symbol_cooldown_minutes", float64(rc.SymbolCooldownMinutes)},
+		{"max_opens_per_symbol_per_day", float64(rc.MaxOpensPerSymbolPerDay)},
+		{"no_flip_minutes", float64(rc.NoFlipMinutes)},
+		{"funding_shrink_rate", rc.FundingShrinkRate},
+		{"funding_block_rate", rc.FundingBlockRate},
+		{"funding_avoid_minutes", float64(rc.FundingAvoidMinutes)},
+		{"funding_hold_hours", rc.FundingHoldHours},
+	}
+	for _, f := range nonNegative {
+		if f.val < 0 {
+			return fmt.Errorf("%s 不能为负数: %v", f.key, f.val)
+		}
+	}
+	if rc.FundingShrinkRate > 0 && rc.FundingBlockRate > 0 && rc.FundingBlockRate < rc.FundingShrinkRate {
+		return fmt.Errorf("funding_block_rate (%.4f) 不能小于 funding_shrink_rate (%.4f)", rc.FundingBlockRate, rc.FundingShrinkRate)
+	}
+
+	if rc.StopATRTimeframe != "" {
+		tf := normalizeATRTimeframe(rc.StopATRTimeframe)
+		valid := false
+		for _, t := range stopATRTimeframes {
+			if t == tf {
+				valid = true
+			}
+		}
+		if !valid {
+			return fmt.Errorf("stop_atr_timeframe 不支持: %s（可选 %s）", rc.StopATRTimeframe, strings.Join(stopATRTimeframes, " / "))
+		}
+	}
+	switch rc.TightStopAction {
+	case "", tightStopWiden, tightStopReject:
+	default:
+		return fmt.Errorf("tight_stop_action 应为 %s 或 %s: %s", tightStopWiden, tightStopReject, rc.TightStopAction)
+	}
+
+	if sc := rc.Sizing; sc != nil {
+		switch sc.Mode {
+		case "", SizingModeAI, SizingModeFixedFractional, SizingModeVolTarget, SizingModeKelly:
+		default:
+			return fmt.Errorf("sizing.mode 不支持: %s", sc.Mode)
+		}
+		if sc.RiskPct < 0 || sc.RiskPct > 0.1 {
+			return fmt.Errorf("sizing.risk_pct 应在 [0, 0.1]: %.4f", sc.RiskPct)
+		}
+	}
+
+	for _, r := range rc.Rules {
+		if _, ok := getRiskRule(r.Name); !ok {
+			return fmt.Errorf("rules 引用了未注册的风控规则: %q", r.Name)
+		}
+	}
+	return nil
+}
+
+// Reload 扫描策略目录中的 *.json 文件，重新加载有变化的文件（每个周期调用，修改在下一周期生效）。
+// 无效文件只记录错误并保留其上一次有效的定义，不影响正在运行的策略。
+func (sm *StrategyManager) Reload() {
+	entries, err := os.ReadDir(sm.strategiesDir)
+	if err != nil {
+		return
+	}
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	changed := false
+	seen := make(map[string]bool)
+	changedPrompts := make(map[string]bool)
+	for _, e := range entries {
+		if !e.IsDir() && strings.EqualFold(filepath.Ext(e.Name()), ".md") {
+			if info, err := e.Info(); err == nil && !info.ModTime().Equal(sm.promptModTimes[e.Name()]) {
+				sm.promptModTimes[e.Name()] = info.ModTime()
+				changedPrompts[e.Name()] = true
+			}
+			continue
+		}
+		if e.IsDir() || !strings.EqualFold(filepath.Ext(e.Name()), ".json") {
+			continue
+		}
+		path := filepath.Join(sm.strategiesDir, e.Name())
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		seen[path] = true
+
+		prev := sm.files[path]
+		if prev != nil && prev.modTime.Equal(info.ModTime()) && prev.size == info.Size() {
+			continue
+		}
+		changed = true
+
+		f := &strategyFile{path: path, modTime: info.ModTime(), size: info.Size()}
+		s, err := loadStrategyFile(path, sm.strategiesDir)
+		if err != nil {
+			f.err = err.Error()
+			if prev != nil {
+				f.strategy, f.loadedAt = prev.strategy, prev.loadedAt
+			}
+			kept := ""
+			if f.strategy != nil {
+				kept = fmt.Sprintf("，继续使用上一次有效的 %s", f.strategy.Name)
+			}
+			log.Printf("⚠️ [Strategy] 策略文件 %s 无效: %v%s", path, err, kept)
+			if n := GetNotifier(); n != nil {
+				n.NotifyError(fmt.Errorf("策略文件 %s 无效: %v", path, err))
+			}
+		} else {
+			s.Source = StrategySourceFile
+			f.strategy, f.loadedAt = s, time.Now()
+			log.Printf("✅ [Strategy] 已加载策略文件 %s (%s)", path, s.Name)
+		}
+		sm.files[path] = f
+	}
+	for path := range sm.files {
+		if !seen[path] {
+			delete(sm.files, path)
+			changed = true
+			log.Printf("ℹ️ [Strategy] 策略文件 %s 已删除", path)
+		}
+	}
+
+	if changed {
+		sm.rebuildLocked()
+	}
+
+	// prompt 模板有修改时重新校验引用它的策略；文件定义的策略把错误记到对应文件上，便于 FileStatus 展示
+	for _, st := range sm.strategies {
+		if !changedPrompts[st.PromptFile] {
+			continue
+		}
+		f := sm.fileOfLocked(st)
+		err := validatePromptTemplate(filepath.Join(sm.strategiesDir, st.PromptFile), st)
+		if err == nil {
+			if f != nil && f.promptErr {
+				f.err, f.promptErr = "", false
+			}
+			continue
+		}
+		if f != nil && (f.err == "" || f.promptErr) {
+			f.err, f.promptErr = fmt.Sprintf("prompt 模板 %s 渲染失败: %v", st.PromptFile, err), true
+		}
+		log.Printf("⚠️ [Strategy] 策略 %s 的 prompt 模板 %s 渲染失败: %v（运行时将回退到默认 prompt）", st.Name, st.PromptFile, err)
+		if n := GetNotifier(); n != nil {
+			n.NotifyError(fmt.Errorf("策略 %s 的 prompt 模板 %s 渲染失败: %v", st.Name, st.PromptFile, err))
+		}
+	}
+}
+
+// fileOfLocked 定义该策略的策略文件，非文件定义的策略返回 nil（调用方需持有锁）
+func (sm *StrategyManager) fileOfLocked(st *Strategy) *strategyFile {
+	if st.Source != StrategySourceFile {
+		return nil
+	}
+	for _, f := range sm.files {
+		if f.strategy == st {
+			return f
+		}
+	}
+	return nil
+}
+
+// rebuildLocked 按 内置 < API 添加 < 文件 的优先级重建策略表（调用方需持有写锁）。
+// 多个文件定义同名策略时按文件名排序取第一个。
+func (sm *StrategyManager) rebuildLocked() {
+	strategies := make(map[string]*Strategy, len(DefaultStrategies)+len(sm.added)+len(sm.files))
+	for i := range DefaultStrategies {
+		s := &DefaultStrategies[i]
+		s.Source = StrategySourceBuiltin
+		strategies[s.Name] = s
+	}
+	for name, s := range sm.added {
+		strategies[name] = s
+	}
+
+	paths := make([]string, 0, len(sm.files))
+	for p := range sm.files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	fromFile := make(map[string]string)
+	for _, p := range paths {
+		s := sm.files[p].strategy
+		if s == nil {
+			continue
+		}
+		if other, dup := fromFile[s.Name]; dup {
+			log.Printf("⚠️ [Strategy] 策略文件 %s 与 %s 重名 (%s)，已忽略", p, other, s.Name)
+			continue
+		}
+		fromFile[s.Name] = p
+		strategies[s.Name] = s
+	}
+	sm.strategies = strategies
+
+	if _, ok := sm.strategies[sm.activeStrategy]; !ok {
+		log.Printf("⚠️ [Strategy] 当前策略 %s 已不存在，回退到 balanced", sm.activeStrategy)
+		sm.activeStrategy = "balanced"
+	}
+}
+
+// FileStatus 返回各策略文件的加载状态（按路径排序）
+func (sm *StrategyManager) FileStatus() []StrategyFileStatus {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	out := make([]StrategyFileStatus, 0, len(sm.files))
+	for _, f := range sm.files {
+		st := StrategyFileStatus{Path: f.path, Error: f.err, LoadedAt: f.loadedAt}
+		if f.strategy != nil {
+			st.Name = f.strategy.Name
+		}
+		out = append(out, st)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	return out
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// TradeMemoryConfig 策略级交易记忆配置：在 user prompt 中加入最近平仓、各币种连胜 / 连败和当前持仓的开仓理由
+type TradeMemoryConfig struct {
+	Enabled      bool `json:"enabled"`
+	RecentTrades int  `json:"recent_trades"` // 展示最近 N 笔平仓，默认 8
+	StreakWindow int  `json:"streak_window"` // 每个币种统计最近 N 笔的连胜 / 连败，默认 5
+	TokenBudget  int  `json:"token_budget"`  // 记忆部分的 token 预算（估算值），默认 600
+}
+
+const (
+	defaultMemoryRecentTrades = 8
+	defaultMemoryStreakWindow = 5
+	defaultMemoryTokenBudget  = 600
+	// memoryReasoningRunes 开仓理由 / 失效条件在记忆中最多保留的字符数
+	memoryReasoningRunes = 160
+	// memoryTradeScan 统计连胜 / 连败时最多回看的平仓记录数
+	memoryTradeScan = 200
+)
+
+// withDefaults 补齐未配置的字段
+func (c TradeMemoryConfig) withDefaults() TradeMemoryConfig {
+	if c.RecentTrades <= 0 {
+		c.RecentTrades = defaultMemoryRecentTrades
+	}
+	if c.StreakWindow <= 0 {
+		c.StreakWindow = defaultMemoryStreakWindow
+	}
+	if c.TokenBudget <= 0 {
+		c.TokenBudget = defaultMemoryTokenBudget
+	}
+	return c
+}
+
+// memoryConfig 本轮上下文使用的交易记忆配置，策略未开启时返回 false
+func (ctx *Context) memoryConfig() (TradeMemoryConfig, bool) {
+	strategy := ctx.Strategy
+	if strategy == nil {
+		if sm := GetStrategyManager(); sm != nil {
+			strategy = sm.GetActiveStrategy()
+		}
+	}
+	if strategy == nil || strategy.Memory == nil || !strategy.Memory.Enabled {
+		return TradeMemoryConfig{}, false
+	}
+	return strategy.Memory.withDefaults(), true
+}
+
+// estimateTokens 粗略估算 token 数：ASCII 约 4 字符 1 token，其余（中文等）约 1 字符 1 token
+func estimateTokens(s string) int {
+	ascii, other := 0, 0
+	for _, r := range s {
+		if r < utf8.RuneSelf {
+			ascii++
+		} else {
+			other++
+		}
+	}
+	return (ascii+3)/4 + other
+}
+
+// memoryBudget 按 token 预算逐行写入，超出预算的行计入 dropped
+type memoryBudget struct {
+	sb      strings.Builder
+	left    int
+	dropped int
+}
+
+// add 写入一行，预算不足时丢弃并返回 false
+func (m *memoryBudget) add(line string) bool {
+	cost := estimateTokens(line)
+	if cost > m.left {
+		m.dropped++
+		return false
+	}
+	m.left -= cost
+	m.sb.WriteString(line)
+	m.sb.WriteString("\n")
+	return true
+}
+
+// section 写入一个小节：标题与至少一行内容都放得下时才写入标题
+func (m *memoryBudget) section(title string, lines []string) {
+	if len(lines) == 0 {
+		return
+	}
+	if estimateTokens(title)+estimateTokens(lines[0]) > m.left {
+		m.dropped += len(lines)
+		return
+	}
+	m.add(title)
+	for _, l := range lines {
+		m.add(l)
+	}
+}
+
+// buildTradeMemory 从本轮账户的 Storage 构建交易记忆，按优先级写入：
+// 当前持仓的开仓理由 > 本轮交易对的连胜 / 连败 > 最近平仓。策略未开启或没有记录时返回空字符串。
+func buildTradeMemory(ctx *Context) string {
+	cfg, ok := ctx.memoryConfig()
+	if !ok {
+		return ""
+	}
+	storage := ctx.riskState().Storage
+	if storage == nil {
+		return ""
+	}
+
+	records, _, _ := storage.GetTradeRecords(memoryTradeScan, 0)
+
+	m := &memoryBudget{left: cfg.TokenBudget}
+	m.section("当前持仓的开仓理由:", memoryPositionLines(ctx, storage))
+	m.section("近期连胜 / 连败（本轮交易对）:", memoryStreakLines(ctx, records, cfg.StreakWindow))
+	recent := records
+	if len(recent) > cfg.RecentTrades {
+		recent = recent[:cfg.RecentTrades]
+	}
+	m.section(fmt.Sprintf("最近 %d 笔平仓（新→旧）:", len(recent)), memoryTradeLines(recent))
+
+	if m.sb.Len() == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString("## 交易记忆\n")
+	sb.WriteString(m.sb.String())
+	if m.dropped > 0 {
+		sb.WriteString(fmt.Sprintf("（超出记忆预算，省略 %d 条）\n", m.dropped))
+	}
+	sb.WriteString("\n")
+	return sb.String()
+}
+
+// memoryPositionLines 当前持仓对应的开仓决策：理由、置信度与失效条件
+func memoryPositionLines(ctx *Context, storage *Storage) []string {
+	var lines []string
+	for _, p := range ctx.Positions {
+		open, ok := storage.LastOpenDecision(p.Symbol, p.Side, time.Time{})
+		if !ok {
+			continue
+		}
+		d := open.Decision
+		line := fmt.Sprintf("- %s %s（%s 开仓", p.Symbol, strings.ToUpper(normalizePositionSide(p.Side)), open.Timestamp.Local().Format("01-02 15:04"))
+		if d.Confidence > 0 {
+			line += fmt.Sprintf("，置信度 %.2f", normalizedConfidence(d.Confidence))
+		}
+		line += "）: " + truncateRunes(d.Reasoning, memoryReasoningRunes)
+		if d.InvalidationCondition != "" {
+			line += " | 失效条件: " + truncateRunes(d.InvalidationCondition, memoryReasoningRunes)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// memoryStreakLines 本轮交易对（含持仓）最近 window 笔平仓的胜负序列与当前连胜 / 连败
+func memoryStreakLines(ctx *Context, records []TradeRecord, window int) []string {
+	var symbols []string
+	seen := make(map[string]bool)
+	for _, p := range ctx.Positions {
+		if !seen[p.Symbol] {
+			seen[p.Symbol] = true
+			symbols = append(symbols, p.Symbol)
+		}
+	}
+	for _, s := range contextSymbols(ctx) {
+		if !seen[s] {
+			seen[s] = true
+			symbols = append(symbols, s)
+		}
+	}
+
+	var lines []string
+	for _, symbol := range symbols {
+		var marks []string
+		for _, r := range records {
+			if r.Symbol != symbol || r.PnL == 0 {
+				continue
+			}
+			if r.PnL > 0 {
+				marks = append(marks, "W")
+			} else {
+				marks = append(marks, "L")
+			}
+			if len(marks) >= window {
+				break
+			}
+		}
+		if len(marks) == 0 {
+			continue
+		}
+		streak := 1
+		for streak < len(marks) && marks[streak] == marks[0] {
+			streak++
+		}
+		label := "连胜"
+		if marks[0] == "L" {
+			label = "连亏"
+		}
+		lines = append(lines, fmt.Sprintf("- %s: %s %d 笔（最近 %d 笔 新→旧: %s）", symbol, label, streak, len(marks), strings.Join(marks, " ")))
+	}
+	return lines
+}
+
+// memoryTradeLines 最近平仓：方向、动作、盈亏、R 倍数与平仓原因
+func memoryTradeLines(records []TradeRecord) []string {
+	lines := make([]string, 0, len(records))
+	for _, r := range records {
+		line := fmt.Sprintf("- %s %s %s %s | 盈亏 %+.2f U (%+.2f%%)", r.Time, r.Symbol, strings.ToUpper(r.Side), r.Action, r.PnL, r.PnLPct)
+		if r.RMultiple != 0 {
+			line += fmt.Sprintf(" | R=%+.2f", r.RMultiple)
+		}
+		if r.Reason != "" {
+			line += " | " + truncateRunes(r.Reason, memoryReasoningRunes)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// truncateRunes 按字符截断，超出时以 "…" 结尾
+func truncateRunes(s string, n int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n]) + "…"
+}
@@ -139,13 +139,17 @@ type MarketData struct {
 	RSI14_30m float64
 	ATR14_30m float64
 		
+	// 各周期最近一根已收盘 K 线的收盘价，key 为 "3m" / "5m" / "15m" / "30m" / "1h" / "4h"
+	LastClose map[string]float64
+
 	// 新增：布林带数据（基于 3m）
 	BollingerUpper  float64
 	BollingerMiddle float64
 	BollingerLower  float64
 
-	FundingRate  float64
-	OpenInterest *OIData
+	FundingRate     float64
+	NextFundingTime time.Time // 下次资金费结算时间，零值表示未知（模拟盘 / 回测）
+	OpenInterest    *OIData
 
 	LongShortRatio *LongShortData   // 新增：多空比数据
 	Liquidation    *LiquidationData // 新增：爆仓数据
@@ -163,13 +167,18 @@ type Context struct {
 	CurrentTime     string                 `json:"current_time"`    // 当前时间字符串
 	RuntimeMinutes  int                    `json:"runtime_minutes"` // 程序运行分钟数
 	CallCount       int                    `json:"call_count"`      // AI 调用计数
+	Symbols         []string               `json:"symbols"`         // 本轮交易对
 	Account         AccountInfo            `json:"account"`         // 账户当前状态
 	Positions       []PositionInfo         `json:"positions"`       // 当前所有持仓
 	Sectors         []SectorInfo           `json:"sectors"`         // 板块热度
 	MarketDataMap   map[string]*MarketData `json:"-"`               // 全市场行情数据 (Map 便于查找)
 	SharpeRatio     float64                `json:"sharpe_ratio"`    // 运行时夏普比率 (基于本次运行的资金曲线)
-	BTCETHLeverage  int                    `json:"-"`               // BTC/ETH 最大杠杆配置
-	AltcoinLeverage int                    `json:"-"`               // 山寨币 最大杠杆配置
+	Leverage        []LeverageResolution   `json:"leverage"`        // 各交易对本轮使用的杠杆（由 LeverageManager 统一计算）
+	HedgeMode       bool                   `json:"hedge_mode"`      // 是否为对冲模式（同一交易对可同时持有多空两条腿）
+	Portfolio       *PortfolioExposure     `json:"portfolio"`       // 组合敞口（净多/净空、板块、相关性调整）
+	PositionEvents  []PositionEvent        `json:"position_events"` // 本轮 AI 调用前后端持仓管理规则执行的动作
+	Strategy        *Strategy              `json:"-"`               // 本轮使用的策略（nil 表示当前激活策略，影子策略会指定自己的策略）
+	Risk            *RiskState             `json:"-"`               // 熔断 / 开仓守卫 / 失效条件等账户状态（nil 表示实盘全局组件）
 }
 
 // Decision AI的交易决策
@@ -181,8 +190,9 @@ type Decision struct {
 	Symbol string `json:"symbol"` // 交易对象
 	Action string `json:"action"` // 动作: "open_long", "open_short", "close_long", "close_short", "wait", etc.
 
-	// 可选：方向字段，仅用于兼容 "open_position" + "side" 风格的输出
-	// 允许取值如 "long" / "short" / "buy" / "sell"，归一化逻辑会将其映射为标准 Action
+	// 可选：方向字段，用于兼容 "open_position" + "side" 风格的输出，
+	// 以及在对冲模式下为 partial_close / update_stop_loss / update_take_profit 指定作用的持仓腿
+	// 允许取值如 "long" / "short" / "buy" / "sell"，归一化逻辑会将其映射为标准 Action / 方向
 	Side string `json:"side,omitempty"`
 
 	// 开仓参数
@@ -198,18 +208,33 @@ type Decision struct {
 	NewTakeProfit   float64 `json:"new_take_profit,omitempty"`  // 新止盈价格 (用于 update_take_profit)
 	ClosePercentage float64 `json:"close_percentage,omitempty"` // 平仓比例 (0-100, 用于 partial_close)
 
+	// 风控调整记录（由风控流水线按规则执行顺序填充），用于追踪 AI 原始决策被缩仓 / 改写 / 拒绝的情况
+	RiskAdjustments []RiskAdjustment `json:"risk_adjustments,omitempty"`
+
 	// 执行结果（由本地实盘执行后填充，方便前端展示成功/失败）
 	ExecStatus string `json:"exec_status,omitempty"` // "success" / "failed" 等
 	ExecError  string `json:"exec_error,omitempty"`  // 失败时的错误信息
 
 	// 通用参数
-	// Confidence 允许使用 0-1 或 0-100 的小数，后端仅做展示，不参与风控计算
+	// Confidence 允许使用 0-1 或 0-100 的小数；开仓时记录用于置信度校准，开启 conviction_scaling 时按校准值缩放仓位
 	Confidence            float64 `json:"confidence,omitempty"`             // AI 信心度 (0-1 或 0-100)
 	RiskUSD               float64 `json:"risk_usd,omitempty"`               // 预估最大风险金额 (USDT)
 	InvalidationCondition string  `json:"invalidation_condition,omitempty"` // 失效条件
+	Setup                 string  `json:"setup,omitempty"`                  // 可选：形态标签，如 breakout / pullback，用于平仓复盘与检索同类教训
 	Reasoning             string  `json:"reasoning"`                        // 决策理由摘要
 }
 
+// RiskAdjustment 单条风控调整记录：某条规则把决策的某个字段从 Before 改为 After
+// 拒绝类记录的 Field / Before / After 为空，仅保留 Reason
+type RiskAdjustment struct {
+	Rule    string      `json:"rule"`
+	Outcome RiskOutcome `json:"outcome"`
+	Field   string      `json:"field,omitempty"`
+	Before  string      `json:"before,omitempty"`
+	After   string      `json:"after,omitempty"`
+	Reason  string      `json:"reason,omitempty"`
+}
+
 // FullDecision AI的完整决策（包含思维链）
 type FullDecision struct {
 	SystemPrompt string     `json:"system_prompt"` // 发送给 AI 的系统提示词
@@ -217,6 +242,13 @@ type FullDecision struct {
 	CoTTrace     string     `json:"cot_trace"`     // AI 的思维链 (Chain of Thought) 分析过程
 	Decisions    []Decision `json:"decisions"`     // AI 输出的具体决策列表
 	Timestamp    time.Time  `json:"timestamp"`     // 决策生成时间
+
+	// 决策时生效的配置版本与策略 prompt 模板哈希（见 config_version.go）
+	ConfigVersion string `json:"config_version,omitempty"`
+	PromptHash    string `json:"prompt_hash,omitempty"`
+
+	// 本次模型调用的 token、耗时与成本（见 usage.go）
+	Usage *ModelUsage `json:"usage,omitempty"`
 }
 
 // TradeRecord 历史交易记录
@@ -231,4 +263,12 @@ type TradeRecord struct {
 	PnL        float64 `json:"pnl"`         // 实现盈亏 (USDT)
 	PnLPct     float64 `json:"pnl_pct"`     // 收益率%
 	Reason     string  `json:"reason"`      // 平仓原因/备注
+
+	// 开仓时生效的配置版本与策略 prompt 模板哈希（写入存储时标记，见 config_version.go）
+	ConfigVersion string `json:"config_version,omitempty"`
+	PromptHash    string `json:"prompt_hash,omitempty"`
+
+	// 写入存储时关联的开仓决策（见 Storage.SaveTradeRecord）：决策记录 ID 与按开仓止损计算的 R 倍数
+	OpenDecisionID int64   `json:"open_decision_id,omitempty"`
+	RMultiple      float64 `json:"r_multiple,omitempty"`
 }
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"sync"
+)
+
+// SymbolUniverse 主循环使用的交易对集合，每个周期按当前策略解析（策略未配置 symbols 时使用 trading_symbols）：
+//   - 新加入的交易对在首次取到行情后才作为 AI 候选（预热）；
+//   - 移出的交易对不再作为候选、也不允许新开仓，但仍获取行情以便持仓管理 / 失效条件 / AI 平仓，
+//     持仓全部平掉并完成一次挂单清理后才彻底移除。
+type SymbolUniverse struct {
+	mu      sync.RWMutex
+	active  []string        // 当前策略的交易对（保持配置顺序）
+	warm    map[string]bool // 已取到有效行情的交易对
+	retired map[string]bool // 已移出策略，但仍有持仓或挂单待清理的交易对
+}
+
+// NewSymbolUniverse 创建交易对集合
+func NewSymbolUniverse() *SymbolUniverse {
+	return &SymbolUniverse{
+		warm:    make(map[string]bool),
+		retired: make(map[string]bool),
+	}
+}
+
+// Update 设置本周期的策略交易对，返回新增与移出的交易对
+func (u *SymbolUniverse) Update(symbols []string) (added, removed []string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	next := make(map[string]bool, len(symbols))
+	active := make([]string, 0, len(symbols))
+	for _, s := range symbols {
+		if s == "" || next[s] {
+			continue
+		}
+		next[s] = true
+		active = append(active, s)
+	}
+	prev := make(map[string]bool, len(u.active))
+	for _, s := range u.active {
+		prev[s] = true
+		if !next[s] {
+			removed = append(removed, s)
+			u.retired[s] = true
+		}
+	}
+	for _, s := range active {
+		if !prev[s] {
+			added = append(added, s)
+			delete(u.retired, s)
+		}
+	}
+	u.active = active
+	return added, removed
+}
+
+// FetchSymbols 需要获取行情和清理挂单的交易对：策略交易对 + 仍在管理中的已移出交易对
+func (u *SymbolUniverse) FetchSymbols() []string {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	out := append([]string(nil), u.active...)
+	return append(out, sortedKeys(u.retired)...)
+}
+
+// MarkWarm 记录已取到有效行情的交易对
+func (u *SymbolUniverse) MarkWarm(md map[string]*MarketData) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for _, s := range u.active {
+		if u.warm[s] {
+			continue
+		}
+		if d, ok := md[s]; ok && d != nil && d.CurrentPrice > 0 {
+			u.warm[s] = true
+			log.Printf("✅ [Universe] %s 行情已就绪，加入候选", s)
+		}
+	}
+}
+
+// Tradable 本周期提供给 AI 的候选交易对（策略交易对中已完成预热的部分）
+func (u *SymbolUniverse) Tradable() []string {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	out := make([]string, 0, len(u.active))
+	for _, s := range u.active {
+		if u.warm[s] {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// IsTradable 是否允许对该交易对新开仓
+func (u *SymbolUniverse) IsTradable(symbol string) bool {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	if !u.warm[symbol] {
+		return false
+	}
+	for _, s := range u.active {
+		if s == symbol {
+			return true
+		}
+	}
+	return false
+}
+
+// Prune 在挂单清理之后调用：移除已没有持仓的已移出交易对；
+// 不在策略交易对中的持仓（如重启前其它策略开的仓）加入管理
+func (u *SymbolUniverse) Prune(positions []PositionInfo) {
+	held := make(map[string]bool, len(positions))
+	for _, p := range positions {
+		held[p.Symbol] = true
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	active := make(map[string]bool, len(u.active))
+	for _, s := range u.active {
+		active[s] = true
+	}
+	for s := range held {
+		if !active[s] && !u.retired[s] {
+			u.retired[s] = true
+			log.Printf("ℹ️ [Universe] %s 不在当前策略交易对中，仅管理已有持仓", s)
+		}
+	}
+	for s := range u.retired {
+		if !held[s] {
+			delete(u.retired, s)
+			delete(u.warm, s)
+			log.Printf("ℹ️ [Universe] %s 已无持仓，停止跟踪", s)
+		}
+	}
+}
+
+// sortedKeys 返回集合中的键（排序后）
+func sortedKeys(set map[string]bool) []string {
+	out := make([]string, 0, len(set))
+	for k := range set {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// 全局交易对集合
+var globalSymbolUniverse *SymbolUniverse
+
+// InitGlobalSymbolUniverse 初始化全局交易对集合
+func InitGlobalSymbolUniverse() {
+	globalSymbolUniverse = NewSymbolUniverse()
+}
+
+// GetSymbolUniverse 获取全局交易对集合（未初始化时返回 nil，例如回测，此时不限制开仓交易对）
+func GetSymbolUniverse() *SymbolUniverse {
+	return globalSymbolUniverse
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ModelPrice 模型单价（美元 / 百万 token）
+type ModelPrice struct {
+	PromptPer1M     float64 `json:"prompt_per_1m"`
+	CompletionPer1M float64 `json:"completion_per_1m"`
+}
+
+// 超出预算时的动作
+const (
+	BudgetActionDowngrade = "downgrade" // 改用 fallback_model
+	BudgetActionSlowDown  = "slow_down" // 循环周期延长到 slow_loop_interval_seconds
+	BudgetActionBoth      = "both"      // 两者都做
+)
+
+// UsageConfig 模型调用计量与每日预算（按 UTC 日计算；预算为 0 表示不限制）
+type UsageConfig struct {
+	Prices                  map[string]ModelPrice `json:"prices"` // key: 模型名，未配置单价的模型成本记为 0
+	DailyBudgetUSD          float64               `json:"daily_budget_usd"`
+	DailyTokenBudget        int                   `json:"daily_token_budget"`
+	BudgetAction            string                `json:"budget_action"`              // downgrade / slow_down / both，默认 both
+	FallbackModel           string                `json:"fallback_model"`             // 实盘决策降级使用的模型（与 ai_api_url 同一 API），为空时不降级
+	SlowLoopIntervalSeconds int                   `json:"slow_loop_interval_seconds"` // 减速后的最短循环周期，默认 600
+}
+
+const (
+	defaultSlowLoopIntervalSeconds = 600
+	// maxModelCalls 最多保留的单次调用明细数（汇总数据不受影响）
+	maxModelCalls = 2000
+	// usageRetentionDays 按日汇总数据的保留天数（UTC，含今天）
+	usageRetentionDays = 90
+)
+
+// ModelUsage 一次模型调用的 token、耗时与成本
+type ModelUsage struct {
+	Model            string  `json:"model"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	LatencyMs        int64   `json:"latency_ms"`
+	CostUSD          float64 `json:"cost_usd"`
+}
+
+// ModelCallRecord 一次模型调用的明细
+type ModelCallRecord struct {
+	Time     time.Time `json:"time"`
+	Source   string    `json:"source"` // live / shadow:<name> / reflection
+	Strategy string    `json:"strategy"`
+	ModelUsage
+	Error string `json:"error,omitempty"`
+}
+
+// UsageAggregate 按 日期 + 模型 + 策略 + 来源 汇总的调用量
+type UsageAggregate struct {
+	Day              string  `json:"day"` // UTC 日期 2006-01-02
+	Model            string  `json:"model"`
+	Strategy         string  `json:"strategy"`
+	Source           string  `json:"source"`
+	Calls            int     `json:"calls"`
+	Errors           int     `json:"errors"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	CostUSD          float64 `json:"cost_usd"`
+	TotalLatencyMs   int64   `json:"total_latency_ms"`
+	AvgLatencyMs     int64   `json:"avg_latency_ms"`
+}
+
+// UsageBudgetStatus 当日预算使用情况
+type UsageBudgetStatus struct {
+	Day               string  `json:"day"`
+	CostUSD           float64 `json:"cost_usd"`
+	Tokens            int     `json:"tokens"`
+	Calls             int     `json:"calls"`
+	DailyBudgetUSD    float64 `json:"daily_budget_usd"`
+	DailyTokenBudget  int     `json:"daily_token_budget"`
+	Exceeded          bool    `json:"exceeded"`
+	Reason            string  `json:"reason,omitempty"`
+	Action            string  `json:"action,omitempty"`
+	FallbackModel     string  `json:"fallback_model,omitempty"`
+	SlowLoopIntervalS int     `json:"slow_loop_interval_seconds,omitempty"`
+}
+
+type usageState struct {
+	Calls      []ModelCallRecord          `json:"calls"`
+	Aggregates map[string]*UsageAggregate `json:"aggregates"` // key: day|model|strategy|source
+}
+
+// UsageTracker 记录每次模型调用的 token、耗时与成本，按日汇总并执行每日预算：
+// 超出预算时把模型降级为 fallback_model 和 / 或延长循环周期，次日（UTC）自动恢复
+type UsageTracker struct {
+	mu       sync.Mutex
+	cfg      UsageConfig
+	filePath string
+	state    usageState
+	exceeded bool // 上次检查时是否超出预算，用于只在状态变化时记录日志 / 通知
+	dirty    bool // 有尚未持久化的调用记录，由 Flush 写入文件
+}
+
+// NewUsageTracker 创建调用计量器并从 filePath 恢复状态（filePath 为空时只保存在内存中）
+func NewUsageTracker(cfg UsageConfig, filePath string) *UsageTracker {
+	switch cfg.BudgetAction {
+	case BudgetActionDowngrade, BudgetActionSlowDown, BudgetActionBoth:
+	default:
+		cfg.BudgetAction = BudgetActionBoth
+	}
+	if cfg.SlowLoopIntervalSeconds <= 0 {
+		cfg.SlowLoopIntervalSeconds = defaultSlowLoopIntervalSeconds
+	}
+	t := &UsageTracker{
+		cfg:      cfg,
+		filePath: filePath,
+		state:    usageState{Aggregates: make(map[string]*UsageAggregate)},
+	}
+	t.load()
+	t.pruneAggregatesLocked(time.Now())
+	t.exceeded, _ = t.overBudgetLocked(time.Now())
+	return t
+}
+
+func (t *UsageTracker) load() {
+	if t.filePath == "" {
+		return
+	}
+	data, err := os.ReadFile(t.filePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("⚠️ [Usage] 加载 %s 失败: %v", t.filePath, err)
+		}
+		return
+	}
+	var st usageState
+	if err := json.Unmarshal(data, &st); err != nil {
+		log.Printf("⚠️ [Usage] 解析 %s 失败: %v", t.filePath, err)
+		return
+	}
+	if st.Aggregates == nil {
+		st.Aggregates = make(map[string]*UsageAggregate)
+	}
+	t.state = st
+}
+
+// saveLocked 持久化状态（调用方需持有锁）
+func (t *UsageTracker) saveLocked() {
+	if t.filePath == "" {
+		return
+	}
+	if err := writeJSONFile(t.filePath, t.state); err != nil {
+		log.Printf("⚠️ [Usage] 保存 %s 失败: %v", t.filePath, err)
+	}
+}
+
+// Cost 按单价表计算一次调用的成本（美元）
+func (t *UsageTracker) Cost(model string, promptTokens, completionTokens int) float64 {
+	if t == nil {
+		return 0
+	}
+	p, ok := t.cfg.Prices[model]
+	if !ok {
+		return 0
+	}
+	return (float64(promptTokens)*p.PromptPer1M + float64(completionTokens)*p.CompletionPer1M) / 1e6
+}
+
+// Flush 持久化尚未写入的调用记录；主循环每个周期调用一次，避免每次模型调用都重写整个文件
+func (t *UsageTracker) Flush() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.dirty {
+		return
+	}
+	t.saveLocked()
+	t.dirty = false
+}
+
+// Record 记录一次模型调用并更新当日汇总（由 Flush 持久化）；预算状态变化时记录日志并发送通知
+func (t *UsageTracker) Record(rec ModelCallRecord) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.state.Calls = append(t.state.Calls, rec)
+	if n := len(t.state.Calls); n > maxModelCalls {
+		t.state.Calls = t.state.Calls[n-maxModelCalls:]
+	}
+
+	day := rec.Time.UTC().Format("2006-01-02")
+	key := strings.Join([]string{day, rec.Model, rec.Strategy, rec.Source}, "|")
+	agg, ok := t.state.Aggregates[key]
+	if !ok {
+		agg = &UsageAggregate{Day: day, Model: rec.Model, Strategy: rec.Strategy, Source: rec.Source}
+		t.state.Aggregates[key] = agg
+		t.pruneAggregatesLocked(rec.Time)
+	}
+	agg.Calls++
+	if rec.Error != "" {
+		agg.Errors++
+	}
+	agg.PromptTokens += rec.PromptTokens
+	agg.CompletionTokens += rec.CompletionTokens
+	agg.CostUSD += rec.CostUSD
+	agg.TotalLatencyMs += rec.LatencyMs
+	agg.AvgLatencyMs = agg.TotalLatencyMs / int64(agg.Calls)
+	t.dirty = true
+
+	t.checkBudgetLocked(rec.Time)
+}
+
+// pruneAggregatesLocked 删除超出保留天数的按日汇总（调用方需持有锁）
+func (t *UsageTracker) pruneAggregatesLocked(now time.Time) {
+	cutoff := now.UTC().AddDate(0, 0, -(usageRetentionDays - 1)).Format("2006-01-02")
+	for key, a := range t.state.Aggregates {
+		if a.Day < cutoff {
+			delete(t.state.Aggregates, key)
+		}
+	}
+}
+
+// checkBudgetLocked 预算状态变化时记录日志并通知（调用方需持有锁）
+func (t *UsageTracker) checkBudgetLocked(now time.Time) bool {
+	exceeded, reason := t.overBudgetLocked(now)
+	if exceeded != t.exceeded {
+		t.exceeded = exceeded
+		if exceeded {
+			log.Printf("💸 [Usage] 超出每日预算（%s），执行 %s", reason, t.cfg.BudgetAction)
+			if n := GetNotifier(); n != nil {
+				n.NotifyUsageBudget(reason, t.budgetActionText())
+			}
+		} else {
+			log.Printf("✅ [Usage] 每日预算已重置，恢复正常模型与循环周期")
+		}
+	}
+	return exceeded
+}
+
+// overBudgetLocked 当日（UTC）花费或 token 是否超出预算（调用方需持有锁）
+func (t *UsageTracker) overBudgetLocked(now time.Time) (bool, string) {
+	cost, tokens, _ := t.dayTotalsLocked(now.UTC().Format("2006-01-02"))
+	if t.cfg.DailyBudgetUSD > 0 && cost >= t.cfg.DailyBudgetUSD {
+		return true, fmt.Sprintf("花费 $%.4f ≥ $%.2f", cost, t.cfg.DailyBudgetUSD)
+	}
+	if t.cfg.DailyTokenBudget > 0 && tokens >= t.cfg.DailyTokenBudget {
+		return true, fmt.Sprintf("token %d ≥ %d", tokens, t.cfg.DailyTokenBudget)
+	}
+	return false, ""
+}
+
+func (t *UsageTracker) dayTotalsLocked(day string) (cost float64, tokens, calls int) {
+	for _, a := range t.state.Aggregates {
+		if a.Day == day {
+			cost += a.CostUSD
+			tokens += a.PromptTokens + a.CompletionTokens
+			calls += a.Calls
+		}
+	}
+	return cost, tokens, calls
+}
+
+func (t *UsageTracker) budgetActionText() string {
+	var parts []string
+	if t.downgrades() {
+		parts = append(parts, "模型降级为 "+t.cfg.FallbackModel)
+	}
+	if t.cfg.BudgetAction != BudgetActionDowngrade {
+		parts = append(parts, fmt.Sprintf("循环周期至少 %d 秒", t.cfg.SlowLoopIntervalSeconds))
+	}
+	return strings.Join(parts, "；")
+}
+
+func (t *UsageTracker) downgrades() bool {
+	return t.cfg.BudgetAction != BudgetActionSlowDown && t.cfg.FallbackModel != ""
+}
+
+// overBudget 当前是否超出预算（跨日时重新判断）
+func (t *UsageTracker) overBudget() bool {
+	if t == nil {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.checkBudgetLocked(time.Now())
+}
+
+// EffectiveModel 超出预算且配置了降级时返回 fallback_model，否则返回 model
+func (t *UsageTracker) EffectiveModel(model string) string {
+	if t == nil || !t.downgrades() || !t.overBudget() {
+		return model
+	}
+	return t.cfg.FallbackModel
+}
+
+// LoopInterval 超出预算且配置了减速时返回延长后的循环周期，否则返回 intervalSec
+func (t *UsageTracker) LoopInterval(intervalSec int) int {
+	if t == nil || t.cfg.BudgetAction == BudgetActionDowngrade || !t.overBudget() {
+		return intervalSec
+	}
+	if intervalSec < t.cfg.SlowLoopIntervalSeconds {
+		return t.cfg.SlowLoopIntervalSeconds
+	}
+	return intervalSec
+}
+
+// Budget 当日预算使用情况
+func (t *UsageTracker) Budget() UsageBudgetStatus {
+	if t == nil {
+		return UsageBudgetStatus{Day: time.Now().UTC().Format("2006-01-02")}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	day := now.UTC().Format("2006-01-02")
+	st := UsageBudgetStatus{Day: day, DailyBudgetUSD: t.cfg.DailyBudgetUSD, DailyTokenBudget: t.cfg.DailyTokenBudget}
+	st.CostUSD, st.Tokens, st.Calls = t.dayTotalsLocked(day)
+	st.Exceeded, st.Reason = t.overBudgetLocked(now)
+	if st.Exceeded {
+		st.Action = t.budgetActionText()
+		if t.downgrades() {
+			st.FallbackModel = t.cfg.FallbackModel
+		}
+		if t.cfg.BudgetAction != BudgetActionDowngrade {
+			st.SlowLoopIntervalS = t.cfg.SlowLoopIntervalSeconds
+		}
+	}
+	return st
+}
+
+// Aggregates 最近 days 天（UTC，含今天，最多保留 usageRetentionDays 天）的汇总，按日期倒序、成本倒序
+func (t *UsageTracker) Aggregates(days int) []UsageAggregate {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	since := time.Now().UTC().AddDate(0, 0, -(days - 1)).Format("2006-01-02")
+	var out []UsageAggregate
+	for _, a := range t.state.Aggregates {
+		if days <= 0 || a.Day >= since {
+			out = append(out, *a)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Day != out[j].Day {
+			return out[i].Day > out[j].Day
+		}
+		return out[i].CostUSD > out[j].CostUSD
+	})
+	return out
+}
+
+// RecentCalls 最近的调用明细（新→旧）
+func (t *UsageTracker) RecentCalls(limit int) []ModelCallRecord {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var out []ModelCallRecord
+	for i := len(t.state.Calls) - 1; i >= 0; i-- {
+		out = append(out, t.state.Calls[i])
+		if limit > 0 && len(out) >= limit {
+			break
+		}
+	}
+	return out
+}
+
+// 全局调用计量器
+var globalUsageTracker *UsageTracker
+
+// InitGlobalUsageTracker 初始化全局调用计量器
+func InitGlobalUsageTracker(cfg UsageConfig, filePath string) {
+	globalUsageTracker = NewUsageTracker(cfg, filePath)
+}
+
+// GetUsageTracker 获取全局调用计量器（未初始化时为 nil，方法均可安全调用）
+func GetUsageTracker() *UsageTracker {
+	return globalUsageTracker
+}
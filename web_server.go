@@ -6,6 +6,7 @@ import (
 	"log"
 	"net/http"
 	"sync"
+	"time"
 )
 
 // WebServer 简单的 Web 监控服务
@@ -93,6 +94,9 @@ func (s *WebServer) Start(port int) {
 			"trade_history":        s.tradeHistory,
 			"loop_interval_seconds": s.loopIntervalSecs,
 		}
+		if cb := GetCircuitBreaker(); cb != nil {
+			resp["circuit_breakers"] = cb.Snapshot()
+		}
 
 		w.Header().Set("Content-Type", "application/json")
 		_ = json.NewEncoder(w).Encode(resp)
@@ -124,6 +128,7 @@ func (s *WebServer) Start(port int) {
 			}
 
 			s.SetLoopIntervalSeconds(req.LoopIntervalSeconds)
+			GetConfigVersions().Check("web: loop_interval")
 
 			w.Header().Set("Content-Type", "application/json")
 			_ = json.NewEncoder(w).Encode(map[string]interface{}{
@@ -207,6 +212,7 @@ func (s *WebServer) Start(port int) {
 	})
 
 	// 手动调整杠杆 API：POST /api/set_leverage {symbol, leverage}
+	// 写入杠杆管理器（风控与执行统一使用），实盘下同时调用交易所；leverage 为 0 时清除单币种设置
 	http.HandleFunc("/api/set_leverage", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			w.WriteHeader(http.StatusMethodNotAllowed)
@@ -222,35 +228,62 @@ func (s *WebServer) Start(port int) {
 			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid json"})
 			return
 		}
-		if req.Symbol == "" || req.Leverage <= 0 {
+		if req.Symbol == "" || req.Leverage < 0 {
 			w.WriteHeader(http.StatusBadRequest)
-			_ = json.NewEncoder(w).Encode(map[string]string{"error": "symbol and positive leverage required"})
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "symbol and non-negative leverage required"})
 			return
 		}
 
-		// 重新加载配置并调用 Binance 实盘
+		// 重新加载配置，实盘模式下先调用 Binance，成功后再更新杠杆管理器
 		cfg, err := LoadConfig()
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			_ = json.NewEncoder(w).Encode(map[string]string{"error": "load config failed"})
 			return
 		}
-		if cfg.BinanceAPIKey == "" || cfg.BinanceSecretKey == "" {
-			w.WriteHeader(http.StatusBadRequest)
-			_ = json.NewEncoder(w).Encode(map[string]string{"error": "binance api key/secret not configured"})
-			return
+		if req.Leverage > 0 && cfg.BinanceAPIKey != "" && cfg.BinanceSecretKey != "" {
+			ex := NewBinanceExchange(cfg.BinanceAPIKey, cfg.BinanceSecretKey, cfg.BinanceProxyURL)
+			if err := ex.SetLeverage(req.Symbol, req.Leverage); err != nil {
+				log.Printf("SetLeverage API error: %v", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+				return
+			}
 		}
 
-		ex := NewBinanceExchange(cfg.BinanceAPIKey, cfg.BinanceSecretKey, cfg.BinanceProxyURL)
-		if err := ex.SetLeverage(req.Symbol, req.Leverage); err != nil {
-			log.Printf("SetLeverage API error: %v", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		lm := GetLeverageManager()
+		lm.Set(req.Symbol, req.Leverage)
+		GetConfigVersions().Check("web: set_leverage " + req.Symbol)
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":   "ok",
+			"resolved": lm.Resolve(req.Symbol, getRiskConfig().FixedLeverage),
+		})
+	})
+
+	// 杠杆一览: GET /api/leverage，返回单币种设置以及各交易对最终使用的杠杆和来源
+	http.HandleFunc("/api/leverage", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
 			return
 		}
 
+		var symbols []string
+		if u := GetSymbolUniverse(); u != nil {
+			symbols = u.FetchSymbols()
+		} else if cfg, err := LoadConfig(); err == nil {
+			symbols = cfg.TradingSymbols
+		}
+		lm := GetLeverageManager()
+		strategyLev := getRiskConfig().FixedLeverage
+
 		w.Header().Set("Content-Type", "application/json")
-		_ = json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"strategy_leverage": strategyLev,
+			"overrides":         lm.GetAllSpecific(),
+			"resolved":          lm.ResolveAll(symbols, strategyLev),
+		})
 	})
 
 	// ===== 新增 API 端点 =====
@@ -350,6 +383,214 @@ func (s *WebServer) Start(port int) {
 		_ = json.NewEncoder(w).Encode(stats)
 	})
 
+	// 获取置信度校准曲线: GET /api/calibration
+	http.HandleFunc("/api/calibration", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		c := GetCalibrator()
+		if c == nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "calibrator not initialized"})
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(c.Summary())
+	})
+
+	// 获取动态扫描结果（候选、来源标签、黑名单排除）: GET /api/scanner
+	http.HandleFunc("/api/scanner", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		sc := GetSymbolScanner()
+		if sc == nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "scanner not enabled"})
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"config": sc.Config(),
+			"last":   sc.Last(),
+		})
+	})
+
+	// 获取市场状态（当前状态、待确认状态、各交易对指标、自动切换记录）: GET /api/regime
+	http.HandleFunc("/api/regime", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		rd := GetRegimeDetector()
+		if rd == nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "regime detection not enabled"})
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(rd.Status())
+	})
+
+	// 获取影子策略与实盘的对比（净值、同期收益、持仓、最近决策、净值曲线）: GET /api/shadows
+	http.HandleFunc("/api/shadows", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		sm := GetShadowManager()
+		if sm == nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "shadow strategies not enabled"})
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(sm.Report())
+	})
+
+	// 获取配置版本（当前版本、历史版本快照、各版本的决策数与交易表现）: GET /api/config_versions?limit=20
+	http.HandleFunc("/api/config_versions", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		cv := GetConfigVersions()
+		storage := GetStorage()
+		if cv == nil || storage == nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "config versioning not enabled"})
+			return
+		}
+
+		limit := 20
+		if l := r.URL.Query().Get("limit"); l != "" {
+			fmt.Sscanf(l, "%d", &limit)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"current":  cv.Current(),
+			"versions": storage.GetConfigVersions(limit),
+			"stats":    storage.GetConfigVersionStats(),
+		})
+	})
+
+	// 搜索平仓复盘提炼的教训（按内容 / 形态标签关键字与币种筛选）: GET /api/lessons?q=止损&symbol=BTCUSDT&limit=50
+	http.HandleFunc("/api/lessons", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		j := GetReflectionJournal()
+		if j == nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "reflection not enabled"})
+			return
+		}
+
+		limit := 50
+		if l := r.URL.Query().Get("limit"); l != "" {
+			fmt.Sscanf(l, "%d", &limit)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(j.SearchLessons(r.URL.Query().Get("q"), r.URL.Query().Get("symbol"), limit))
+	})
+
+	// 获取最近的平仓复盘记录: GET /api/reflections?symbol=BTCUSDT&limit=20
+	http.HandleFunc("/api/reflections", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		j := GetReflectionJournal()
+		if j == nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "reflection not enabled"})
+			return
+		}
+
+		limit := 20
+		if l := r.URL.Query().Get("limit"); l != "" {
+			fmt.Sscanf(l, "%d", &limit)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(j.Reflections(r.URL.Query().Get("symbol"), limit))
+	})
+
+	// 模型调用计量: GET /api/usage?days=7&limit=50
+	// 返回当日预算使用情况、最近 days 天按日期 / 模型 / 策略 / 来源汇总的 token 与成本、最近 limit 次调用明细
+	http.HandleFunc("/api/usage", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		tracker := GetUsageTracker()
+		if tracker == nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "usage tracking not enabled"})
+			return
+		}
+
+		days, limit := 7, 50
+		if d := r.URL.Query().Get("days"); d != "" {
+			fmt.Sscanf(d, "%d", &days)
+		}
+		if l := r.URL.Query().Get("limit"); l != "" {
+			fmt.Sscanf(l, "%d", &limit)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"budget":     tracker.Budget(),
+			"aggregates": tracker.Aggregates(days),
+			"recent":     tracker.RecentCalls(limit),
+		})
+	})
+
+	// 获取风控调整统计: GET /api/risk_adjustments
+	http.HandleFunc("/api/risk_adjustments", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		storage := GetStorage()
+		if storage == nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "storage not initialized"})
+			return
+		}
+
+		stats, err := storage.GetRiskAdjustmentStats()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"stats":                  stats,
+			"avg_size_reduction_pct": stats.AvgSizeReductionPct(),
+		})
+	})
+
 	// 获取AI决策历史: GET /api/decisions?limit=50
 	http.HandleFunc("/api/decisions", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
@@ -398,9 +639,53 @@ func (s *WebServer) Start(port int) {
 		_ = json.NewEncoder(w).Encode(map[string]interface{}{
 			"strategies": sm.ListStrategies(),
 			"active":     sm.GetActiveStrategyName(),
+			"files":      sm.FileStatus(),
 		})
 	})
 
+	// 预览 prompt: GET /api/prompt/preview?strategy=name，按最近一个周期的上下文渲染（strategy 为空时使用当前策略）
+	http.HandleFunc("/api/prompt/preview", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		sm := GetStrategyManager()
+		if sm == nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "strategy manager not initialized"})
+			return
+		}
+
+		strategy := sm.GetActiveStrategy()
+		if name := r.URL.Query().Get("strategy"); name != "" {
+			st, ok := sm.GetStrategy(name)
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				_ = json.NewEncoder(w).Encode(map[string]string{"error": "strategy not found: " + name})
+				return
+			}
+			strategy = st
+		}
+
+		s.mu.RLock()
+		ctx := s.latestContext
+		s.mu.RUnlock()
+
+		resp := map[string]interface{}{"strategy": strategy.Name}
+		if ctx == nil {
+			// 尚未完成第一个周期：只能渲染 system prompt
+			ctx = &Context{CurrentTime: time.Now().Format("2006-01-02 15:04:05"), Symbols: strategy.Symbols}
+		} else {
+			resp["user_prompt"] = buildUserPrompt(ctx)
+			resp["context_time"] = ctx.CurrentTime
+		}
+		resp["system_prompt"] = buildSystemPromptFor(strategy, ctx)
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(resp)
+	})
+
 	// 切换策略: POST /api/strategy/switch {name: "strategy_name"}
 	http.HandleFunc("/api/strategy/switch", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
@@ -429,11 +714,100 @@ func (s *WebServer) Start(port int) {
 			_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
 			return
 		}
+		GetConfigVersions().Check("web: strategy_switch")
 
 		w.Header().Set("Content-Type", "application/json")
 		_ = json.NewEncoder(w).Encode(map[string]string{"status": "ok", "active": req.Name})
 	})
 
+	// 熔断状态: GET /api/circuit_breakers
+	http.HandleFunc("/api/circuit_breakers", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		cb := GetCircuitBreaker()
+		if cb == nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "circuit breaker not initialized"})
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(cb.Snapshot())
+	})
+
+	// 手动重置熔断: POST /api/circuit_breakers/reset {name: "daily_loss"}，name 为空时重置全部
+	http.HandleFunc("/api/circuit_breakers/reset", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req struct {
+			Name string `json:"name"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid json"})
+				return
+			}
+		}
+
+		cb := GetCircuitBreaker()
+		if cb == nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "circuit breaker not initialized"})
+			return
+		}
+
+		if err := cb.Reset(req.Name); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"status": "ok", "state": cb.Snapshot()})
+	})
+
+	// 资产分类: GET /api/assets 列出全部；POST /api/assets {symbol, tier, sector, ...} 新增或覆盖；DELETE /api/assets?symbol=XXX 删除
+	http.HandleFunc("/api/assets", func(w http.ResponseWriter, r *http.Request) {
+		registry := GetAssetRegistry()
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.Method {
+		case http.MethodGet:
+			_ = json.NewEncoder(w).Encode(registry.List())
+		case http.MethodPost:
+			var req AssetInfo
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid json"})
+				return
+			}
+			asset, err := registry.Set(req)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+				return
+			}
+			log.Printf("✅ [Assets] 更新资产 %s: tier=%s sector=%s max_leverage=%d", asset.Symbol, asset.Tier, asset.Sector, asset.MaxLeverage)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"status": "ok", "asset": asset})
+		case http.MethodDelete:
+			if err := registry.Delete(r.URL.Query().Get("symbol")); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+
 	// 导出净值曲线: GET /api/export/equity?format=csv
 	http.HandleFunc("/api/export/equity", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {